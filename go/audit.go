@@ -0,0 +1,87 @@
+package diagnyx
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// auditLog writes accepted calls to Config.AuditWriter as JSON lines from a
+// background goroutine. A nil *auditLog is a no-op.
+type auditLog struct {
+	calls  chan LLMCall
+	logf   func(format string, args ...interface{})
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+func newAuditLog(w io.Writer, size int, logf func(format string, args ...interface{})) *auditLog {
+	if w == nil {
+		return nil
+	}
+	if size < 1 {
+		size = 1
+	}
+	a := &auditLog{
+		calls: make(chan LLMCall, size),
+		logf:  logf,
+		done:  make(chan struct{}),
+	}
+	go a.run(w)
+	return a
+}
+
+func (a *auditLog) run(w io.Writer) {
+	defer close(a.done)
+	enc := json.NewEncoder(w)
+	for call := range a.calls {
+		a.write(enc, call)
+	}
+}
+
+// write encodes one call, recovering a panic in the AuditWriter
+func (a *auditLog) write(enc *json.Encoder, call LLMCall) {
+	defer func() {
+		if v := recover(); v != nil {
+			a.logf("AuditWriter panicked: %v", v)
+		}
+	}()
+	if err := enc.Encode(call); err != nil {
+		a.logf("Audit write failed: %v", err)
+	}
+}
+
+// record queues calls for the audit log without blocking. Calls that do not
+// fit in the queue are dropped from the audit log only.
+func (a *auditLog) record(calls ...LLMCall) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	for _, call := range calls {
+		select {
+		case a.calls <- call:
+		default:
+			a.logf("Audit buffer full, leaving %s/%s call out of the audit log", call.Provider, call.Model)
+		}
+	}
+}
+
+// close stops accepting calls and waits for queued ones to be written
+func (a *auditLog) close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.calls)
+	}
+	a.mu.Unlock()
+	<-a.done
+}
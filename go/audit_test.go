@@ -0,0 +1,51 @@
+package diagnyx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestAuditWriter(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	var buf bytes.Buffer
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		MaxBufferSize:   2,
+		AuditWriter:     &buf,
+	})
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	client.TrackCalls([]LLMCall{
+		{Provider: ProviderAnthropic, Model: "claude-3-opus", Status: StatusSuccess},
+		{Provider: ProviderOpenAI, Model: "dropped", Status: StatusSuccess},
+	})
+	client.Flush()
+	if _, err := client.TrackAndFlush(context.Background(), LLMCall{Provider: ProviderOpenAI, Model: "sync", Status: StatusSuccess}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client.Close()
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "after-close", Status: StatusSuccess})
+
+	var models []string
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var call LLMCall
+		if err := json.Unmarshal(scanner.Bytes(), &call); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		if call.Timestamp.IsZero() {
+			t.Error("expected audited calls to carry their timestamp")
+		}
+		models = append(models, call.Model)
+	}
+	if len(models) != 3 || models[0] != "gpt-4" || models[1] != "claude-3-opus" || models[2] != "sync" {
+		t.Errorf("expected only accepted calls in order, got %v", models)
+	}
+}
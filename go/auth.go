@@ -0,0 +1,20 @@
+package diagnyx
+
+import "net/http"
+
+// SetAuthHeader sets the header that authenticates a request with apiKey.
+// An empty header means "Authorization", with scheme defaulting to
+// "Bearer". A custom header gets scheme as given, so an empty scheme sends
+// the bare key, as gateways expecting e.g. X-API-Key do.
+func SetAuthHeader(h http.Header, header, scheme, apiKey string) {
+	if header == "" {
+		header = "Authorization"
+		if scheme == "" {
+			scheme = "Bearer"
+		}
+	}
+	if scheme != "" {
+		apiKey = scheme + " " + apiKey
+	}
+	h.Set(header, apiKey)
+}
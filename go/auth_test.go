@@ -0,0 +1,66 @@
+package diagnyx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetAuthHeader(t *testing.T) {
+	tests := []struct {
+		name, header, scheme string
+		wantHeader, want     string
+	}{
+		{"default", "", "", "Authorization", "Bearer key"},
+		{"custom scheme", "", "Token", "Authorization", "Token key"},
+		{"raw custom header", "X-API-Key", "", "X-API-Key", "key"},
+		{"custom header and scheme", "X-Auth", "ApiKey", "X-Auth", "ApiKey key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			SetAuthHeader(h, tt.header, tt.scheme, "key")
+			if got := h.Get(tt.wantHeader); got != tt.want {
+				t.Errorf("expected %s: %q, got %q", tt.wantHeader, tt.want, got)
+			}
+			if tt.wantHeader != "Authorization" && h.Get("Authorization") != "" {
+				t.Error("expected no Authorization header")
+			}
+		})
+	}
+}
+
+func TestAuthHeaderConfig(t *testing.T) {
+	var apiKey, authorization, feedbackKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/ingest/llm/batch" {
+			apiKey, authorization = r.Header.Get("X-API-Key"), r.Header.Get("Authorization")
+		} else {
+			feedbackKey = r.Header.Get("X-API-Key")
+		}
+		w.Write([]byte(`{"tracked":1}`))
+	}))
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		AuthHeader:      "X-API-Key",
+	})
+	defer client.Close()
+
+	if _, err := client.TrackAndFlush(context.Background(), LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if apiKey != "test-key" || authorization != "" {
+		t.Errorf("expected the bare key in X-API-Key only, got %q and Authorization %q", apiKey, authorization)
+	}
+
+	NewFeedbackClientFrom(client, "org-1").ThumbsUp("trace-1", nil)
+	if feedbackKey != "test-key" {
+		t.Errorf("expected the feedback client to use X-API-Key, got %q", feedbackKey)
+	}
+}
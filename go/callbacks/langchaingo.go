@@ -3,12 +3,13 @@ package callbacks
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/diagnyxai/diagnyx-go"
-	"github.com/google/uuid"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/schema"
 )
@@ -16,20 +17,24 @@ import (
 // DiagnyxHandler is a LangChain callback handler for Diagnyx cost tracking.
 // Implements the langchaingo callbacks.Handler interface.
 type DiagnyxHandler struct {
-	client         *diagnyx.Client
+	client         diagnyx.Tracker
 	projectID      string
 	environment    string
 	userIdentifier string
 	captureContent bool
+	capturePolicy  diagnyx.CapturePolicy
+	runIDExtractor func(ctx context.Context) string
+	idGenerator    func() string
 
-	mu             sync.Mutex
-	callStarts     map[string]time.Time
-	callMetadata   map[string]*callMeta
+	mu           sync.Mutex
+	callStarts   map[string]time.Time
+	callMetadata map[string]*callMeta
 }
 
 type callMeta struct {
-	model   string
-	prompts []string
+	model       string
+	prompts     []string
+	parentRunID string
 }
 
 // HandlerOption is a function that configures a DiagnyxHandler.
@@ -63,10 +68,40 @@ func WithCaptureContent(capture bool) HandlerOption {
 	}
 }
 
+// WithCapturePolicy sets which parts of the content to capture. It takes
+// precedence over WithCaptureContent and the client's CapturePolicy.
+func WithCapturePolicy(policy diagnyx.CapturePolicy) HandlerOption {
+	return func(h *DiagnyxHandler) {
+		h.capturePolicy = policy
+	}
+}
+
+// WithRunIDExtractor sets how run IDs are read from the callback context,
+// e.g. to reuse your own correlation IDs. A run ID pairs the start and end
+// callbacks of a call, so the extractor must return the same ID for both.
+// If it returns "", a random run ID is used.
+func WithRunIDExtractor(extract func(ctx context.Context) string) HandlerOption {
+	return func(h *DiagnyxHandler) {
+		h.runIDExtractor = extract
+	}
+}
+
+// WithIDGenerator sets how run IDs are generated when none is found in the
+// callback context, e.g. to match your own ID scheme. A nil generator is
+// ignored. Default: 32 random hex characters (128 bits).
+func WithIDGenerator(generate func() string) HandlerOption {
+	return func(h *DiagnyxHandler) {
+		if generate != nil {
+			h.idGenerator = generate
+		}
+	}
+}
+
 // NewDiagnyxHandler creates a new LangChain callback handler for Diagnyx.
-func NewDiagnyxHandler(client *diagnyx.Client, opts ...HandlerOption) *DiagnyxHandler {
+func NewDiagnyxHandler(client diagnyx.Tracker, opts ...HandlerOption) *DiagnyxHandler {
 	h := &DiagnyxHandler{
 		client:       client,
+		idGenerator:  newRunID,
 		callStarts:   make(map[string]time.Time),
 		callMetadata: make(map[string]*callMeta),
 	}
@@ -87,7 +122,8 @@ func (h *DiagnyxHandler) HandleLLMStart(ctx context.Context, prompts []string) {
 
 	h.callStarts[runID] = time.Now()
 	h.callMetadata[runID] = &callMeta{
-		prompts: prompts,
+		prompts:     prompts,
+		parentRunID: getParentRunID(ctx),
 	}
 }
 
@@ -114,7 +150,8 @@ func (h *DiagnyxHandler) HandleLLMGenerateContentStart(ctx context.Context, ms [
 
 	h.callStarts[runID] = time.Now()
 	h.callMetadata[runID] = &callMeta{
-		prompts: prompts,
+		prompts:     prompts,
+		parentRunID: getParentRunID(ctx),
 	}
 }
 
@@ -141,24 +178,32 @@ func (h *DiagnyxHandler) HandleLLMGenerateContentEnd(ctx context.Context, res *l
 	}
 
 	// Detect provider from model name
-	provider := detectProvider(model)
-
-	// Extract token usage from response
-	inputTokens := 0
-	outputTokens := 0
-
-	// Try to get token counts from response choices
-	if res != nil && len(res.Choices) > 0 {
-		for _, choice := range res.Choices {
-			if choice.GenerationInfo != nil {
-				if pt, ok := choice.GenerationInfo["PromptTokens"].(int); ok {
-					inputTokens = pt
-				}
-				if ct, ok := choice.GenerationInfo["CompletionTokens"].(int); ok {
-					outputTokens = ct
-				}
-			}
+	provider := diagnyx.DetectProvider(model)
+
+	var choices []*llms.ContentChoice
+	if res != nil {
+		choices = res.Choices
+	}
+	var prompt string
+	if meta != nil {
+		prompt = strings.Join(meta.prompts, "\n---\n")
+	}
+	response := responseText(choices)
+
+	// Extract token usage from response choices, estimating it from the
+	// text when the integration reports none
+	inputTokens, outputTokens, found := extractUsage(provider, choices)
+	var metadata map[string]interface{}
+	if !found {
+		inputTokens = diagnyx.EstimateTokens(prompt)
+		outputTokens = diagnyx.EstimateTokens(response)
+		metadata = map[string]interface{}{"tokens_estimated": true}
+	}
+	if responseTruncated(choices) {
+		if metadata == nil {
+			metadata = make(map[string]interface{})
 		}
+		metadata["truncated"] = true
 	}
 
 	// Build the call data
@@ -169,41 +214,25 @@ func (h *DiagnyxHandler) HandleLLMGenerateContentEnd(ctx context.Context, res *l
 		OutputTokens:   outputTokens,
 		Status:         diagnyx.StatusSuccess,
 		LatencyMs:      latencyMs,
+		SpanID:         runID,
+		ParentSpanID:   parentRunID(ctx, meta),
 		ProjectID:      h.projectID,
 		Environment:    h.environment,
 		UserIdentifier: h.userIdentifier,
+		Metadata:       metadata,
 		Timestamp:      time.Now().UTC(),
 	}
 
 	// Capture content if enabled
-	if h.captureContent && meta != nil {
-		maxLen := h.client.Config().ContentMaxLength
-		if maxLen == 0 {
-			maxLen = 10000
-		}
+	policy := h.contentCapturePolicy()
+	if policy != diagnyx.CaptureNone && meta != nil {
+		config := h.client.Config()
 
-		if len(meta.prompts) > 0 {
-			prompt := strings.Join(meta.prompts, "\n---\n")
-			if len(prompt) > maxLen {
-				prompt = prompt[:maxLen] + "... [truncated]"
-			}
-			call.FullPrompt = prompt
+		if policy.CapturesPrompt() {
+			call.SetPrompt(ctx, config, prompt)
 		}
-
-		if res != nil && len(res.Choices) > 0 {
-			var responseParts []string
-			for _, choice := range res.Choices {
-				if choice.Content != "" {
-					responseParts = append(responseParts, choice.Content)
-				}
-			}
-			if len(responseParts) > 0 {
-				response := strings.Join(responseParts, "\n")
-				if len(response) > maxLen {
-					response = response[:maxLen] + "... [truncated]"
-				}
-				call.FullResponse = response
-			}
+		if policy.CapturesResponse() {
+			call.SetResponse(ctx, config, response)
 		}
 	}
 
@@ -233,7 +262,7 @@ func (h *DiagnyxHandler) HandleLLMError(ctx context.Context, err error) {
 	}
 
 	// Detect provider
-	provider := detectProvider(model)
+	provider := diagnyx.DetectProvider(model)
 
 	// Extract error details
 	errorMsg := err.Error()
@@ -249,6 +278,8 @@ func (h *DiagnyxHandler) HandleLLMError(ctx context.Context, err error) {
 		Status:         diagnyx.StatusError,
 		LatencyMs:      latencyMs,
 		ErrorMessage:   errorMsg,
+		SpanID:         runID,
+		ParentSpanID:   parentRunID(ctx, meta),
 		ProjectID:      h.projectID,
 		Environment:    h.environment,
 		UserIdentifier: h.userIdentifier,
@@ -318,36 +349,165 @@ func (h *DiagnyxHandler) HandleText(ctx context.Context, text string) {
 	// No-op for cost tracking
 }
 
+// contentCapturePolicy resolves the capture policy from the handler options,
+// falling back to the client's explicit CapturePolicy.
+func (h *DiagnyxHandler) contentCapturePolicy() diagnyx.CapturePolicy {
+	if h.capturePolicy != "" {
+		return h.capturePolicy
+	}
+	if h.captureContent {
+		return diagnyx.CaptureBoth
+	}
+	if policy := h.client.Config().CapturePolicy; policy != "" {
+		return policy
+	}
+	return diagnyx.CaptureNone
+}
+
 // getRunID extracts or generates a run ID from context.
 func (h *DiagnyxHandler) getRunID(ctx context.Context) string {
+	if h.runIDExtractor != nil {
+		if runID := h.runIDExtractor(ctx); runID != "" {
+			return runID
+		}
+		return h.idGenerator()
+	}
 	// Try to get run ID from context if available
 	if runID, ok := ctx.Value("run_id").(string); ok && runID != "" {
 		return runID
 	}
-	// Generate a new UUID if not found
-	return uuid.New().String()
+	// Generate a new ID if not found
+	return h.idGenerator()
+}
+
+// newRunID returns 128 random bits as hex
+func newRunID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// The system's random source failed; diagnyx.NewID falls back to a
+		// weaker one
+		return strings.ReplaceAll(diagnyx.NewID(), "-", "")
+	}
+	return hex.EncodeToString(b[:])
 }
 
-// detectProvider detects the LLM provider from the model name.
-func detectProvider(model string) diagnyx.Provider {
-	modelLower := strings.ToLower(model)
+// getParentRunID extracts the parent run ID from context, if any.
+func getParentRunID(ctx context.Context) string {
+	if parentRunID, ok := ctx.Value("parent_run_id").(string); ok {
+		return parentRunID
+	}
+	return ""
+}
 
-	providerPrefixes := map[string]diagnyx.Provider{
-		"gpt-":     diagnyx.ProviderOpenAI,
-		"o1-":      diagnyx.ProviderOpenAI,
-		"claude-":  diagnyx.ProviderAnthropic,
-		"gemini-":  diagnyx.ProviderGoogle,
-		"command":  diagnyx.ProviderCustom, // Cohere
-		"mistral":  diagnyx.ProviderCustom,
-		"mixtral":  diagnyx.ProviderCustom,
-		"llama":    diagnyx.ProviderCustom,
+// parentRunID returns the parent run ID recorded at start, falling back to
+// the context of the end callback.
+func parentRunID(ctx context.Context, meta *callMeta) string {
+	if meta != nil && meta.parentRunID != "" {
+		return meta.parentRunID
 	}
+	return getParentRunID(ctx)
+}
 
-	for prefix, provider := range providerPrefixes {
-		if strings.HasPrefix(modelLower, prefix) {
-			return provider
+// usageFields returns the GenerationInfo keys holding token counts for a
+// provider, defaulting to langchaingo's OpenAI-style names
+func usageFields(provider diagnyx.Provider) (input, output string) {
+	input, output = "PromptTokens", "CompletionTokens"
+	if info, ok := diagnyx.DefaultProviderRegistry.Lookup(provider); ok {
+		if info.InputTokensField != "" {
+			input = info.InputTokensField
+		}
+		if info.OutputTokensField != "" {
+			output = info.OutputTokensField
 		}
 	}
+	return input, output
+}
+
+// GenerationInfo keys that hold token counts across langchaingo backends,
+// tried after the provider's own InputTokensField/OutputTokensField
+var (
+	inputTokenKeys  = []string{"PromptTokens", "InputTokens", "prompt_tokens", "input_tokens", "promptTokens", "inputTokens"}
+	outputTokenKeys = []string{"CompletionTokens", "OutputTokens", "completion_tokens", "output_tokens", "completionTokens", "outputTokens"}
+	totalTokenKeys  = []string{"TotalTokens", "total_tokens", "totalTokens"}
+)
 
-	return diagnyx.ProviderCustom
+// extractUsage reads token counts from the choices' GenerationInfo. When
+// several choices report usage the last one wins. A missing output count
+// is derived from a total count if both total and input are present.
+func extractUsage(provider diagnyx.Provider, choices []*llms.ContentChoice) (input, output int, found bool) {
+	inputField, outputField := usageFields(provider)
+	inputKeys := append([]string{inputField}, inputTokenKeys...)
+	outputKeys := append([]string{outputField}, outputTokenKeys...)
+
+	for _, choice := range choices {
+		if choice == nil || choice.GenerationInfo == nil {
+			continue
+		}
+		in, inOK := lookupTokens(choice.GenerationInfo, inputKeys)
+		out, outOK := lookupTokens(choice.GenerationInfo, outputKeys)
+		if inOK && !outOK {
+			if total, ok := lookupTokens(choice.GenerationInfo, totalTokenKeys); ok && total >= in {
+				out, outOK = total-in, true
+			}
+		}
+		if inOK {
+			input, found = in, true
+		}
+		if outOK {
+			output, found = out, true
+		}
+	}
+	return input, output, found
+}
+
+// lookupTokens returns the first of keys holding an integer or float count
+func lookupTokens(info map[string]any, keys []string) (int, bool) {
+	for _, key := range keys {
+		switch v := info[key].(type) {
+		case int:
+			return v, true
+		case int32:
+			return int(v), true
+		case int64:
+			return int(v), true
+		case float64:
+			return int(v), true
+		case float32:
+			return int(v), true
+		}
+	}
+	return 0, false
+}
+
+// responseText joins the non-empty contents of choices
+func responseText(choices []*llms.ContentChoice) string {
+	var parts []string
+	for _, choice := range choices {
+		if choice != nil && choice.Content != "" {
+			parts = append(parts, choice.Content)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// responseTruncated reports whether any choice stopped at the output token
+// limit, per its StopReason or a finish/stop reason in GenerationInfo
+func responseTruncated(choices []*llms.ContentChoice) bool {
+	for _, choice := range choices {
+		if choice == nil {
+			continue
+		}
+		reasons := []string{choice.StopReason}
+		for _, key := range []string{"finish_reason", "stop_reason", "StopReason"} {
+			if reason, ok := choice.GenerationInfo[key].(string); ok {
+				reasons = append(reasons, reason)
+			}
+		}
+		for _, reason := range reasons {
+			if diagnyx.IsLengthFinishReason(reason) {
+				return true
+			}
+		}
+	}
+	return false
 }
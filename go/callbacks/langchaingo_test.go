@@ -30,10 +30,40 @@ func (m *mockClient) Track(call diagnyx.LLMCall) {
 	m.calls = append(m.calls, call)
 }
 
+func (m *mockClient) TrackCalls(calls []diagnyx.LLMCall) {
+	m.calls = append(m.calls, calls...)
+}
+
 func (m *mockClient) Config() diagnyx.Config {
 	return m.config
 }
 
+func (m *mockClient) Flush() error {
+	return nil
+}
+
+func TestHandlerWithMockClient(t *testing.T) {
+	client := newMockClient()
+	handler := NewDiagnyxHandler(client, WithProjectID("test-project"))
+
+	ctx := context.Background()
+	handler.HandleLLMStart(ctx, []string{"Hello"})
+	handler.HandleLLMGenerateContentEnd(ctx, &llms.ContentResponse{
+		Choices: []*llms.ContentChoice{{
+			Content:        "Hi",
+			GenerationInfo: map[string]any{"PromptTokens": 10, "CompletionTokens": 5},
+		}},
+	})
+
+	if len(client.calls) != 1 {
+		t.Fatalf("expected 1 tracked call, got %d", len(client.calls))
+	}
+	call := client.calls[0]
+	if call.ProjectID != "test-project" || call.InputTokens != 10 || call.OutputTokens != 5 {
+		t.Errorf("unexpected tracked call: %+v", call)
+	}
+}
+
 func TestNewDiagnyxHandler(t *testing.T) {
 	client := diagnyx.NewClient("test-key")
 	defer client.Close()
@@ -92,9 +122,9 @@ func TestDetectProvider(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.model, func(t *testing.T) {
-			result := detectProvider(tt.model)
+			result := diagnyx.DetectProvider(tt.model)
 			if result != tt.expected {
-				t.Errorf("detectProvider(%s) = %s, expected %s", tt.model, result, tt.expected)
+				t.Errorf("DetectProvider(%s) = %s, expected %s", tt.model, result, tt.expected)
 			}
 		})
 	}
@@ -290,3 +320,176 @@ func TestTextCallbackIsNoOp(t *testing.T) {
 	// This should not panic
 	handler.HandleText(ctx, "some text")
 }
+
+// newTestClient creates a client whose buffer is discarded on cleanup so
+// tests can inspect tracked calls without sending them.
+func newTestClient(t *testing.T, config diagnyx.Config) *diagnyx.Client {
+	t.Helper()
+	config.APIKey = "test-key"
+	config.FlushIntervalMs = 60000
+	client := diagnyx.NewClientWithConfig(config)
+	t.Cleanup(func() {
+		client.ClearBuffer()
+		client.Close()
+	})
+	return client
+}
+
+func TestParentRunID(t *testing.T) {
+	client := newTestClient(t, diagnyx.Config{})
+	handler := NewDiagnyxHandler(client)
+
+	ctx := context.WithValue(context.Background(), "run_id", "run-child")
+	ctx = context.WithValue(ctx, "parent_run_id", "run-parent")
+
+	handler.HandleLLMStart(ctx, []string{"Hello"})
+	handler.HandleLLMGenerateContentEnd(ctx, &llms.ContentResponse{})
+
+	calls := client.PeekBuffer()
+	if len(calls) != 1 {
+		t.Fatalf("expected 1 tracked call, got %d", len(calls))
+	}
+	if calls[0].ParentSpanID != "run-parent" {
+		t.Errorf("expected parent span ID 'run-parent', got '%s'", calls[0].ParentSpanID)
+	}
+}
+
+func TestNestedRunSpans(t *testing.T) {
+	client := newMockClient()
+	handler := NewDiagnyxHandler(client)
+
+	parent := context.WithValue(context.Background(), "run_id", "run-parent")
+	child := context.WithValue(context.Background(), "run_id", "run-child")
+	child = context.WithValue(child, "parent_run_id", "run-parent")
+
+	handler.HandleLLMStart(parent, []string{"Plan"})
+	handler.HandleLLMStart(child, []string{"Search"})
+	handler.HandleLLMError(child, errors.New("tool failed"))
+	handler.HandleLLMGenerateContentEnd(parent, &llms.ContentResponse{})
+
+	if len(client.calls) != 2 {
+		t.Fatalf("expected 2 tracked calls, got %d", len(client.calls))
+	}
+	childCall, parentCall := client.calls[0], client.calls[1]
+	if parentCall.SpanID != "run-parent" || parentCall.ParentSpanID != "" {
+		t.Errorf("unexpected parent span IDs: %q/%q", parentCall.SpanID, parentCall.ParentSpanID)
+	}
+	if childCall.SpanID != "run-child" || childCall.ParentSpanID != parentCall.SpanID {
+		t.Errorf("expected the child to link to the parent span, got %q/%q", childCall.SpanID, childCall.ParentSpanID)
+	}
+}
+
+type correlationKey struct{}
+
+func TestWithRunIDExtractor(t *testing.T) {
+	client := newMockClient()
+	handler := NewDiagnyxHandler(client, WithRunIDExtractor(func(ctx context.Context) string {
+		id, _ := ctx.Value(correlationKey{}).(string)
+		return id
+	}))
+
+	ctx := context.WithValue(context.Background(), correlationKey{}, "corr-1")
+	handler.HandleLLMStart(ctx, []string{"Hello"})
+
+	handler.mu.Lock()
+	_, started := handler.callStarts["corr-1"]
+	handler.mu.Unlock()
+	if !started {
+		t.Fatal("expected the call to be keyed by the extracted run ID")
+	}
+
+	handler.HandleLLMGenerateContentEnd(ctx, &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "Hi"}}})
+	if len(client.calls) != 1 {
+		t.Errorf("expected 1 tracked call, got %d", len(client.calls))
+	}
+	if len(handler.callStarts) != 0 {
+		t.Error("expected the start entry to be removed at the end of the call")
+	}
+}
+
+func TestWithIDGenerator(t *testing.T) {
+	handler := NewDiagnyxHandler(newMockClient(), WithIDGenerator(func() string { return "gen-1" }))
+	handler.HandleLLMStart(context.Background(), []string{"Hello"})
+
+	handler.mu.Lock()
+	_, started := handler.callStarts["gen-1"]
+	handler.mu.Unlock()
+	if !started {
+		t.Fatal("expected the call to be keyed by the generated run ID")
+	}
+
+	handler = NewDiagnyxHandler(newMockClient(), WithIDGenerator(nil))
+	if id := handler.getRunID(context.Background()); len(id) != 32 {
+		t.Errorf("expected a nil generator to keep the default, got %q", id)
+	}
+
+	id := newRunID()
+	if len(id) != 32 || id == newRunID() {
+		t.Errorf("expected 32 random hex characters, got %q", id)
+	}
+}
+
+func TestHandleLLMEndTruncated(t *testing.T) {
+	mock := newMockClient()
+	handler := NewDiagnyxHandler(mock)
+	ctx := context.Background()
+
+	handler.HandleLLMGenerateContentStart(ctx, nil)
+	handler.HandleLLMGenerateContentEnd(ctx, &llms.ContentResponse{
+		Choices: []*llms.ContentChoice{{Content: "Hi", StopReason: "length"}},
+	})
+	handler.HandleLLMGenerateContentStart(ctx, nil)
+	handler.HandleLLMGenerateContentEnd(ctx, &llms.ContentResponse{
+		Choices: []*llms.ContentChoice{{Content: "Hi", GenerationInfo: map[string]any{"stop_reason": "max_tokens"}}},
+	})
+	handler.HandleLLMGenerateContentStart(ctx, nil)
+	handler.HandleLLMGenerateContentEnd(ctx, &llms.ContentResponse{
+		Choices: []*llms.ContentChoice{{Content: "Hi", StopReason: "stop"}},
+	})
+
+	if len(mock.calls) != 3 {
+		t.Fatalf("expected 3 calls, got %d", len(mock.calls))
+	}
+	if mock.calls[0].Metadata["truncated"] != true || mock.calls[1].Metadata["truncated"] != true {
+		t.Errorf("expected length stops to be marked truncated, got %v and %v", mock.calls[0].Metadata, mock.calls[1].Metadata)
+	}
+	if mock.calls[2].Metadata["truncated"] != nil {
+		t.Errorf("expected normal stop not to be marked truncated, got %v", mock.calls[2].Metadata)
+	}
+}
+
+func TestHandleLLMEndTokenExtraction(t *testing.T) {
+	tests := []struct {
+		name          string
+		info          map[string]any
+		input, output int
+		wantEstimated bool
+	}{
+		{"int keys", map[string]any{"PromptTokens": 10, "CompletionTokens": 5}, 10, 5, false},
+		{"float JSON values", map[string]any{"prompt_tokens": float64(12), "completion_tokens": float64(3)}, 12, 3, false},
+		{"int64 input/output keys", map[string]any{"InputTokens": int64(7), "OutputTokens": int64(2)}, 7, 2, false},
+		{"total only", map[string]any{"PromptTokens": 10, "TotalTokens": 25}, 10, 15, false},
+		{"nothing reported", nil, 3, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := newMockClient()
+			handler := NewDiagnyxHandler(mock, WithRunIDExtractor(func(context.Context) string { return "run-1" }))
+			ctx := context.Background()
+
+			handler.HandleLLMStart(ctx, []string{"Hello there!"})
+			handler.HandleLLMGenerateContentEnd(ctx, &llms.ContentResponse{
+				Choices: []*llms.ContentChoice{{Content: "Hi", GenerationInfo: tt.info}},
+			})
+
+			call := mock.calls[0]
+			if call.InputTokens != tt.input || call.OutputTokens != tt.output {
+				t.Errorf("expected %d/%d tokens, got %d/%d", tt.input, tt.output, call.InputTokens, call.OutputTokens)
+			}
+			if estimated := call.Metadata["tokens_estimated"] == true; estimated != tt.wantEstimated {
+				t.Errorf("expected tokens_estimated %v, got %v", tt.wantEstimated, call.Metadata)
+			}
+		})
+	}
+}
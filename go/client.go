@@ -2,10 +2,21 @@ package diagnyx
 
 import (
 	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	mrand "math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,10 +25,23 @@ type Client struct {
 	config      Config
 	httpClient  *http.Client
 	buffer      []LLMCall
+	traces      map[string][]LLMCall
+	errorCount  int
+	oldestCall  time.Time
+	closed      bool
 	bufferMu    sync.Mutex
+	latencies   *latencyRing
+	calls       atomic.Int64
+	cacheHits   atomic.Int64
+	usage       *usageAccumulator
+	audit       *auditLog
 	flushTicker *time.Ticker
 	done        chan struct{}
 	wg          sync.WaitGroup
+	traceWG     sync.WaitGroup
+	// inflight counts flushes and trace sends running in the background
+	inflight atomic.Int32
+	flushes  flushQueue
 }
 
 // NewClient creates a new Diagnyx client
@@ -30,6 +54,9 @@ func NewClientWithConfig(config Config) *Client {
 	if config.APIKey == "" {
 		panic("diagnyx: api_key is required")
 	}
+	if config.Debug {
+		debugLogging.Store(true)
+	}
 	if config.BaseURL == "" {
 		config.BaseURL = "https://api.diagnyx.io"
 	}
@@ -42,104 +69,645 @@ func NewClientWithConfig(config Config) *Client {
 	if config.MaxRetries == 0 {
 		config.MaxRetries = 3
 	}
+	if config.RetryPolicy == nil {
+		config.RetryPolicy = DefaultRetryPolicy()
+	}
+	if config.LatencySampleSize <= 0 {
+		config.LatencySampleSize = 1000
+	}
+	if config.MaxTokenTimingSamples == 0 {
+		config.MaxTokenTimingSamples = 1000
+	}
+	if config.MetadataOffloadBytes == 0 {
+		config.MetadataOffloadBytes = 8192
+	}
+	if config.MaxIdleConns == 0 {
+		config.MaxIdleConns = 10
+	}
+	if config.MaxIdleConnsPerHost == 0 {
+		config.MaxIdleConnsPerHost = 4
+	}
+	if config.IdleConnTimeout == 0 {
+		config.IdleConnTimeout = 90 * time.Second
+	}
+	if config.AuditBufferSize == 0 {
+		config.AuditBufferSize = 1000
+	}
+	if config.Providers == nil {
+		config.Providers = DefaultProviderRegistry
+	}
+	if config.Environment == EnvironmentAuto {
+		config.Environment = DetectEnvironment()
+	}
+	var codecErr error
+	if config.Codec == nil {
+		config.Codec, codecErr = codecFor(config.Encoding)
+		if codecErr != nil {
+			config.Encoding = EncodingJSON
+			config.Codec = JSONCodec{}
+		}
+	}
 
 	c := &Client{
-		config: config,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		buffer: make([]LLMCall, 0, config.BatchSize),
-		done:   make(chan struct{}),
+		config:     config,
+		httpClient: newHTTPClient(config),
+		buffer:     make([]LLMCall, 0, config.BatchSize),
+		traces:     make(map[string][]LLMCall),
+		latencies:  newLatencyRing(config.LatencySampleSize),
+		usage:      newUsageAccumulator(),
+		done:       make(chan struct{}),
 	}
 
+	if codecErr != nil {
+		c.log("Invalid Encoding, sending JSON: %v", codecErr)
+	}
+	c.audit = newAuditLog(config.AuditWriter, config.AuditBufferSize, c.log)
 	c.startFlushTimer()
 	return c
 }
 
-// Track records a single LLM call
+// ErrClientClosed is passed to Config.OnError for calls tracked after Close
+var ErrClientClosed = errors.New("diagnyx: client is closed")
+
+// ErrOrganizationRequired is passed to Config.OnError, or returned by the
+// synchronous methods, for calls without an organization when
+// Config.RequireOrganizationID is set
+var ErrOrganizationRequired = errors.New("diagnyx: organization ID is required")
+
+// ErrMissingTimestamp is passed to Config.OnError, or returned by
+// TrackAndFlush, for calls without a Timestamp when
+// Config.PreserveTimestamps is set
+var ErrMissingTimestamp = errors.New("diagnyx: call has no timestamp")
+
+// ErrCallTooOld is passed to Config.OnError for calls dropped for staying
+// in the buffer past the hard limit derived from MaxCallAge
+var ErrCallTooOld = errors.New("diagnyx: call exceeded max age")
+
+// callAgeDropFactor multiplies Config.MaxCallAge to get the age at which
+// buffered calls are dropped instead of sent
+const callAgeDropFactor = 3
+
+// ErrBufferFull is passed to Config.OnError for calls dropped because the
+// buffer reached MaxBufferSize
+var ErrBufferFull = errors.New("diagnyx: buffer is full")
+
+// ErrSendVetoed is wrapped, along with the hook's error, by sends that
+// Config.BeforeSend refused. Vetoed calls are passed to Config.OnError
+// instead of being put back in the buffer.
+var ErrSendVetoed = errors.New("diagnyx: send vetoed by BeforeSend")
+
+var nilClientWarning sync.Once
+
+// debugLogging is set once a client is created with Config.Debug. A nil
+// *Client has no config, so its warning follows the other clients' Debug.
+var debugLogging atomic.Bool
+
+// warnNilClient logs, once per process and only if a client was created
+// with Config.Debug, that calls were tracked on a nil *Client and discarded
+func warnNilClient() {
+	if !debugLogging.Load() {
+		return
+	}
+	nilClientWarning.Do(func() {
+		logf("Track called on a nil *Client, calls are discarded")
+	})
+}
+
+// newHTTPClient returns config.HTTPClient, or a client whose transport keeps
+// a small pool of connections alive between flushes
+func newHTTPClient(config Config) *http.Client {
+	if config.HTTPClient != nil {
+		return config.HTTPClient
+	}
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if ok {
+		transport = transport.Clone()
+	} else {
+		// http.DefaultTransport was wrapped or replaced, e.g. by
+		// instrumentation; start from the standard library's defaults
+		transport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		}
+	}
+	transport.MaxIdleConns = config.MaxIdleConns
+	transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = config.IdleConnTimeout
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	}
+}
+
+// Track records a single LLM call, subject to the configured Sampler.
+// Calling it on a nil *Client is a no-op; after Close the call is passed to
+// Config.OnError with ErrClientClosed. An empty Provider is inferred from
+// the Model with DetectProvider; an explicit Provider is never overridden.
 func (c *Client) Track(call LLMCall) {
-	if call.Timestamp.IsZero() {
-		call.Timestamp = time.Now().UTC()
+	if c == nil {
+		warnNilClient()
+		return
+	}
+	if !c.ShouldTrack(call) {
+		return
+	}
+	c.trackSampled(call)
+}
+
+// TryTrack records a call like Track and reports whether it was accepted.
+// It returns false if the call was dropped because the buffer is full (see
+// Config.MaxBufferSize) or the client is closed. Calls discarded by the
+// Sampler count as accepted.
+func (c *Client) TryTrack(call LLMCall) bool {
+	if c == nil {
+		warnNilClient()
+		return false
 	}
+	if !c.ShouldTrack(call) {
+		return true
+	}
+	return c.trackSampled(call)
+}
+
+// trackSampled records a call that has already passed sampling and reports
+// whether it was buffered
+func (c *Client) trackSampled(call LLMCall) bool {
+	if err := c.validateCall(call); err != nil {
+		c.deadLetter(err, []LLMCall{call})
+		return false
+	}
+
+	now := time.Now().UTC()
+	c.prepareCall(&call, now)
 
 	c.bufferMu.Lock()
-	c.buffer = append(c.buffer, call)
-	shouldFlush := len(c.buffer) >= c.config.BatchSize
+	if c.closed {
+		c.bufferMu.Unlock()
+		c.deadLetter(ErrClientClosed, []LLMCall{call})
+		return false
+	}
+	if c.bufferSpaceLocked() == 0 {
+		c.bufferMu.Unlock()
+		c.deadLetter(ErrBufferFull, []LLMCall{call})
+		return false
+	}
+	c.audit.record(call)
+	ready := c.bufferLocked(call)
+	shouldFlush := c.shouldFlushLocked()
 	c.bufferMu.Unlock()
 
+	c.recordStats(now, call)
+
+	c.sendTraces(ready)
 	if shouldFlush {
-		go c.Flush()
+		c.flushAsync()
+	}
+	return true
+}
+
+// validateCall returns the reason call must be rejected before it is
+// accepted, or nil: a missing organization under RequireOrganizationID or
+// a zero Timestamp under PreserveTimestamps
+func (c *Client) validateCall(call LLMCall) error {
+	if c.config.RequireOrganizationID && c.config.OrganizationID == "" && call.OrganizationID == "" {
+		return ErrOrganizationRequired
+	}
+	if c.config.PreserveTimestamps && call.Timestamp.IsZero() {
+		return ErrMissingTimestamp
+	}
+	return nil
+}
+
+// bufferSpaceLocked returns how many more calls fit in the buffer, or -1 if
+// it is unbounded. Callers must hold bufferMu.
+func (c *Client) bufferSpaceLocked() int {
+	if c.config.MaxBufferSize <= 0 {
+		return -1
+	}
+	space := c.config.MaxBufferSize - len(c.buffer) - c.heldTraceCallsLocked()
+	if space < 0 {
+		return 0
+	}
+	return space
+}
+
+// rebufferLocked puts calls that failed to send back in the buffer, ahead
+// of the calls buffered since if front is set, and returns those that no
+// longer fit under MaxBufferSize. Callers must hold bufferMu.
+func (c *Client) rebufferLocked(failed []LLMCall, front bool) []LLMCall {
+	var overflow []LLMCall
+	if space := c.bufferSpaceLocked(); space >= 0 && space < len(failed) {
+		failed, overflow = failed[:space:space], failed[space:]
+	}
+	if front {
+		c.buffer = append(failed, c.buffer...)
+	} else {
+		c.buffer = append(c.buffer, failed...)
 	}
+	c.noteBufferedLocked(failed...)
+	c.errorCount += countErrors(failed)
+	return overflow
 }
 
-// TrackCalls records multiple LLM calls
+// TrackCalls records multiple LLM calls, subject to the configured Sampler.
+// Like Track, it is a no-op on a nil *Client.
 func (c *Client) TrackCalls(calls []LLMCall) {
+	if c == nil {
+		warnNilClient()
+		return
+	}
+	if c.config.Sampler != nil {
+		sampled := make([]LLMCall, 0, len(calls))
+		for _, call := range calls {
+			if c.ShouldTrack(call) {
+				sampled = append(sampled, call)
+			}
+		}
+		calls = sampled
+	}
+	if c.config.RequireOrganizationID || c.config.PreserveTimestamps {
+		valid := make([]LLMCall, 0, len(calls))
+		for _, call := range calls {
+			if err := c.validateCall(call); err != nil {
+				c.deadLetter(err, []LLMCall{call})
+				continue
+			}
+			valid = append(valid, call)
+		}
+		calls = valid
+	}
+
 	now := time.Now().UTC()
 	for i := range calls {
-		if calls[i].Timestamp.IsZero() {
-			calls[i].Timestamp = now
-		}
+		c.prepareCall(&calls[i], now)
 	}
 
 	c.bufferMu.Lock()
-	c.buffer = append(c.buffer, calls...)
-	shouldFlush := len(c.buffer) >= c.config.BatchSize
+	if c.closed {
+		c.bufferMu.Unlock()
+		c.deadLetter(ErrClientClosed, calls)
+		return
+	}
+	var overflow []LLMCall
+	if space := c.bufferSpaceLocked(); space >= 0 && space < len(calls) {
+		calls, overflow = calls[:space], calls[space:]
+	}
+	c.audit.record(calls...)
+	ready := c.bufferLocked(calls...)
+	shouldFlush := c.shouldFlushLocked()
 	c.bufferMu.Unlock()
 
+	for _, call := range calls {
+		c.recordStats(now, call)
+	}
+	c.deadLetter(ErrBufferFull, overflow)
+	c.sendTraces(ready)
 	if shouldFlush {
-		go c.Flush()
+		c.flushAsync()
 	}
 }
 
-// Flush sends all buffered calls to the API
-func (c *Client) Flush() error {
+// TrackAndFlush sends a single call synchronously, bypassing the buffer and
+// the Sampler, and returns the server's result. Use it where a call must be
+// delivered before returning, such as at the end of a serverless handler;
+// it costs a full round trip, including retries, on the caller's goroutine.
+// If ctx has a deadline it bounds the whole operation; each attempt is still
+// limited by the client's 30s per-request timeout. Buffered calls are not
+// affected.
+func (c *Client) TrackAndFlush(ctx context.Context, call LLMCall) (*BatchResponse, error) {
 	c.bufferMu.Lock()
-	if len(c.buffer) == 0 {
-		c.bufferMu.Unlock()
-		return nil
-	}
-	calls := make([]LLMCall, len(c.buffer))
-	copy(calls, c.buffer)
-	c.buffer = c.buffer[:0]
+	closed := c.closed
 	c.bufferMu.Unlock()
+	if closed {
+		return nil, ErrClientClosed
+	}
+	if err := c.validateCall(call); err != nil {
+		return nil, err
+	}
 
-	err := c.sendBatch(calls)
-	if err != nil {
-		// On error, put calls back in buffer
+	now := time.Now().UTC()
+	c.prepareCall(&call, now)
+	c.recordStats(now, call)
+	c.audit.record(call)
+
+	return c.send(ctx, []LLMCall{call}, nil)
+}
+
+// deadLetter hands calls that will not be sent to Config.OnError
+func (c *Client) deadLetter(err error, calls []LLMCall) {
+	if len(calls) == 0 {
+		return
+	}
+	c.log("Dropping %d calls: %v", len(calls), err)
+	c.reportError(err, calls)
+}
+
+// bufferLocked appends calls to the buffer or, with FlushByTrace, to their
+// trace's group. It returns the trace groups that ended and are ready to be
+// sent. Callers must hold bufferMu.
+func (c *Client) bufferLocked(calls ...LLMCall) [][]LLMCall {
+	var ready [][]LLMCall
+	now := time.Now()
+	for _, call := range calls {
+		call.bufferedAt = now
+		if !c.config.FlushByTrace || call.TraceID == "" {
+			c.buffer = append(c.buffer, call)
+			c.noteBufferedLocked(call)
+			if call.Status == StatusError {
+				c.errorCount++
+			}
+			continue
+		}
+
+		trace := append(c.traces[call.TraceID], call)
+		if call.EndOfTrace || len(trace) >= c.config.BatchSize {
+			delete(c.traces, call.TraceID)
+			ready = append(ready, trace)
+			continue
+		}
+		c.traces[call.TraceID] = trace
+	}
+	return ready
+}
+
+// sendTraces sends each completed trace in its own batch, in the background
+// unless SyncFlush is set. Traces that fail to send are moved to the buffer
+// so the regular flush retries them.
+func (c *Client) sendTraces(traces [][]LLMCall) {
+	for _, trace := range traces {
+		if c.config.SyncFlush {
+			c.sendTrace(trace)
+			continue
+		}
+		c.traceWG.Add(1)
+		c.inflight.Add(1)
+		go func(trace []LLMCall) {
+			defer c.traceWG.Done()
+			defer c.inflight.Add(-1)
+			c.sendTrace(trace)
+		}(trace)
+	}
+}
+
+func (c *Client) sendTrace(trace []LLMCall) {
+	if err := c.sendBatch(trace); err != nil {
+		c.log("Trace flush failed: %v", err)
+		failed := failedCalls(trace, err)
 		c.bufferMu.Lock()
-		c.buffer = append(calls, c.buffer...)
+		overflow := c.rebufferLocked(failed, false)
 		c.bufferMu.Unlock()
-		c.log("Flush failed: %v", err)
-		return err
+		c.deadLetter(ErrBufferFull, overflow)
+		vetoed, vetoErr := vetoedCalls(trace, err)
+		c.deadLetter(vetoErr, vetoed)
+		return
 	}
+	c.log("Flushed trace %s (%d calls)", trace[0].TraceID, len(trace))
+}
 
-	c.log("Flushed %d calls", len(calls))
-	return nil
+// releaseTracesLocked moves calls held for unfinished traces into the
+// buffer. Callers must hold bufferMu.
+func (c *Client) releaseTracesLocked() {
+	for traceID, trace := range c.traces {
+		c.buffer = append(c.buffer, trace...)
+		c.noteBufferedLocked(trace...)
+		c.errorCount += countErrors(trace)
+		delete(c.traces, traceID)
+	}
+}
+
+// noteBufferedLocked keeps oldestCall up to date for calls added to the
+// buffer. Callers must hold bufferMu.
+func (c *Client) noteBufferedLocked(calls ...LLMCall) {
+	for i := range calls {
+		if c.oldestCall.IsZero() || calls[i].bufferedAt.Before(c.oldestCall) {
+			c.oldestCall = calls[i].bufferedAt
+		}
+	}
+}
+
+// oldestCallAge returns how long the oldest buffered call has been
+// buffered, or 0 if the buffer is empty
+func (c *Client) oldestCallAge() time.Duration {
+	c.bufferMu.Lock()
+	oldest := c.oldestCall
+	c.bufferMu.Unlock()
+	if oldest.IsZero() {
+		return 0
+	}
+	return time.Since(oldest)
+}
+
+// dropStale removes calls buffered for longer than the MaxCallAge hard
+// limit from calls and passes them to OnError
+func (c *Client) dropStale(calls []LLMCall) []LLMCall {
+	if c.config.MaxCallAge <= 0 {
+		return calls
+	}
+	cutoff := time.Now().Add(-callAgeDropFactor * c.config.MaxCallAge)
+	fresh := calls[:0]
+	var stale []LLMCall
+	for _, call := range calls {
+		if call.bufferedAt.Before(cutoff) {
+			stale = append(stale, call)
+			continue
+		}
+		fresh = append(fresh, call)
+	}
+	c.deadLetter(ErrCallTooOld, stale)
+	return fresh
+}
+
+// heldTraceCallsLocked returns the number of calls held for unfinished
+// traces. Callers must hold bufferMu.
+func (c *Client) heldTraceCallsLocked() int {
+	n := 0
+	for _, trace := range c.traces {
+		n += len(trace)
+	}
+	return n
+}
+
+// shouldFlushLocked reports whether the buffer has reached a flush threshold.
+// Callers must hold bufferMu.
+func (c *Client) shouldFlushLocked() bool {
+	if len(c.buffer) >= c.config.BatchSize {
+		return true
+	}
+	return c.config.FlushOnErrorCount > 0 && c.errorCount >= c.config.FlushOnErrorCount
+}
+
+// countErrors returns the number of error-status calls in calls
+func countErrors(calls []LLMCall) int {
+	n := 0
+	for i := range calls {
+		if calls[i].Status == StatusError {
+			n++
+		}
+	}
+	return n
 }
 
-// BufferSize returns the current number of buffered calls
+// prepareCall fills in defaults for fields the caller left empty
+func (c *Client) prepareCall(call *LLMCall, now time.Time) {
+	if call.Provider == "" && call.Model != "" {
+		call.Provider = DetectProvider(call.Model)
+	}
+	if call.Timestamp.IsZero() {
+		call.Timestamp = now
+	}
+	if c.config.AutoGenerateTraceID && call.TraceID == "" && call.SpanID == "" {
+		call.TraceID = NewTraceID()
+		call.SpanID = NewSpanID()
+	}
+	if call.ExperimentID == "" {
+		call.ExperimentID = c.config.DefaultExperimentID
+	}
+	if call.Environment == "" {
+		call.Environment = c.config.Environment
+	}
+	if c.config.ModelNormalizer != nil {
+		model := call.Model
+		c.runCallback("ModelNormalizer", func() { model = c.config.ModelNormalizer(call.Provider, call.Model) })
+		if model != call.Model {
+			call.Metadata = withMetadata(call.Metadata, "raw_model", call.Model)
+			call.Model = model
+		}
+	}
+	c.offloadMetadata(call)
+}
+
+// Flush sends all buffered calls to the API. If the server rejects some of
+// the calls, it returns a *PartialFlushError; see FlushWithResult.
+func (c *Client) Flush() error {
+	_, err := c.FlushWithResult()
+	return err
+}
+
+// flushAsync flushes the buffer in the background, coalesced with other
+// background flushes, or inline with SyncFlush
+func (c *Client) flushAsync() {
+	c.FlushAsync()
+}
+
+// Drain blocks until no background flush is running and the buffer is
+// empty, flushing any remaining calls itself, or until ctx is done. Calls
+// held for unfinished traces (see Config.FlushByTrace) are not waited for.
+// A flush already under way finishes its retries before ctx is checked.
+func (c *Client) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if c.inflight.Load() == 0 {
+			c.bufferMu.Lock()
+			empty := len(c.buffer) == 0
+			c.bufferMu.Unlock()
+			if empty {
+				return nil
+			}
+			if err := c.Flush(); err == nil {
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// BufferSize returns the current number of buffered calls, including calls
+// held for unfinished traces
 func (c *Client) BufferSize() int {
 	c.bufferMu.Lock()
 	defer c.bufferMu.Unlock()
-	return len(c.buffer)
+	return len(c.buffer) + c.heldTraceCallsLocked()
+}
+
+// PeekBuffer returns a copy of the currently buffered calls. The copy is
+// taken under the buffer lock so callers never see the internal slice;
+// use it to dump pending calls when flushes keep failing.
+func (c *Client) PeekBuffer() []LLMCall {
+	c.bufferMu.Lock()
+	defer c.bufferMu.Unlock()
+	calls := make([]LLMCall, len(c.buffer), len(c.buffer)+c.heldTraceCallsLocked())
+	copy(calls, c.buffer)
+	for _, trace := range c.traces {
+		calls = append(calls, trace...)
+	}
+	return calls
+}
+
+// ClearBuffer discards all buffered calls without sending them and returns
+// the number discarded
+func (c *Client) ClearBuffer() int {
+	c.bufferMu.Lock()
+	defer c.bufferMu.Unlock()
+	n := len(c.buffer) + c.heldTraceCallsLocked()
+	c.buffer = c.buffer[:0]
+	c.oldestCall = time.Time{}
+	c.traces = make(map[string][]LLMCall)
+	c.errorCount = 0
+	return n
 }
 
-// Close shuts down the client and flushes remaining calls
+// Close shuts down the client and flushes remaining calls, including calls
+// held for unfinished traces. Calls tracked after Close are not buffered.
+// Closing an already closed client is a no-op.
 func (c *Client) Close() error {
+	c.bufferMu.Lock()
+	if c.closed {
+		c.bufferMu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.bufferMu.Unlock()
+
 	close(c.done)
 	if c.flushTicker != nil {
 		c.flushTicker.Stop()
 	}
 	c.wg.Wait()
-	return c.Flush()
+	c.traceWG.Wait()
+
+	c.bufferMu.Lock()
+	c.releaseTracesLocked()
+	c.bufferMu.Unlock()
+	err := c.Flush()
+	c.audit.close()
+	return err
 }
 
 func (c *Client) startFlushTimer() {
 	c.flushTicker = time.NewTicker(time.Duration(c.config.FlushIntervalMs) * time.Millisecond)
 	c.wg.Add(1)
 
+	// With MaxCallAge, check the oldest call's age a few times per
+	// MaxCallAge so it is flushed soon after crossing it
+	var ageCheck <-chan time.Time
+	var ageTicker *time.Ticker
+	if c.config.MaxCallAge > 0 {
+		interval := c.config.MaxCallAge / 4
+		if interval <= 0 {
+			interval = c.config.MaxCallAge
+		}
+		ageTicker = time.NewTicker(interval)
+		ageCheck = ageTicker.C
+	}
+
 	go func() {
 		defer c.wg.Done()
+		if ageTicker != nil {
+			defer ageTicker.Stop()
+		}
 		for {
 			select {
 			case <-c.flushTicker.C:
@@ -148,6 +716,12 @@ func (c *Client) startFlushTimer() {
 						c.log("Background flush error: %v", err)
 					}
 				}
+			case <-ageCheck:
+				if c.oldestCallAge() > c.config.MaxCallAge {
+					if err := c.Flush(); err != nil {
+						c.log("Max call age flush error: %v", err)
+					}
+				}
 			case <-c.done:
 				return
 			}
@@ -155,58 +729,244 @@ func (c *Client) startFlushTimer() {
 	}()
 }
 
+var (
+	hostnameOnce sync.Once
+	hostname     string
+)
+
+// cachedHostname returns the local hostname, looked up once. Failures yield
+// an empty string.
+func cachedHostname() string {
+	hostnameOnce.Do(func() {
+		hostname, _ = os.Hostname()
+	})
+	return hostname
+}
+
 func (c *Client) sendBatch(calls []LLMCall) error {
-	payload := BatchRequest{Calls: calls}
-	body, err := json.Marshal(payload)
+	_, err := c.send(context.Background(), calls, nil)
+	return err
+}
+
+// sentBatch identifies the calls of a sent batch
+type sentBatch struct {
+	id   string
+	size int
+}
+
+// batchID returns the BatchID of calls: the ID they were last sent under if
+// they are exactly that batch, or else a new ID, which is recorded on the
+// calls so that a re-buffered batch keeps it
+func batchID(calls []LLMCall) string {
+	if len(calls) > 0 {
+		if batch := calls[0].batch; batch != nil && batch.size == len(calls) {
+			same := true
+			for i := range calls {
+				if calls[i].batch != batch {
+					same = false
+					break
+				}
+			}
+			if same {
+				return batch.id
+			}
+		}
+	}
+	batch := &sentBatch{id: NewID(), size: len(calls)}
+	for i := range calls {
+		calls[i].batch = batch
+	}
+	return batch.id
+}
+
+// sendTo posts calls to the ingest endpoint at baseURL with retries and
+// returns the decoded server response. Extra headers are added to every
+// attempt.
+func (c *Client) sendTo(ctx context.Context, baseURL string, calls []LLMCall, header http.Header) (*BatchResponse, error) {
+	payload := BatchRequest{
+		Calls:      calls,
+		SDKVersion: "diagnyx-go/" + Version,
+		Host:       cachedHostname(),
+		BatchID:    batchID(calls),
+	}
+	if c.config.BeforeSend != nil {
+		var hookErr error
+		if err := c.runCallback("BeforeSend", func() { hookErr = c.config.BeforeSend(&payload) }); err != nil {
+			hookErr = err
+		}
+		if hookErr != nil {
+			return nil, fmt.Errorf("%w: %w", ErrSendVetoed, hookErr)
+		}
+	}
+	var body []byte
+	var err error
+	if panicErr := c.runCallback("Codec", func() { body, err = c.config.Codec.Marshal(&payload) }); panicErr != nil {
+		err = panicErr
+	}
 	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	contentType := c.config.Codec.ContentType()
+
+	if c.config.DryRun {
+		if c.config.OnFlush != nil {
+			c.runCallback("OnFlush", func() { c.config.OnFlush(payload) })
+		} else if contentType == "application/json" {
+			c.log("Dry run, not sending batch: %s", body)
+		} else {
+			c.log("Dry run, not sending %d-byte %s batch", len(body), contentType)
+		}
+		return &BatchResponse{Tracked: len(calls)}, nil
 	}
 
 	var lastErr error
 	for attempt := 0; attempt < c.config.MaxRetries; attempt++ {
-		req, err := http.NewRequest("POST", c.config.BaseURL+"/api/v1/ingest/llm/batch", bytes.NewReader(body))
+		req, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/api/v1/ingest/llm/batch", bytes.NewReader(body))
 		if err != nil {
-			return fmt.Errorf("failed to create request: %w", err)
+			return nil, fmt.Errorf("failed to create request: %w", err)
 		}
 
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+		for key, values := range header {
+			for _, v := range values {
+				req.Header.Add(key, v)
+			}
+		}
+		req.Header.Set("Content-Type", contentType)
+		SetAuthHeader(req.Header, c.config.AuthHeader, c.config.AuthScheme, c.config.APIKey)
+		req.Header.Set("User-Agent", UserAgent(c.config.UserAgent))
+		if c.config.OrganizationID != "" {
+			req.Header.Set("X-Organization-ID", c.config.OrganizationID)
+		}
 
+		statusCode := 0
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
 			lastErr = err
-			c.log("Attempt %d failed: %v", attempt+1, err)
-			time.Sleep(time.Duration(1<<attempt) * time.Second)
-			continue
-		}
+		} else {
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				var result BatchResponse
+				if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && err != io.EOF {
+					c.log("Failed to decode response: %v", err)
+				}
+				resp.Body.Close()
+				return &result, nil
+			}
 
-		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 			resp.Body.Close()
-			return nil
+			statusCode = resp.StatusCode
+			lastErr = fmt.Errorf("HTTP %d", resp.StatusCode)
 		}
-
-		resp.Body.Close()
-		lastErr = fmt.Errorf("HTTP %d", resp.StatusCode)
 		c.log("Attempt %d failed: %v", attempt+1, lastErr)
 
-		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-			// Don't retry client errors
-			return lastErr
+		// The batch may have landed even though the response was lost
+		mayHaveLanded := statusCode == 0 || statusCode >= 500
+		var retry bool
+		var delay time.Duration
+		c.runCallback("RetryPolicy", func() { retry, delay = c.config.RetryPolicy.ShouldRetry(attempt, statusCode, err) })
+		if !retry || attempt == c.config.MaxRetries-1 {
+			if mayHaveLanded {
+				if result, ok := c.batchLanded(ctx, baseURL, payload.BatchID); ok {
+					return result, nil
+				}
+			}
+			return nil, lastErr
 		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+		if mayHaveLanded {
+			if result, ok := c.batchLanded(ctx, baseURL, payload.BatchID); ok {
+				return result, nil
+			}
+		}
+	}
+
+	return nil, lastErr
+}
+
+// batchLanded asks the ingest status endpoint whether a batch whose send
+// failed was processed anyway, returning the server's result if so. It
+// always reports false unless Config.VerifyBatchDelivery is set.
+func (c *Client) batchLanded(ctx context.Context, baseURL, batchID string) (*BatchResponse, bool) {
+	if !c.config.VerifyBatchDelivery {
+		return nil, false
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/api/v1/ingest/status/"+url.PathEscape(batchID), nil)
+	if err != nil {
+		return nil, false
+	}
+	SetAuthHeader(req.Header, c.config.AuthHeader, c.config.AuthScheme, c.config.APIKey)
+	req.Header.Set("User-Agent", UserAgent(c.config.UserAgent))
+	if c.config.OrganizationID != "" {
+		req.Header.Set("X-Organization-ID", c.config.OrganizationID)
+	}
 
-		time.Sleep(time.Duration(1<<attempt) * time.Second)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.log("Failed to check status of batch %s: %v", batchID, err)
+		return nil, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
 	}
 
-	return lastErr
+	var result BatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && err != io.EOF {
+		c.log("Failed to decode status of batch %s: %v", batchID, err)
+		return nil, false
+	}
+	c.log("Batch %s already landed, not re-sending", batchID)
+	return &result, true
 }
 
 func (c *Client) log(format string, args ...interface{}) {
 	if c.config.Debug {
-		fmt.Printf("[Diagnyx] "+format+"\n", args...)
+		logf(format, args...)
 	}
 }
 
+// logf prints a debug log line with the SDK's prefix
+func logf(format string, args ...interface{}) {
+	fmt.Printf("[Diagnyx] "+format+"\n", args...)
+}
+
 // Config returns the client configuration
 func (c *Client) Config() Config {
 	return c.config
 }
+
+// NewTraceID returns a new random trace ID
+func NewTraceID() string {
+	return NewID()
+}
+
+// NewSpanID returns a new random span ID
+func NewSpanID() string {
+	return NewID()
+}
+
+// NewID returns a new random ID formatted as a version 4 UUID. If the
+// system's secure random source fails it falls back to math/rand rather
+// than panicking.
+func NewID() string {
+	var b [16]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		binary.LittleEndian.PutUint64(b[:8], mrand.Uint64())
+		binary.LittleEndian.PutUint64(b[8:], mrand.Uint64())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], b[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], b[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], b[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], b[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], b[10:])
+	return string(buf[:])
+}
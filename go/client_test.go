@@ -1,9 +1,15 @@
 package diagnyx
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -44,6 +50,13 @@ func newMockServer() *MockServer {
 	return ms
 }
 
+// Calls returns the calls from the last batch request
+func (ms *MockServer) Calls() []LLMCall {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.LastRequest.Calls
+}
+
 func TestNewClient(t *testing.T) {
 	t.Run("creates client with API key", func(t *testing.T) {
 		client := NewClient("test-api-key")
@@ -175,12 +188,17 @@ func TestTrack(t *testing.T) {
 		}
 
 		// Wait for async flush
-		time.Sleep(100 * time.Millisecond)
+		if err := client.Drain(context.Background()); err != nil {
+			t.Fatalf("unexpected drain error: %v", err)
+		}
 
 		// Buffer should be empty after auto-flush
 		if client.BufferSize() != 0 {
 			t.Errorf("expected buffer size 0 after auto-flush, got %d", client.BufferSize())
 		}
+		if len(server.Calls()) != 5 {
+			t.Errorf("expected all 5 calls in the auto-flushed batch, got %d", len(server.Calls()))
+		}
 	})
 }
 
@@ -457,3 +475,1065 @@ func TestConcurrentTracking(t *testing.T) {
 		t.Errorf("expected buffer size %d, got %d", expectedSize, client.BufferSize())
 	}
 }
+
+func TestAutoGenerateTraceID(t *testing.T) {
+	t.Run("assigns trace and span IDs when both are empty", func(t *testing.T) {
+		server := newMockServer()
+		defer server.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:              "test-key",
+			BaseURL:             server.URL,
+			FlushIntervalMs:     60000,
+			AutoGenerateTraceID: true,
+		})
+		defer client.Close()
+
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+		client.Flush()
+
+		call := server.Calls()[0]
+		if call.TraceID == "" || call.SpanID == "" {
+			t.Errorf("expected generated IDs, got trace '%s' span '%s'", call.TraceID, call.SpanID)
+		}
+	})
+
+	t.Run("keeps caller-provided trace ID", func(t *testing.T) {
+		server := newMockServer()
+		defer server.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:              "test-key",
+			BaseURL:             server.URL,
+			FlushIntervalMs:     60000,
+			AutoGenerateTraceID: true,
+		})
+		defer client.Close()
+
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, TraceID: "trace-1"})
+		client.Flush()
+
+		call := server.Calls()[0]
+		if call.TraceID != "trace-1" {
+			t.Errorf("expected trace ID 'trace-1', got '%s'", call.TraceID)
+		}
+		if call.SpanID != "" {
+			t.Errorf("expected empty span ID, got '%s'", call.SpanID)
+		}
+	})
+
+	t.Run("leaves IDs empty by default", func(t *testing.T) {
+		server := newMockServer()
+		defer server.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         server.URL,
+			FlushIntervalMs: 60000,
+		})
+		defer client.Close()
+
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+		client.Flush()
+
+		call := server.Calls()[0]
+		if call.TraceID != "" || call.SpanID != "" {
+			t.Errorf("expected empty IDs, got trace '%s' span '%s'", call.TraceID, call.SpanID)
+		}
+	})
+}
+
+func TestFlushOnErrorCount(t *testing.T) {
+	t.Run("flushes once error threshold is reached", func(t *testing.T) {
+		server := newMockServer()
+		defer server.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:            "test-key",
+			BaseURL:           server.URL,
+			BatchSize:         100,
+			FlushIntervalMs:   60000,
+			FlushOnErrorCount: 3,
+		})
+		defer client.Close()
+
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+		for i := 0; i < 3; i++ {
+			client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusError})
+		}
+
+		// Wait for async flush
+		time.Sleep(100 * time.Millisecond)
+
+		if client.BufferSize() != 0 {
+			t.Errorf("expected buffer size 0 after error flush, got %d", client.BufferSize())
+		}
+		if len(server.Calls()) != 4 {
+			t.Errorf("expected 4 calls in flushed batch, got %d", len(server.Calls()))
+		}
+	})
+
+	t.Run("does not flush below threshold", func(t *testing.T) {
+		server := newMockServer()
+		defer server.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:            "test-key",
+			BaseURL:           server.URL,
+			BatchSize:         100,
+			FlushIntervalMs:   60000,
+			FlushOnErrorCount: 3,
+		})
+		defer client.Close()
+
+		for i := 0; i < 2; i++ {
+			client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusError})
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		if client.BufferSize() != 2 {
+			t.Errorf("expected buffer size 2, got %d", client.BufferSize())
+		}
+	})
+}
+
+func TestPeekBuffer(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+	})
+	defer client.Close()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+
+	calls := client.PeekBuffer()
+	if len(calls) != 1 {
+		t.Fatalf("expected 1 buffered call, got %d", len(calls))
+	}
+
+	calls[0].Model = "modified"
+	if client.PeekBuffer()[0].Model != "gpt-4" {
+		t.Error("expected PeekBuffer to return a copy")
+	}
+	if client.BufferSize() != 1 {
+		t.Errorf("expected buffer size 1 after peek, got %d", client.BufferSize())
+	}
+}
+
+func TestClearBuffer(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+	})
+	defer client.Close()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusError})
+
+	if n := client.ClearBuffer(); n != 2 {
+		t.Errorf("expected 2 discarded calls, got %d", n)
+	}
+	if client.BufferSize() != 0 {
+		t.Errorf("expected buffer size 0 after clear, got %d", client.BufferSize())
+	}
+}
+
+func TestBatchEnvelope(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+	})
+	defer client.Close()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	if err := client.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server.mu.Lock()
+	req := server.LastRequest
+	server.mu.Unlock()
+
+	if req.SDKVersion != "diagnyx-go/"+Version {
+		t.Errorf("expected SDK version 'diagnyx-go/%s', got '%s'", Version, req.SDKVersion)
+	}
+	if req.BatchID == "" {
+		t.Error("expected batch ID to be set")
+	}
+}
+
+func TestBeforeSend(t *testing.T) {
+	t.Run("can mutate the batch", func(t *testing.T) {
+		server := newMockServer()
+		defer server.Close()
+
+		hookCalls := 0
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         server.URL,
+			FlushIntervalMs: 60000,
+			BeforeSend: func(batch *BatchRequest) error {
+				hookCalls++
+				for i := range batch.Calls {
+					batch.Calls[i].FullPrompt = "[redacted]"
+				}
+				return nil
+			},
+		})
+		defer client.Close()
+
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, FullPrompt: "secret"})
+		if err := client.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if hookCalls != 1 {
+			t.Errorf("expected hook to run once, got %d", hookCalls)
+		}
+		if prompt := server.Calls()[0].FullPrompt; prompt != "[redacted]" {
+			t.Errorf("expected redacted prompt, got '%s'", prompt)
+		}
+	})
+
+	t.Run("runs once per flush across retries", func(t *testing.T) {
+		attemptCount := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attemptCount++
+			if attemptCount < 2 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		hookCalls := 0
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         server.URL,
+			FlushIntervalMs: 60000,
+			RetryPolicy:     ExponentialBackoff{BaseDelay: time.Millisecond},
+			BeforeSend: func(batch *BatchRequest) error {
+				hookCalls++
+				return nil
+			},
+		})
+		defer client.Close()
+
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+		if err := client.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if attemptCount != 2 {
+			t.Errorf("expected 2 attempts, got %d", attemptCount)
+		}
+		if hookCalls != 1 {
+			t.Errorf("expected hook to run once, got %d", hookCalls)
+		}
+	})
+
+	t.Run("dead-letters vetoed calls", func(t *testing.T) {
+		server := newMockServer()
+		defer server.Close()
+
+		hookErr := errors.New("vetoed")
+		var dropped []LLMCall
+		var dropErr error
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         server.URL,
+			FlushIntervalMs: 60000,
+			BeforeSend: func(batch *BatchRequest) error {
+				return hookErr
+			},
+			OnError: func(err error, calls []LLMCall) {
+				dropErr = err
+				dropped = append(dropped, calls...)
+			},
+		})
+		defer client.Close()
+
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+		err := client.Flush()
+		if !errors.Is(err, ErrSendVetoed) || !errors.Is(err, hookErr) {
+			t.Errorf("expected a veto error wrapping the hook's error, got %v", err)
+		}
+
+		if server.RequestCount != 0 {
+			t.Errorf("expected no API requests, got %d", server.RequestCount)
+		}
+		if client.BufferSize() != 0 {
+			t.Errorf("expected vetoed calls not to be re-buffered, got buffer size %d", client.BufferSize())
+		}
+		if len(dropped) != 1 || !errors.Is(dropErr, hookErr) {
+			t.Errorf("expected the vetoed call to be dead-lettered, got %d calls with %v", len(dropped), dropErr)
+		}
+	})
+}
+
+func TestFlushByTrace(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		BatchSize:       100,
+		FlushIntervalMs: 60000,
+		FlushByTrace:    true,
+	})
+	defer client.Close()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, TraceID: "trace-a"})
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, TraceID: "trace-b"})
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, TraceID: "trace-a"})
+
+	if client.BufferSize() != 4 {
+		t.Fatalf("expected 4 buffered calls before trace end, got %d", client.BufferSize())
+	}
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, TraceID: "trace-a", EndOfTrace: true})
+
+	// Wait for async trace flush
+	time.Sleep(100 * time.Millisecond)
+
+	calls := server.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 calls in trace batch, got %d", len(calls))
+	}
+	for _, call := range calls {
+		if call.TraceID != "trace-a" {
+			t.Errorf("expected only trace-a calls in batch, got trace '%s'", call.TraceID)
+		}
+	}
+	if client.BufferSize() != 2 {
+		t.Errorf("expected 2 calls left buffered, got %d", client.BufferSize())
+	}
+
+	// Flush sends untraced calls but keeps unfinished traces
+	if err := client.Flush(); err != nil {
+		t.Fatalf("unexpected flush error: %v", err)
+	}
+	if len(server.Calls()) != 1 || server.Calls()[0].TraceID != "" {
+		t.Errorf("expected flush to send only the untraced call, got %+v", server.Calls())
+	}
+	if client.BufferSize() != 1 {
+		t.Errorf("expected unfinished trace to stay buffered, got %d", client.BufferSize())
+	}
+}
+
+func TestTrackNilClient(t *testing.T) {
+	var client *Client
+
+	// These should not panic
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	client.TrackCalls([]LLMCall{{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess}})
+}
+
+func TestTrackAfterClose(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	var dropped []LLMCall
+	var dropErr error
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		OnError: func(err error, calls []LLMCall) {
+			dropErr = err
+			dropped = append(dropped, calls...)
+		},
+	})
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	client.TrackCalls([]LLMCall{{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess}})
+
+	if client.BufferSize() != 0 {
+		t.Errorf("expected no buffered calls after close, got %d", client.BufferSize())
+	}
+	if len(dropped) != 2 {
+		t.Errorf("expected 2 dropped calls, got %d", len(dropped))
+	}
+	if !errors.Is(dropErr, ErrClientClosed) {
+		t.Errorf("expected ErrClientClosed, got %v", dropErr)
+	}
+
+	// A second Close is a no-op
+	if err := client.Close(); err != nil {
+		t.Errorf("unexpected error on second close: %v", err)
+	}
+}
+
+func TestConnectionReuse(t *testing.T) {
+	var mu sync.Mutex
+	newConns := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(BatchResponse{Tracked: 1})
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			newConns++
+			mu.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+	})
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+		if err := client.Flush(); err != nil {
+			t.Fatalf("unexpected flush error: %v", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if newConns != 1 {
+		t.Errorf("expected 1 connection reused across flushes, got %d", newConns)
+	}
+}
+
+func TestCustomHTTPClient(t *testing.T) {
+	custom := &http.Client{Timeout: time.Second}
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, HTTPClient: custom})
+	defer client.Close()
+
+	if client.httpClient != custom {
+		t.Error("expected the custom HTTP client to be used")
+	}
+}
+
+// wrappedTransport stands in for instrumentation that replaces
+// http.DefaultTransport
+type wrappedTransport struct{ http.RoundTripper }
+
+func TestReplacedDefaultTransport(t *testing.T) {
+	original := http.DefaultTransport
+	http.DefaultTransport = wrappedTransport{original}
+	defer func() { http.DefaultTransport = original }()
+
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, MaxIdleConnsPerHost: 7})
+	defer client.Close()
+
+	transport, ok := client.httpClient.Transport.(*http.Transport)
+	if !ok || transport.MaxIdleConnsPerHost != 7 {
+		t.Errorf("expected a fresh transport with the configured pool, got %+v", client.httpClient.Transport)
+	}
+}
+
+func TestMaxBufferSize(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	var dropped []LLMCall
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		MaxBufferSize:   3,
+		OnError: func(err error, calls []LLMCall) {
+			if errors.Is(err, ErrBufferFull) {
+				dropped = append(dropped, calls...)
+			}
+		},
+	})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	if !client.TryTrack(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess}) {
+		t.Error("expected first call to be accepted")
+	}
+	client.TrackCalls([]LLMCall{
+		{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess},
+		{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess},
+		{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess},
+	})
+	if client.TryTrack(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess}) {
+		t.Error("expected call to be rejected when the buffer is full")
+	}
+
+	if client.BufferSize() != 3 {
+		t.Errorf("expected buffer size 3, got %d", client.BufferSize())
+	}
+	if len(dropped) != 2 {
+		t.Errorf("expected 2 dropped calls, got %d", len(dropped))
+	}
+}
+
+func TestMaxBufferSizeOnRebuffer(t *testing.T) {
+	received := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-release
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var dropped []LLMCall
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		MaxRetries:      1,
+		MaxBufferSize:   3,
+		OnError: func(err error, calls []LLMCall) {
+			if errors.Is(err, ErrBufferFull) {
+				mu.Lock()
+				dropped = append(dropped, calls...)
+				mu.Unlock()
+			}
+		},
+	})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	client.TrackCalls([]LLMCall{
+		{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess},
+		{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess},
+		{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess},
+	})
+	flushed := make(chan error)
+	go func() { flushed <- client.Flush() }()
+
+	// Fill the buffer again while the flush is in flight
+	<-received
+	client.TrackCalls([]LLMCall{
+		{Provider: ProviderAnthropic, Model: "claude-3-haiku", Status: StatusSuccess},
+		{Provider: ProviderAnthropic, Model: "claude-3-haiku", Status: StatusSuccess},
+	})
+	close(release)
+	if err := <-flushed; err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+
+	if client.BufferSize() != 3 {
+		t.Errorf("expected the buffer to stay at MaxBufferSize, got %d", client.BufferSize())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dropped) != 2 || dropped[0].Model != "gpt-4" {
+		t.Errorf("expected the 2 failed calls that no longer fit to be dropped, got %v", dropped)
+	}
+}
+
+func TestTrackAndFlush(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+	})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "buffered", Status: StatusSuccess})
+
+	resp, err := client.TrackAndFlush(context.Background(), LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Tracked != 1 {
+		t.Errorf("expected 1 tracked call, got %d", resp.Tracked)
+	}
+
+	calls := server.Calls()
+	if len(calls) != 1 || calls[0].Model != "gpt-4" {
+		t.Errorf("expected only the flushed call to be sent, got %+v", calls)
+	}
+	if calls[0].Timestamp.IsZero() {
+		t.Error("expected timestamp to be set")
+	}
+	if client.BufferSize() != 1 {
+		t.Errorf("expected buffered call to be untouched, got buffer size %d", client.BufferSize())
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	server := newMockServer()
+	server.StatusCode = http.StatusInternalServerError
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		MaxRetries:      1,
+	})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded while flushes fail, got %v", err)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	userAgents := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.Header.Get("User-Agent")
+		json.NewEncoder(w).Encode(BatchResponse{Tracked: 1})
+	}))
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		UserAgent:       "my-app/1.2",
+	})
+	defer client.Close()
+
+	if _, err := client.TrackAndFlush(context.Background(), LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "diagnyx-go/" + Version + " (" + runtime.Version() + ") my-app/1.2"
+	if got := <-userAgents; got != expected {
+		t.Errorf("expected User-Agent %q, got %q", expected, got)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	var batches []BatchRequest
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		DryRun:          true,
+		OnFlush:         func(batch BatchRequest) { batches = append(batches, batch) },
+	})
+	defer client.Close()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	if err := client.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if server.RequestCount != 0 {
+		t.Errorf("expected no requests in dry run, got %d", server.RequestCount)
+	}
+	if len(batches) != 1 || len(batches[0].Calls) != 2 {
+		t.Errorf("expected one batch of 2 calls passed to OnFlush, got %+v", batches)
+	}
+	if client.BufferSize() != 0 {
+		t.Errorf("expected buffer to be cleared, got %d", client.BufferSize())
+	}
+}
+
+func TestTrackAndFlushDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		json.NewEncoder(w).Encode(BatchResponse{Tracked: 1})
+	}))
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		MaxRetries:      1,
+		HTTPClient:      &http.Client{Timeout: 20 * time.Millisecond},
+	})
+	defer client.Close()
+
+	call := LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess}
+	if _, err := client.TrackAndFlush(context.Background(), call); err == nil {
+		t.Error("expected the per-request timeout to apply without a deadline")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.TrackAndFlush(ctx, call); err == nil {
+		t.Error("expected the per-request timeout to apply under a longer deadline")
+	}
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("expected the attempt to time out well before the deadline, took %v", elapsed)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := client.TrackAndFlush(ctx, call); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a shorter deadline to win, got %v", err)
+	}
+}
+
+func TestMaxCallAge(t *testing.T) {
+	t.Run("flushes once the oldest call is too old", func(t *testing.T) {
+		server := newMockServer()
+		defer server.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         server.URL,
+			FlushIntervalMs: 60000,
+			MaxCallAge:      40 * time.Millisecond,
+		})
+		defer client.Close()
+
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+		deadline := time.Now().Add(time.Second)
+		// The buffer empties before the request reaches the server, so wait
+		// for the server rather than the buffer
+		for len(server.Calls()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if len(server.Calls()) != 1 {
+			t.Errorf("expected the call to be flushed after MaxCallAge, got %d calls sent", len(server.Calls()))
+		}
+	})
+
+	t.Run("drops calls past the hard limit", func(t *testing.T) {
+		server := newMockServer()
+		defer server.Close()
+
+		var dropped []LLMCall
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         server.URL,
+			FlushIntervalMs: 60000,
+			MaxCallAge:      time.Minute,
+			OnError: func(err error, calls []LLMCall) {
+				if errors.Is(err, ErrCallTooOld) {
+					dropped = append(dropped, calls...)
+				}
+			},
+		})
+		defer client.Close()
+
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "stale", Status: StatusSuccess})
+		client.bufferMu.Lock()
+		client.buffer[0].bufferedAt = time.Now().Add(-time.Hour)
+		client.bufferMu.Unlock()
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "fresh", Status: StatusSuccess})
+		if err := client.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(dropped) != 1 || dropped[0].Model != "stale" {
+			t.Errorf("expected the stale call to be dropped, got %v", dropped)
+		}
+		if calls := server.Calls(); len(calls) != 1 || calls[0].Model != "fresh" {
+			t.Errorf("expected only the fresh call to be sent, got %v", calls)
+		}
+	})
+
+	t.Run("keeps backfilled calls", func(t *testing.T) {
+		server := newMockServer()
+		defer server.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:             "test-key",
+			BaseURL:            server.URL,
+			FlushIntervalMs:    60000,
+			MaxCallAge:         time.Minute,
+			PreserveTimestamps: true,
+			OnError: func(err error, calls []LLMCall) {
+				t.Errorf("expected no calls to be dropped, got %v", err)
+			},
+		})
+		defer client.Close()
+
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "backfill", Status: StatusSuccess, Timestamp: time.Now().Add(-24 * time.Hour)})
+		if err := client.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if calls := server.Calls(); len(calls) != 1 || calls[0].Model != "backfill" {
+			t.Errorf("expected the backfilled call to be sent, got %v", calls)
+		}
+	})
+}
+
+func TestSyncFlush(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		BatchSize:       2,
+		FlushIntervalMs: 60000,
+		FlushByTrace:    true,
+		SyncFlush:       true,
+	})
+	defer client.Close()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	if server.RequestCount != 1 || client.BufferSize() != 0 {
+		t.Errorf("expected the batch to be sent before Track returned, got %d requests and %d buffered", server.RequestCount, client.BufferSize())
+	}
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, TraceID: "trace-1", EndOfTrace: true})
+	if server.RequestCount != 2 {
+		t.Errorf("expected the trace to be sent before Track returned, got %d requests", server.RequestCount)
+	}
+}
+
+func TestOrganizationID(t *testing.T) {
+	t.Run("sends the organization header and per-call overrides", func(t *testing.T) {
+		var header string
+		var calls []LLMCall
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header.Get("X-Organization-ID")
+			var req BatchRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			calls = req.Calls
+			json.NewEncoder(w).Encode(BatchResponse{Tracked: len(req.Calls)})
+		}))
+		defer server.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         server.URL,
+			FlushIntervalMs: 60000,
+			OrganizationID:  "org-1",
+		})
+		defer client.Close()
+
+		TrackCall(client, ProviderOpenAI, "gpt-4", func() (int, int, error) { return 1, 1, nil }, TrackOptions{OrganizationID: "org-2"})
+		if err := client.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if header != "org-1" {
+			t.Errorf("expected X-Organization-ID 'org-1', got '%s'", header)
+		}
+		if len(calls) != 1 || calls[0].OrganizationID != "org-2" {
+			t.Errorf("expected the per-call organization to be sent, got %+v", calls)
+		}
+	})
+
+	t.Run("rejects unscoped calls when required", func(t *testing.T) {
+		var rejected []LLMCall
+		client := NewClientWithConfig(Config{
+			APIKey:                "test-key",
+			FlushIntervalMs:       60000,
+			RequireOrganizationID: true,
+			OnError: func(err error, calls []LLMCall) {
+				if errors.Is(err, ErrOrganizationRequired) {
+					rejected = append(rejected, calls...)
+				}
+			},
+		})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "unscoped"})
+		client.TrackCalls([]LLMCall{
+			{Provider: ProviderOpenAI, Model: "scoped", OrganizationID: "org-1"},
+			{Provider: ProviderOpenAI, Model: "unscoped"},
+		})
+		if _, err := client.TrackAndFlush(context.Background(), LLMCall{Provider: ProviderOpenAI, Model: "unscoped"}); !errors.Is(err, ErrOrganizationRequired) {
+			t.Errorf("expected ErrOrganizationRequired from TrackAndFlush, got %v", err)
+		}
+
+		if len(rejected) != 2 {
+			t.Errorf("expected 2 rejected calls, got %d", len(rejected))
+		}
+		if buffered := client.PeekBuffer(); len(buffered) != 1 || buffered[0].Model != "scoped" {
+			t.Errorf("expected only the scoped call to be buffered, got %v", buffered)
+		}
+	})
+}
+
+func TestPreserveTimestamps(t *testing.T) {
+	var rejected []LLMCall
+	client := NewClientWithConfig(Config{
+		APIKey:             "test-key",
+		FlushIntervalMs:    60000,
+		PreserveTimestamps: true,
+		OnError: func(err error, calls []LLMCall) {
+			if errors.Is(err, ErrMissingTimestamp) {
+				rejected = append(rejected, calls...)
+			}
+		},
+	})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	epoch := time.Unix(0, 0).UTC()
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "missing"})
+	client.TrackCalls([]LLMCall{
+		{Provider: ProviderOpenAI, Model: "epoch", Timestamp: epoch},
+		{Provider: ProviderOpenAI, Model: "missing"},
+	})
+	if _, err := client.TrackAndFlush(context.Background(), LLMCall{Provider: ProviderOpenAI, Model: "missing"}); !errors.Is(err, ErrMissingTimestamp) {
+		t.Errorf("expected ErrMissingTimestamp from TrackAndFlush, got %v", err)
+	}
+
+	if len(rejected) != 2 {
+		t.Errorf("expected 2 rejected calls, got %d", len(rejected))
+	}
+	buffered := client.PeekBuffer()
+	if len(buffered) != 1 || !buffered[0].Timestamp.Equal(epoch) {
+		t.Errorf("expected the epoch timestamp to be preserved, got %v", buffered)
+	}
+}
+
+func TestVerifyBatchDelivery(t *testing.T) {
+	for _, verify := range []bool{true, false} {
+		t.Run(fmt.Sprintf("verify=%v", verify), func(t *testing.T) {
+			var mu sync.Mutex
+			landed := map[string]bool{}
+			sends := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if strings.HasPrefix(r.URL.Path, "/api/v1/ingest/status/") {
+					if !landed[strings.TrimPrefix(r.URL.Path, "/api/v1/ingest/status/")] {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+					json.NewEncoder(w).Encode(BatchResponse{Tracked: 1})
+					return
+				}
+				// The batch is processed but the response is lost
+				var req BatchRequest
+				json.NewDecoder(r.Body).Decode(&req)
+				landed[req.BatchID] = true
+				sends++
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+			defer server.Close()
+
+			client := NewClientWithConfig(Config{
+				APIKey:              "test-key",
+				BaseURL:             server.URL,
+				FlushIntervalMs:     60000,
+				MaxRetries:          3,
+				RetryPolicy:         ExponentialBackoff{BaseDelay: time.Millisecond},
+				VerifyBatchDelivery: verify,
+			})
+			defer client.Close()
+			defer client.ClearBuffer()
+
+			client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"})
+			err := client.Flush()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if verify {
+				if err != nil || sends != 1 || client.BufferSize() != 0 {
+					t.Errorf("expected the landed batch to be sent once and cleared, got err %v, %d sends, buffer %d", err, sends, client.BufferSize())
+				}
+			} else if err == nil || sends != 3 || client.BufferSize() != 1 {
+				t.Errorf("expected every retry to re-send and the call to be re-buffered, got err %v, %d sends, buffer %d", err, sends, client.BufferSize())
+			}
+		})
+	}
+}
+
+func TestTrackInfersProvider(t *testing.T) {
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	client.Track(LLMCall{Model: "claude-3-opus"})
+	client.TrackCalls([]LLMCall{
+		{Model: "gpt-4"},
+		{Provider: ProviderAzure, Model: "gpt-4"},
+		{},
+	})
+
+	calls := client.PeekBuffer()
+	expected := []Provider{ProviderAnthropic, ProviderOpenAI, ProviderAzure, ""}
+	for i, call := range calls {
+		if call.Provider != expected[i] {
+			t.Errorf("call %d: expected provider '%s', got '%s'", i, expected[i], call.Provider)
+		}
+	}
+}
+
+func TestNewID(t *testing.T) {
+	id := NewID()
+	if len(id) != 36 || id[8] != '-' || id[13] != '-' || id[14] != '4' || id[18] != '-' || id[23] != '-' {
+		t.Errorf("expected a version 4 UUID, got %q", id)
+	}
+	if !strings.ContainsAny(id[19:20], "89ab") {
+		t.Errorf("expected the RFC 4122 variant, got %q", id)
+	}
+	if id == NewID() {
+		t.Error("expected IDs to differ")
+	}
+}
+
+func TestBatchIDSurvivesRebuffering(t *testing.T) {
+	var mu sync.Mutex
+	var batchIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req BatchRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		batchIDs = append(batchIDs, req.BatchID)
+		mu.Unlock()
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		MaxRetries:      1,
+	})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"})
+	client.Flush()
+	client.Flush()
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"})
+	client.Flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batchIDs) != 3 || batchIDs[0] == "" {
+		t.Fatalf("expected 3 sends with a batch ID, got %v", batchIDs)
+	}
+	if batchIDs[1] != batchIDs[0] {
+		t.Errorf("expected the re-buffered batch to keep its ID, got %v", batchIDs)
+	}
+	if batchIDs[2] == batchIDs[0] {
+		t.Errorf("expected a batch with a new call to get a new ID, got %v", batchIDs)
+	}
+}
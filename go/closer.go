@@ -0,0 +1,67 @@
+package diagnyx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Closer shuts down several SDK components, such as the tracking Client,
+// a FeedbackClient and a guardrails Client, with a single call.
+//
+// Example:
+//
+//	closer := diagnyx.NewCloser(client, feedback, guard)
+//	defer closer.Close(shutdownCtx)
+type Closer struct {
+	mu      sync.Mutex
+	closers []io.Closer
+}
+
+// NewCloser creates a Closer for the given components
+func NewCloser(closers ...io.Closer) *Closer {
+	return &Closer{closers: closers}
+}
+
+// Register adds components to be closed
+func (c *Closer) Register(closers ...io.Closer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closers = append(c.closers, closers...)
+}
+
+// Close closes all registered components concurrently and waits until they
+// finish or ctx is done. It returns the errors of all components joined
+// together, plus ctx.Err() if it gave up waiting; components still closing
+// at that point keep running in the background.
+func (c *Closer) Close(ctx context.Context) error {
+	c.mu.Lock()
+	closers := append([]io.Closer(nil), c.closers...)
+	c.mu.Unlock()
+
+	errs := make(chan error, len(closers))
+	for _, closer := range closers {
+		go func(closer io.Closer) {
+			if err := closer.Close(); err != nil {
+				errs <- fmt.Errorf("failed to close %T: %w", closer, err)
+				return
+			}
+			errs <- nil
+		}(closer)
+	}
+
+	var collected []error
+	for range closers {
+		select {
+		case err := <-errs:
+			if err != nil {
+				collected = append(collected, err)
+			}
+		case <-ctx.Done():
+			return errors.Join(append(collected, ctx.Err())...)
+		}
+	}
+	return errors.Join(collected...)
+}
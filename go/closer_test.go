@@ -0,0 +1,52 @@
+package diagnyx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// closerFunc adapts a function to io.Closer
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+func TestCloser(t *testing.T) {
+	t.Run("closes all and joins errors", func(t *testing.T) {
+		server := newMockServer()
+		defer server.Close()
+
+		client := NewClientWithConfig(Config{APIKey: "test-key", BaseURL: server.URL, FlushIntervalMs: 60000})
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+
+		errA := errors.New("a failed")
+		errB := errors.New("b failed")
+		closer := NewCloser(client, NewFeedbackClient("test-key", "org-1"), closerFunc(func() error { return errA }))
+		closer.Register(closerFunc(func() error { return errB }))
+
+		err := closer.Close(context.Background())
+		if !errors.Is(err, errA) || !errors.Is(err, errB) {
+			t.Errorf("expected both errors, got %v", err)
+		}
+		if len(server.Calls()) != 1 {
+			t.Errorf("expected the tracking client to be flushed, got %d calls", len(server.Calls()))
+		}
+	})
+
+	t.Run("bounded by context", func(t *testing.T) {
+		block := make(chan struct{})
+		defer close(block)
+		closer := NewCloser(closerFunc(func() error {
+			<-block
+			return nil
+		}))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		if err := closer.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,411 @@
+package diagnyx
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Encoding selects the wire format of ingest batches
+type Encoding string
+
+const (
+	// EncodingJSON sends batches as JSON
+	EncodingJSON Encoding = "json"
+	// EncodingMsgpack sends batches as MessagePack, which is typically
+	// about a fifth smaller than JSON for tracked calls
+	EncodingMsgpack Encoding = "msgpack"
+)
+
+// Codec marshals outgoing ingest batches. Implement it to plug in another
+// encoder, such as a MessagePack library, without the SDK depending on it.
+type Codec interface {
+	// ContentType is sent as the request's Content-Type header
+	ContentType() string
+	// Marshal encodes a *BatchRequest
+	Marshal(v interface{}) ([]byte, error)
+}
+
+// JSONCodec encodes batches with encoding/json
+type JSONCodec struct{}
+
+// ContentType returns "application/json"
+func (JSONCodec) ContentType() string { return "application/json" }
+
+// Marshal encodes v as JSON
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// MsgpackCodec encodes batches as MessagePack without third-party
+// dependencies. Values are encoded directly, following their encoding/json
+// field tags, so field names, omitted fields and timestamp formats match
+// EncodingJSON and floats stay floats. json.RawMessage and json.Marshaler
+// values are transcoded from their JSON. Conflicting embedded field names
+// are not resolved the way encoding/json resolves them.
+type MsgpackCodec struct{}
+
+// ContentType returns "application/msgpack"
+func (MsgpackCodec) ContentType() string { return "application/msgpack" }
+
+// Marshal encodes v as MessagePack
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return appendMsgpackValue(make([]byte, 0, 1024), reflect.ValueOf(v))
+}
+
+// codecFor returns the codec for an encoding
+func codecFor(encoding Encoding) (Codec, error) {
+	switch encoding {
+	case "", EncodingJSON:
+		return JSONCodec{}, nil
+	case EncodingMsgpack:
+		return MsgpackCodec{}, nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage(nil))
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// appendMsgpackValue appends the MessagePack encoding of v, mirroring what
+// encoding/json would produce for it
+func appendMsgpackValue(buf []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(buf, 0xc0), nil
+	}
+	switch v.Type() {
+	case timeType:
+		t := v.Interface().(time.Time)
+		return appendMsgpackString(buf, t.Format(time.RFC3339Nano)), nil
+	case rawMessageType:
+		if v.Len() == 0 {
+			return append(buf, 0xc0), nil
+		}
+		return appendMsgpackJSON(buf, v.Bytes())
+	}
+
+	kind := v.Kind()
+	if (kind == reflect.Ptr || kind == reflect.Interface) && v.IsNil() {
+		return append(buf, 0xc0), nil
+	}
+	if kind != reflect.Interface && v.CanInterface() {
+		if v.Type().Implements(jsonMarshalerType) {
+			raw, err := v.Interface().(json.Marshaler).MarshalJSON()
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal %s: %w", v.Type(), err)
+			}
+			return appendMsgpackJSON(buf, raw)
+		}
+		if v.Type().Implements(textMarshalerType) {
+			text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal %s: %w", v.Type(), err)
+			}
+			return appendMsgpackString(buf, string(text)), nil
+		}
+	}
+
+	switch kind {
+	case reflect.Ptr, reflect.Interface:
+		return appendMsgpackValue(buf, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendMsgpackInt(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := v.Uint(); u > math.MaxInt64 {
+			return binary.BigEndian.AppendUint64(append(buf, 0xcf), u), nil
+		}
+		return appendMsgpackInt(buf, int64(v.Uint())), nil
+	case reflect.Float32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xca), math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendMsgpackString(buf, v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(buf, 0xc0), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// encoding/json sends byte slices as base64 strings
+			return appendMsgpackString(buf, base64.StdEncoding.EncodeToString(v.Bytes())), nil
+		}
+		return appendMsgpackArray(buf, v)
+	case reflect.Array:
+		return appendMsgpackArray(buf, v)
+	case reflect.Map:
+		if v.IsNil() {
+			return append(buf, 0xc0), nil
+		}
+		return appendMsgpackMap(buf, v)
+	case reflect.Struct:
+		return appendMsgpackStruct(buf, v)
+	}
+	return nil, fmt.Errorf("cannot encode %s as msgpack", v.Type())
+}
+
+// appendMsgpackString appends a string with its header
+func appendMsgpackString(buf []byte, s string) []byte {
+	buf = appendMsgpackHeader(buf, len(s), 0xa0, 32, 0xd9, 0xda, 0xdb)
+	return append(buf, s...)
+}
+
+// appendMsgpackArray appends the elements of a slice or array
+func appendMsgpackArray(buf []byte, v reflect.Value) ([]byte, error) {
+	buf = appendMsgpackHeader(buf, v.Len(), 0x90, 16, 0, 0xdc, 0xdd)
+	var err error
+	for i := 0; i < v.Len(); i++ {
+		if buf, err = appendMsgpackValue(buf, v.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// appendMsgpackMap appends a map with string or integer keys. Keys are
+// sorted so the output is deterministic.
+func appendMsgpackMap(buf []byte, v reflect.Value) ([]byte, error) {
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		k := iter.Key()
+		var key string
+		switch k.Kind() {
+		case reflect.String:
+			key = k.String()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			key = strconv.FormatInt(k.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			key = strconv.FormatUint(k.Uint(), 10)
+		default:
+			return nil, fmt.Errorf("cannot encode map key of type %s as msgpack", k.Type())
+		}
+		entries = append(entries, entry{key, iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	buf = appendMsgpackHeader(buf, len(entries), 0x80, 16, 0, 0xde, 0xdf)
+	var err error
+	for _, e := range entries {
+		buf = appendMsgpackString(buf, e.key)
+		if buf, err = appendMsgpackValue(buf, e.value); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// msgpackField is an encoded struct field, as named by its json tag
+type msgpackField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// msgpackFieldCache maps a struct type to its []msgpackField
+var msgpackFieldCache sync.Map
+
+// msgpackFields returns the fields encoding/json would encode for a struct
+// type, flattening untagged embedded structs
+func msgpackFields(t reflect.Type) []msgpackField {
+	if cached, ok := msgpackFieldCache.Load(t); ok {
+		return cached.([]msgpackField)
+	}
+	var fields []msgpackField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for _, inner := range msgpackFields(f.Type) {
+				inner.index = append([]int{i}, inner.index...)
+				fields = append(fields, inner)
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		omitEmpty := strings.Contains(","+opts+",", ",omitempty,")
+		fields = append(fields, msgpackField{name: name, index: []int{i}, omitEmpty: omitEmpty})
+	}
+	msgpackFieldCache.Store(t, fields)
+	return fields
+}
+
+// appendMsgpackStruct appends a struct as a map of its fields, in
+// declaration order
+func appendMsgpackStruct(buf []byte, v reflect.Value) ([]byte, error) {
+	fields := msgpackFields(v.Type())
+	n := 0
+	for _, f := range fields {
+		if !f.omitEmpty || !isEmptyValue(v.FieldByIndex(f.index)) {
+			n++
+		}
+	}
+
+	buf = appendMsgpackHeader(buf, n, 0x80, 16, 0, 0xde, 0xdf)
+	var err error
+	for _, f := range fields {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		buf = appendMsgpackString(buf, f.name)
+		if buf, err = appendMsgpackValue(buf, fv); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// isEmptyValue reports whether omitempty drops v, as in encoding/json
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// appendMsgpackJSON transcodes raw JSON. Number literals keep the form they
+// were written in: 1 becomes an integer and 1.0 a float.
+func appendMsgpackJSON(buf []byte, raw []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON for msgpack: %w", err)
+	}
+	return appendMsgpack(buf, value)
+}
+
+// appendMsgpack appends the MessagePack encoding of a value decoded from
+// JSON with UseNumber. Map keys are sorted so the output is deterministic.
+func appendMsgpack(buf []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if v {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendMsgpackInt(buf, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode number %s: %w", v, err)
+		}
+		buf = append(buf, 0xcb)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(f)), nil
+	case string:
+		return appendMsgpackString(buf, v), nil
+	case []interface{}:
+		buf = appendMsgpackHeader(buf, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		var err error
+		for _, item := range v {
+			if buf, err = appendMsgpack(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf = appendMsgpackHeader(buf, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		var err error
+		for _, key := range keys {
+			if buf, err = appendMsgpack(buf, key); err != nil {
+				return nil, err
+			}
+			if buf, err = appendMsgpack(buf, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("cannot encode %T as msgpack", value)
+}
+
+// appendMsgpackInt appends an integer in its smallest MessagePack form
+func appendMsgpackInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i < 128:
+		return append(buf, byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(buf, 0xcc, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(i))
+	case i >= 0:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), uint64(i))
+	case i >= -32:
+		return append(buf, byte(int8(i)))
+	case i >= math.MinInt8:
+		return append(buf, 0xd0, byte(int8(i)))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(int16(i)))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(int32(i)))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(i))
+}
+
+// appendMsgpackHeader appends a string, array or map header: the fixed
+// form for lengths below fixMax, otherwise the 8-bit (if any), 16-bit or
+// 32-bit form
+func appendMsgpackHeader(buf []byte, n int, fix byte, fixMax int, b8, b16, b32 byte) []byte {
+	switch {
+	case n < fixMax:
+		return append(buf, fix|byte(n))
+	case b8 != 0 && n <= math.MaxUint8:
+		return append(buf, b8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, b16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, b32), uint32(n))
+}
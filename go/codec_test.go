@@ -0,0 +1,171 @@
+package diagnyx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMsgpackCodec(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  []byte
+	}{
+		{"nil", nil, []byte{0xc0}},
+		{"true", true, []byte{0xc3}},
+		{"fixint", 7, []byte{0x07}},
+		{"uint8", 200, []byte{0xcc, 0xc8}},
+		{"uint16", 1000, []byte{0xcd, 0x03, 0xe8}},
+		{"negative fixint", -3, []byte{0xfd}},
+		{"int8", -100, []byte{0xd0, 0x9c}},
+		{"float", 0.5, []byte{0xcb, 0x3f, 0xe0, 0, 0, 0, 0, 0, 0}},
+		{"whole float", 1.0, []byte{0xcb, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0}},
+		{"float in metadata", map[string]interface{}{"t": 1.0}, []byte{0x81, 0xa1, 't', 0xcb, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0}},
+		{"raw JSON", json.RawMessage(`[1,1.0]`), []byte{0x92, 0x01, 0xcb, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0}},
+		{"omitempty", struct {
+			A string `json:"a,omitempty"`
+			B int    `json:"b"`
+			C bool   `json:"-"`
+		}{C: true}, []byte{0x81, 0xa1, 'b', 0x00}},
+		{"fixstr", "gpt-4", []byte{0xa5, 'g', 'p', 't', '-', '4'}},
+		{"array", []int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{"map with sorted keys", map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MsgpackCodec{}.Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("expected % x, got % x", tt.want, got)
+			}
+		})
+	}
+
+	long, _ := MsgpackCodec{}.Marshal(string(make([]byte, 40)))
+	if long[0] != 0xd9 || long[1] != 40 {
+		t.Errorf("expected a str8 header for a 40-byte string, got % x", long[:2])
+	}
+}
+
+func TestEncodingMsgpack(t *testing.T) {
+	var contentType string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"tracked":1}`))
+	}))
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		Encoding:        EncodingMsgpack,
+	})
+	defer client.Close()
+
+	if _, err := client.TrackAndFlush(context.Background(), LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if contentType != "application/msgpack" {
+		t.Errorf("expected Content-Type application/msgpack, got %q", contentType)
+	}
+	if len(body) == 0 || body[0]&0xf0 != 0x80 {
+		t.Errorf("expected a msgpack map, got % x", body)
+	}
+	if !bytes.Contains(body, []byte("gpt-4")) {
+		t.Error("expected the body to contain the model")
+	}
+}
+
+func TestUnknownEncoding(t *testing.T) {
+	client := NewClientWithConfig(Config{APIKey: "test-key", Encoding: "xml"})
+	defer client.Close()
+
+	if _, ok := client.Config().Codec.(JSONCodec); !ok {
+		t.Errorf("expected an unknown encoding to fall back to JSON, got %T", client.Config().Codec)
+	}
+}
+
+func TestMsgpackMatchesJSON(t *testing.T) {
+	batch := benchmarkBatch()
+	batch.Calls[0].RawResponse = json.RawMessage(`{"id":"chatcmpl-1"}`)
+	ttft := int64(120)
+	batch.Calls[0].TTFTMs = &ttft
+
+	raw, err := json.Marshal(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := appendMsgpackJSON(nil, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := MsgpackCodec{}.Marshal(batch)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Transcoded JSON sorts keys; compare sizes and decoded field names
+	// rather than bytes
+	if len(got) != len(want) {
+		t.Errorf("expected %d bytes like the transcoded JSON, got %d", len(want), len(got))
+	}
+	for _, field := range []string{"sdk_version", "batch_id", "input_tokens", "ttft_ms", "raw_response", "chatcmpl-1", "2024-01-01T00:00:00Z"} {
+		if !bytes.Contains(got, []byte(field)) {
+			t.Errorf("expected the encoding to contain %q", field)
+		}
+	}
+	if bytes.Contains(got, []byte("EndOfTrace")) || bytes.Contains(got, []byte("full_prompt")) {
+		t.Error("expected ignored and empty omitempty fields to be left out")
+	}
+}
+
+func benchmarkBatch() *BatchRequest {
+	calls := make([]LLMCall, 100)
+	for i := range calls {
+		calls[i] = LLMCall{
+			Provider:     ProviderOpenAI,
+			Model:        "gpt-4o-mini",
+			Endpoint:     "chat.completions",
+			InputTokens:  512 + i,
+			OutputTokens: 128,
+			LatencyMs:    840,
+			Status:       StatusSuccess,
+			TraceID:      fmt.Sprintf("trace-%d", i),
+			Timestamp:    time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC),
+			Metadata:     map[string]interface{}{"route": "/chat", "attempt": 1},
+		}
+	}
+	return &BatchRequest{Calls: calls, SDKVersion: "diagnyx-go/" + Version, BatchID: "batch-1"}
+}
+
+// BenchmarkEncoding compares payload size and marshal time for a batch of
+// 100 calls; run with -bench Encoding -benchmem
+func BenchmarkEncoding(b *testing.B) {
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+		b.Run(codec.ContentType(), func(b *testing.B) {
+			batch := benchmarkBatch()
+			var size int
+			for i := 0; i < b.N; i++ {
+				body, err := codec.Marshal(batch)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(body)
+			}
+			b.ReportMetric(float64(size), "bytes/batch")
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package diagnyx
+
+import "context"
+
+// ContentStore keeps captured content outside of Diagnyx, for example in a
+// blob store, and returns a reference to it
+type ContentStore interface {
+	Put(ctx context.Context, content string) (ref string, err error)
+}
+
+// SetPrompt records a captured prompt on the call: in config.ContentStore
+// with a reference in PromptRef if one is configured, otherwise inline in
+// FullPrompt, truncated per config. If the store fails the prompt is left
+// out and the error is recorded in Metadata["content_store_error"].
+func (c *LLMCall) SetPrompt(ctx context.Context, config Config, prompt string) {
+	c.FullPrompt, c.PromptRef = c.storeContent(ctx, config, prompt)
+}
+
+// SetResponse records a captured response like SetPrompt, using
+// FullResponse and ResponseRef
+func (c *LLMCall) SetResponse(ctx context.Context, config Config, response string) {
+	c.FullResponse, c.ResponseRef = c.storeContent(ctx, config, response)
+}
+
+// storeContent returns content either inline or as a ContentStore reference
+func (c *LLMCall) storeContent(ctx context.Context, config Config, content string) (inline, ref string) {
+	if content == "" {
+		return "", ""
+	}
+	if config.ContentStore == nil {
+		return config.TruncateContent(content), ""
+	}
+	ref, err := putContent(ctx, config.ContentStore, content)
+	if err != nil {
+		c.Metadata = withMetadata(c.Metadata, "content_store_error", err.Error())
+		return "", ""
+	}
+	return "", ref
+}
+
+// putContent stores content, recovering a panic in the store as an error
+func putContent(ctx context.Context, store ContentStore, content string) (ref string, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &CallbackPanicError{Callback: "ContentStore", Value: v}
+		}
+	}()
+	return store.Put(ctx, content)
+}
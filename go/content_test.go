@@ -0,0 +1,76 @@
+package diagnyx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type mapContentStore struct {
+	content map[string]string
+	err     error
+}
+
+func (s *mapContentStore) Put(ctx context.Context, content string) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	ref := fmt.Sprintf("blob://%d", len(s.content))
+	s.content[ref] = content
+	return ref, nil
+}
+
+func TestContentStore(t *testing.T) {
+	t.Run("stores content and records references", func(t *testing.T) {
+		store := &mapContentStore{content: map[string]string{}}
+		client := NewClientWithConfig(Config{
+			APIKey:             "test-key",
+			FlushIntervalMs:    60000,
+			CaptureFullContent: true,
+			ContentMaxLength:   5,
+			ContentStore:       store,
+		})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		TrackCallWithContent(client, ProviderOpenAI, "gpt-4", "a long prompt", "a long response", 10, 5, 100)
+
+		call := client.PeekBuffer()[0]
+		if call.FullPrompt != "" || call.FullResponse != "" {
+			t.Errorf("expected no inline content, got %q / %q", call.FullPrompt, call.FullResponse)
+		}
+		if store.content[call.PromptRef] != "a long prompt" || store.content[call.ResponseRef] != "a long response" {
+			t.Errorf("expected untruncated content behind the refs, got %v", store.content)
+		}
+	})
+
+	t.Run("records store errors", func(t *testing.T) {
+		client := NewClientWithConfig(Config{
+			APIKey:             "test-key",
+			FlushIntervalMs:    60000,
+			CaptureFullContent: true,
+			ContentStore:       &mapContentStore{err: errors.New("bucket unavailable")},
+		})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		TrackCallWithContent(client, ProviderOpenAI, "gpt-4", "prompt", "response", 10, 5, 100)
+
+		call := client.PeekBuffer()[0]
+		if call.FullPrompt != "" || call.PromptRef != "" {
+			t.Errorf("expected the prompt to be left out, got %+v", call)
+		}
+		if call.Metadata["content_store_error"] != "bucket unavailable" {
+			t.Errorf("expected the store error in metadata, got %v", call.Metadata)
+		}
+	})
+
+	t.Run("falls back to inline content", func(t *testing.T) {
+		var call LLMCall
+		call.SetPrompt(context.Background(), Config{ContentMaxLength: 3, TruncationMarker: "~"}, "abcdef")
+		if call.FullPrompt != "abc~" || call.PromptRef != "" {
+			t.Errorf("expected truncated inline prompt, got %q / %q", call.FullPrompt, call.PromptRef)
+		}
+	})
+}
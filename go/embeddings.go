@@ -0,0 +1,94 @@
+package diagnyx
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// BatchEmbeddingTracker tracks the embedding requests of a document set,
+// such as an ingestion job. In rolled-up mode the requests are accumulated
+// and recorded as a single LLMCall on Finish, so embedding thousands of
+// documents produces one record instead of one per request; token totals,
+// and therefore cost, are unchanged. Otherwise every request is tracked as
+// by OpenAIWrapper.CreateEmbeddings.
+//
+// Example:
+//
+//	batch := diagnyx.NewBatchEmbeddingTracker(wrapped, true)
+//	for _, doc := range docs {
+//		batch.CreateEmbeddings(ctx, openai.EmbeddingRequest{Input: doc, Model: openai.AdaEmbeddingV2})
+//	}
+//	batch.Finish()
+type BatchEmbeddingTracker struct {
+	wrapper *OpenAIWrapper
+	rollUp  bool
+
+	mu      sync.Mutex
+	call    *LLMCall
+	count   int
+	errored int
+}
+
+// NewBatchEmbeddingTracker creates a tracker that sends embedding requests
+// through wrapper. With rollUp false it tracks each request individually.
+func NewBatchEmbeddingTracker(wrapper *OpenAIWrapper, rollUp bool) *BatchEmbeddingTracker {
+	return &BatchEmbeddingTracker{wrapper: wrapper, rollUp: rollUp}
+}
+
+// CreateEmbeddings creates embeddings and tracks or accumulates the call.
+// It is safe for concurrent use.
+func (b *BatchEmbeddingTracker) CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequest) (openai.EmbeddingResponse, error) {
+	if !b.rollUp {
+		return b.wrapper.CreateEmbeddings(ctx, req)
+	}
+
+	start := time.Now()
+	resp, err := b.wrapper.client.CreateEmbeddings(ctx, req)
+	call := b.wrapper.embeddingCall(req, resp, err, time.Since(start).Milliseconds())
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.count++
+	if err != nil {
+		b.errored++
+	}
+	if b.call == nil {
+		call.Status = StatusSuccess
+		call.ErrorMessage = ""
+		b.call = &call
+		return resp, err
+	}
+	b.call.InputTokens += call.InputTokens
+	b.call.LatencyMs += call.LatencyMs
+	return resp, err
+}
+
+// Finish records the accumulated requests as one LLMCall with the summed
+// input tokens and latency, the first request's model and timestamp, and
+// the number of requests in Metadata["embedding_count"] (failed requests in
+// Metadata["embedding_errors"]). It then starts a new accumulation. Finish
+// does nothing if no request was made since the last call.
+func (b *BatchEmbeddingTracker) Finish() {
+	b.mu.Lock()
+	call := b.call
+	count, errored := b.count, b.errored
+	b.call, b.count, b.errored = nil, 0, 0
+	b.mu.Unlock()
+
+	if call == nil {
+		return
+	}
+	call.Metadata = withMetadata(call.Metadata, "embedding_count", count)
+	if errored > 0 {
+		call.Metadata = withMetadata(call.Metadata, "embedding_errors", errored)
+		if errored == count {
+			call.Status = StatusError
+		}
+	}
+	if shouldTrack(b.wrapper.diagnyx, *call) {
+		b.wrapper.track(*call)
+	}
+}
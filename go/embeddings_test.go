@@ -0,0 +1,58 @@
+package diagnyx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestBatchEmbeddingTracker(t *testing.T) {
+	stub := &stubOpenAIClient{embedResp: openai.EmbeddingResponse{Usage: openai.Usage{PromptTokens: 7}}}
+	req := openai.EmbeddingRequest{Input: "doc", Model: openai.AdaEmbeddingV2}
+
+	t.Run("rolls up calls", func(t *testing.T) {
+		tracker := &fakeTracker{}
+		batch := NewBatchEmbeddingTracker(WrapOpenAI(stub, tracker), true)
+
+		for i := 0; i < 3; i++ {
+			batch.CreateEmbeddings(context.Background(), req)
+		}
+		if len(tracker.calls) != 0 {
+			t.Fatalf("expected no calls before Finish, got %d", len(tracker.calls))
+		}
+
+		batch.Finish()
+		if len(tracker.calls) != 1 {
+			t.Fatalf("expected 1 rolled-up call, got %d", len(tracker.calls))
+		}
+		call := tracker.calls[0]
+		if call.InputTokens != 21 {
+			t.Errorf("expected 21 input tokens, got %d", call.InputTokens)
+		}
+		if call.Metadata["embedding_count"] != 3 {
+			t.Errorf("expected embedding_count 3, got %v", call.Metadata["embedding_count"])
+		}
+		if call.Status != StatusSuccess || call.Endpoint != "/v1/embeddings" {
+			t.Errorf("unexpected rolled-up call: %+v", call)
+		}
+
+		batch.Finish()
+		if len(tracker.calls) != 1 {
+			t.Errorf("expected Finish without new calls to do nothing, got %d calls", len(tracker.calls))
+		}
+	})
+
+	t.Run("tracks each call", func(t *testing.T) {
+		tracker := &fakeTracker{}
+		batch := NewBatchEmbeddingTracker(WrapOpenAI(stub, tracker), false)
+
+		batch.CreateEmbeddings(context.Background(), req)
+		batch.CreateEmbeddings(context.Background(), req)
+		batch.Finish()
+
+		if len(tracker.calls) != 2 {
+			t.Errorf("expected 2 individual calls, got %d", len(tracker.calls))
+		}
+	})
+}
@@ -0,0 +1,21 @@
+package diagnyx
+
+import "os"
+
+// EnvironmentAuto, as Config.Environment, detects the environment with
+// DetectEnvironment when the client is created
+const EnvironmentAuto = "auto"
+
+// environmentVars are checked in order by DetectEnvironment
+var environmentVars = []string{"DIAGNYX_ENV", "ENV", "APP_ENV"}
+
+// DetectEnvironment returns the first non-empty value of the DIAGNYX_ENV,
+// ENV and APP_ENV environment variables, or "development" if none is set
+func DetectEnvironment() string {
+	for _, name := range environmentVars {
+		if env := os.Getenv(name); env != "" {
+			return env
+		}
+	}
+	return "development"
+}
@@ -0,0 +1,10 @@
+package diagnyx
+
+import "unicode/utf8"
+
+// EstimateTokens approximates the number of tokens in text at four
+// characters per token, the usual rule of thumb for English text with
+// OpenAI tokenizers. Use it only when the provider reports no usage.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
@@ -2,7 +2,9 @@ package diagnyx
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -44,6 +46,13 @@ type FeedbackOptions struct {
 	UserID string
 	// Session identifier
 	SessionID string
+	// Optional sentiment override, e.g. from InferSentiment. Left to the
+	// server when empty.
+	Sentiment FeedbackSentiment
+	// Timeout bounds the request, including retries. Each attempt is
+	// still limited by the 30s per-request timeout. Default: 0 (no bound
+	// beyond ctx)
+	Timeout time.Duration
 }
 
 // Feedback represents a feedback record
@@ -84,6 +93,10 @@ type ListFeedbackOptions struct {
 	Tag          string
 	StartDate    *time.Time
 	EndDate      *time.Time
+	// Timeout bounds the request, including retries. Each attempt is
+	// still limited by the 30s per-request timeout. Default: 0 (no bound
+	// beyond ctx)
+	Timeout time.Duration
 }
 
 // ListFeedbackResult contains the paginated feedback list
@@ -100,7 +113,12 @@ type FeedbackClient struct {
 	baseURL        string
 	organizationID string
 	maxRetries     int
+	retryPolicy    RetryPolicy
 	debug          bool
+	userAgent      string
+	authHeader     string
+	authScheme     string
+	dryRun         bool
 	httpClient     *http.Client
 }
 
@@ -111,6 +129,7 @@ func NewFeedbackClient(apiKey, organizationID string, opts ...FeedbackClientOpti
 		baseURL:        "https://api.diagnyx.io",
 		organizationID: organizationID,
 		maxRetries:     3,
+		retryPolicy:    DefaultRetryPolicy(),
 		debug:          false,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
@@ -124,6 +143,33 @@ func NewFeedbackClient(apiKey, organizationID string, opts ...FeedbackClientOpti
 	return c
 }
 
+// NewFeedbackClientFrom creates a feedback client that shares client's API
+// key, base URL, HTTP client, User-Agent, retry settings and debug logging,
+// so feedback goes through the same egress path as tracking. opts are
+// applied afterwards and may override any of these.
+func NewFeedbackClientFrom(client *Client, organizationID string, opts ...FeedbackClientOption) *FeedbackClient {
+	config := client.Config()
+	c := &FeedbackClient{
+		apiKey:         config.APIKey,
+		baseURL:        config.BaseURL,
+		organizationID: organizationID,
+		maxRetries:     config.MaxRetries,
+		retryPolicy:    config.RetryPolicy,
+		debug:          config.Debug,
+		userAgent:      config.UserAgent,
+		authHeader:     config.AuthHeader,
+		authScheme:     config.AuthScheme,
+		dryRun:         config.DryRun,
+		httpClient:     client.httpClient,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
 // FeedbackClientOption configures a FeedbackClient
 type FeedbackClientOption func(*FeedbackClient)
 
@@ -141,6 +187,13 @@ func WithFeedbackMaxRetries(retries int) FeedbackClientOption {
 	}
 }
 
+// WithFeedbackRetryPolicy sets the retry policy
+func WithFeedbackRetryPolicy(policy RetryPolicy) FeedbackClientOption {
+	return func(c *FeedbackClient) {
+		c.retryPolicy = policy
+	}
+}
+
 // WithFeedbackDebug enables debug mode
 func WithFeedbackDebug(debug bool) FeedbackClientOption {
 	return func(c *FeedbackClient) {
@@ -148,6 +201,38 @@ func WithFeedbackDebug(debug bool) FeedbackClientOption {
 	}
 }
 
+// WithFeedbackDryRun skips all HTTP requests. Submissions succeed and
+// return the submitted fields; queries return empty results.
+func WithFeedbackDryRun(dryRun bool) FeedbackClientOption {
+	return func(c *FeedbackClient) {
+		c.dryRun = dryRun
+	}
+}
+
+// WithFeedbackUserAgent appends an application identifier to the SDK's
+// User-Agent header
+func WithFeedbackUserAgent(userAgent string) FeedbackClientOption {
+	return func(c *FeedbackClient) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithFeedbackAuth sets the header and scheme that carry the API key, as
+// Config.AuthHeader and Config.AuthScheme do for the tracking client
+func WithFeedbackAuth(header, scheme string) FeedbackClientOption {
+	return func(c *FeedbackClient) {
+		c.authHeader = header
+		c.authScheme = scheme
+	}
+}
+
+// Close releases idle connections held by the client's HTTP transport.
+// Feedback is submitted synchronously, so there is nothing to flush.
+func (c *FeedbackClient) Close() error {
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
+
 // ThumbsUp submits positive feedback
 func (c *FeedbackClient) ThumbsUp(traceID string, opts *FeedbackOptions) (*Feedback, error) {
 	return c.submit(traceID, FeedbackTypeThumbsUp, nil, "", "", opts)
@@ -176,12 +261,61 @@ func (c *FeedbackClient) Correction(traceID, correction string, opts *FeedbackOp
 	return c.submit(traceID, FeedbackTypeCorrection, nil, "", correction, opts)
 }
 
+// CorrectionFromTrace submits a correction that carries the trace's
+// original content. See CorrectionFromTraceWithContext.
+func (c *FeedbackClient) CorrectionFromTrace(traceID, correction string, opts *FeedbackOptions) (*Feedback, error) {
+	return c.CorrectionFromTraceWithContext(context.Background(), traceID, correction, opts)
+}
+
+// CorrectionFromTraceWithContext submits a correction with the trace's
+// captured prompt and response attached as Metadata["original_prompt"] and
+// Metadata["original_response"], so the record is usable for fine-tuning
+// without a later join. If the trace is unknown or its content was not
+// captured, the correction is still submitted with
+// Metadata["trace_context_missing"] set to "trace_not_found" or
+// "content_not_captured". Other errors fetching the trace are returned
+// without submitting. opts.Timeout bounds both requests.
+func (c *FeedbackClient) CorrectionFromTraceWithContext(ctx context.Context, traceID, correction string, opts *FeedbackOptions) (*Feedback, error) {
+	if opts == nil {
+		opts = &FeedbackOptions{}
+	}
+	ctx, cancel := contextWithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	metadata := make(map[string]interface{}, len(opts.Metadata)+2)
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+
+	content, err := c.GetTraceContentWithContext(ctx, traceID)
+	var notFound *TraceNotFoundError
+	switch {
+	case errors.As(err, &notFound):
+		metadata["trace_context_missing"] = "trace_not_found"
+	case err != nil:
+		return nil, fmt.Errorf("failed to fetch trace content: %w", err)
+	case content.Prompt == "" && content.Response == "":
+		metadata["trace_context_missing"] = "content_not_captured"
+	default:
+		metadata["original_prompt"] = content.Prompt
+		metadata["original_response"] = content.Response
+	}
+
+	withContext := *opts
+	withContext.Metadata = metadata
+	return c.submitWithContext(ctx, traceID, FeedbackTypeCorrection, nil, "", correction, &withContext)
+}
+
 // Flag flags a response for review
 func (c *FeedbackClient) Flag(traceID string, reason string, opts *FeedbackOptions) (*Feedback, error) {
 	return c.submit(traceID, FeedbackTypeFlag, nil, reason, "", opts)
 }
 
 func (c *FeedbackClient) submit(traceID string, feedbackType FeedbackType, rating *int, comment, correction string, opts *FeedbackOptions) (*Feedback, error) {
+	return c.submitWithContext(context.Background(), traceID, feedbackType, rating, comment, correction, opts)
+}
+
+func (c *FeedbackClient) submitWithContext(ctx context.Context, traceID string, feedbackType FeedbackType, rating *int, comment, correction string, opts *FeedbackOptions) (*Feedback, error) {
 	if opts == nil {
 		opts = &FeedbackOptions{}
 	}
@@ -217,14 +351,20 @@ func (c *FeedbackClient) submit(traceID string, feedbackType FeedbackType, ratin
 	if opts.SessionID != "" {
 		payload["sessionId"] = opts.SessionID
 	}
+	if opts.Sentiment != "" {
+		payload["sentiment"] = opts.Sentiment
+	}
 
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
+	ctx, cancel := contextWithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
 	var result Feedback
-	err = c.request("POST", "/api/v1/feedback", body, &result)
+	err = c.requestWithContext(ctx, "POST", "/api/v1/feedback", body, &result)
 	if err != nil {
 		return nil, err
 	}
@@ -266,8 +406,11 @@ func (c *FeedbackClient) List(opts *ListFeedbackOptions) (*ListFeedbackResult, e
 		path += "?" + params.Encode()
 	}
 
+	ctx, cancel := contextWithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
 	var result ListFeedbackResult
-	err := c.request("GET", path, nil, &result)
+	err := c.requestWithContext(ctx, "GET", path, nil, &result)
 	if err != nil {
 		return nil, err
 	}
@@ -312,45 +455,152 @@ func (c *FeedbackClient) GetForTrace(traceID string) ([]Feedback, error) {
 	return result, nil
 }
 
+// GetForSpan retrieves feedback for a specific span
+func (c *FeedbackClient) GetForSpan(spanID string) ([]Feedback, error) {
+	return c.GetForSpanWithContext(context.Background(), spanID)
+}
+
+// GetForSpanWithContext retrieves feedback for a specific span using ctx for
+// the request. It returns a *FeedbackNotFoundError if the span is unknown.
+func (c *FeedbackClient) GetForSpanWithContext(ctx context.Context, spanID string) ([]Feedback, error) {
+	path := fmt.Sprintf("/api/v1/organizations/%s/feedback/span/%s", c.organizationID, url.PathEscape(spanID))
+
+	var result []Feedback
+	err := c.requestWithContext(ctx, "GET", path, nil, &result)
+	if err != nil {
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			return nil, &FeedbackNotFoundError{SpanID: spanID}
+		}
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// TraceContent is the content captured for a trace. Prompt and Response
+// are empty if content capture was disabled when the trace was tracked.
+type TraceContent struct {
+	TraceID  string `json:"traceId"`
+	Prompt   string `json:"prompt,omitempty"`
+	Response string `json:"response,omitempty"`
+}
+
+// GetTraceContent retrieves the captured prompt and response of a trace
+func (c *FeedbackClient) GetTraceContent(traceID string) (*TraceContent, error) {
+	return c.GetTraceContentWithContext(context.Background(), traceID)
+}
+
+// GetTraceContentWithContext retrieves the captured prompt and response of
+// a trace using ctx for the request. It returns a *TraceNotFoundError if the
+// trace is unknown.
+func (c *FeedbackClient) GetTraceContentWithContext(ctx context.Context, traceID string) (*TraceContent, error) {
+	path := fmt.Sprintf("/api/v1/organizations/%s/traces/%s/content", c.organizationID, url.PathEscape(traceID))
+
+	var result TraceContent
+	err := c.requestWithContext(ctx, "GET", path, nil, &result)
+	if err != nil {
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			return nil, &TraceNotFoundError{TraceID: traceID}
+		}
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// TraceNotFoundError is returned when content is requested for a trace the
+// API does not know
+type TraceNotFoundError struct {
+	TraceID string
+}
+
+func (e *TraceNotFoundError) Error() string {
+	return fmt.Sprintf("trace %s not found", e.TraceID)
+}
+
+// FeedbackNotFoundError is returned when feedback is requested for a span
+// the API does not know
+type FeedbackNotFoundError struct {
+	SpanID string
+}
+
+func (e *FeedbackNotFoundError) Error() string {
+	return fmt.Sprintf("no feedback found for span %s", e.SpanID)
+}
+
+// httpStatusError records a non-2xx response status
+type httpStatusError struct {
+	StatusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d", e.StatusCode)
+}
+
+// contextWithTimeout returns ctx bounded by timeout, or ctx itself if
+// timeout is not positive
+func contextWithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 func (c *FeedbackClient) request(method, path string, body []byte, result interface{}) error {
+	return c.requestWithContext(context.Background(), method, path, body, result)
+}
+
+func (c *FeedbackClient) requestWithContext(ctx context.Context, method, path string, body []byte, result interface{}) error {
+	if c.dryRun {
+		c.log("Dry run, not sending %s %s: %s", method, path, body)
+		if result != nil && body != nil {
+			json.Unmarshal(body, result)
+		}
+		return nil
+	}
+
 	var lastErr error
 
 	for attempt := 0; attempt < c.maxRetries; attempt++ {
-		req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
 		if err != nil {
 			return fmt.Errorf("failed to create request: %w", err)
 		}
 
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+		SetAuthHeader(req.Header, c.authHeader, c.authScheme, c.apiKey)
+		req.Header.Set("User-Agent", UserAgent(c.userAgent))
 
+		statusCode := 0
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
 			lastErr = err
-			c.log("Attempt %d failed: %v", attempt+1, err)
-			time.Sleep(time.Duration(1<<attempt) * time.Second)
-			continue
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-			if result != nil {
-				if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
-					return fmt.Errorf("failed to decode response: %w", err)
+		} else {
+			defer resp.Body.Close()
+
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				if result != nil {
+					if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+						return fmt.Errorf("failed to decode response: %w", err)
+					}
 				}
+				return nil
 			}
-			return nil
-		}
 
-		lastErr = fmt.Errorf("HTTP %d", resp.StatusCode)
+			statusCode = resp.StatusCode
+			lastErr = &httpStatusError{StatusCode: resp.StatusCode}
+		}
 		c.log("Attempt %d failed: %v", attempt+1, lastErr)
 
-		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-			// Don't retry client errors
+		retry, delay := c.retryPolicy.ShouldRetry(attempt, statusCode, err)
+		if !retry || attempt == c.maxRetries-1 {
 			return lastErr
 		}
-
-		time.Sleep(time.Duration(1<<attempt) * time.Second)
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
 	}
 
 	return lastErr
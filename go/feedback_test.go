@@ -0,0 +1,186 @@
+package diagnyx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFeedbackGetForSpan(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/organizations/org-1/feedback/span/span-1":
+			json.NewEncoder(w).Encode([]Feedback{{ID: "fb-1", TraceID: "trace-1", SpanID: "span-1"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NewFeedbackClient("test-key", "org-1", WithFeedbackBaseURL(server.URL))
+
+	feedback, err := client.GetForSpan("span-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(feedback) != 1 || feedback[0].SpanID != "span-1" {
+		t.Errorf("expected feedback for span-1, got %+v", feedback)
+	}
+
+	_, err = client.GetForSpan("missing")
+	var notFound *FeedbackNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected FeedbackNotFoundError, got %v", err)
+	}
+	if notFound.SpanID != "missing" {
+		t.Errorf("expected span ID 'missing', got '%s'", notFound.SpanID)
+	}
+}
+
+func TestFeedbackUserAgent(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		json.NewEncoder(w).Encode([]Feedback{})
+	}))
+	defer server.Close()
+
+	client := NewFeedbackClient("test-key", "org-1", WithFeedbackBaseURL(server.URL), WithFeedbackUserAgent("my-app/1.2"))
+	if _, err := client.GetForSpan("span-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := UserAgent("my-app/1.2"); userAgent != expected {
+		t.Errorf("expected User-Agent %q, got %q", expected, userAgent)
+	}
+}
+
+func TestNewFeedbackClientFrom(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("User-Agent") != UserAgent("my-app/1.2") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode([]Feedback{})
+	}))
+	defer server.Close()
+
+	custom := &http.Client{}
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		HTTPClient:      custom,
+		UserAgent:       "my-app/1.2",
+	})
+	defer client.Close()
+
+	feedback := NewFeedbackClientFrom(client, "org-1")
+	if feedback.httpClient != custom {
+		t.Error("expected the main client's HTTP client to be shared")
+	}
+	if feedback.maxRetries != 3 {
+		t.Errorf("expected the main client's max retries, got %d", feedback.maxRetries)
+	}
+	if _, err := feedback.GetForSpan("span-1"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFeedbackDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request in dry run: %s %s", r.Method, r.URL.Path)
+	}))
+	defer server.Close()
+
+	client := NewFeedbackClient("test-key", "org-1", WithFeedbackBaseURL(server.URL), WithFeedbackDryRun(true))
+
+	feedback, err := client.ThumbsUp("trace-1", &FeedbackOptions{Comment: "great"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if feedback.TraceID != "trace-1" || feedback.FeedbackType != FeedbackTypeThumbsUp || feedback.Comment != "great" {
+		t.Errorf("expected the submitted fields to be returned, got %+v", feedback)
+	}
+
+	if list, err := client.GetForSpan("span-1"); err != nil || len(list) != 0 {
+		t.Errorf("expected an empty result, got %v, %v", list, err)
+	}
+}
+
+func TestFeedbackTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		json.NewEncoder(w).Encode(Feedback{ID: "fb-1"})
+	}))
+	defer server.Close()
+
+	client := NewFeedbackClient("test-key", "org-1", WithFeedbackBaseURL(server.URL), WithFeedbackMaxRetries(1))
+	start := time.Now()
+	_, err := client.ThumbsUp("trace-1", &FeedbackOptions{Timeout: 20 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("expected the request to fail fast, took %s", elapsed)
+	}
+
+	if _, err := client.ThumbsUp("trace-1", nil); err != nil {
+		t.Errorf("expected the default timeout to allow the request, got %v", err)
+	}
+}
+
+func TestFeedbackCorrectionFromTrace(t *testing.T) {
+	var submitted []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/organizations/org-1/traces/trace-1/content":
+			json.NewEncoder(w).Encode(TraceContent{TraceID: "trace-1", Prompt: "[user]: Hi", Response: "Hello!"})
+		case "/api/v1/organizations/org-1/traces/trace-2/content":
+			json.NewEncoder(w).Encode(TraceContent{TraceID: "trace-2"})
+		case "/api/v1/feedback":
+			var payload map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			submitted = append(submitted, payload)
+			json.NewEncoder(w).Encode(Feedback{ID: "fb-1", Metadata: payload["metadata"].(map[string]interface{})})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NewFeedbackClient("test-key", "org-1", WithFeedbackBaseURL(server.URL), WithFeedbackMaxRetries(1))
+	opts := &FeedbackOptions{Metadata: map[string]interface{}{"source": "review"}}
+
+	feedback, err := client.CorrectionFromTrace("trace-1", "Hi there!", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if feedback.Metadata["original_prompt"] != "[user]: Hi" || feedback.Metadata["original_response"] != "Hello!" || feedback.Metadata["source"] != "review" {
+		t.Errorf("expected the trace content alongside the caller's metadata, got %v", feedback.Metadata)
+	}
+	if len(opts.Metadata) != 1 {
+		t.Errorf("expected the caller's metadata not to be modified, got %v", opts.Metadata)
+	}
+
+	for traceID, reason := range map[string]string{"trace-2": "content_not_captured", "missing": "trace_not_found"} {
+		feedback, err := client.CorrectionFromTraceWithContext(context.Background(), traceID, "fix", nil)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", traceID, err)
+		}
+		if feedback.Metadata["trace_context_missing"] != reason {
+			t.Errorf("expected %s to be marked %q, got %v", traceID, reason, feedback.Metadata)
+		}
+	}
+	if len(submitted) != 3 || submitted[0]["correction"] != "Hi there!" {
+		t.Errorf("expected 3 corrections to be submitted, got %v", submitted)
+	}
+}
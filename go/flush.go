@@ -0,0 +1,180 @@
+package diagnyx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FlushResult describes the outcome of a flush
+type FlushResult struct {
+	// Sent is the number of calls sent to the API
+	Sent int
+	// Response is the server's response, nil if nothing was sent
+	Response *BatchResponse
+}
+
+// RejectedCall is a call the server refused, with the reason it gave
+type RejectedCall struct {
+	Call   LLMCall
+	Reason string
+}
+
+// PartialFlushError is returned when a batch was delivered but the server
+// rejected some of its calls. Rejected calls are not re-buffered, since
+// resending them would fail again; they are passed to Config.OnError.
+type PartialFlushError struct {
+	Rejected []RejectedCall
+	Sent     int
+}
+
+func (e *PartialFlushError) Error() string {
+	return fmt.Sprintf("%d of %d calls rejected by server", len(e.Rejected), e.Sent)
+}
+
+// FlushWithResult sends all buffered calls to the API and reports the
+// server's response. On a transport or HTTP error the calls are put back in
+// the buffer, up to MaxBufferSize, and those that no longer fit are passed
+// to Config.OnError with ErrBufferFull; calls vetoed by Config.BeforeSend
+// are passed to Config.OnError. If the server rejects individual calls, the error is a
+// *PartialFlushError mapping each rejection back to its call. With
+// Config.ProjectRouting, if only some endpoints fail, just their calls are
+// put back and the result covers the calls the others accepted; the error
+// then joins the send error with any *PartialFlushError.
+func (c *Client) FlushWithResult() (*FlushResult, error) {
+	c.bufferMu.Lock()
+	if len(c.buffer) == 0 {
+		c.bufferMu.Unlock()
+		return &FlushResult{}, nil
+	}
+	calls := make([]LLMCall, len(c.buffer))
+	copy(calls, c.buffer)
+	c.buffer = c.buffer[:0]
+	c.oldestCall = time.Time{}
+	c.errorCount = 0
+	c.bufferMu.Unlock()
+
+	calls = c.dropStale(calls)
+	if len(calls) == 0 {
+		return &FlushResult{}, nil
+	}
+
+	resp, sendErr := c.send(context.Background(), calls, nil)
+	sent := len(calls)
+	if sendErr != nil {
+		// On error, put calls back in buffer, except those BeforeSend
+		// vetoed: they would be vetoed again on every flush
+		failed := failedCalls(calls, sendErr)
+		c.bufferMu.Lock()
+		overflow := c.rebufferLocked(failed, true)
+		c.bufferMu.Unlock()
+		c.log("Flush failed: %v", sendErr)
+		c.deadLetter(ErrBufferFull, overflow)
+		vetoed, vetoErr := vetoedCalls(calls, sendErr)
+		c.deadLetter(vetoErr, vetoed)
+
+		var routeErr *routeError
+		if !errors.As(sendErr, &routeErr) || len(failed)+len(vetoed) == len(calls) {
+			return nil, sendErr
+		}
+		// Other endpoints took their calls; report those
+		resp = routeErr.delivered
+		sent -= len(failed) + len(vetoed)
+	}
+
+	c.log("Flushed %d calls", sent)
+	result := &FlushResult{Sent: sent, Response: resp}
+
+	if rejected := rejectedCalls(calls, resp); len(rejected) > 0 {
+		partialErr := &PartialFlushError{Rejected: rejected, Sent: sent}
+		dropped := make([]LLMCall, len(rejected))
+		for i, r := range rejected {
+			dropped[i] = r.Call
+		}
+		c.deadLetter(partialErr, dropped)
+		if sendErr != nil {
+			return result, errors.Join(sendErr, partialErr)
+		}
+		return result, partialErr
+	}
+	return result, sendErr
+}
+
+// flushQueue coalesces background flushes: one runs at a time, and all
+// requests made while it runs share a single follow-up flush
+type flushQueue struct {
+	mu      sync.Mutex
+	running bool
+	// next holds the result channels of requests waiting for the
+	// follow-up flush
+	next []chan error
+}
+
+// FlushAsync flushes the buffer in the background and returns a channel
+// that receives the flush's error, or nil, exactly once and is then closed.
+// Only one background flush runs at a time: a request made while one is
+// running waits for a single follow-up flush shared with every other
+// request made meanwhile, so calls buffered since the running flush started
+// are covered without redundant work. Drain waits for background flushes.
+// With SyncFlush the flush runs before FlushAsync returns.
+func (c *Client) FlushAsync() <-chan error {
+	result := make(chan error, 1)
+	if c.config.SyncFlush {
+		result <- c.Flush()
+		close(result)
+		return result
+	}
+
+	c.flushes.mu.Lock()
+	if c.flushes.running {
+		c.flushes.next = append(c.flushes.next, result)
+		c.flushes.mu.Unlock()
+		return result
+	}
+	c.flushes.running = true
+	c.flushes.mu.Unlock()
+
+	c.inflight.Add(1)
+	go c.runFlushes([]chan error{result})
+	return result
+}
+
+// runFlushes flushes for waiters, then for each batch of requests queued
+// meanwhile, until none are left
+func (c *Client) runFlushes(waiters []chan error) {
+	defer c.inflight.Add(-1)
+	for {
+		err := c.Flush()
+		for _, result := range waiters {
+			result <- err
+			close(result)
+		}
+
+		c.flushes.mu.Lock()
+		if len(c.flushes.next) == 0 {
+			c.flushes.running = false
+			c.flushes.mu.Unlock()
+			return
+		}
+		waiters, c.flushes.next = c.flushes.next, nil
+		c.flushes.mu.Unlock()
+	}
+}
+
+// rejectedCalls maps the per-index errors of a response back to the calls
+// that were sent. Errors with an out-of-range index are ignored.
+func rejectedCalls(calls []LLMCall, resp *BatchResponse) []RejectedCall {
+	if resp == nil || len(resp.Errors) == 0 {
+		return nil
+	}
+	rejected := make([]RejectedCall, 0, len(resp.Errors))
+	for _, callErr := range resp.Errors {
+		if callErr.Index < 0 || callErr.Index >= len(calls) {
+			continue
+		}
+		rejected = append(rejected, RejectedCall{Call: calls[callErr.Index], Reason: callErr.Reason})
+	}
+	return rejected
+}
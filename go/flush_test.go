@@ -0,0 +1,335 @@
+package diagnyx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFlushRejectedCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(BatchResponse{
+			Tracked: 1,
+			Errors: []BatchCallError{
+				{Index: 1, Reason: "unknown model"},
+				{Index: 2, Reason: "unknown project"},
+				{Index: 9, Reason: "out of range"},
+			},
+		})
+	}))
+	defer server.Close()
+
+	var deadLettered []LLMCall
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		OnError: func(err error, calls []LLMCall) {
+			deadLettered = append(deadLettered, calls...)
+		},
+	})
+	defer client.Close()
+
+	client.TrackCalls([]LLMCall{
+		{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess},
+		{Provider: ProviderOpenAI, Model: "gpt-5-typo", Status: StatusSuccess},
+		{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, ProjectID: "missing"},
+	})
+
+	result, err := client.FlushWithResult()
+
+	var partialErr *PartialFlushError
+	if !errors.As(err, &partialErr) {
+		t.Fatalf("expected PartialFlushError, got %v", err)
+	}
+	if len(partialErr.Rejected) != 2 {
+		t.Fatalf("expected 2 rejected calls, got %d", len(partialErr.Rejected))
+	}
+	if partialErr.Rejected[0].Call.Model != "gpt-5-typo" || partialErr.Rejected[0].Reason != "unknown model" {
+		t.Errorf("unexpected first rejection: %+v", partialErr.Rejected[0])
+	}
+	if partialErr.Rejected[1].Call.ProjectID != "missing" || partialErr.Rejected[1].Reason != "unknown project" {
+		t.Errorf("unexpected second rejection: %+v", partialErr.Rejected[1])
+	}
+	if result == nil || result.Sent != 3 {
+		t.Errorf("expected result with 3 sent calls, got %+v", result)
+	}
+	if client.BufferSize() != 0 {
+		t.Errorf("expected rejected calls not to be re-buffered, got %d", client.BufferSize())
+	}
+	if len(deadLettered) != 2 {
+		t.Errorf("expected 2 dead-lettered calls, got %d", len(deadLettered))
+	}
+}
+
+func TestFlushAsync(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var batches []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req BatchRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		batches = append(batches, len(req.Calls))
+		first := len(batches) == 1
+		mu.Unlock()
+		if first {
+			<-release
+		}
+		json.NewEncoder(w).Encode(BatchResponse{Tracked: len(req.Calls)})
+	}))
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{APIKey: "test-key", BaseURL: server.URL, FlushIntervalMs: 60000})
+	defer client.Close()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"})
+	first := client.FlushAsync()
+	for client.BufferSize() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Requests made while the first flush runs share one follow-up flush
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"})
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"})
+	second, third := client.FlushAsync(), client.FlushAsync()
+	close(release)
+
+	for i, result := range []<-chan error{first, second, third} {
+		if err := <-result; err != nil {
+			t.Errorf("flush %d: unexpected error: %v", i, err)
+		}
+		if _, open := <-result; open {
+			t.Errorf("flush %d: expected the channel to be closed after the result", i)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(batches) != "[1 2]" {
+		t.Errorf("expected one flush and one coalesced follow-up, got batches %v", batches)
+	}
+}
+
+func TestProjectRouting(t *testing.T) {
+	t.Run("sends each project's calls to its endpoint", func(t *testing.T) {
+		defaultServer := newMockServer()
+		defer defaultServer.Close()
+		euServer := newMockServer()
+		defer euServer.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         defaultServer.URL,
+			FlushIntervalMs: 60000,
+			ProjectRouting:  map[string]string{"eu-project": euServer.URL},
+		})
+		defer client.Close()
+
+		client.TrackCalls([]LLMCall{
+			{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, ProjectID: "us-project"},
+			{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, ProjectID: "eu-project"},
+			{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess},
+			{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, ProjectID: "eu-project"},
+		})
+
+		result, err := client.FlushWithResult()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n := len(defaultServer.Calls()); n != 2 {
+			t.Errorf("expected 2 calls at the default endpoint, got %d", n)
+		}
+		for _, call := range euServer.Calls() {
+			if call.ProjectID != "eu-project" {
+				t.Errorf("unexpected project at the routed endpoint: %q", call.ProjectID)
+			}
+		}
+		if n := len(euServer.Calls()); n != 2 {
+			t.Errorf("expected 2 calls at the routed endpoint, got %d", n)
+		}
+		if result.Sent != 4 || result.Response.Tracked != 4 || result.Response.TotalTokens != 200 {
+			t.Errorf("expected merged response for 4 calls, got %+v", result.Response)
+		}
+	})
+
+	t.Run("one endpoint for every call sends one batch", func(t *testing.T) {
+		server := newMockServer()
+		defer server.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         server.URL,
+			FlushIntervalMs: 60000,
+			ProjectRouting:  map[string]string{"other": "http://127.0.0.1:1"},
+		})
+		defer client.Close()
+
+		client.TrackCalls([]LLMCall{
+			{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, ProjectID: "a"},
+			{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, ProjectID: "b"},
+		})
+		if err := client.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if server.RequestCount != 1 || len(server.Calls()) != 2 {
+			t.Errorf("expected one batch of 2 calls, got %d requests", server.RequestCount)
+		}
+	})
+
+	t.Run("re-buffers only the failed endpoint's calls", func(t *testing.T) {
+		defaultServer := newMockServer()
+		defer defaultServer.Close()
+		euServer := newMockServer()
+		euServer.StatusCode = http.StatusInternalServerError
+		defer euServer.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         defaultServer.URL,
+			FlushIntervalMs: 60000,
+			MaxRetries:      1,
+			ProjectRouting:  map[string]string{"eu-project": euServer.URL},
+		})
+		defer client.Close()
+
+		client.TrackCalls([]LLMCall{
+			{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, ProjectID: "us-project"},
+			{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, ProjectID: "eu-project"},
+		})
+
+		result, err := client.FlushWithResult()
+		if err == nil {
+			t.Fatal("expected an error for the failed endpoint")
+		}
+		if result == nil || result.Sent != 1 {
+			t.Errorf("expected result with 1 sent call, got %+v", result)
+		}
+		buffered := client.PeekBuffer()
+		if len(buffered) != 1 || buffered[0].ProjectID != "eu-project" {
+			t.Errorf("expected only the eu-project call re-buffered, got %+v", buffered)
+		}
+	})
+
+	t.Run("maps rejections back to the whole batch", func(t *testing.T) {
+		defaultServer := newMockServer()
+		defer defaultServer.Close()
+		euServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(BatchResponse{
+				Tracked: 1,
+				Errors:  []BatchCallError{{Index: 1, Reason: "unknown model"}},
+			})
+		}))
+		defer euServer.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         defaultServer.URL,
+			FlushIntervalMs: 60000,
+			ProjectRouting:  map[string]string{"eu-project": euServer.URL},
+		})
+		defer client.Close()
+
+		client.TrackCalls([]LLMCall{
+			{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, ProjectID: "eu-project"},
+			{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, ProjectID: "us-project"},
+			{Provider: ProviderOpenAI, Model: "gpt-5-typo", Status: StatusSuccess, ProjectID: "eu-project"},
+		})
+
+		_, err := client.FlushWithResult()
+		var partialErr *PartialFlushError
+		if !errors.As(err, &partialErr) {
+			t.Fatalf("expected PartialFlushError, got %v", err)
+		}
+		if len(partialErr.Rejected) != 1 || partialErr.Rejected[0].Call.Model != "gpt-5-typo" {
+			t.Errorf("expected the gpt-5-typo call rejected, got %+v", partialErr.Rejected)
+		}
+	})
+
+	t.Run("puts IDs in call order", func(t *testing.T) {
+		echoIDs := func(w http.ResponseWriter, r *http.Request) {
+			var req BatchRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			resp := BatchResponse{Tracked: len(req.Calls)}
+			for _, call := range req.Calls {
+				resp.IDs = append(resp.IDs, call.Model)
+			}
+			json.NewEncoder(w).Encode(resp)
+		}
+		defaultServer := httptest.NewServer(http.HandlerFunc(echoIDs))
+		defer defaultServer.Close()
+		euServer := httptest.NewServer(http.HandlerFunc(echoIDs))
+		defer euServer.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         defaultServer.URL,
+			FlushIntervalMs: 60000,
+			ProjectRouting:  map[string]string{"eu-project": euServer.URL},
+		})
+		defer client.Close()
+
+		client.TrackCalls([]LLMCall{
+			{Provider: ProviderOpenAI, Model: "a", Status: StatusSuccess, ProjectID: "eu-project"},
+			{Provider: ProviderOpenAI, Model: "b", Status: StatusSuccess},
+			{Provider: ProviderOpenAI, Model: "c", Status: StatusSuccess, ProjectID: "eu-project"},
+			{Provider: ProviderOpenAI, Model: "d", Status: StatusSuccess},
+		})
+		result, err := client.FlushWithResult()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := strings.Join(result.Response.IDs, ","); got != "a,b,c,d" {
+			t.Errorf("expected IDs in call order, got %s", got)
+		}
+	})
+
+	t.Run("reports rejections alongside a failed endpoint", func(t *testing.T) {
+		defaultServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(BatchResponse{
+				Tracked: 1,
+				Errors:  []BatchCallError{{Index: 1, Reason: "unknown model"}},
+			})
+		}))
+		defer defaultServer.Close()
+		euServer := newMockServer()
+		euServer.StatusCode = http.StatusInternalServerError
+		defer euServer.Close()
+
+		var dropped []LLMCall
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         defaultServer.URL,
+			FlushIntervalMs: 60000,
+			MaxRetries:      1,
+			ProjectRouting:  map[string]string{"eu-project": euServer.URL},
+			OnError:         func(err error, calls []LLMCall) { dropped = append(dropped, calls...) },
+		})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		client.TrackCalls([]LLMCall{
+			{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess},
+			{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, ProjectID: "eu-project"},
+			{Provider: ProviderOpenAI, Model: "gpt-5-typo", Status: StatusSuccess},
+		})
+		_, err := client.FlushWithResult()
+		var partialErr *PartialFlushError
+		if !errors.As(err, &partialErr) || len(partialErr.Rejected) != 1 {
+			t.Fatalf("expected a PartialFlushError for the rejected call, got %v", err)
+		}
+		var routeErr *routeError
+		if !errors.As(err, &routeErr) {
+			t.Errorf("expected the failed endpoint to be reported too, got %v", err)
+		}
+		if len(dropped) != 1 || dropped[0].Model != "gpt-5-typo" {
+			t.Errorf("expected the rejected call to be dead-lettered, got %+v", dropped)
+		}
+	})
+}
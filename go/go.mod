@@ -3,12 +3,12 @@ module github.com/diagnyxai/diagnyx-go
 go 1.22.0
 
 require (
-	github.com/google/uuid v1.6.0
 	github.com/sashabaranov/go-openai v1.17.9
 	github.com/tmc/langchaingo v0.1.12
 )
 
 require (
 	github.com/dlclark/regexp2 v1.10.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
 	github.com/pkoukk/tiktoken-go v0.1.6 // indirect
 )
@@ -0,0 +1,79 @@
+package guardrails
+
+import "time"
+
+// AuditRecord is the structured record of a guardrail decision passed to
+// Config.AuditLogger. Its JSON form is meant for audit trails and keeps
+// these field names.
+type AuditRecord struct {
+	SessionID      string `json:"sessionId"`
+	OrganizationID string `json:"organizationId"`
+	ProjectID      string `json:"projectId"`
+	// Decision is the event that concluded the session, EventSessionComplete
+	// or EventEarlyTermination
+	Decision EventType `json:"decision"`
+	// Allowed is the verdict: false for an early termination, otherwise as
+	// reported by the server when completing the session
+	Allowed bool `json:"allowed"`
+	// Reason is the early termination reason
+	Reason string `json:"reason,omitempty"`
+	// BlockingPolicyID is the policy whose violation terminated the session
+	BlockingPolicyID string `json:"blockingPolicyId,omitempty"`
+	TokensProcessed  int    `json:"tokensProcessed"`
+	// Violations are all the violations detected in the session
+	Violations []Violation `json:"violations"`
+	// Timestamp is when the decision was made, in Unix milliseconds
+	Timestamp int64 `json:"timestamp"`
+}
+
+// auditDecision passes the record of a terminal event to Config.AuditLogger,
+// once per session. session may be nil for a session the client no longer
+// tracks, in which case the record has no violations and is not
+// deduplicated.
+func (c *Client) auditDecision(session *Session, sessionID string, event Event) {
+	if c.config.AuditLogger == nil {
+		return
+	}
+
+	record := AuditRecord{
+		SessionID:      sessionID,
+		OrganizationID: c.config.OrganizationID,
+		ProjectID:      c.config.ProjectID,
+		Decision:       event.GetType(),
+		Timestamp:      event.GetTimestamp(),
+	}
+	switch e := event.(type) {
+	case *EarlyTerminationEvent:
+		record.Reason = e.Reason
+		record.TokensProcessed = e.TokensProcessed
+		if e.BlockingViolation != nil {
+			record.BlockingPolicyID = e.BlockingViolation.PolicyID
+		}
+	case *SessionCompleteEvent:
+		record.Allowed = e.Allowed
+		record.TokensProcessed = e.TotalTokens
+	default:
+		return
+	}
+	if record.Timestamp == 0 {
+		record.Timestamp = time.Now().UnixMilli()
+	}
+
+	if session != nil {
+		session.mu.Lock()
+		audited := session.audited
+		session.audited = true
+		session.mu.Unlock()
+		if audited {
+			return
+		}
+		if session.OrganizationID != "" {
+			record.OrganizationID = session.OrganizationID
+		}
+		if session.ProjectID != "" {
+			record.ProjectID = session.ProjectID
+		}
+		record.Violations = append([]Violation(nil), session.Violations...)
+	}
+	runCallback(c.log, "AuditLogger", func() { c.config.AuditLogger(record) })
+}
@@ -9,27 +9,68 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/diagnyxai/diagnyx-go"
 )
 
-// ViolationError is returned when a blocking guardrail violation occurs
+// ViolationError is returned when a blocking guardrail violation occurs.
+// Error includes the policy's internal message and is meant for logs; use
+// UserMessage for text shown to end users.
 type ViolationError struct {
 	Violation Violation
-	Session   *Session
+	Session   *StreamingGuardrailSession
+
+	userMessages map[string]string
 }
 
 func (e *ViolationError) Error() string {
 	return fmt.Sprintf("guardrail violation: %s", e.Violation.Message)
 }
 
+// DefaultUserMessage is the end-user message for violations of policy
+// types without a more specific one
+const DefaultUserMessage = "This response was blocked by a safety policy."
+
+// defaultUserMessages are the built-in end-user messages by policy type
+var defaultUserMessages = map[string]string{
+	"content_filter":   "This response was blocked by a content policy.",
+	"pii_detection":    "This response was blocked because it may contain personal information.",
+	"prompt_injection": "This request was blocked by a security policy.",
+}
+
+// UserMessage returns a message safe to show end users for the violation's
+// policy type, from StreamingGuardrailConfig.UserMessages or the built-in
+// defaults. It never includes the policy's own message.
+func (e *ViolationError) UserMessage() string {
+	policyType := e.Violation.PolicyType
+	if msg, ok := e.userMessages[policyType]; ok {
+		return msg
+	}
+	if msg, ok := defaultUserMessages[policyType]; ok {
+		return msg
+	}
+	if msg, ok := e.userMessages[""]; ok {
+		return msg
+	}
+	return DefaultUserMessage
+}
+
 // Client provides streaming guardrails evaluation
 type Client struct {
 	config     Config
 	httpClient *http.Client
 	sessions   map[string]*Session
-	mu         sync.RWMutex
+	pool       sessionPool
+	limiter    *rateLimiter
+	slots      *SessionLimiter
+	// parseErrors counts SSE frames that could not be decoded
+	parseErrors atomic.Int64
+	mu          sync.RWMutex
 }
 
 // NewClient creates a new streaming guardrails client
@@ -43,6 +84,15 @@ func NewClient(config Config) *Client {
 	if config.EvaluateEveryNTokens == 0 {
 		config.EvaluateEveryNTokens = 10
 	}
+	if config.EventBufferSize == 0 {
+		config.EventBufferSize = 100
+	}
+	if config.MaxPooledSessions == 0 {
+		config.MaxPooledSessions = 10
+	}
+	if config.PooledSessionTTL == 0 {
+		config.PooledSessionTTL = time.Minute
+	}
 
 	return &Client{
 		config: config,
@@ -50,6 +100,8 @@ func NewClient(config Config) *Client {
 			Timeout: time.Duration(config.Timeout) * time.Second,
 		},
 		sessions: make(map[string]*Session),
+		limiter:  newRateLimiter(config.MaxEvaluationsPerSecond),
+		slots:    NewSessionLimiter(config.MaxConcurrentSessions, config.SessionWaitTimeout),
 	}
 }
 
@@ -60,15 +112,61 @@ func (c *Client) log(msg string) {
 }
 
 func (c *Client) getBaseEndpoint() string {
+	return c.endpointFor(c.config.OrganizationID)
+}
+
+func (c *Client) endpointFor(organizationID string) string {
 	return fmt.Sprintf("%s/api/v1/organizations/%s/guardrails",
 		strings.TrimSuffix(c.config.BaseURL, "/"),
-		c.config.OrganizationID)
+		organizationID)
+}
+
+// sessionEndpoint returns the endpoint for the organization a session was
+// started under, or the configured organization for unknown sessions
+func (c *Client) sessionEndpoint(sessionID string) string {
+	c.mu.RLock()
+	session := c.sessions[sessionID]
+	c.mu.RUnlock()
+	if session != nil && session.OrganizationID != "" {
+		return c.endpointFor(session.OrganizationID)
+	}
+	return c.getBaseEndpoint()
 }
 
-// StartSession starts a new streaming guardrails session
-func (c *Client) StartSession(ctx context.Context, sessionID, input string) (*SessionStartedEvent, error) {
+// Tenant selects the organization and project of a session, letting one
+// client serve several tenants. Empty fields fall back to the Config values.
+type Tenant struct {
+	OrganizationID string
+	ProjectID      string
+}
+
+// resolveTenant merges an optional Tenant override with the config
+func (c *Client) resolveTenant(tenant []Tenant) Tenant {
+	resolved := Tenant{OrganizationID: c.config.OrganizationID, ProjectID: c.config.ProjectID}
+	if len(tenant) > 0 {
+		if tenant[0].OrganizationID != "" {
+			resolved.OrganizationID = tenant[0].OrganizationID
+		}
+		if tenant[0].ProjectID != "" {
+			resolved.ProjectID = tenant[0].ProjectID
+		}
+	}
+	return resolved
+}
+
+// StartSession starts a new streaming guardrails session. An optional Tenant
+// overrides the configured organization and project; EvaluateToken,
+// CompleteSession and CancelSession then use the session's organization.
+// Config.OnDuplicateSession selects what happens if sessionID is already
+// active.
+func (c *Client) StartSession(ctx context.Context, sessionID, input string, tenant ...Tenant) (*SessionStartedEvent, error) {
+	if resumed, err := c.existingSession(sessionID); resumed != nil || err != nil {
+		return resumed, err
+	}
+
+	t := c.resolveTenant(tenant)
 	req := StartSessionRequest{
-		ProjectID:              c.config.ProjectID,
+		ProjectID:              t.ProjectID,
 		EvaluateEveryNTokens:   c.config.EvaluateEveryNTokens,
 		EnableEarlyTermination: c.config.EnableEarlyTermination,
 	}
@@ -79,19 +177,70 @@ func (c *Client) StartSession(ctx context.Context, sessionID, input string) (*Se
 		req.Input = input
 	}
 
+	if c.config.DryRun {
+		return c.startLocalSession(req, t), nil
+	}
+
+	// A replaced session hands its slot to the new one
+	replaced := c.takeSlot(sessionID)
+	if replaced == nil {
+		if err := c.slots.acquire(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start session: %w", err)
+		}
+	}
+	started, err := c.startSession(ctx, req, t)
+	if err != nil {
+		c.returnSlot(sessionID, replaced)
+	}
+	return started, err
+}
+
+// takeSlot moves the MaxConcurrentSessions slot of the active session
+// sessionID to the caller and returns the session, or nil if there is no
+// such session holding a slot
+func (c *Client) takeSlot(sessionID string) *Session {
+	if sessionID == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	session := c.sessions[sessionID]
+	if session == nil || !session.slot {
+		return nil
+	}
+	session.slot = false
+	return session
+}
+
+// returnSlot gives a slot taken by a failed start back to the session it
+// was taken from, or frees it if that session is gone
+func (c *Client) returnSlot(sessionID string, replaced *Session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if replaced != nil && c.sessions[sessionID] == replaced {
+		replaced.slot = true
+		return
+	}
+	c.slots.release()
+}
+
+// startSession asks the server to start a session and registers it as
+// holding the slot its caller acquired
+func (c *Client) startSession(ctx context.Context, req StartSessionRequest, t Tenant) (*SessionStartedEvent, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
-		c.getBaseEndpoint()+"/evaluate/stream/start", bytes.NewReader(body))
+		c.endpointFor(t.OrganizationID)+"/evaluate/stream/start", bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+	diagnyx.SetAuthHeader(httpReq.Header, c.config.AuthHeader, c.config.AuthScheme, c.config.APIKey)
+	httpReq.Header.Set("User-Agent", diagnyx.UserAgent(c.config.UserAgent))
 	httpReq.Header.Set("Accept", "application/json")
 
 	resp, err := c.httpClient.Do(httpReq)
@@ -101,7 +250,7 @@ func (c *Client) StartSession(ctx context.Context, sessionID, input string) (*Se
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, &statusError{StatusCode: resp.StatusCode}
 	}
 
 	var data map[string]interface{}
@@ -112,12 +261,14 @@ func (c *Client) StartSession(ctx context.Context, sessionID, input string) (*Se
 	event := parseEvent(data)
 	if startEvent, ok := event.(*SessionStartedEvent); ok {
 		c.mu.Lock()
+		c.removeSessionLocked(startEvent.SessionID)
 		c.sessions[startEvent.SessionID] = &Session{
 			SessionID:      startEvent.SessionID,
-			OrganizationID: c.config.OrganizationID,
-			ProjectID:      c.config.ProjectID,
+			OrganizationID: t.OrganizationID,
+			ProjectID:      t.ProjectID,
 			ActivePolicies: startEvent.ActivePolicies,
 			Allowed:        true,
+			slot:           true,
 		}
 		c.mu.Unlock()
 		c.log(fmt.Sprintf("Session started: %s", startEvent.SessionID))
@@ -125,12 +276,60 @@ func (c *Client) StartSession(ctx context.Context, sessionID, input string) (*Se
 	}
 
 	if errEvent, ok := event.(*ErrorEvent); ok {
-		return nil, fmt.Errorf("failed to start session: %s", errEvent.Error)
+		return nil, fmt.Errorf("failed to start session: %w", errEvent.Err())
 	}
 
 	return nil, fmt.Errorf("unexpected response type")
 }
 
+// existingSession applies Config.OnDuplicateSession to a start request for
+// sessionID. It returns the event of a resumed session or an error, and nil
+// for both if a new session should be started.
+func (c *Client) existingSession(sessionID string) (*SessionStartedEvent, error) {
+	if sessionID == "" {
+		return nil, nil
+	}
+	c.mu.RLock()
+	session := c.sessions[sessionID]
+	c.mu.RUnlock()
+	if session == nil {
+		return nil, nil
+	}
+
+	switch c.config.OnDuplicateSession {
+	case DuplicateSessionError:
+		return nil, fmt.Errorf("failed to start session %s: %w", sessionID, ErrDuplicateSession)
+	case DuplicateSessionResume:
+		c.log(fmt.Sprintf("Resuming active session: %s", sessionID))
+		return &SessionStartedEvent{
+			BaseEvent:      BaseEvent{Type: EventSessionStarted, SessionID: sessionID, Timestamp: time.Now().UnixMilli()},
+			ActivePolicies: session.ActivePolicies,
+		}, nil
+	}
+	c.log(fmt.Sprintf("Replacing active session: %s", sessionID))
+	return nil, nil
+}
+
+// startLocalSession registers a DryRun session without contacting the server
+func (c *Client) startLocalSession(req StartSessionRequest, t Tenant) *SessionStartedEvent {
+	sessionID := req.SessionID
+	if sessionID == "" {
+		sessionID = diagnyx.NewID()
+	}
+	c.mu.Lock()
+	c.sessions[sessionID] = &Session{
+		SessionID:      sessionID,
+		OrganizationID: t.OrganizationID,
+		ProjectID:      t.ProjectID,
+		Allowed:        true,
+	}
+	c.mu.Unlock()
+	c.log(fmt.Sprintf("Dry run, session started locally: %s", sessionID))
+	return &SessionStartedEvent{
+		BaseEvent: BaseEvent{Type: EventSessionStarted, SessionID: sessionID, Timestamp: time.Now().UnixMilli()},
+	}
+}
+
 // EvaluateToken evaluates a token against guardrail policies
 func (c *Client) EvaluateToken(ctx context.Context, sessionID, token string, tokenIndex *int, isLast bool) (<-chan Event, error) {
 	c.mu.RLock()
@@ -142,12 +341,29 @@ func (c *Client) EvaluateToken(ctx context.Context, sessionID, token string, tok
 		errChan <- &ErrorEvent{
 			BaseEvent: BaseEvent{Type: EventError, SessionID: sessionID, Timestamp: time.Now().UnixMilli()},
 			Error:     "Session not found",
-			Code:      "SESSION_NOT_FOUND",
+			Code:      ErrorCodeSessionNotFound,
 		}
 		close(errChan)
 		return errChan, nil
 	}
 
+	if c.config.DryRun {
+		index := session.TokensProcessed
+		if tokenIndex != nil {
+			index = *tokenIndex
+		}
+		session.TokensProcessed = index + 1
+
+		events := make(chan Event, 1)
+		c.emit(session, events, &TokenAllowedEvent{
+			BaseEvent:  BaseEvent{Type: EventTokenAllowed, SessionID: sessionID, Timestamp: time.Now().UnixMilli()},
+			TokenIndex: index,
+			Token:      token,
+		})
+		close(events)
+		return events, nil
+	}
+
 	req := EvaluateTokenRequest{
 		SessionID: sessionID,
 		Token:     token,
@@ -162,15 +378,66 @@ func (c *Client) EvaluateToken(ctx context.Context, sessionID, token string, tok
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
+	endpoint := c.endpointFor(session.OrganizationID)
+	resp, err := c.postEvaluate(ctx, endpoint, body, "")
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event, 10)
+
+	go func() {
+		defer close(events)
+
+		seen := make(map[string]bool)
+		for reconnects := 0; ; reconnects++ {
+			err := c.readTokenEvents(resp, session, sessionID, token, seen, events)
+			resp.Body.Close()
+			if err == nil || reconnects >= c.config.MaxReconnects || ctx.Err() != nil {
+				if err != nil {
+					c.log(fmt.Sprintf("Error reading stream: %v", err))
+				}
+				return
+			}
+
+			lastEventID := session.getLastEventID()
+			c.log(fmt.Sprintf("Stream interrupted (%v), reconnecting from event %q", err, lastEventID))
+			resp, err = c.postEvaluate(ctx, endpoint, body, lastEventID)
+			if err != nil {
+				c.log(fmt.Sprintf("Reconnect failed: %v", err))
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// postEvaluate sends a token evaluation request. A non-empty lastEventID is
+// sent as the Last-Event-ID header so the server can resume the stream.
+// It first waits for the MaxEvaluationsPerSecond limiter.
+func (c *Client) postEvaluate(ctx context.Context, endpoint string, body []byte, lastEventID string) (*http.Response, error) {
+	wait, err := c.limiter.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 && c.config.OnRateLimitWait != nil {
+		runCallback(c.log, "OnRateLimitWait", func() { c.config.OnRateLimitWait(wait) })
+	}
+
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
-		c.getBaseEndpoint()+"/evaluate/stream", bytes.NewReader(body))
+		endpoint+"/evaluate/stream", bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+	diagnyx.SetAuthHeader(httpReq.Header, c.config.AuthHeader, c.config.AuthScheme, c.config.APIKey)
+	httpReq.Header.Set("User-Agent", diagnyx.UserAgent(c.config.UserAgent))
 	httpReq.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		httpReq.Header.Set("Last-Event-ID", lastEventID)
+	}
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
@@ -179,60 +446,117 @@ func (c *Client) EvaluateToken(ctx context.Context, sessionID, token string, tok
 
 	if resp.StatusCode != http.StatusOK {
 		resp.Body.Close()
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, &statusError{StatusCode: resp.StatusCode}
 	}
 
-	events := make(chan Event, 10)
-
-	go func() {
-		defer close(events)
-		defer resp.Body.Close()
+	return resp, nil
+}
 
-		reader := bufio.NewReader(resp.Body)
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				if err != io.EOF {
-					c.log(fmt.Sprintf("Error reading stream: %v", err))
-				}
-				return
+// readTokenEvents forwards events from an evaluation stream until it ends.
+// It returns nil when the stream finished or went idle, and the read error
+// when the connection was lost. Events whose ID is already in seen are
+// skipped, so a server that ignores Last-Event-ID and replays from the start
+// does not produce duplicates. TokenAllowedEvents carry token unless the
+// server named the token itself.
+func (c *Client) readTokenEvents(resp *http.Response, session *Session, sessionID, token string, seen map[string]bool, events chan<- Event) error {
+	watchdog := newIdleWatchdog(c.config.StreamIdleTimeout, resp.Body)
+	defer watchdog.stop()
+
+	reader := newSSEReader(resp.Body, watchdog.reset)
+	for {
+		frame, err := reader.next()
+		if err != nil {
+			if watchdog.idle() {
+				c.log(fmt.Sprintf("Stream idle for %s, closing", c.config.StreamIdleTimeout))
+				c.emit(session, events, c.idleEvent(sessionID))
+				return nil
 			}
-
-			line = strings.TrimSpace(line)
-			if !strings.HasPrefix(line, "data: ") {
-				continue
+			if err == io.EOF {
+				return nil
 			}
+			return err
+		}
 
-			jsonData := line[6:]
-			var data map[string]interface{}
-			if err := json.Unmarshal([]byte(jsonData), &data); err != nil {
-				c.log(fmt.Sprintf("Failed to parse event: %v", err))
+		if frame.id != "" {
+			if seen[frame.id] {
 				continue
 			}
+			seen[frame.id] = true
+			session.setLastEventID(frame.id)
+		}
 
-			event := parseEvent(data)
-			c.updateSession(session, event)
-			events <- event
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(frame.data), &data); err != nil {
+			c.log(fmt.Sprintf("Failed to parse event: %v", err))
+			c.emit(session, events, c.parseErrorEvent(sessionID, frame.data, err))
+			continue
+		}
 
-			switch event.GetType() {
-			case EventEarlyTermination, EventSessionComplete, EventError:
-				return
-			}
+		event := parseEvent(data)
+		if allowed, ok := event.(*TokenAllowedEvent); ok && allowed.Token == "" {
+			allowed.Token = token
 		}
-	}()
+		c.updateSession(session, event)
+		c.auditDecision(session, sessionID, event)
+		c.emit(session, events, event)
 
-	return events, nil
+		switch event.GetType() {
+		case EventEarlyTermination, EventSessionComplete, EventError:
+			return nil
+		}
+	}
+}
+
+// emit records an event in the session's replay buffer and delivers it
+func (c *Client) emit(session *Session, events chan<- Event, event Event) {
+	session.recordEvent(event, c.config.EventBufferSize)
+	events <- event
+}
+
+// SessionEvents returns the most recent events delivered by EvaluateToken
+// for an active session, oldest first, so a consumer that attaches late can
+// replay them. At most Config.EventBufferSize events are kept; the buffer is
+// discarded when the session is completed or canceled.
+func (c *Client) SessionEvents(sessionID string) []Event {
+	c.mu.RLock()
+	session := c.sessions[sessionID]
+	c.mu.RUnlock()
+	if session == nil {
+		return nil
+	}
+	return session.recentEvents()
 }
 
 // CompleteSession completes a streaming session manually
 func (c *Client) CompleteSession(ctx context.Context, sessionID string) (<-chan Event, error) {
+	if c.config.DryRun {
+		c.mu.Lock()
+		session := c.sessions[sessionID]
+		c.removeSessionLocked(sessionID)
+		c.mu.Unlock()
+
+		complete := &SessionCompleteEvent{
+			BaseEvent: BaseEvent{Type: EventSessionComplete, SessionID: sessionID, Timestamp: time.Now().UnixMilli()},
+			Allowed:   true,
+		}
+		if session != nil {
+			complete.TotalTokens = session.TokensProcessed
+		}
+		c.auditDecision(session, sessionID, complete)
+		events := make(chan Event, 1)
+		events <- complete
+		close(events)
+		return events, nil
+	}
+
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
-		fmt.Sprintf("%s/evaluate/stream/%s/complete", c.getBaseEndpoint(), sessionID), nil)
+		fmt.Sprintf("%s/evaluate/stream/%s/complete", c.sessionEndpoint(sessionID), sessionID), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	httpReq.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+	diagnyx.SetAuthHeader(httpReq.Header, c.config.AuthHeader, c.config.AuthScheme, c.config.APIKey)
+	httpReq.Header.Set("User-Agent", diagnyx.UserAgent(c.config.UserAgent))
 	httpReq.Header.Set("Accept", "text/event-stream")
 
 	resp, err := c.httpClient.Do(httpReq)
@@ -242,9 +566,10 @@ func (c *Client) CompleteSession(ctx context.Context, sessionID string) (<-chan
 
 	if resp.StatusCode != http.StatusOK {
 		resp.Body.Close()
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, &statusError{StatusCode: resp.StatusCode}
 	}
 
+	session := c.GetSession(sessionID)
 	events := make(chan Event, 10)
 
 	go func() {
@@ -252,16 +577,23 @@ func (c *Client) CompleteSession(ctx context.Context, sessionID string) (<-chan
 		defer resp.Body.Close()
 		defer func() {
 			c.mu.Lock()
-			delete(c.sessions, sessionID)
+			c.removeSessionLocked(sessionID)
 			c.mu.Unlock()
 		}()
 
+		watchdog := newIdleWatchdog(c.config.StreamIdleTimeout, resp.Body)
+		defer watchdog.stop()
+
 		reader := bufio.NewReader(resp.Body)
 		for {
 			line, err := reader.ReadString('\n')
 			if err != nil {
+				if watchdog.idle() {
+					events <- c.idleEvent(sessionID)
+				}
 				return
 			}
+			watchdog.reset()
 
 			line = strings.TrimSpace(line)
 			if !strings.HasPrefix(line, "data: ") {
@@ -270,25 +602,65 @@ func (c *Client) CompleteSession(ctx context.Context, sessionID string) (<-chan
 
 			var data map[string]interface{}
 			if err := json.Unmarshal([]byte(line[6:]), &data); err != nil {
+				events <- c.parseErrorEvent(sessionID, line[6:], err)
 				continue
 			}
 
-			events <- parseEvent(data)
+			event := parseEvent(data)
+			c.auditDecision(session, sessionID, event)
+			events <- event
 		}
 	}()
 
 	return events, nil
 }
 
+// Close cancels the idle pooled sessions, which only the client knows
+// about, then forgets all sessions and releases idle connections held by
+// the client's HTTP transport. Other sessions are not canceled on the
+// server; call CancelSession first for those that should not run to
+// completion. It returns the first error canceling a pooled session.
+func (c *Client) Close() error {
+	var firstErr error
+	for _, session := range c.pool.drain() {
+		if c.GetSession(session.SessionID) != session {
+			// Completed or canceled while pooled
+			continue
+		}
+		if _, err := c.CancelSession(context.Background(), session.SessionID); err != nil {
+			c.log(fmt.Sprintf("Failed to cancel pooled session %s: %v", session.SessionID, err))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to cancel pooled session %s: %w", session.SessionID, err)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	for id := range c.sessions {
+		c.removeSessionLocked(id)
+	}
+	c.mu.Unlock()
+	c.httpClient.CloseIdleConnections()
+	return firstErr
+}
+
 // CancelSession cancels a streaming session
 func (c *Client) CancelSession(ctx context.Context, sessionID string) (bool, error) {
+	if c.config.DryRun {
+		c.mu.Lock()
+		c.removeSessionLocked(sessionID)
+		c.mu.Unlock()
+		return true, nil
+	}
+
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodDelete,
-		fmt.Sprintf("%s/evaluate/stream/%s", c.getBaseEndpoint(), sessionID), nil)
+		fmt.Sprintf("%s/evaluate/stream/%s", c.sessionEndpoint(sessionID), sessionID), nil)
 	if err != nil {
 		return false, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	httpReq.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+	diagnyx.SetAuthHeader(httpReq.Header, c.config.AuthHeader, c.config.AuthScheme, c.config.APIKey)
+	httpReq.Header.Set("User-Agent", diagnyx.UserAgent(c.config.UserAgent))
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
@@ -297,7 +669,7 @@ func (c *Client) CancelSession(ctx context.Context, sessionID string) (bool, err
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return false, &statusError{StatusCode: resp.StatusCode}
 	}
 
 	var result struct {
@@ -308,12 +680,47 @@ func (c *Client) CancelSession(ctx context.Context, sessionID string) (bool, err
 	}
 
 	c.mu.Lock()
-	delete(c.sessions, sessionID)
+	c.removeSessionLocked(sessionID)
 	c.mu.Unlock()
 
 	return result.Cancelled, nil
 }
 
+// removeSessionLocked forgets a session and frees its
+// MaxConcurrentSessions slot. Callers must hold c.mu.
+func (c *Client) removeSessionLocked(sessionID string) {
+	if session := c.sessions[sessionID]; session != nil && session.slot {
+		c.slots.release()
+	}
+	delete(c.sessions, sessionID)
+}
+
+// ActiveSessions returns the IDs of the sessions this client is tracking,
+// sorted. Sessions are removed once completed or cancelled.
+func (c *Client) ActiveSessions() []string {
+	c.mu.RLock()
+	ids := make([]string, 0, len(c.sessions))
+	for id := range c.sessions {
+		ids = append(ids, id)
+	}
+	c.mu.RUnlock()
+	sort.Strings(ids)
+	return ids
+}
+
+// CancelAll cancels every session returned by ActiveSessions and returns
+// the errors of those that could not be cancelled. Sessions started while
+// CancelAll runs are left alone.
+func (c *Client) CancelAll(ctx context.Context) []error {
+	var errs []error
+	for _, id := range c.ActiveSessions() {
+		if _, err := c.CancelSession(ctx, id); err != nil {
+			errs = append(errs, fmt.Errorf("failed to cancel session %s: %w", id, err))
+		}
+	}
+	return errs
+}
+
 // GetSession returns the current state of a session
 func (c *Client) GetSession(sessionID string) *Session {
 	c.mu.RLock()
@@ -321,6 +728,33 @@ func (c *Client) GetSession(sessionID string) *Session {
 	return c.sessions[sessionID]
 }
 
+// idleEvent builds the ErrorEvent emitted when a stream stalls
+func (c *Client) idleEvent(sessionID string) *ErrorEvent {
+	return &ErrorEvent{
+		BaseEvent: BaseEvent{Type: EventError, SessionID: sessionID, Timestamp: time.Now().UnixMilli()},
+		Error:     ErrStreamIdle.Error(),
+		Code:      ErrorCodeStreamIdle,
+	}
+}
+
+// parseErrorEvent counts a malformed frame and builds the ErrorEvent
+// reporting it. The stream continues after it.
+func (c *Client) parseErrorEvent(sessionID, raw string, err error) *ErrorEvent {
+	c.parseErrors.Add(1)
+	return &ErrorEvent{
+		BaseEvent: BaseEvent{Type: EventError, SessionID: sessionID, Timestamp: time.Now().UnixMilli()},
+		Error:     parseErrorMessage(raw, err),
+		Code:      ErrorCodeParseError,
+	}
+}
+
+// ParseErrors returns how many events were lost because their SSE frame
+// was not valid JSON. Each one is also delivered as an ErrorEvent with
+// code PARSE_ERROR.
+func (c *Client) ParseErrors() int64 {
+	return c.parseErrors.Load()
+}
+
 func (c *Client) updateSession(session *Session, event Event) {
 	switch e := event.(type) {
 	case *ViolationDetectedEvent:
@@ -360,6 +794,7 @@ func parseEvent(data map[string]interface{}) Event {
 			BaseEvent:         base,
 			TokenIndex:        getInt(data, "tokenIndex", "token_index"),
 			AccumulatedLength: getInt(data, "accumulatedLength", "accumulated_length"),
+			Token:             getString(data, "token"),
 		}
 	case EventViolationDetected:
 		return &ViolationDetectedEvent{
@@ -0,0 +1,610 @@
+package guardrails
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/diagnyxai/diagnyx-go"
+)
+
+// newReconnectServer serves an evaluation stream that drops after the first
+// event on the first request. If honorLastEventID is false, the retried
+// request replays every event from the start.
+func newReconnectServer(t *testing.T, honorLastEventID bool) (*httptest.Server, *[]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var lastEventIDs []string
+	requests := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/organizations/org-1/guardrails/evaluate/stream/start", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": "sess-1"})
+	})
+	mux.HandleFunc("/api/v1/organizations/org-1/guardrails/evaluate/stream", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		attempt := requests
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		violation := `{"type":"violation_detected","sessionId":"sess-1","policyId":"pii","enforcementLevel":"warning"}`
+		allowed := `{"type":"token_allowed","sessionId":"sess-1","tokenIndex":0}`
+
+		if attempt == 1 {
+			fmt.Fprintf(w, "id: 1\ndata: %s\n\n", violation)
+			w.(http.Flusher).Flush()
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("failed to hijack connection: %v", err)
+				return
+			}
+			conn.Close()
+			return
+		}
+
+		if !honorLastEventID {
+			fmt.Fprintf(w, "id: 1\ndata: %s\n\n", violation)
+		}
+		fmt.Fprintf(w, "id: 2\ndata: %s\n\n", allowed)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, &lastEventIDs
+}
+
+func TestEvaluateTokenReconnect(t *testing.T) {
+	for _, honor := range []bool{true, false} {
+		t.Run(fmt.Sprintf("honorLastEventID=%v", honor), func(t *testing.T) {
+			server, lastEventIDs := newReconnectServer(t, honor)
+			client := NewClient(Config{
+				APIKey:         "test-key",
+				OrganizationID: "org-1",
+				BaseURL:        server.URL,
+				MaxReconnects:  1,
+			})
+
+			ctx := context.Background()
+			if _, err := client.StartSession(ctx, "", ""); err != nil {
+				t.Fatalf("failed to start session: %v", err)
+			}
+
+			events, err := client.EvaluateToken(ctx, "sess-1", "hello", nil, false)
+			if err != nil {
+				t.Fatalf("failed to evaluate token: %v", err)
+			}
+
+			var types []EventType
+			for event := range events {
+				types = append(types, event.GetType())
+			}
+
+			expected := []EventType{EventViolationDetected, EventTokenAllowed}
+			if fmt.Sprint(types) != fmt.Sprint(expected) {
+				t.Errorf("expected events %v, got %v", expected, types)
+			}
+			if got := fmt.Sprint(*lastEventIDs); got != "[ 1]" {
+				t.Errorf("expected Last-Event-ID only on reconnect, got %q", got)
+			}
+
+			session := client.GetSession("sess-1")
+			if len(session.Violations) != 1 {
+				t.Errorf("expected 1 violation, got %d", len(session.Violations))
+			}
+			if session.LastEventID() != "2" {
+				t.Errorf("expected last event ID '2', got '%s'", session.LastEventID())
+			}
+		})
+	}
+}
+
+func TestClientTenantOverride(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	var projectIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/api/v1/organizations/org-2/guardrails/evaluate/stream/start":
+			var req StartSessionRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			projectIDs = append(projectIDs, req.ProjectID)
+			mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": "sess-2"})
+		case r.Method == http.MethodDelete:
+			json.NewEncoder(w).Encode(map[string]bool{"cancelled": true})
+		default:
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"type\":\"token_allowed\",\"tokenIndex\":0}\n\n")
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(Config{
+		APIKey:         "test-key",
+		OrganizationID: "org-1",
+		ProjectID:      "proj-1",
+		BaseURL:        server.URL,
+	})
+
+	ctx := context.Background()
+	if _, err := client.StartSession(ctx, "", "", Tenant{OrganizationID: "org-2", ProjectID: "proj-2"}); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	session := client.GetSession("sess-2")
+	if session.OrganizationID != "org-2" || session.ProjectID != "proj-2" {
+		t.Errorf("expected session for org-2/proj-2, got %s/%s", session.OrganizationID, session.ProjectID)
+	}
+
+	events, err := client.EvaluateToken(ctx, "sess-2", "hi", nil, false)
+	if err != nil {
+		t.Fatalf("failed to evaluate token: %v", err)
+	}
+	for range events {
+	}
+	if _, err := client.CancelSession(ctx, "sess-2"); err != nil {
+		t.Fatalf("failed to cancel session: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{
+		"POST /api/v1/organizations/org-2/guardrails/evaluate/stream/start",
+		"POST /api/v1/organizations/org-2/guardrails/evaluate/stream",
+		"DELETE /api/v1/organizations/org-2/guardrails/evaluate/stream/sess-2",
+	}
+	if fmt.Sprint(paths) != fmt.Sprint(expected) {
+		t.Errorf("expected requests %v, got %v", expected, paths)
+	}
+	if fmt.Sprint(projectIDs) != "[proj-2]" {
+		t.Errorf("expected project ID proj-2 in start payload, got %v", projectIDs)
+	}
+}
+
+func TestClientUserAgent(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": "sess-1"})
+	}))
+	defer server.Close()
+
+	client := NewClient(Config{
+		APIKey:         "test-key",
+		OrganizationID: "org-1",
+		BaseURL:        server.URL,
+		UserAgent:      "my-app/1.2",
+	})
+	if _, err := client.StartSession(context.Background(), "", ""); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+
+	if expected := diagnyx.UserAgent("my-app/1.2"); userAgent != expected {
+		t.Errorf("expected User-Agent %q, got %q", expected, userAgent)
+	}
+}
+
+func TestSessionEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": "sess-1"})
+		case r.Method == http.MethodDelete:
+			json.NewEncoder(w).Encode(map[string]bool{"cancelled": true})
+		default:
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(w, "data: {\"type\":\"token_allowed\",\"tokenIndex\":%d}\n\n", i)
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(Config{
+		APIKey:          "test-key",
+		OrganizationID:  "org-1",
+		BaseURL:         server.URL,
+		EventBufferSize: 2,
+	})
+
+	ctx := context.Background()
+	if _, err := client.StartSession(ctx, "", ""); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	events, err := client.EvaluateToken(ctx, "sess-1", "hi", nil, false)
+	if err != nil {
+		t.Fatalf("failed to evaluate token: %v", err)
+	}
+	for range events {
+	}
+
+	replayed := client.SessionEvents("sess-1")
+	if len(replayed) != 2 {
+		t.Fatalf("expected 2 buffered events, got %d", len(replayed))
+	}
+	for i, event := range replayed {
+		allowed, ok := event.(*TokenAllowedEvent)
+		if !ok || allowed.TokenIndex != i+1 {
+			t.Errorf("expected event %d to be token %d, got %+v", i, i+1, event)
+		}
+	}
+
+	if _, err := client.CancelSession(ctx, "sess-1"); err != nil {
+		t.Fatalf("failed to cancel session: %v", err)
+	}
+	if replayed := client.SessionEvents("sess-1"); replayed != nil {
+		t.Errorf("expected buffer to be cleared after cancel, got %d events", len(replayed))
+	}
+}
+
+func TestSessionReconstructOutput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/start") {
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": "sess-1"})
+			return
+		}
+		var req EvaluateTokenRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "text/event-stream")
+		switch req.Token {
+		case "card ":
+			fmt.Fprint(w, "data: {\"type\":\"violation_detected\",\"policyId\":\"pii\",\"enforcementLevel\":\"advisory\"}\n\n")
+			fmt.Fprint(w, "data: {\"type\":\"token_allowed\",\"tokenIndex\":1}\n\n")
+		case "4111":
+			fmt.Fprint(w, "data: {\"type\":\"early_termination\",\"reason\":\"pii\",\"tokensProcessed\":2}\n\n")
+		default:
+			fmt.Fprint(w, "data: {\"type\":\"token_allowed\",\"tokenIndex\":0}\n\n")
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(Config{APIKey: "test-key", OrganizationID: "org-1", BaseURL: server.URL})
+	ctx := context.Background()
+	if _, err := client.StartSession(ctx, "", ""); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	session := client.GetSession("sess-1")
+
+	for _, token := range []string{"my ", "card ", "4111", "after"} {
+		events, err := client.EvaluateToken(ctx, "sess-1", token, nil, false)
+		if err != nil {
+			t.Fatalf("failed to evaluate token: %v", err)
+		}
+		for range events {
+		}
+	}
+
+	if got := session.ReconstructOutput(); got != "my card " {
+		t.Errorf("expected output up to the termination, 'my card ', got %q", got)
+	}
+}
+
+func TestMalformedEvent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/start") {
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": "sess-1"})
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"type\":\"violation_detected\",\"policyId\":\n\n")
+		fmt.Fprint(w, "data: {\"type\":\"token_allowed\",\"tokenIndex\":0}\n\n")
+	}))
+	defer server.Close()
+
+	client := NewClient(Config{APIKey: "test-key", OrganizationID: "org-1", BaseURL: server.URL})
+	ctx := context.Background()
+	if _, err := client.StartSession(ctx, "", ""); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	events, err := client.EvaluateToken(ctx, "sess-1", "hi", nil, false)
+	if err != nil {
+		t.Fatalf("failed to evaluate token: %v", err)
+	}
+
+	var received []Event
+	for event := range events {
+		received = append(received, event)
+	}
+	if len(received) != 2 {
+		t.Fatalf("expected the parse error and the allowed token, got %d events", len(received))
+	}
+	errEvent, ok := received[0].(*ErrorEvent)
+	if !ok || errEvent.Code != ErrorCodeParseError || !strings.Contains(errEvent.Error, `"policyId":`) {
+		t.Errorf("expected a PARSE_ERROR event quoting the frame, got %+v", received[0])
+	}
+	if !errors.Is(errEvent.Err(), ErrParseError) {
+		t.Errorf("expected the event's error to match ErrParseError, got %v", errEvent.Err())
+	}
+	if _, ok := received[1].(*TokenAllowedEvent); !ok {
+		t.Errorf("expected the stream to continue after the parse error, got %+v", received[1])
+	}
+	if client.ParseErrors() != 1 {
+		t.Errorf("expected 1 parse error, got %d", client.ParseErrors())
+	}
+}
+
+func TestClientDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request in dry run: %s %s", r.Method, r.URL.Path)
+	}))
+	defer server.Close()
+
+	client := NewClient(Config{APIKey: "test-key", OrganizationID: "org-1", BaseURL: server.URL, DryRun: true})
+
+	ctx := context.Background()
+	started, err := client.StartSession(ctx, "sess-1", "")
+	if err != nil || started.SessionID != "sess-1" {
+		t.Fatalf("expected a local session, got %+v, %v", started, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		events, err := client.EvaluateToken(ctx, "sess-1", "hi", nil, false)
+		if err != nil {
+			t.Fatalf("failed to evaluate token: %v", err)
+		}
+		for event := range events {
+			if allowed, ok := event.(*TokenAllowedEvent); !ok || allowed.TokenIndex != i {
+				t.Errorf("expected token %d to be allowed, got %+v", i, event)
+			}
+		}
+	}
+
+	events, err := client.CompleteSession(ctx, "sess-1")
+	if err != nil {
+		t.Fatalf("failed to complete session: %v", err)
+	}
+	complete, ok := (<-events).(*SessionCompleteEvent)
+	if !ok || !complete.Allowed || complete.TotalTokens != 2 {
+		t.Errorf("expected an allowed completion of 2 tokens, got %+v", complete)
+	}
+}
+
+func TestErrorEventErr(t *testing.T) {
+	event := &ErrorEvent{Error: "slow down", Code: ErrorCodeRateLimited}
+	if err := event.Err(); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+	if err := (&ErrorEvent{Error: "boom", Code: "UNKNOWN"}).Err(); errors.Is(err, ErrPolicyError) || err.Error() != "guardrails error UNKNOWN: boom" {
+		t.Errorf("unexpected error for unknown code: %v", err)
+	}
+}
+
+func TestCancelAll(t *testing.T) {
+	var mu sync.Mutex
+	var cancelled []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/organizations/org-1/guardrails/evaluate/stream/start", func(w http.ResponseWriter, r *http.Request) {
+		var req StartSessionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": req.SessionID})
+	})
+	mux.HandleFunc("/api/v1/organizations/org-1/guardrails/evaluate/stream/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/v1/organizations/org-1/guardrails/evaluate/stream/")
+		if id == "sess-bad" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		mu.Lock()
+		cancelled = append(cancelled, id)
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]bool{"cancelled": true})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewClient(Config{APIKey: "test-key", OrganizationID: "org-1", BaseURL: server.URL})
+	ctx := context.Background()
+	for _, id := range []string{"sess-b", "sess-a", "sess-bad"} {
+		if _, err := client.StartSession(ctx, id, ""); err != nil {
+			t.Fatalf("failed to start session: %v", err)
+		}
+	}
+
+	if ids := client.ActiveSessions(); fmt.Sprint(ids) != "[sess-a sess-b sess-bad]" {
+		t.Errorf("expected sorted session IDs, got %v", ids)
+	}
+
+	errs := client.CancelAll(ctx)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "sess-bad") {
+		t.Errorf("expected one error for sess-bad, got %v", errs)
+	}
+	if fmt.Sprint(cancelled) != "[sess-a sess-b]" {
+		t.Errorf("expected sess-a and sess-b to be cancelled, got %v", cancelled)
+	}
+	if ids := client.ActiveSessions(); fmt.Sprint(ids) != "[sess-bad]" {
+		t.Errorf("expected only the failed session to remain, got %v", ids)
+	}
+}
+
+func TestViolationErrorUserMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		policyType string
+		overrides  map[string]string
+		expected   string
+	}{
+		{"built-in message", "pii_detection", nil, "This response was blocked because it may contain personal information."},
+		{"unknown type", "custom_policy", nil, DefaultUserMessage},
+		{"override by type", "pii_detection", map[string]string{"pii_detection": "Redacted."}, "Redacted."},
+		{"override fallback", "custom_policy", map[string]string{"": "Blocked."}, "Blocked."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := &ViolationError{
+				Violation:    Violation{PolicyType: tt.policyType, Message: "matched SSN regex 3"},
+				userMessages: tt.overrides,
+			}
+			if got := err.UserMessage(); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+			if !strings.Contains(err.Error(), "matched SSN regex 3") {
+				t.Errorf("expected Error to keep the internal message, got %q", err.Error())
+			}
+		})
+	}
+}
+
+func TestStartSessionDuplicate(t *testing.T) {
+	starts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		starts++
+		json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": "sess-1", "activePolicies": []string{"pii"}})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		policy         DuplicateSessionPolicy
+		expectedStarts int
+		expectedErr    error
+		keepsState     bool
+	}{
+		{DuplicateSessionReplace, 2, nil, false},
+		{DuplicateSessionError, 1, ErrDuplicateSession, true},
+		{DuplicateSessionResume, 1, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			starts = 0
+			client := NewClient(Config{APIKey: "test-key", OrganizationID: "org-1", BaseURL: server.URL, OnDuplicateSession: tt.policy})
+			ctx := context.Background()
+			if _, err := client.StartSession(ctx, "sess-1", ""); err != nil {
+				t.Fatalf("failed to start session: %v", err)
+			}
+			client.GetSession("sess-1").Violations = []Violation{{PolicyID: "pii"}}
+
+			event, err := client.StartSession(ctx, "sess-1", "")
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err == nil && (event.SessionID != "sess-1" || fmt.Sprint(event.ActivePolicies) != "[pii]") {
+				t.Errorf("expected the started event for sess-1, got %+v", event)
+			}
+			if starts != tt.expectedStarts {
+				t.Errorf("expected %d start requests, got %d", tt.expectedStarts, starts)
+			}
+			if kept := len(client.GetSession("sess-1").Violations) == 1; kept != tt.keepsState {
+				t.Errorf("expected accumulated violations kept=%v, got %v", tt.keepsState, kept)
+			}
+		})
+	}
+}
+
+func TestAuditLogger(t *testing.T) {
+	t.Run("records one decision per session", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/start") {
+				json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": "sess-1"})
+				return
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			if strings.HasSuffix(r.URL.Path, "/complete") {
+				fmt.Fprint(w, "data: {\"type\":\"session_complete\",\"totalTokens\":1,\"allowed\":false}\n\n")
+				return
+			}
+			fmt.Fprint(w, "data: {\"type\":\"violation_detected\",\"policyId\":\"pii\",\"enforcementLevel\":\"blocking\"}\n\n")
+			fmt.Fprint(w, "data: {\"type\":\"early_termination\",\"reason\":\"pii\",\"tokensProcessed\":1,\"blockingViolation\":{\"policyId\":\"pii\"},\"timestamp\":42}\n\n")
+		}))
+		defer server.Close()
+
+		var records []AuditRecord
+		client := NewClient(Config{
+			APIKey:         "test-key",
+			OrganizationID: "org-1",
+			ProjectID:      "proj-1",
+			BaseURL:        server.URL,
+			AuditLogger:    func(record AuditRecord) { records = append(records, record) },
+		})
+		ctx := context.Background()
+		if _, err := client.StartSession(ctx, "", ""); err != nil {
+			t.Fatalf("failed to start session: %v", err)
+		}
+		events, err := client.EvaluateToken(ctx, "sess-1", "4111", nil, false)
+		if err != nil {
+			t.Fatalf("failed to evaluate token: %v", err)
+		}
+		for range events {
+		}
+		events, err = client.CompleteSession(ctx, "sess-1")
+		if err != nil {
+			t.Fatalf("failed to complete session: %v", err)
+		}
+		for range events {
+		}
+
+		if len(records) != 1 {
+			t.Fatalf("expected 1 audit record, got %d", len(records))
+		}
+		record := records[0]
+		if record.Decision != EventEarlyTermination || record.Allowed || record.Reason != "pii" || record.BlockingPolicyID != "pii" {
+			t.Errorf("unexpected decision: %+v", record)
+		}
+		if record.SessionID != "sess-1" || record.OrganizationID != "org-1" || record.ProjectID != "proj-1" {
+			t.Errorf("unexpected session identity: %+v", record)
+		}
+		if len(record.Violations) != 1 || record.TokensProcessed != 1 || record.Timestamp != 42 {
+			t.Errorf("unexpected record details: %+v", record)
+		}
+	})
+
+	t.Run("records completed sessions", func(t *testing.T) {
+		var records []AuditRecord
+		client := NewClient(Config{
+			OrganizationID: "org-1",
+			DryRun:         true,
+			AuditLogger:    func(record AuditRecord) { records = append(records, record) },
+		})
+		ctx := context.Background()
+		started, err := client.StartSession(ctx, "", "")
+		if err != nil {
+			t.Fatalf("failed to start session: %v", err)
+		}
+		events, err := client.CompleteSession(ctx, started.SessionID)
+		if err != nil {
+			t.Fatalf("failed to complete session: %v", err)
+		}
+		for range events {
+		}
+
+		if len(records) != 1 || records[0].Decision != EventSessionComplete || !records[0].Allowed || records[0].Timestamp == 0 {
+			t.Errorf("expected an allowed session_complete record, got %+v", records)
+		}
+	})
+
+	t.Run("recovers a panicking logger", func(t *testing.T) {
+		client := NewClient(Config{
+			OrganizationID: "org-1",
+			DryRun:         true,
+			AuditLogger:    func(AuditRecord) { panic("audit sink bug") },
+		})
+		ctx := context.Background()
+		started, err := client.StartSession(ctx, "", "")
+		if err != nil {
+			t.Fatalf("failed to start session: %v", err)
+		}
+		events, err := client.CompleteSession(ctx, started.SessionID)
+		if err != nil {
+			t.Fatalf("failed to complete session: %v", err)
+		}
+		var complete bool
+		for event := range events {
+			_, complete = event.(*SessionCompleteEvent)
+		}
+		if !complete {
+			t.Error("expected the session to complete despite the panicking logger")
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package guardrails
+
+import (
+	"context"
+	"errors"
+)
+
+// EvalContentType identifies the kind of content in an EvalContent
+type EvalContentType string
+
+const (
+	EvalContentText        EvalContentType = "text"
+	EvalContentImageURL    EvalContentType = "image_url"
+	EvalContentImageBase64 EvalContentType = "image_base64"
+)
+
+// EvalContent is a piece of model output evaluated by EvaluateContent. Use
+// TextContent, ImageURLContent or ImageDataContent to build one.
+type EvalContent struct {
+	Type     EvalContentType `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL string          `json:"imageUrl,omitempty"`
+	// ImageData holds raw image bytes, sent base64-encoded
+	ImageData []byte `json:"imageData,omitempty"`
+	MIMEType  string `json:"mimeType,omitempty"`
+}
+
+// TextContent returns text content
+func TextContent(text string) EvalContent {
+	return EvalContent{Type: EvalContentText, Text: text}
+}
+
+// ImageURLContent returns an image referenced by URL
+func ImageURLContent(url string) EvalContent {
+	return EvalContent{Type: EvalContentImageURL, ImageURL: url}
+}
+
+// ImageDataContent returns inline image bytes of the given MIME type, such
+// as "image/png"
+func ImageDataContent(data []byte, mimeType string) EvalContent {
+	return EvalContent{Type: EvalContentImageBase64, ImageData: data, MIMEType: mimeType}
+}
+
+// EvaluateContent evaluates one piece of text or image content against the
+// session's policies through the multimodal endpoint. It counts as a single
+// token. Any tokens still buffered by Evaluate are evaluated first so the
+// server sees output in order; the returned string is that buffered text
+// followed by content's Text, once allowed. Violations, early termination,
+// retries and FailOpen behave as for Evaluate.
+func (sg *StreamingGuardrail) EvaluateContent(ctx context.Context, content EvalContent, isLast bool) (string, error) {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+
+	if sg.session == nil {
+		return "", errors.New("no active session, call StartSession first")
+	}
+
+	var released string
+	if len(sg.pending) > 0 {
+		result, err := sg.evaluatePendingLocked(ctx, sg.tokenIndex-1, false)
+		if err != nil {
+			return result, err
+		}
+		released = result
+	}
+
+	tokenIndex := sg.tokenIndex
+	sg.tokenIndex++
+	sg.session.AccumulatedText += content.Text
+	if sg.session.local {
+		sg.session.TokensProcessed++
+		return released + content.Text, nil
+	}
+
+	var tokens []string
+	if content.Text != "" {
+		tokens = []string{content.Text}
+	}
+	payload := map[string]interface{}{
+		"sessionId":  sg.session.SessionID,
+		"content":    content,
+		"tokenIndex": tokenIndex,
+		"tokenCount": 1,
+		"isLast":     isLast,
+	}
+	result, err := sg.evaluateWithRetryLocked(ctx, tokens, func() (string, error) {
+		return sg.postEvaluationLocked(ctx, "/evaluate/stream/multimodal", payload, tokens, tokenIndex)
+	})
+	return released + result, err
+}
@@ -0,0 +1,60 @@
+package guardrails
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEvaluateContent(t *testing.T) {
+	server := newMockStreamServer(t)
+	server.onToken = func(token string, index int) []map[string]interface{} {
+		if token != "https://example.com/bad.png" {
+			return []map[string]interface{}{{"type": "token_allowed", "tokenIndex": index}}
+		}
+		return []map[string]interface{}{{
+			"type":              "early_termination",
+			"reason":            "blocked",
+			"tokensProcessed":   index,
+			"blockingViolation": map[string]interface{}{"policyId": "nsfw", "policyType": "content_filter", "enforcementLevel": "blocking", "message": "nudity"},
+		}}
+	}
+	config := server.config()
+	config.EvaluateEveryNTokens = 5
+	config.EnableEarlyTermination = true
+	guardrail := NewStreamingGuardrail(config)
+
+	ctx := context.Background()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+
+	// Buffered text is released ahead of the content
+	guardrail.Evaluate(ctx, "Here: ", false)
+	result, err := guardrail.EvaluateContent(ctx, ImageDataContent([]byte{0x89, 'P', 'N', 'G'}, "image/png"), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != "Here: " {
+		t.Errorf("expected buffered text to be released, got %q", result)
+	}
+	server.mu.Lock()
+	sent := server.lastContent
+	server.mu.Unlock()
+	if sent.Type != EvalContentImageBase64 || string(sent.ImageData) != "\x89PNG" || sent.MIMEType != "image/png" {
+		t.Errorf("expected the image bytes to round-trip, got %+v", sent)
+	}
+
+	if result, err := guardrail.EvaluateContent(ctx, TextContent("caption"), false); err != nil || result != "caption" {
+		t.Errorf("expected text content to be returned once allowed, got %q, %v", result, err)
+	}
+
+	_, err = guardrail.EvaluateContent(ctx, ImageURLContent("https://example.com/bad.png"), true)
+	var violationErr *ViolationError
+	if !errors.As(err, &violationErr) || violationErr.Violation.PolicyID != "nsfw" {
+		t.Fatalf("expected a ViolationError, got %v", err)
+	}
+	if session := guardrail.GetSession(); session == nil || !session.Terminated {
+		t.Error("expected the session to be terminated")
+	}
+}
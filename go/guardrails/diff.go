@@ -0,0 +1,63 @@
+package guardrails
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TokenEdit is a token that a guarded stream received but did not emit,
+// recorded under StreamingGuardrailConfig.RecordDiff
+type TokenEdit struct {
+	// Index is the token's position in the stream
+	Index int
+	// Original is the token as received
+	Original string
+	// Violation is the violation that stopped the token, or nil if it was
+	// dropped because its evaluation failed
+	Violation *Violation
+}
+
+// Diff returns the tokens of the session that were not emitted, in stream
+// order, when StreamingGuardrailConfig.RecordDiff is set. Tokens that were
+// emitted are omitted, so a stream that was not altered has an empty diff.
+// Call it once the stream is done, e.g. from OnSessionComplete or on the
+// session returned by CompleteSession; calling it earlier is safe but
+// returns the edits so far.
+func (s *StreamingGuardrailSession) Diff() []TokenEdit {
+	if s.mu != nil {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+	return append([]TokenEdit(nil), s.edits...)
+}
+
+// recordDiffLocked records the tokens of a chunk starting at firstIndex
+// that are missing from emitted, the chunk's output. Callers must hold
+// sg.mu.
+func (sg *StreamingGuardrail) recordDiffLocked(tokens []string, firstIndex int, emitted string, err error) {
+	var violation *Violation
+	var violationErr *ViolationError
+	if errors.As(err, &violationErr) {
+		v := violationErr.Violation
+		violation = &v
+	}
+
+	for i, token := range tokens {
+		if strings.HasPrefix(emitted, token) {
+			emitted = emitted[len(token):]
+			continue
+		}
+		// Output is emitted in order, so every later token is missing too
+		emitted = ""
+		if len(sg.session.edits) >= sg.config.MaxDiffEdits {
+			sg.log(fmt.Sprintf("Diff limit of %d edits reached, not recording further edits", sg.config.MaxDiffEdits))
+			return
+		}
+		sg.session.edits = append(sg.session.edits, TokenEdit{
+			Index:     firstIndex + i,
+			Original:  token,
+			Violation: violation,
+		})
+	}
+}
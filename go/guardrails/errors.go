@@ -0,0 +1,97 @@
+package guardrails
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes carried by ErrorEvent.Code
+const (
+	ErrorCodeSessionNotFound = "SESSION_NOT_FOUND"
+	ErrorCodeSessionExpired  = "SESSION_EXPIRED"
+	ErrorCodeRateLimited     = "RATE_LIMITED"
+	ErrorCodePolicyError     = "POLICY_ERROR"
+)
+
+// Sentinel errors matching the known ErrorEvent codes. Use errors.Is to
+// branch on them; the returned error is a *ServerError.
+var (
+	ErrSessionNotFound = errors.New("guardrails: session not found")
+	ErrSessionExpired  = errors.New("guardrails: session expired")
+	ErrRateLimited     = errors.New("guardrails: rate limited")
+	ErrPolicyError     = errors.New("guardrails: policy error")
+)
+
+// ErrDuplicateSession is returned by StartSession for a session ID that is
+// already active when Config.OnDuplicateSession is DuplicateSessionError
+var ErrDuplicateSession = errors.New("guardrails: session already active")
+
+// ErrSessionEnded is returned by an evaluation whose StreamingGuardrail
+// session was completed, canceled or replaced while the evaluation waited
+// out a retry backoff or the rate limit
+var ErrSessionEnded = errors.New("guardrails: session ended during evaluation")
+
+var errorsByCode = map[string]error{
+	ErrorCodeSessionNotFound: ErrSessionNotFound,
+	ErrorCodeSessionExpired:  ErrSessionExpired,
+	ErrorCodeRateLimited:     ErrRateLimited,
+	ErrorCodePolicyError:     ErrPolicyError,
+	ErrorCodeStreamIdle:      ErrStreamIdle,
+	ErrorCodeParseError:      ErrParseError,
+}
+
+// ServerError is an error reported by the server in an ErrorEvent. It
+// unwraps to the sentinel error for Code, if the code is known.
+type ServerError struct {
+	Code    string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("guardrails error: %s", e.Message)
+	}
+	return fmt.Sprintf("guardrails error %s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the sentinel error for Code, or nil for unknown codes
+func (e *ServerError) Unwrap() error {
+	return errorsByCode[e.Code]
+}
+
+// Err returns the event as a *ServerError
+func (e *ErrorEvent) Err() error {
+	return &ServerError{Code: e.Code, Message: e.Error}
+}
+
+// statusError records an unexpected HTTP response status
+type statusError struct {
+	StatusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// isUnavailable reports whether err means the server could not be reached
+// or failed, rather than refused the request: any error other than a
+// non-5xx response status, such as 401, 403 or 404
+func isUnavailable(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// isSessionError reports whether err means the session can no longer be
+// used, so retrying or failing open would not help
+func isSessionError(err error) bool {
+	return errors.Is(err, ErrSessionNotFound) || errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrPolicyError) ||
+		errors.Is(err, ErrSessionEnded)
+}
+
+// serverError builds a *ServerError from a decoded error event
+func serverError(data map[string]interface{}) error {
+	return &ServerError{Code: getString(data, "code"), Message: getString(data, "error")}
+}
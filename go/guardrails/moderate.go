@@ -0,0 +1,72 @@
+package guardrails
+
+import (
+	"context"
+	"time"
+)
+
+// Verdict is the outcome of moderating a complete text
+type Verdict struct {
+	// Allowed is false if any blocking policy was violated
+	Allowed    bool
+	Violations []Violation
+	// HighestEnforcement is the strictest enforcement level among the
+	// violations, or "" if there were none
+	HighestEnforcement EnforcementLevel
+	// Latency is the time the moderation took, including the session round
+	// trips
+	Latency time.Duration
+	// Degraded is set when FailOpen let the text through because the
+	// guardrails server was unavailable. Allowed is then true and
+	// Violations may be incomplete, so the text was not actually moderated.
+	Degraded bool
+}
+
+// enforcementRank orders the enforcement levels from least to most strict
+var enforcementRank = map[EnforcementLevel]int{
+	EnforcementAdvisory: 1,
+	EnforcementWarning:  2,
+	EnforcementBlocking: 3,
+}
+
+// Moderate evaluates an already complete text, such as a comment or a
+// stored generation, and returns a single verdict. It starts a session,
+// evaluates the whole text as one chunk with early termination disabled so
+// every violation is collected, and completes the session. Use it for
+// offline or batch moderation; StreamingGuardrail is the real-time path.
+// Under FailOpen a server or network error yields a Degraded verdict
+// rather than an error.
+func Moderate(ctx context.Context, config StreamingGuardrailConfig, text string) (*Verdict, error) {
+	start := time.Now()
+	guardrail := NewStreamingGuardrail(config)
+	defer guardrail.releaseSlot()
+
+	earlyTermination := false
+	if _, err := guardrail.StartSessionWithOptions(ctx, SessionOptions{EnableEarlyTermination: &earlyTermination}); err != nil {
+		return nil, err
+	}
+	if _, err := guardrail.Evaluate(ctx, text, true); err != nil {
+		guardrail.CancelSession(ctx)
+		return nil, err
+	}
+	session, err := guardrail.CompleteSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	verdict := &Verdict{
+		Allowed:    session.Allowed,
+		Violations: session.Violations,
+		Latency:    time.Since(start),
+		Degraded:   session.Degraded || guardrail.Degradations() > 0,
+	}
+	for _, v := range session.Violations {
+		if enforcementRank[v.EnforcementLevel] > enforcementRank[verdict.HighestEnforcement] {
+			verdict.HighestEnforcement = v.EnforcementLevel
+		}
+		if v.EnforcementLevel == EnforcementBlocking {
+			verdict.Allowed = false
+		}
+	}
+	return verdict, nil
+}
@@ -0,0 +1,79 @@
+package guardrails
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestModerate(t *testing.T) {
+	server := newMockStreamServer(t)
+	server.onToken = func(token string, index int) []map[string]interface{} {
+		var events []map[string]interface{}
+		if strings.Contains(token, "@") {
+			events = append(events, map[string]interface{}{"type": "violation_detected", "policyId": "pii", "enforcementLevel": "warning", "message": "email"})
+		}
+		if strings.Contains(token, "kill") {
+			events = append(events, map[string]interface{}{
+				"type":              "early_termination",
+				"reason":            "blocked",
+				"blockingViolation": map[string]interface{}{"policyId": "toxicity", "enforcementLevel": "blocking", "message": "violence"},
+			})
+		}
+		return append(events, map[string]interface{}{"type": "token_allowed", "tokenIndex": index})
+	}
+	ctx := context.Background()
+
+	verdict, err := Moderate(ctx, server.config(), "Nice post, thanks!")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !verdict.Allowed || len(verdict.Violations) != 0 || verdict.HighestEnforcement != "" {
+		t.Errorf("expected clean text to be allowed, got %+v", verdict)
+	}
+
+	verdict, err = Moderate(ctx, server.config(), "Mail me at a@b.c or I will kill the process")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if verdict.Allowed {
+		t.Error("expected text with a blocking violation not to be allowed")
+	}
+	if len(verdict.Violations) != 2 || verdict.HighestEnforcement != EnforcementBlocking {
+		t.Errorf("expected both violations with blocking as the highest level, got %+v", verdict)
+	}
+	if verdict.Latency <= 0 {
+		t.Errorf("expected a positive latency, got %v", verdict.Latency)
+	}
+	if verdict.Degraded {
+		t.Error("expected a moderated verdict not to be degraded")
+	}
+}
+
+func TestModerateFailOpen(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	config := StreamingGuardrailConfig{
+		APIKey:         "test-key",
+		OrganizationID: "org-1",
+		BaseURL:        server.URL,
+		FailOpen:       true,
+	}
+	verdict, err := Moderate(context.Background(), config, "Mail me at a@b.c")
+	if err != nil {
+		t.Fatalf("expected no error with FailOpen, got %v", err)
+	}
+	if !verdict.Allowed || !verdict.Degraded {
+		t.Errorf("expected an allowed, degraded verdict, got %+v", verdict)
+	}
+
+	config.FailOpen = false
+	if _, err := Moderate(context.Background(), config, "Mail me at a@b.c"); err == nil {
+		t.Error("expected an error without FailOpen")
+	}
+}
@@ -0,0 +1,251 @@
+package guardrails
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotPooled is returned by ReleaseSession for a session that was
+// not acquired with AcquireSession, or was already released
+var ErrSessionNotPooled = errors.New("guardrails: session not acquired from the pool")
+
+// pooledSession is an idle session waiting in the pool
+type pooledSession struct {
+	session   *Session
+	idleSince time.Time
+}
+
+// sessionPool holds idle sessions by key for AcquireSession. The zero value
+// is an empty pool.
+type sessionPool struct {
+	mu   sync.Mutex
+	idle map[string][]pooledSession
+	size int
+}
+
+// take removes and returns the most recently released session for key, or
+// nil if there is none, along with every pooled session idle for longer
+// than ttl
+func (p *sessionPool) take(key string, ttl time.Duration, now time.Time) (*Session, []*Session) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	expired := p.expireLocked(ttl, now)
+	idle := p.idle[key]
+	if len(idle) == 0 {
+		return nil, expired
+	}
+	session := idle[len(idle)-1].session
+	p.idle[key] = idle[:len(idle)-1]
+	p.size--
+	return session, expired
+}
+
+// put adds a session to the pool for key unless the pool already holds
+// maxSize sessions, and returns every pooled session idle for longer than
+// ttl. added reports whether the session was pooled.
+func (p *sessionPool) put(key string, session *Session, maxSize int, ttl time.Duration, now time.Time) (expired []*Session, added bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	expired = p.expireLocked(ttl, now)
+	if p.size >= maxSize {
+		return expired, false
+	}
+	if p.idle == nil {
+		p.idle = make(map[string][]pooledSession)
+	}
+	p.idle[key] = append(p.idle[key], pooledSession{session: session, idleSince: now})
+	p.size++
+	return expired, true
+}
+
+// hasRoom reports whether put would accept another session
+func (p *sessionPool) hasRoom(maxSize int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size < maxSize
+}
+
+// expireLocked removes and returns the sessions idle for longer than ttl.
+// Callers must hold p.mu.
+func (p *sessionPool) expireLocked(ttl time.Duration, now time.Time) []*Session {
+	if ttl <= 0 {
+		return nil
+	}
+	var expired []*Session
+	for key, idle := range p.idle {
+		kept := idle[:0]
+		for _, pooled := range idle {
+			if now.Sub(pooled.idleSince) > ttl {
+				expired = append(expired, pooled.session)
+			} else {
+				kept = append(kept, pooled)
+			}
+		}
+		p.size -= len(idle) - len(kept)
+		if len(kept) == 0 {
+			delete(p.idle, key)
+		} else {
+			p.idle[key] = kept
+		}
+	}
+	return expired
+}
+
+// takeOldest removes and returns the session idle for the longest, or nil
+// if the pool is empty
+func (p *sessionPool) takeOldest() *Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	oldestKey := ""
+	var oldest *pooledSession
+	for key, idle := range p.idle {
+		if len(idle) > 0 && (oldest == nil || idle[0].idleSince.Before(oldest.idleSince)) {
+			oldestKey, oldest = key, &idle[0]
+		}
+	}
+	if oldest == nil {
+		return nil
+	}
+	session := oldest.session
+	if idle := p.idle[oldestKey][1:]; len(idle) == 0 {
+		delete(p.idle, oldestKey)
+	} else {
+		p.idle[oldestKey] = idle
+	}
+	p.size--
+	return session
+}
+
+// drain empties the pool and returns the sessions it held
+func (p *sessionPool) drain() []*Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var sessions []*Session
+	for _, idle := range p.idle {
+		for _, pooled := range idle {
+			sessions = append(sessions, pooled.session)
+		}
+	}
+	p.idle = nil
+	p.size = 0
+	return sessions
+}
+
+// AcquireSession returns an idle session pooled under key, or starts a new
+// one if there is none, so short back-to-back generations, e.g. for one
+// user, skip the start round trip. Return the session with ReleaseSession
+// instead of completing it. Pooled sessions idle for longer
+// than Config.PooledSessionTTL are completed instead of reused. Idle
+// pooled sessions count towards Config.MaxConcurrentSessions; when the
+// client is at capacity, the longest idle ones are completed to make room.
+func (c *Client) AcquireSession(ctx context.Context, key string) (*Session, error) {
+	for {
+		session, expired := c.pool.take(key, c.config.PooledSessionTTL, time.Now())
+		c.retireSessions(ctx, expired)
+		if session == nil {
+			break
+		}
+		// Skip sessions completed or canceled while pooled
+		if c.GetSession(session.SessionID) == session {
+			c.log(fmt.Sprintf("Reusing pooled session: %s", session.SessionID))
+			session.markAcquired(key)
+			return session, nil
+		}
+	}
+
+	// Idle sessions would never give up their slots on their own
+	for c.slots.full() {
+		idle := c.pool.takeOldest()
+		if idle == nil {
+			break
+		}
+		c.retireSessions(ctx, []*Session{idle})
+	}
+
+	started, err := c.StartSession(ctx, "", "")
+	if err != nil {
+		return nil, err
+	}
+	session := c.GetSession(started.SessionID)
+	if session == nil {
+		return nil, fmt.Errorf("failed to start session: session %s is no longer active", started.SessionID)
+	}
+	session.markAcquired(key)
+	return session, nil
+}
+
+// ReleaseSession ends the generation of a session from AcquireSession and
+// pre-warms the pool for the next one under the same key. The session is
+// completed, so its decision reaches Config.AuditLogger and the server
+// evaluates the next generation from scratch, and a fresh session is
+// started and pooled in its place, unless the pool is full at
+// Config.MaxPooledSessions or the client is at Config.MaxConcurrentSessions.
+// Run it off the request path to keep both round trips out of the
+// generation's latency.
+func (c *Client) ReleaseSession(ctx context.Context, session *Session) error {
+	key, ok := session.markReleased()
+	if !ok {
+		return fmt.Errorf("failed to release session %s: %w", session.SessionID, ErrSessionNotPooled)
+	}
+
+	c.mu.Lock()
+	if c.sessions[session.SessionID] != session {
+		// Completed, canceled or replaced while in use
+		c.mu.Unlock()
+		return nil
+	}
+	if session.Terminated {
+		// The early termination already concluded it on the server
+		c.removeSessionLocked(session.SessionID)
+		c.mu.Unlock()
+	} else {
+		c.mu.Unlock()
+		if err := c.retireSessions(ctx, []*Session{session}); err != nil {
+			return err
+		}
+	}
+
+	if !c.pool.hasRoom(c.config.MaxPooledSessions) || c.slots.full() {
+		return nil
+	}
+	started, err := c.StartSession(ctx, "", "")
+	if err != nil {
+		return fmt.Errorf("failed to pre-warm session: %w", err)
+	}
+	fresh := c.GetSession(started.SessionID)
+	if fresh == nil {
+		return nil
+	}
+	expired, added := c.pool.put(key, fresh, c.config.MaxPooledSessions, c.config.PooledSessionTTL, time.Now())
+	if !added {
+		expired = append(expired, fresh)
+	}
+	return c.retireSessions(ctx, expired)
+}
+
+// retireSessions completes released or evicted sessions. A session that
+// cannot be completed is forgotten locally, and the first error returned.
+func (c *Client) retireSessions(ctx context.Context, sessions []*Session) error {
+	var firstErr error
+	for _, session := range sessions {
+		events, err := c.CompleteSession(ctx, session.SessionID)
+		if err != nil {
+			c.log(fmt.Sprintf("Failed to complete pooled session %s: %v", session.SessionID, err))
+			c.mu.Lock()
+			if c.sessions[session.SessionID] == session {
+				c.removeSessionLocked(session.SessionID)
+			}
+			c.mu.Unlock()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to complete pooled session %s: %w", session.SessionID, err)
+			}
+			continue
+		}
+		for range events {
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,200 @@
+package guardrails
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newPoolServer starts sessions with sequential IDs and counts the start
+// and complete requests
+func newPoolServer() (*httptest.Server, *atomic.Int32, *atomic.Int32) {
+	var starts, completes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			n := starts.Add(1)
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": fmt.Sprintf("sess-%d", n)})
+		case strings.HasSuffix(r.URL.Path, "/complete"):
+			completes.Add(1)
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"type\":\"session_complete\",\"allowed\":true}\n\n")
+		default:
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"type\":\"token_allowed\",\"tokenIndex\":0}\n\n")
+		}
+	}))
+	return server, &starts, &completes
+}
+
+func TestSessionPool(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("pre-warms a fresh session per key", func(t *testing.T) {
+		server, starts, completes := newPoolServer()
+		defer server.Close()
+		var records []AuditRecord
+		client := NewClient(Config{
+			APIKey:         "test-key",
+			OrganizationID: "org-1",
+			BaseURL:        server.URL,
+			AuditLogger:    func(record AuditRecord) { records = append(records, record) },
+		})
+
+		first, err := client.AcquireSession(ctx, "user-1")
+		if err != nil {
+			t.Fatalf("failed to acquire session: %v", err)
+		}
+		if err := client.ReleaseSession(ctx, first); err != nil {
+			t.Fatalf("failed to release session: %v", err)
+		}
+		if client.GetSession(first.SessionID) != nil {
+			t.Error("expected the released session to be completed")
+		}
+		if len(records) != 1 || records[0].SessionID != first.SessionID || records[0].Decision != EventSessionComplete {
+			t.Errorf("expected an audit record for the released session, got %+v", records)
+		}
+
+		second, err := client.AcquireSession(ctx, "user-1")
+		if err != nil {
+			t.Fatalf("failed to acquire session: %v", err)
+		}
+		if second == first || second.TokensProcessed != 0 {
+			t.Errorf("expected a fresh pre-warmed session, got %+v", second)
+		}
+		if starts.Load() != 2 || completes.Load() != 1 {
+			t.Errorf("expected 2 starts and 1 complete, got %d and %d", starts.Load(), completes.Load())
+		}
+
+		other, err := client.AcquireSession(ctx, "user-2")
+		if err != nil {
+			t.Fatalf("failed to acquire session: %v", err)
+		}
+		if other == second || starts.Load() != 3 {
+			t.Error("expected another key to get its own session")
+		}
+
+		if err := client.ReleaseSession(ctx, other); err != nil {
+			t.Fatalf("failed to release session: %v", err)
+		}
+		if err := client.ReleaseSession(ctx, other); !errors.Is(err, ErrSessionNotPooled) {
+			t.Errorf("expected ErrSessionNotPooled for a double release, got %v", err)
+		}
+	})
+
+	t.Run("pre-warms no more than the pool size", func(t *testing.T) {
+		server, starts, completes := newPoolServer()
+		defer server.Close()
+		client := NewClient(Config{APIKey: "test-key", OrganizationID: "org-1", BaseURL: server.URL, MaxPooledSessions: 1})
+
+		a, _ := client.AcquireSession(ctx, "user-1")
+		b, _ := client.AcquireSession(ctx, "user-1")
+		client.ReleaseSession(ctx, a)
+		client.ReleaseSession(ctx, b)
+
+		if starts.Load() != 3 || completes.Load() != 2 {
+			t.Errorf("expected 3 starts and 2 completes, got %d and %d", starts.Load(), completes.Load())
+		}
+		if active := client.ActiveSessions(); len(active) != 1 {
+			t.Errorf("expected only the pooled session to stay active, got %v", active)
+		}
+	})
+
+	t.Run("completes expired sessions", func(t *testing.T) {
+		server, starts, completes := newPoolServer()
+		defer server.Close()
+		client := NewClient(Config{APIKey: "test-key", OrganizationID: "org-1", BaseURL: server.URL, PooledSessionTTL: 10 * time.Millisecond})
+
+		first, _ := client.AcquireSession(ctx, "user-1")
+		client.ReleaseSession(ctx, first)
+		pooled := client.ActiveSessions()
+		time.Sleep(20 * time.Millisecond)
+
+		second, err := client.AcquireSession(ctx, "user-1")
+		if err != nil {
+			t.Fatalf("failed to acquire session: %v", err)
+		}
+		if len(pooled) != 1 || second.SessionID == pooled[0] {
+			t.Error("expected the expired session not to be reused")
+		}
+		if starts.Load() != 3 || completes.Load() != 2 {
+			t.Errorf("expected 3 starts and 2 completes, got %d and %d", starts.Load(), completes.Load())
+		}
+	})
+
+	t.Run("evicts idle sessions at MaxConcurrentSessions", func(t *testing.T) {
+		server, _, completes := newPoolServer()
+		defer server.Close()
+		client := NewClient(Config{APIKey: "test-key", OrganizationID: "org-1", BaseURL: server.URL, MaxConcurrentSessions: 1})
+
+		first, _ := client.AcquireSession(ctx, "user-1")
+		client.ReleaseSession(ctx, first)
+
+		acquireCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if _, err := client.AcquireSession(acquireCtx, "user-2"); err != nil {
+			t.Fatalf("expected the idle session to make room, got %v", err)
+		}
+		if completes.Load() != 2 {
+			t.Errorf("expected the released and the idle session to be completed, got %d completes", completes.Load())
+		}
+	})
+
+	t.Run("drops terminated sessions", func(t *testing.T) {
+		server, starts, completes := newPoolServer()
+		defer server.Close()
+		client := NewClient(Config{APIKey: "test-key", OrganizationID: "org-1", BaseURL: server.URL})
+
+		first, _ := client.AcquireSession(ctx, "user-1")
+		first.Terminated = true
+		client.ReleaseSession(ctx, first)
+
+		if client.GetSession(first.SessionID) != nil {
+			t.Error("expected the terminated session to be forgotten")
+		}
+		if completes.Load() != 0 {
+			t.Errorf("expected the terminated session not to be completed, got %d completes", completes.Load())
+		}
+		if second, _ := client.AcquireSession(ctx, "user-1"); second == first || starts.Load() != 2 {
+			t.Error("expected a pre-warmed session after a terminated one")
+		}
+	})
+
+	t.Run("cancels pooled sessions on Close", func(t *testing.T) {
+		pool, _, _ := newPoolServer()
+		defer pool.Close()
+		var canceled []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				canceled = append(canceled, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+				json.NewEncoder(w).Encode(map[string]interface{}{"cancelled": true})
+				return
+			}
+			pool.Config.Handler.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+		client := NewClient(Config{APIKey: "test-key", OrganizationID: "org-1", BaseURL: server.URL})
+
+		first, _ := client.AcquireSession(ctx, "user-1")
+		client.ReleaseSession(ctx, first)
+		pooled := client.ActiveSessions()
+		inUse, _ := client.AcquireSession(ctx, "user-2")
+
+		if err := client.Close(); err != nil {
+			t.Fatalf("unexpected close error: %v", err)
+		}
+		if len(pooled) != 1 || len(canceled) != 1 || canceled[0] != pooled[0] {
+			t.Errorf("expected only the pooled session %v to be canceled, got %v", pooled, canceled)
+		}
+		if client.GetSession(inUse.SessionID) != nil {
+			t.Error("expected the session in use to be forgotten")
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package guardrails
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/diagnyxai/diagnyx-go"
+)
+
+// PrecheckInput evaluates the complete user input against the project's
+// input-scoped policies, such as prompt injection, in a single synchronous
+// request. It returns whether to proceed and the violations found. Call it
+// before starting generation and skip the generation entirely when proceed
+// is false: input policies need the whole input and cannot be enforced
+// token by token. No session is needed and the current one is not changed.
+//
+// Under DryRun every input is allowed. Under FailOpen a server or network
+// error allows the input and is reported to OnDegraded.
+func (sg *StreamingGuardrail) PrecheckInput(ctx context.Context, input string) (bool, []Violation, error) {
+	if sg.config.DryRun {
+		sg.log("Dry run, not prechecking input")
+		return true, nil, nil
+	}
+
+	proceed, violations, err := sg.precheckInput(ctx, input)
+	if err != nil {
+		if sg.failOpen(ctx, err) {
+			return true, nil, nil
+		}
+		return false, nil, err
+	}
+	return proceed, violations, nil
+}
+
+// precheckInput sends the input precheck request
+func (sg *StreamingGuardrail) precheckInput(ctx context.Context, input string) (bool, []Violation, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"projectId": sg.config.ProjectID,
+		"input":     input,
+	})
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	wait, err := sg.limiter.wait(ctx)
+	if err != nil {
+		return false, nil, err
+	}
+	if wait > 0 && sg.config.OnRateLimitWait != nil {
+		runCallback(sg.log, "OnRateLimitWait", func() { sg.config.OnRateLimitWait(wait) })
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		sg.getBaseEndpoint()+"/evaluate/input", bytes.NewReader(body))
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	diagnyx.SetAuthHeader(req.Header, sg.config.AuthHeader, sg.config.AuthScheme, sg.config.APIKey)
+	req.Header.Set("User-Agent", diagnyx.UserAgent(sg.config.UserAgent))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := sg.httpClient.Do(req)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, nil, &statusError{StatusCode: resp.StatusCode}
+	}
+
+	var data map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return false, nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if data["type"] == "error" {
+		return false, nil, fmt.Errorf("failed to precheck input: %w", serverError(data))
+	}
+
+	var violations []Violation
+	blocked := false
+	if items, ok := data["violations"].([]interface{}); ok {
+		for _, item := range items {
+			fields, _ := item.(map[string]interface{})
+			violation := sg.parseViolation(fields)
+			if violation.EnforcementLevel == EnforcementBlocking {
+				blocked = true
+			}
+			violations = append(violations, violation)
+		}
+	}
+
+	proceed := !blocked
+	if allowed, ok := data["allowed"].(bool); ok {
+		proceed = allowed
+	}
+	sg.log(fmt.Sprintf("Input precheck: proceed=%v, %d violations", proceed, len(violations)))
+	return proceed, violations, nil
+}
@@ -0,0 +1,73 @@
+package guardrails
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("allows a burst then spaces requests", func(t *testing.T) {
+		limiter := newRateLimiter(20)
+		ctx := context.Background()
+		for i := 0; i < 20; i++ {
+			if wait, _ := limiter.wait(ctx); wait != 0 {
+				t.Fatalf("expected burst request %d not to wait, waited %s", i, wait)
+			}
+		}
+		wait, err := limiter.wait(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if wait < 30*time.Millisecond || wait > 60*time.Millisecond {
+			t.Errorf("expected a wait of about 50ms, got %s", wait)
+		}
+	})
+
+	t.Run("stops waiting when the context is done", func(t *testing.T) {
+		limiter := newRateLimiter(1)
+		limiter.wait(context.Background())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := limiter.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded, got %v", err)
+		}
+	})
+
+	t.Run("nil limiter never waits", func(t *testing.T) {
+		var limiter *rateLimiter
+		if wait, err := limiter.wait(context.Background()); wait != 0 || err != nil {
+			t.Errorf("expected no wait, got %s, %v", wait, err)
+		}
+	})
+}
+
+func TestEvaluateRateLimit(t *testing.T) {
+	server := newMockStreamServer(t)
+	config := server.config()
+	config.EvaluateEveryNTokens = 1
+	config.MaxEvaluationsPerSecond = 50
+	var waits []time.Duration
+	config.OnRateLimitWait = func(wait time.Duration) { waits = append(waits, wait) }
+	guardrail := NewStreamingGuardrail(config)
+
+	ctx := context.Background()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 60; i++ {
+		if _, err := guardrail.Evaluate(ctx, "a", false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected 10 evaluations past the burst to take about 200ms, took %s", elapsed)
+	}
+	if len(waits) == 0 {
+		t.Error("expected OnRateLimitWait to be called")
+	}
+}
@@ -0,0 +1,91 @@
+package guardrails
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+)
+
+// GuardReader wraps r so that only guardrail-approved content can be read
+// from it. The input is split into tokens with split (bufio.ScanRunes if
+// nil) and evaluated with a StreamingGuardrail session started before
+// GuardReader returns. Tokens are emitted as produced by split, so split
+// functions that drop delimiters, such as bufio.ScanLines, drop them from
+// the output too.
+//
+// A blocking violation ends the stream with a *ViolationError returned from
+// Read, after the content that passed before it. Canceling ctx ends the
+// stream with ctx.Err(). A stream that ends with an error cancels its
+// session if it is still active, and the session's SessionLimiter slot is
+// freed before Read reports the end of the stream.
+func GuardReader(ctx context.Context, config StreamingGuardrailConfig, r io.Reader, split bufio.SplitFunc) (io.Reader, error) {
+	if split == nil {
+		split = bufio.ScanRunes
+	}
+
+	guardrail := NewStreamingGuardrail(config)
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		stop := context.AfterFunc(ctx, func() {
+			pw.CloseWithError(ctx.Err())
+		})
+		defer stop()
+
+		err := guardTokens(ctx, guardrail, r, split, pw)
+		if err != nil && guardrail.IsActive() {
+			// ctx may be done already; the cancel must still reach the server
+			if _, cerr := guardrail.CancelSession(context.WithoutCancel(ctx)); cerr != nil {
+				guardrail.log(fmt.Sprintf("Failed to cancel session: %v", cerr))
+			}
+		}
+		guardrail.releaseSlot()
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+// guardTokens evaluates the tokens of r and writes approved content to w.
+// It returns nil once r is exhausted and the session is completed.
+func guardTokens(ctx context.Context, guardrail *StreamingGuardrail, r io.Reader, split bufio.SplitFunc, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(split)
+
+	for scanner.Scan() {
+		result, err := guardrail.Evaluate(ctx, scanner.Text(), false)
+		if result != "" {
+			if _, werr := io.WriteString(w, result); werr != nil {
+				return werr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	result, err := guardrail.Flush(ctx)
+	if result != "" {
+		if _, werr := io.WriteString(w, result); werr != nil {
+			return werr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if guardrail.IsActive() {
+		if _, err := guardrail.CompleteSession(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
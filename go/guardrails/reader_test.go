@@ -0,0 +1,116 @@
+package guardrails
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestGuardReader(t *testing.T) {
+	server := newMockStreamServer(t)
+	config := server.config()
+	config.EvaluateEveryNTokens = 4
+
+	reader, err := GuardReader(context.Background(), config, strings.NewReader("Hello, world!\nBye"), nil)
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if string(output) != "Hello, world!\nBye" {
+		t.Errorf("expected content to pass through unchanged, got %q", output)
+	}
+}
+
+func TestGuardReaderViolation(t *testing.T) {
+	server := newMockStreamServer(t)
+	server.onToken = func(token string, index int) []map[string]interface{} {
+		if strings.Contains(token, "secret") {
+			return []map[string]interface{}{{
+				"type":              "early_termination",
+				"reason":            "blocked",
+				"blockingViolation": map[string]interface{}{"policyId": "pii", "enforcementLevel": "blocking", "message": "secret"},
+			}}
+		}
+		return []map[string]interface{}{{"type": "token_allowed", "tokenIndex": index}}
+	}
+	config := server.config()
+	config.EvaluateEveryNTokens = 1
+	config.EnableEarlyTermination = true
+
+	input := "the password is secret and more"
+	reader, err := GuardReader(context.Background(), config, strings.NewReader(input), bufio.ScanWords)
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+
+	output, err := io.ReadAll(reader)
+	var violationErr *ViolationError
+	if !errors.As(err, &violationErr) {
+		t.Fatalf("expected ViolationError, got %v", err)
+	}
+	if violationErr.Violation.PolicyID != "pii" {
+		t.Errorf("expected pii violation, got '%s'", violationErr.Violation.PolicyID)
+	}
+	if string(output) != "thepasswordis" {
+		t.Errorf("expected content before the violation, got %q", output)
+	}
+}
+
+func TestGuardReaderReleasesSlot(t *testing.T) {
+	server := newMockStreamServer(t)
+	server.onToken = func(token string, index int) []map[string]interface{} {
+		return []map[string]interface{}{{
+			"type":              "early_termination",
+			"reason":            "blocked",
+			"blockingViolation": map[string]interface{}{"policyId": "pii", "enforcementLevel": "blocking"},
+		}}
+	}
+	limiter := NewSessionLimiter(1, 0)
+	config := server.config()
+	config.EvaluateEveryNTokens = 1
+	config.EnableEarlyTermination = true
+	config.SessionLimiter = limiter
+	ctx := context.Background()
+
+	reader, err := GuardReader(ctx, config, strings.NewReader("secret"), bufio.ScanWords)
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	var violationErr *ViolationError
+	if _, err := io.ReadAll(reader); !errors.As(err, &violationErr) {
+		t.Fatalf("expected ViolationError, got %v", err)
+	}
+
+	startCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, err := NewStreamingGuardrail(config).StartSession(startCtx, nil); err != nil {
+		t.Fatalf("expected the blocked reader to free its slot, got %v", err)
+	}
+
+	t.Run("cancels the session on a read error", func(t *testing.T) {
+		config := server.config()
+		config.SessionLimiter = NewSessionLimiter(1, 0)
+		reader, err := GuardReader(ctx, config, iotest.ErrReader(errors.New("boom")), nil)
+		if err != nil {
+			t.Fatalf("failed to create reader: %v", err)
+		}
+		if _, err := io.ReadAll(reader); err == nil || err.Error() != "boom" {
+			t.Fatalf("expected the read error, got %v", err)
+		}
+		if server.cancels.Load() != 1 {
+			t.Errorf("expected the session to be canceled, got %d cancels", server.cancels.Load())
+		}
+		if config.SessionLimiter.Active() != 0 {
+			t.Errorf("expected the slot to be freed, got %d active", config.SessionLimiter.Active())
+		}
+	})
+}
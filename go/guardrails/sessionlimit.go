@@ -0,0 +1,83 @@
+package guardrails
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrAtCapacity is returned by StartSession when no session slot frees up
+// within the limiter's wait timeout
+var ErrAtCapacity = errors.New("guardrails: too many concurrent sessions")
+
+// SessionLimiter bounds the number of concurrent guardrail sessions, and
+// with them the connections held open to the guardrails API. Share one
+// limiter between the StreamingGuardrails of a process through
+// StreamingGuardrailConfig.SessionLimiter; a nil *SessionLimiter imposes no
+// limit.
+type SessionLimiter struct {
+	slots   chan struct{}
+	maxWait time.Duration
+}
+
+// NewSessionLimiter returns a limiter allowing maxSessions concurrent
+// sessions. StartSession waits up to maxWait for a slot and then fails with
+// ErrAtCapacity; zero waits until the start's context is done.
+func NewSessionLimiter(maxSessions int, maxWait time.Duration) *SessionLimiter {
+	if maxSessions <= 0 {
+		return nil
+	}
+	return &SessionLimiter{slots: make(chan struct{}, maxSessions), maxWait: maxWait}
+}
+
+// Active returns the number of sessions currently holding a slot
+func (l *SessionLimiter) Active() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.slots)
+}
+
+// acquire takes a slot, waiting until one is free, maxWait elapses or ctx
+// is done
+func (l *SessionLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if l.maxWait > 0 {
+		timer := time.NewTimer(l.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return ErrAtCapacity
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// full reports whether every slot is taken
+func (l *SessionLimiter) full() bool {
+	return l != nil && len(l.slots) == cap(l.slots)
+}
+
+// release frees a slot taken by acquire
+func (l *SessionLimiter) release() {
+	if l == nil {
+		return
+	}
+	select {
+	case <-l.slots:
+	default:
+	}
+}
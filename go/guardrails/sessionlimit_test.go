@@ -0,0 +1,115 @@
+package guardrails
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionLimiter(t *testing.T) {
+	server := newMockStreamServer(t)
+	limiter := NewSessionLimiter(1, 0)
+	config := server.config()
+	config.SessionLimiter = limiter
+	ctx := context.Background()
+
+	first := NewStreamingGuardrail(config)
+	if _, err := first.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	if limiter.Active() != 1 {
+		t.Errorf("expected 1 active session, got %d", limiter.Active())
+	}
+
+	shortCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := NewStreamingGuardrail(config).StartSession(shortCtx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait to end with the context, got %v", err)
+	}
+
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := NewStreamingGuardrail(config).StartSession(ctx, nil)
+		waiting <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if _, err := first.CompleteSession(ctx); err != nil {
+		t.Fatalf("failed to complete session: %v", err)
+	}
+	select {
+	case err := <-waiting:
+		if err != nil {
+			t.Errorf("expected the waiting start to get the freed slot, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting start did not get the freed slot")
+	}
+	if limiter.Active() != 1 {
+		t.Errorf("expected 1 active session, got %d", limiter.Active())
+	}
+}
+
+func TestStreamWithGuardrailsReleasesSlot(t *testing.T) {
+	server := newMockStreamServer(t)
+	server.onToken = func(token string, index int) []map[string]interface{} {
+		return []map[string]interface{}{{"type": "error", "code": "POLICY_ERROR", "error": "boom"}}
+	}
+	limiter := NewSessionLimiter(1, 0)
+	config := server.config()
+	config.SessionLimiter = limiter
+
+	tokens := make(chan string, 1)
+	tokens <- "hello"
+	close(tokens)
+	results, errs := StreamWithGuardrails(context.Background(), config, tokens, nil, nil)
+	for range results {
+	}
+	if err := <-errs; err == nil {
+		t.Fatal("expected the stream to fail")
+	}
+	if limiter.Active() != 0 {
+		t.Errorf("expected the failed stream to free its slot, got %d active", limiter.Active())
+	}
+}
+
+func TestClientMaxConcurrentSessions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			json.NewEncoder(w).Encode(map[string]bool{"cancelled": true})
+			return
+		}
+		var req StartSessionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": req.SessionID})
+	}))
+	defer server.Close()
+
+	client := NewClient(Config{
+		APIKey:                "test-key",
+		OrganizationID:        "org-1",
+		BaseURL:               server.URL,
+		MaxConcurrentSessions: 1,
+		SessionWaitTimeout:    20 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	if _, err := client.StartSession(ctx, "a", ""); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	if _, err := client.StartSession(ctx, "a", ""); err != nil {
+		t.Fatalf("expected replacing a session to reuse its slot, got %v", err)
+	}
+	if _, err := client.StartSession(ctx, "b", ""); !errors.Is(err, ErrAtCapacity) {
+		t.Fatalf("expected ErrAtCapacity, got %v", err)
+	}
+	if _, err := client.CancelSession(ctx, "a"); err != nil {
+		t.Fatalf("failed to cancel session: %v", err)
+	}
+	if _, err := client.StartSession(ctx, "b", ""); err != nil {
+		t.Errorf("expected a start after cancel to succeed, got %v", err)
+	}
+}
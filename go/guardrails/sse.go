@@ -0,0 +1,139 @@
+package guardrails
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrorCodeStreamIdle is the ErrorEvent code emitted when a stream stalls
+const ErrorCodeStreamIdle = "STREAM_IDLE"
+
+// ErrStreamIdle is returned when no SSE frame arrives within the configured
+// StreamIdleTimeout
+var ErrStreamIdle = errors.New("guardrails: stream idle timeout")
+
+// ErrorCodeParseError is the ErrorEvent code emitted for an SSE frame whose
+// data is not valid JSON, so the event it carried, possibly a violation,
+// was lost
+const ErrorCodeParseError = "PARSE_ERROR"
+
+// ErrParseError is the sentinel error for ErrorCodeParseError
+var ErrParseError = errors.New("guardrails: malformed event")
+
+// maxRawEventLength caps the raw frame data quoted in parse errors
+const maxRawEventLength = 200
+
+// parseErrorMessage describes a frame that could not be decoded, quoting
+// its data up to maxRawEventLength bytes
+func parseErrorMessage(raw string, err error) string {
+	if len(raw) > maxRawEventLength {
+		raw = raw[:maxRawEventLength] + "..."
+	}
+	return fmt.Sprintf("malformed event (%v): %s", err, raw)
+}
+
+// idleWatchdog closes a response body if no line is received within the
+// timeout, unblocking a pending read. A nil watchdog is a no-op.
+type idleWatchdog struct {
+	timer   *time.Timer
+	timeout time.Duration
+	fired   atomic.Bool
+}
+
+func newIdleWatchdog(timeout time.Duration, body io.Closer) *idleWatchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	w := &idleWatchdog{timeout: timeout}
+	w.timer = time.AfterFunc(timeout, func() {
+		w.fired.Store(true)
+		body.Close()
+	})
+	return w
+}
+
+// reset restarts the idle timer; call it on every received line
+func (w *idleWatchdog) reset() {
+	if w != nil {
+		w.timer.Reset(w.timeout)
+	}
+}
+
+// stop disarms the watchdog
+func (w *idleWatchdog) stop() {
+	if w != nil {
+		w.timer.Stop()
+	}
+}
+
+// idle reports whether the watchdog closed the stream
+func (w *idleWatchdog) idle() bool {
+	return w != nil && w.fired.Load()
+}
+
+// sseFrame is a single server-sent event
+type sseFrame struct {
+	id   string
+	data string
+}
+
+// sseReader splits a text/event-stream body into frames. It handles the id
+// and data fields, joins multi-line data and skips comments.
+type sseReader struct {
+	reader *bufio.Reader
+	onLine func()
+}
+
+// newSSEReader returns a reader over body. onLine, if non-nil, is called for
+// every line received, including keepalive comments.
+func newSSEReader(body io.Reader, onLine func()) *sseReader {
+	return &sseReader{reader: bufio.NewReader(body), onLine: onLine}
+}
+
+// next returns the next frame carrying data. A final frame not terminated
+// by a blank line is still returned before io.EOF.
+func (r *sseReader) next() (sseFrame, error) {
+	var frame sseFrame
+	var data []string
+
+	for {
+		line, err := r.reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && len(data) > 0 {
+				frame.data = strings.Join(data, "\n")
+				return frame, nil
+			}
+			return sseFrame{}, err
+		}
+		if r.onLine != nil {
+			r.onLine()
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(data) > 0 {
+				frame.data = strings.Join(data, "\n")
+				return frame, nil
+			}
+			frame = sseFrame{}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			frame.id = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+}
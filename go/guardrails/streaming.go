@@ -12,7 +12,10 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/diagnyxai/diagnyx-go"
 )
 
 // StreamingGuardrail provides token-by-token evaluation of LLM output
@@ -44,48 +47,181 @@ import (
 //		}
 //		fmt.Print(filtered)
 //	}
+//	rest, err := guardrail.Flush(ctx)
 type StreamingGuardrail struct {
 	config     StreamingGuardrailConfig
 	httpClient *http.Client
 	session    *StreamingGuardrailSession
 	tokenIndex int
-	mu         sync.RWMutex
+	pending    []string
+	// everyN is the chunk size for the current session
+	everyN int
+	// earlyTermination is whether the current session stops on a blocking
+	// violation
+	earlyTermination bool
+	// holdsSlot is whether the current session holds a SessionLimiter slot
+	holdsSlot bool
+	// degraded counts evaluations skipped under FailOpen
+	degraded atomic.Int64
+	// parseErrors counts SSE frames that could not be decoded
+	parseErrors atomic.Int64
+	limiter     *rateLimiter
+	mu          sync.RWMutex
 }
 
 // StreamingGuardrailConfig holds configuration for StreamingGuardrail
 type StreamingGuardrailConfig struct {
-	APIKey                 string
-	OrganizationID         string
-	ProjectID              string
-	BaseURL                string
-	Timeout                time.Duration
-	EvaluateEveryNTokens   int
+	APIKey         string
+	OrganizationID string
+	ProjectID      string
+	BaseURL        string
+	Timeout        time.Duration
+	// EvaluateEveryNTokens is the number of tokens Evaluate buffers before
+	// sending them to the server as one chunk. The buffered tail is
+	// evaluated by Flush, CompleteSession or an isLast token. Set it to 1
+	// to evaluate every token. Default: 10
+	EvaluateEveryNTokens int
+	// EnableEarlyTermination controls what happens on a blocking violation:
+	//
+	//   - enabled: the session is marked Terminated and Evaluate returns a
+	//     *ViolationError with the allowed prefix of the chunk; later tokens
+	//     should not be streamed.
+	//   - disabled: the violation is recorded in Violations and Allowed is
+	//     set to false, but Evaluate returns the chunk without an error and
+	//     streaming continues.
+	//
+	// Non-blocking violations never stop the stream. Default: false
 	EnableEarlyTermination bool
 	Debug                  bool
+	// StreamIdleTimeout aborts an evaluation with ErrStreamIdle when no SSE
+	// line arrives within the duration. Zero disables idle detection.
+	StreamIdleTimeout time.Duration
+	// OnSessionComplete is called by StreamWithGuardrails with the final
+	// session state once the guarded stream finishes, before its channels
+	// are closed. It is not called if the session could not be started.
+	OnSessionComplete func(session *StreamingGuardrailSession)
+	// MaxEvaluateRetries is how many times a chunk is re-sent after a
+	// transport or server error before Evaluate gives up (and FailOpen, if
+	// set, applies). Violations are never retried, so a long stream such as
+	// StreamWithGuardrails survives brief network hiccups without masking
+	// real blocks. Default: 0 (no retries)
+	MaxEvaluateRetries int
+	// RetryBaseDelay is the backoff before the first retry, doubling with
+	// each further retry, plus up to 20% jitter. Default: 100ms
+	RetryBaseDelay time.Duration
+	// RetryMaxDelay caps the backoff between retries. Default: 2s
+	RetryMaxDelay time.Duration
+	// FailOpen lets content through unguarded when the guardrails server is
+	// unavailable: a network error or a 5xx response. If a session can't be
+	// started, a local Degraded session is returned and Evaluate passes
+	// every token through; if a chunk can't be evaluated, the chunk is
+	// returned without an error. Violations, context cancellation and
+	// other response statuses, such as 401 for a bad API key, are never
+	// affected.
+	// Default: false (server and network errors are returned)
+	FailOpen bool
+	// OnDegraded is called with the underlying error each time FailOpen
+	// lets content through unguarded
+	OnDegraded func(err error)
+	// DryRun skips all HTTP requests: sessions are started locally and
+	// every token is allowed. Default: false
+	DryRun bool
+	// UserAgent is appended to the SDK's own User-Agent, e.g. "my-app/1.2".
+	// Default: "" (only "diagnyx-go/<Version> (go<version>)" is sent)
+	UserAgent string
+	// AuthHeader and AuthScheme select how the API key is sent, for
+	// gateways with their own auth conventions; see diagnyx.SetAuthHeader.
+	// Default: "" ("Authorization: Bearer <key>")
+	AuthHeader string
+	AuthScheme string
+	// UserMessages overrides the end-user messages returned by
+	// ViolationError.UserMessage, keyed by policy type. The "" key replaces
+	// DefaultUserMessage for types without an entry.
+	// Default: nil (built-in messages)
+	UserMessages map[string]string
+	// MaxEvaluationsPerSecond caps chunk evaluation requests, including
+	// retries, with a token bucket allowing bursts of one second's worth.
+	// Evaluate blocks until a request is allowed or its context is done.
+	// Default: 0 (unlimited)
+	MaxEvaluationsPerSecond float64
+	// OnRateLimitWait is called with how long an evaluation was held back
+	// by MaxEvaluationsPerSecond
+	OnRateLimitWait func(wait time.Duration)
+	// RecordDiff records every token Evaluate buffered but did not emit,
+	// for debugging what guardrails changed in a stream. Read the edits
+	// with StreamingGuardrailSession.Diff once the stream is done.
+	// Default: false
+	RecordDiff bool
+	// MaxDiffEdits caps the edits recorded per session under RecordDiff;
+	// later edits are not recorded. Default: 1000
+	MaxDiffEdits int
+	// SessionLimiter bounds concurrent sessions across every
+	// StreamingGuardrail sharing it. StartSession takes a slot, waiting
+	// while the limiter is at capacity, and CompleteSession or
+	// CancelSession frees it, so complete or cancel every session.
+	// DryRun and FailOpen local sessions take no slot.
+	// Default: nil (unlimited)
+	SessionLimiter *SessionLimiter
+	// OnParseError is called with a *ServerError with code PARSE_ERROR,
+	// quoting the raw data, for each SSE frame that is not valid JSON. The
+	// event it carried, possibly a violation, is lost and evaluation
+	// continues; see also ParseErrors.
+	OnParseError func(err error)
+	// RecordTokenTimings makes EvaluateChannel and StreamWithGuardrails
+	// time every chunk they emit and store the result in the session's
+	// Timings, to see the stalls that chunked evaluation adds. It costs a
+	// clock read per chunk and up to MaxTokenTimingSamples gaps of memory.
+	// Default: false
+	RecordTokenTimings bool
+	// MaxTokenTimingSamples caps the gaps kept per stream for the p95.
+	// Default: 1000
+	MaxTokenTimingSamples int
 }
 
 // StreamingGuardrailSession represents an active streaming session
 type StreamingGuardrailSession struct {
-	SessionID        string
-	OrganizationID   string
-	ProjectID        string
-	ActivePolicies   []string
-	TokensProcessed  int
-	Violations       []Violation
-	Terminated       bool
+	SessionID         string
+	OrganizationID    string
+	ProjectID         string
+	ActivePolicies    []string
+	TokensProcessed   int
+	Violations        []Violation
+	Terminated        bool
 	TerminationReason string
-	Allowed          bool
-	AccumulatedText  string
+	Allowed           bool
+	AccumulatedText   string
+	// Timings describes how the stream's output was emitted, under
+	// RecordTokenTimings. Tokens counts input tokens, attributed to the
+	// chunk that released them.
+	Timings *diagnyx.StreamTimings
+	// edits holds the tokens that were not emitted as is, under RecordDiff
+	edits []TokenEdit
+	// Degraded is set on local sessions created by FailOpen when the server
+	// could not start a session; their tokens are not evaluated
+	Degraded bool
+	// local is set on sessions that exist only in the client (Degraded or
+	// DryRun) and never contact the server
+	local bool
+	// mu is the lock of the StreamingGuardrail that updates the session
+	mu *sync.RWMutex
+}
+
+// sessionState is the part of a session an evaluation attempt updates
+type sessionState struct {
+	tokensProcessed int
+	violations      int
+	allowed         bool
 }
 
-// ViolationError is returned when a blocking guardrail violation occurs
-type ViolationError struct {
-	Violation Violation
-	Session   *StreamingGuardrailSession
+func (s *StreamingGuardrailSession) snapshot() sessionState {
+	return sessionState{tokensProcessed: s.TokensProcessed, violations: len(s.Violations), allowed: s.Allowed}
 }
 
-func (e *ViolationError) Error() string {
-	return fmt.Sprintf("guardrail violation: %s", e.Violation.Message)
+// restore undoes the updates made since state was taken
+func (s *StreamingGuardrailSession) restore(state sessionState) {
+	s.TokensProcessed = state.tokensProcessed
+	s.Violations = s.Violations[:state.violations]
+	s.Allowed = state.allowed
 }
 
 // EvaluateOptions contains options for token evaluation
@@ -94,6 +230,22 @@ type EvaluateOptions struct {
 	IsLast     bool
 }
 
+// SessionOptions contains per-session overrides for StartSessionWithOptions.
+// Zero values fall back to the StreamingGuardrailConfig.
+type SessionOptions struct {
+	// Input is the user input the streamed output responds to
+	Input *string
+	// EvaluateEveryNTokens overrides the config's evaluation frequency
+	EvaluateEveryNTokens int
+	// EnableEarlyTermination overrides the config's early-termination setting
+	EnableEarlyTermination *bool
+	// PolicyIDs restricts evaluation to the listed policies. Empty evaluates
+	// all of the project's active policies.
+	PolicyIDs []string
+	// Metadata is attached to the session
+	Metadata map[string]interface{}
+}
+
 // NewStreamingGuardrail creates a new streaming guardrail client
 func NewStreamingGuardrail(config StreamingGuardrailConfig) *StreamingGuardrail {
 	if config.BaseURL == "" {
@@ -105,12 +257,25 @@ func NewStreamingGuardrail(config StreamingGuardrailConfig) *StreamingGuardrail
 	if config.EvaluateEveryNTokens == 0 {
 		config.EvaluateEveryNTokens = 10
 	}
+	if config.RetryBaseDelay == 0 {
+		config.RetryBaseDelay = 100 * time.Millisecond
+	}
+	if config.RetryMaxDelay == 0 {
+		config.RetryMaxDelay = 2 * time.Second
+	}
+	if config.MaxDiffEdits == 0 {
+		config.MaxDiffEdits = 1000
+	}
+	if config.MaxTokenTimingSamples == 0 {
+		config.MaxTokenTimingSamples = 1000
+	}
 
 	return &StreamingGuardrail{
 		config: config,
 		httpClient: &http.Client{
 			Timeout: config.Timeout,
 		},
+		limiter: newRateLimiter(config.MaxEvaluationsPerSecond),
 	}
 }
 
@@ -120,6 +285,20 @@ func (sg *StreamingGuardrail) log(msg string) {
 	}
 }
 
+// runCallback calls a user-supplied callback, recovering a panic into a
+// *diagnyx.CallbackPanicError that is logged and returned, so a faulty
+// callback cannot crash the process or a guarded stream's goroutine
+func runCallback(log func(string), name string, fn func()) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &diagnyx.CallbackPanicError{Callback: name, Value: v}
+			log(err.Error())
+		}
+	}()
+	fn()
+	return nil
+}
+
 func (sg *StreamingGuardrail) getBaseEndpoint() string {
 	return fmt.Sprintf("%s/api/v1/organizations/%s/guardrails",
 		strings.TrimSuffix(sg.config.BaseURL, "/"),
@@ -128,18 +307,86 @@ func (sg *StreamingGuardrail) getBaseEndpoint() string {
 
 // StartSession starts a new streaming guardrail session
 func (sg *StreamingGuardrail) StartSession(ctx context.Context, input *string) (*StreamingGuardrailSession, error) {
+	return sg.StartSessionWithOptions(ctx, SessionOptions{Input: input})
+}
+
+// StartSessionWithOptions starts a new streaming guardrail session with
+// per-session overrides of the config
+func (sg *StreamingGuardrail) StartSessionWithOptions(ctx context.Context, opts SessionOptions) (*StreamingGuardrailSession, error) {
 	sg.mu.Lock()
 	defer sg.mu.Unlock()
 
+	everyN := sg.config.EvaluateEveryNTokens
+	if opts.EvaluateEveryNTokens > 0 {
+		everyN = opts.EvaluateEveryNTokens
+	}
+	earlyTermination := sg.config.EnableEarlyTermination
+	if opts.EnableEarlyTermination != nil {
+		earlyTermination = *opts.EnableEarlyTermination
+	}
+
 	payload := map[string]interface{}{
 		"projectId":              sg.config.ProjectID,
-		"evaluateEveryNTokens":   sg.config.EvaluateEveryNTokens,
-		"enableEarlyTermination": sg.config.EnableEarlyTermination,
+		"evaluateEveryNTokens":   everyN,
+		"enableEarlyTermination": earlyTermination,
 	}
-	if input != nil {
-		payload["input"] = *input
+	if opts.Input != nil {
+		payload["input"] = *opts.Input
+	}
+	if len(opts.PolicyIDs) > 0 {
+		payload["policyIds"] = opts.PolicyIDs
+	}
+	if len(opts.Metadata) > 0 {
+		payload["metadata"] = opts.Metadata
+	}
+
+	if !sg.config.DryRun && !sg.holdsSlot {
+		if err := sg.config.SessionLimiter.acquire(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start session: %w", err)
+		}
+		sg.holdsSlot = true
+	}
+
+	var session *StreamingGuardrailSession
+	var err error
+	if sg.config.DryRun {
+		sg.log(fmt.Sprintf("Dry run, not starting session: %v", payload))
+		session = sg.localSession()
+	} else if session, err = sg.startSessionLocked(ctx, payload); err != nil {
+		if !sg.failOpen(ctx, err) {
+			if sg.session == nil {
+				sg.releaseSlotLocked()
+			}
+			return nil, err
+		}
+		sg.releaseSlotLocked()
+		session = sg.localSession()
+		session.Degraded = true
 	}
 
+	sg.session = session
+	sg.tokenIndex = 0
+	sg.pending = nil
+	sg.everyN = everyN
+	sg.earlyTermination = earlyTermination
+	return session, nil
+}
+
+// localSession returns a session that is not known to the server and
+// allows every token
+func (sg *StreamingGuardrail) localSession() *StreamingGuardrailSession {
+	return &StreamingGuardrailSession{
+		OrganizationID: sg.config.OrganizationID,
+		ProjectID:      sg.config.ProjectID,
+		Allowed:        true,
+		local:          true,
+		mu:             &sg.mu,
+	}
+}
+
+// startSessionLocked asks the server to start a session. Callers must hold
+// sg.mu.
+func (sg *StreamingGuardrail) startSessionLocked(ctx context.Context, payload map[string]interface{}) (*StreamingGuardrailSession, error) {
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
@@ -152,7 +399,8 @@ func (sg *StreamingGuardrail) StartSession(ctx context.Context, input *string) (
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+sg.config.APIKey)
+	diagnyx.SetAuthHeader(req.Header, sg.config.AuthHeader, sg.config.AuthScheme, sg.config.APIKey)
+	req.Header.Set("User-Agent", diagnyx.UserAgent(sg.config.UserAgent))
 	req.Header.Set("Accept", "application/json")
 
 	resp, err := sg.httpClient.Do(req)
@@ -162,7 +410,7 @@ func (sg *StreamingGuardrail) StartSession(ctx context.Context, input *string) (
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, &statusError{StatusCode: resp.StatusCode}
 	}
 
 	var data map[string]interface{}
@@ -175,27 +423,30 @@ func (sg *StreamingGuardrail) StartSession(ctx context.Context, input *string) (
 		sessionID, _ := data["sessionId"].(string)
 		policies := getStringSlice(data, "activePolicies")
 
-		sg.session = &StreamingGuardrailSession{
+		sg.log(fmt.Sprintf("Session started: %s", sessionID))
+		return &StreamingGuardrailSession{
 			SessionID:      sessionID,
 			OrganizationID: sg.config.OrganizationID,
 			ProjectID:      sg.config.ProjectID,
 			ActivePolicies: policies,
 			Allowed:        true,
-		}
-		sg.tokenIndex = 0
-		sg.log(fmt.Sprintf("Session started: %s", sessionID))
-		return sg.session, nil
+			mu:             &sg.mu,
+		}, nil
 	} else if eventType == "error" {
-		errorMsg, _ := data["error"].(string)
-		return nil, fmt.Errorf("failed to start session: %s", errorMsg)
+		return nil, fmt.Errorf("failed to start session: %w", serverError(data))
 	}
 
 	return nil, errors.New("unexpected response type")
 }
 
-// Evaluate evaluates a token against guardrail policies
-// Returns the token if it passes validation, empty string if blocked,
-// and an error if a blocking violation occurred.
+// Evaluate evaluates a token against guardrail policies.
+// Tokens are buffered locally and sent to the server in chunks of the
+// session's EvaluateEveryNTokens, or sooner when isLast is set. Evaluate returns an
+// empty string while a token is buffered and the whole chunk once it passes
+// validation. With EnableEarlyTermination, a blocking violation returns a
+// *ViolationError together with the part of the chunk that preceded the
+// violation. An error event from the server is returned as a *ServerError;
+// use errors.Is with ErrSessionExpired and friends to branch on its code.
 func (sg *StreamingGuardrail) Evaluate(ctx context.Context, token string, isLast bool) (string, error) {
 	return sg.EvaluateWithOptions(ctx, token, EvaluateOptions{IsLast: isLast})
 }
@@ -216,27 +467,195 @@ func (sg *StreamingGuardrail) EvaluateWithOptions(ctx context.Context, token str
 	sg.tokenIndex++
 
 	sg.session.AccumulatedText += token
+	if sg.session.local {
+		sg.session.TokensProcessed++
+		return token, nil
+	}
+	sg.pending = append(sg.pending, token)
+
+	if len(sg.pending) < sg.everyN && !opts.IsLast {
+		return "", nil
+	}
+	return sg.evaluatePendingLocked(ctx, tokenIndex, opts.IsLast)
+}
+
+// Flush evaluates any tokens still buffered by Evaluate as the final chunk
+// of the stream and returns them if they pass validation
+func (sg *StreamingGuardrail) Flush(ctx context.Context) (string, error) {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+
+	if sg.session == nil || len(sg.pending) == 0 {
+		return "", nil
+	}
+	return sg.evaluatePendingLocked(ctx, sg.tokenIndex-1, true)
+}
+
+// evaluatePendingLocked evaluates the buffered tokens as one chunk ending
+// at lastIndex, applying FailOpen to errors. Callers must hold sg.mu.
+func (sg *StreamingGuardrail) evaluatePendingLocked(ctx context.Context, lastIndex int, isLast bool) (string, error) {
+	tokens := sg.pending
+	sg.pending = nil
+	result, err := sg.evaluateWithRetryLocked(ctx, tokens, func() (string, error) {
+		return sg.evaluateChunkLocked(ctx, tokens, lastIndex, isLast)
+	})
+	if sg.config.RecordDiff && !errors.Is(err, ErrSessionEnded) {
+		sg.recordDiffLocked(tokens, lastIndex-len(tokens)+1, result, err)
+	}
+	return result, err
+}
+
+// evaluateWithRetryLocked runs evaluate, retrying failures other than
+// violations and session errors up to MaxEvaluateRetries, then applies
+// FailOpen, which lets tokens through. Whatever a failed attempt recorded
+// on the session from a partly read response is undone before retrying.
+// Callers must hold sg.mu; it is released during the backoff.
+func (sg *StreamingGuardrail) evaluateWithRetryLocked(ctx context.Context, tokens []string, evaluate func() (string, error)) (string, error) {
+	backoff := diagnyx.ExponentialBackoff{
+		BaseDelay: sg.config.RetryBaseDelay,
+		MaxDelay:  sg.config.RetryMaxDelay,
+		Jitter:    0.2,
+	}
+	for attempt := 0; ; attempt++ {
+		before := sg.session.snapshot()
+		result, err := evaluate()
+		var violationErr *ViolationError
+		if err == nil || errors.As(err, &violationErr) || isSessionError(err) || ctx.Err() != nil {
+			return result, err
+		}
+
+		if attempt < sg.config.MaxEvaluateRetries {
+			sg.session.restore(before)
+			_, delay := backoff.ShouldRetry(attempt, 0, err)
+			sg.log(fmt.Sprintf("Evaluation failed (%v), retrying in %s", err, delay))
+			if err := sg.waitUnlocked(func() error { return sleepContext(ctx, delay) }); err != nil {
+				return result, err
+			}
+			continue
+		}
+
+		if sg.failOpen(ctx, err) {
+			return strings.Join(tokens, ""), nil
+		}
+		return result, err
+	}
+}
+
+// waitUnlocked runs wait with sg.mu released, so a backoff or rate-limit
+// wait does not block other methods such as CancelSession. It returns
+// ErrSessionEnded if the session changed meanwhile. Callers must hold sg.mu.
+func (sg *StreamingGuardrail) waitUnlocked(wait func() error) error {
+	session := sg.session
+	sg.mu.Unlock()
+	err := wait()
+	sg.mu.Lock()
+	if sg.session != session {
+		return ErrSessionEnded
+	}
+	return err
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// failOpen reports whether err, which is not a violation, should let
+// content through unguarded, and records the degradation if so. Responses
+// refusing the request, such as 401 or 403, never fail open.
+func (sg *StreamingGuardrail) failOpen(ctx context.Context, err error) bool {
+	if !sg.config.FailOpen || ctx.Err() != nil || !isUnavailable(err) {
+		return false
+	}
+	sg.degraded.Add(1)
+	sg.log(fmt.Sprintf("Guardrails unavailable, failing open: %v", err))
+	if sg.config.OnDegraded != nil {
+		runCallback(sg.log, "OnDegraded", func() { sg.config.OnDegraded(err) })
+	}
+	return true
+}
+
+// parseError counts a malformed SSE frame and reports it to OnParseError
+func (sg *StreamingGuardrail) parseError(raw string, err error) {
+	sg.parseErrors.Add(1)
+	message := parseErrorMessage(raw, err)
+	sg.log(fmt.Sprintf("Failed to parse event: %s", message))
+	if sg.config.OnParseError != nil {
+		runCallback(sg.log, "OnParseError", func() {
+			sg.config.OnParseError(&ServerError{Code: ErrorCodeParseError, Message: message})
+		})
+	}
+}
+
+// ParseErrors returns how many events were lost because their SSE frame
+// was not valid JSON
+func (sg *StreamingGuardrail) ParseErrors() int64 {
+	return sg.parseErrors.Load()
+}
+
+// Degradations returns how many times FailOpen let content through
+// because the guardrails server was unavailable
+func (sg *StreamingGuardrail) Degradations() int64 {
+	return sg.degraded.Load()
+}
+
+// evaluateChunkLocked sends tokens to the server as one chunk ending at
+// lastIndex. Callers must hold sg.mu.
+func (sg *StreamingGuardrail) evaluateChunkLocked(ctx context.Context, tokens []string, lastIndex int, isLast bool) (string, error) {
+	chunk := strings.Join(tokens, "")
+	firstIndex := lastIndex - len(tokens) + 1
 
 	payload := map[string]interface{}{
 		"sessionId":  sg.session.SessionID,
-		"token":      token,
-		"tokenIndex": tokenIndex,
-		"isLast":     opts.IsLast,
+		"token":      chunk,
+		"tokenIndex": lastIndex,
+		"tokenCount": len(tokens),
+		"isLast":     isLast,
 	}
+	return sg.postEvaluationLocked(ctx, "/evaluate/stream", payload, tokens, firstIndex)
+}
 
+// postEvaluationLocked sends an evaluation request to path and applies the
+// streamed verdict to the session. tokens are the text being evaluated,
+// starting at firstIndex; it is returned in full once allowed, or up to
+// the violation on early termination. Callers must hold sg.mu; it is
+// released while waiting for the rate limiter.
+func (sg *StreamingGuardrail) postEvaluationLocked(ctx context.Context, path string, payload map[string]interface{}, tokens []string, firstIndex int) (string, error) {
+	chunk := strings.Join(tokens, "")
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
+	var wait time.Duration
+	err = sg.waitUnlocked(func() error {
+		var err error
+		wait, err = sg.limiter.wait(ctx)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if wait > 0 && sg.config.OnRateLimitWait != nil {
+		runCallback(sg.log, "OnRateLimitWait", func() { sg.config.OnRateLimitWait(wait) })
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
-		sg.getBaseEndpoint()+"/evaluate/stream", bytes.NewReader(body))
+		sg.getBaseEndpoint()+path, bytes.NewReader(body))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+sg.config.APIKey)
+	diagnyx.SetAuthHeader(req.Header, sg.config.AuthHeader, sg.config.AuthScheme, sg.config.APIKey)
+	req.Header.Set("User-Agent", diagnyx.UserAgent(sg.config.UserAgent))
 	req.Header.Set("Accept", "text/event-stream")
 
 	resp, err := sg.httpClient.Do(req)
@@ -246,20 +665,27 @@ func (sg *StreamingGuardrail) EvaluateWithOptions(ctx context.Context, token str
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return "", &statusError{StatusCode: resp.StatusCode}
 	}
 
+	watchdog := newIdleWatchdog(sg.config.StreamIdleTimeout, resp.Body)
+	defer watchdog.stop()
+
 	reader := bufio.NewReader(resp.Body)
 	var result string
 
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
+			if watchdog.idle() {
+				return result, ErrStreamIdle
+			}
 			if err == io.EOF {
 				break
 			}
 			return result, fmt.Errorf("error reading stream: %w", err)
 		}
+		watchdog.reset()
 
 		line = strings.TrimSpace(line)
 		if !strings.HasPrefix(line, "data: ") {
@@ -269,7 +695,7 @@ func (sg *StreamingGuardrail) EvaluateWithOptions(ctx context.Context, token str
 		jsonData := line[6:]
 		var data map[string]interface{}
 		if err := json.Unmarshal([]byte(jsonData), &data); err != nil {
-			sg.log(fmt.Sprintf("Failed to parse event: %v", err))
+			sg.parseError(jsonData, err)
 			continue
 		}
 
@@ -279,7 +705,7 @@ func (sg *StreamingGuardrail) EvaluateWithOptions(ctx context.Context, token str
 		case "token_allowed":
 			idx, _ := data["tokenIndex"].(float64)
 			sg.session.TokensProcessed = int(idx) + 1
-			result = token
+			result = chunk
 
 		case "violation_detected":
 			violation := sg.parseViolation(data)
@@ -291,13 +717,23 @@ func (sg *StreamingGuardrail) EvaluateWithOptions(ctx context.Context, token str
 		case "early_termination":
 			blockingData, _ := data["blockingViolation"].(map[string]interface{})
 			violation := sg.parseViolation(blockingData)
+			if !sg.earlyTermination {
+				// Early termination is disabled for this session, so keep
+				// streaming and only record the violation
+				sg.session.Violations = append(sg.session.Violations, violation)
+				sg.session.Allowed = false
+				result = chunk
+				continue
+			}
 			sg.session.Terminated = true
 			reason, _ := data["reason"].(string)
 			sg.session.TerminationReason = reason
 			sg.session.Allowed = false
-			return "", &ViolationError{
-				Violation: violation,
-				Session:   sg.session,
+			processed := getInt(data, "tokensProcessed", "tokens_processed")
+			return allowedPrefix(tokens, firstIndex, processed), &ViolationError{
+				Violation:    violation,
+				Session:      sg.session,
+				userMessages: sg.config.UserMessages,
 			}
 
 		case "session_complete":
@@ -307,15 +743,33 @@ func (sg *StreamingGuardrail) EvaluateWithOptions(ctx context.Context, token str
 			sg.session.Allowed = allowed
 
 		case "error":
-			errorMsg, _ := data["error"].(string)
-			sg.log(fmt.Sprintf("Error: %s", errorMsg))
+			err := serverError(data)
+			sg.log(fmt.Sprintf("Error: %v", err))
+			return result, err
 		}
 	}
 
 	return result, nil
 }
 
-// EvaluateChannel evaluates tokens from a channel and sends results to output channel
+// allowedPrefix joins the tokens of a chunk starting at firstIndex that were
+// processed before the stream was terminated
+func allowedPrefix(tokens []string, firstIndex, tokensProcessed int) string {
+	n := tokensProcessed - firstIndex
+	if n <= 0 {
+		return ""
+	}
+	if n > len(tokens) {
+		n = len(tokens)
+	}
+	return strings.Join(tokens[:n], "")
+}
+
+// EvaluateChannel evaluates tokens from a channel and sends results to output channel.
+// Canceling ctx aborts any in-flight evaluation and terminates the goroutine
+// even if tokens is never closed; the error channel then receives ctx.Err().
+// Nothing reads tokens after that, so producers must select on the same
+// ctx when sending rather than block forever.
 func (sg *StreamingGuardrail) EvaluateChannel(ctx context.Context, tokens <-chan string, markLast func(string) bool) (<-chan string, <-chan error) {
 	results := make(chan string, 10)
 	errors := make(chan error, 1)
@@ -324,29 +778,76 @@ func (sg *StreamingGuardrail) EvaluateChannel(ctx context.Context, tokens <-chan
 		defer close(results)
 		defer close(errors)
 
-		for token := range tokens {
-			select {
-			case <-ctx.Done():
-				errors <- ctx.Err()
-				return
-			default:
+		if err := sg.evaluateTokens(ctx, tokens, markLast, results); err != nil {
+			errors <- err
+		}
+	}()
+
+	return results, errors
+}
+
+// evaluateTokens evaluates tokens until the channel closes, an evaluation
+// fails or ctx is canceled, sending allowed output to results
+func (sg *StreamingGuardrail) evaluateTokens(ctx context.Context, tokens <-chan string, markLast func(string) bool, results chan<- string) error {
+	var timer *diagnyx.TokenTimer
+	if sg.config.RecordTokenTimings {
+		timer = diagnyx.NewTokenTimer(sg.config.MaxTokenTimingSamples)
+		defer sg.setTimings(timer)
+	}
+	// pending counts the tokens received since the last emission
+	pending := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case token, ok := <-tokens:
+			var result string
+			var err error
+			if ok {
+				pending++
 				isLast := markLast != nil && markLast(token)
-				result, err := sg.Evaluate(ctx, token, isLast)
-				if err != nil {
-					errors <- err
-					return
+				result, err = sg.Evaluate(ctx, token, isLast)
+			} else {
+				result, err = sg.Flush(ctx)
+			}
+			if result != "" {
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				if timer != nil {
+					timer.Record(time.Now(), pending)
 				}
-				if result != "" {
-					results <- result
+				pending = 0
+			}
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return ctxErr
 				}
+				return err
+			}
+			if !ok {
+				return nil
 			}
 		}
-	}()
+	}
+}
 
-	return results, errors
+// setTimings stores a stream's timings in the current session
+func (sg *StreamingGuardrail) setTimings(timer *diagnyx.TokenTimer) {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	if sg.session != nil {
+		timings := timer.Timings()
+		sg.session.Timings = &timings
+	}
 }
 
-// CompleteSession completes the current session
+// CompleteSession completes the current session. Tokens still buffered by
+// Evaluate are evaluated first, so their violations count toward the
+// session; call Flush beforehand to receive them.
 func (sg *StreamingGuardrail) CompleteSession(ctx context.Context) (*StreamingGuardrailSession, error) {
 	sg.mu.Lock()
 	defer sg.mu.Unlock()
@@ -354,6 +855,25 @@ func (sg *StreamingGuardrail) CompleteSession(ctx context.Context) (*StreamingGu
 	if sg.session == nil {
 		return nil, errors.New("no active session")
 	}
+	if sg.session.local {
+		session := sg.session
+		sg.session = nil
+		sg.releaseSlotLocked()
+		return session, nil
+	}
+	if len(sg.pending) > 0 {
+		// Evaluate the tail still buffered by Evaluate so its violations
+		// are part of the session. A violation is already recorded on the
+		// session, so completion goes ahead.
+		_, err := sg.evaluatePendingLocked(ctx, sg.tokenIndex-1, true)
+		var violationErr *ViolationError
+		if err != nil && !errors.As(err, &violationErr) {
+			return nil, fmt.Errorf("failed to evaluate buffered tokens: %w", err)
+		}
+		if sg.session == nil {
+			return nil, errors.New("no active session")
+		}
+	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
 		fmt.Sprintf("%s/evaluate/stream/%s/complete", sg.getBaseEndpoint(), sg.session.SessionID), nil)
@@ -361,7 +881,8 @@ func (sg *StreamingGuardrail) CompleteSession(ctx context.Context) (*StreamingGu
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+sg.config.APIKey)
+	diagnyx.SetAuthHeader(req.Header, sg.config.AuthHeader, sg.config.AuthScheme, sg.config.APIKey)
+	req.Header.Set("User-Agent", diagnyx.UserAgent(sg.config.UserAgent))
 	req.Header.Set("Accept", "text/event-stream")
 
 	resp, err := sg.httpClient.Do(req)
@@ -371,18 +892,22 @@ func (sg *StreamingGuardrail) CompleteSession(ctx context.Context) (*StreamingGu
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, &statusError{StatusCode: resp.StatusCode}
 	}
 
+	watchdog := newIdleWatchdog(sg.config.StreamIdleTimeout, resp.Body)
+	defer watchdog.stop()
+
 	reader := bufio.NewReader(resp.Body)
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
-			if err == io.EOF {
-				break
+			if watchdog.idle() {
+				return nil, ErrStreamIdle
 			}
 			break
 		}
+		watchdog.reset()
 
 		line = strings.TrimSpace(line)
 		if !strings.HasPrefix(line, "data: ") {
@@ -391,6 +916,7 @@ func (sg *StreamingGuardrail) CompleteSession(ctx context.Context) (*StreamingGu
 
 		var data map[string]interface{}
 		if err := json.Unmarshal([]byte(line[6:]), &data); err != nil {
+			sg.parseError(line[6:], err)
 			continue
 		}
 
@@ -404,6 +930,7 @@ func (sg *StreamingGuardrail) CompleteSession(ctx context.Context) (*StreamingGu
 
 	session := sg.session
 	sg.session = nil
+	sg.releaseSlotLocked()
 	return session, nil
 }
 
@@ -415,6 +942,11 @@ func (sg *StreamingGuardrail) CancelSession(ctx context.Context) (bool, error) {
 	if sg.session == nil {
 		return false, nil
 	}
+	if sg.session.local {
+		sg.session = nil
+		sg.releaseSlotLocked()
+		return true, nil
+	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodDelete,
 		fmt.Sprintf("%s/evaluate/stream/%s", sg.getBaseEndpoint(), sg.session.SessionID), nil)
@@ -422,7 +954,8 @@ func (sg *StreamingGuardrail) CancelSession(ctx context.Context) (bool, error) {
 		return false, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+sg.config.APIKey)
+	diagnyx.SetAuthHeader(req.Header, sg.config.AuthHeader, sg.config.AuthScheme, sg.config.APIKey)
+	req.Header.Set("User-Agent", diagnyx.UserAgent(sg.config.UserAgent))
 
 	resp, err := sg.httpClient.Do(req)
 	if err != nil {
@@ -431,7 +964,7 @@ func (sg *StreamingGuardrail) CancelSession(ctx context.Context) (bool, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return false, &statusError{StatusCode: resp.StatusCode}
 	}
 
 	var result struct {
@@ -442,9 +975,27 @@ func (sg *StreamingGuardrail) CancelSession(ctx context.Context) (bool, error) {
 	}
 
 	sg.session = nil
+	sg.releaseSlotLocked()
 	return result.Cancelled, nil
 }
 
+// releaseSlot frees the SessionLimiter slot of a guardrail that is being
+// discarded with its session still active
+func (sg *StreamingGuardrail) releaseSlot() {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	sg.releaseSlotLocked()
+}
+
+// releaseSlotLocked frees the current session's SessionLimiter slot, if it
+// holds one. Callers must hold sg.mu.
+func (sg *StreamingGuardrail) releaseSlotLocked() {
+	if sg.holdsSlot {
+		sg.config.SessionLimiter.release()
+		sg.holdsSlot = false
+	}
+}
+
 // GetSession returns the current session
 func (sg *StreamingGuardrail) GetSession() *StreamingGuardrailSession {
 	sg.mu.RLock()
@@ -482,7 +1033,12 @@ func (sg *StreamingGuardrail) parseViolation(data map[string]interface{}) Violat
 	}
 }
 
-// StreamWithGuardrails wraps a token channel with guardrail protection
+// StreamWithGuardrails wraps a token channel with guardrail protection.
+// Set config.OnSessionComplete to receive the final session (total tokens,
+// violations and verdict) after the stream finishes. tokens is not read
+// after the stream ends early on a violation, an error or ctx, so
+// producers should send in a select on a context they cancel once the
+// results channel is closed.
 func StreamWithGuardrails(
 	ctx context.Context,
 	config StreamingGuardrailConfig,
@@ -498,33 +1054,28 @@ func StreamWithGuardrails(
 		defer close(errors)
 
 		guardrail := NewStreamingGuardrail(config)
+		defer guardrail.releaseSlot()
 
-		_, err := guardrail.StartSession(ctx, input)
+		session, err := guardrail.StartSession(ctx, input)
 		if err != nil {
 			errors <- err
 			return
 		}
+		if config.OnSessionComplete != nil {
+			defer func() {
+				runCallback(guardrail.log, "OnSessionComplete", func() { config.OnSessionComplete(session) })
+			}()
+		}
 
-		for token := range tokens {
-			select {
-			case <-ctx.Done():
-				errors <- ctx.Err()
-				return
-			default:
-				isLast := markLast != nil && markLast(token)
-				result, err := guardrail.Evaluate(ctx, token, isLast)
-				if err != nil {
-					errors <- err
-					return
-				}
-				if result != "" {
-					results <- result
-				}
-			}
+		if err := guardrail.evaluateTokens(ctx, tokens, markLast, results); err != nil {
+			errors <- err
+			return
 		}
 
 		if guardrail.IsActive() {
-			_, _ = guardrail.CompleteSession(ctx)
+			if completed, err := guardrail.CompleteSession(ctx); err == nil {
+				session = completed
+			}
 		}
 	}()
 
@@ -0,0 +1,926 @@
+package guardrails
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mockStreamServer emulates the streaming guardrails endpoints. onToken
+// returns the SSE events sent for each evaluated token; by default every
+// token is allowed.
+type mockStreamServer struct {
+	*httptest.Server
+	onToken     func(token string, index int) []map[string]interface{}
+	evaluations atomic.Int32
+	cancels     atomic.Int32
+
+	mu          sync.Mutex
+	startBody   map[string]interface{}
+	lastContent EvalContent
+}
+
+func newMockStreamServer(t *testing.T) *mockStreamServer {
+	t.Helper()
+	m := &mockStreamServer{}
+	mux := http.NewServeMux()
+	base := "/api/v1/organizations/org-1/guardrails/evaluate/stream"
+
+	mux.HandleFunc(base+"/start", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		m.mu.Lock()
+		m.startBody = body
+		m.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"type":           "session_started",
+			"sessionId":      "sess-1",
+			"activePolicies": []string{"pii"},
+		})
+	})
+
+	mux.HandleFunc(base, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token      string `json:"token"`
+			TokenIndex int    `json:"tokenIndex"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		m.evaluations.Add(1)
+
+		events := []map[string]interface{}{{"type": "token_allowed", "tokenIndex": req.TokenIndex}}
+		if m.onToken != nil {
+			events = m.onToken(req.Token, req.TokenIndex)
+		}
+		writeSSE(w, events...)
+	})
+
+	mux.HandleFunc(base+"/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			m.cancels.Add(1)
+			json.NewEncoder(w).Encode(map[string]bool{"cancelled": true})
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/multimodal") {
+			var req struct {
+				Content    EvalContent `json:"content"`
+				TokenIndex int         `json:"tokenIndex"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			m.evaluations.Add(1)
+			m.mu.Lock()
+			m.lastContent = req.Content
+			m.mu.Unlock()
+
+			events := []map[string]interface{}{{"type": "token_allowed", "tokenIndex": req.TokenIndex}}
+			if m.onToken != nil {
+				events = m.onToken(req.Content.Text+req.Content.ImageURL, req.TokenIndex)
+			}
+			writeSSE(w, events...)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/complete") {
+			writeSSE(w, map[string]interface{}{"type": "session_complete", "totalTokens": 0, "allowed": true})
+			return
+		}
+		http.NotFound(w, r)
+	})
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockStreamServer) config() StreamingGuardrailConfig {
+	return StreamingGuardrailConfig{
+		APIKey:         "test-key",
+		OrganizationID: "org-1",
+		ProjectID:      "proj-1",
+		BaseURL:        m.URL,
+	}
+}
+
+// lastStart returns the payload of the most recent session start request
+func (m *mockStreamServer) lastStart() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.startBody
+}
+
+func writeSSE(w http.ResponseWriter, events ...map[string]interface{}) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, event := range events {
+		data, _ := json.Marshal(event)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+}
+
+func TestEvaluateChannel(t *testing.T) {
+	server := newMockStreamServer(t)
+	guardrail := NewStreamingGuardrail(server.config())
+
+	ctx := context.Background()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+
+	tokens := make(chan string, 3)
+	tokens <- "Hello"
+	tokens <- ", "
+	tokens <- "world"
+	close(tokens)
+
+	results, errs := guardrail.EvaluateChannel(ctx, tokens, nil)
+
+	var output string
+	for result := range results {
+		output += result
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output != "Hello, world" {
+		t.Errorf("expected 'Hello, world', got '%s'", output)
+	}
+}
+
+func TestEvaluateChannelCancel(t *testing.T) {
+	server := newMockStreamServer(t)
+	config := server.config()
+	config.EvaluateEveryNTokens = 1
+	guardrail := NewStreamingGuardrail(config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+
+	// The producer never closes the channel
+	tokens := make(chan string)
+	results, errs := guardrail.EvaluateChannel(ctx, tokens, nil)
+
+	tokens <- "Hello"
+	if result := <-results; result != "Hello" {
+		t.Fatalf("expected 'Hello', got '%s'", result)
+	}
+
+	cancel()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("EvaluateChannel did not stop after cancellation")
+	}
+
+	select {
+	case _, ok := <-results:
+		if ok {
+			t.Error("expected results channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("results channel was not closed after cancellation")
+	}
+}
+
+func TestEvaluateEveryNTokens(t *testing.T) {
+	server := newMockStreamServer(t)
+	config := server.config()
+	config.EvaluateEveryNTokens = 3
+	guardrail := NewStreamingGuardrail(config)
+
+	ctx := context.Background()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+
+	var outputs []string
+	for _, token := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		result, err := guardrail.Evaluate(ctx, token, token == "g")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		outputs = append(outputs, result)
+	}
+
+	expected := []string{"", "", "abc", "", "", "def", "g"}
+	if fmt.Sprint(outputs) != fmt.Sprint(expected) {
+		t.Errorf("expected outputs %q, got %q", expected, outputs)
+	}
+	if n := server.evaluations.Load(); n != 3 {
+		t.Errorf("expected 3 evaluation requests, got %d", n)
+	}
+}
+
+func TestCompleteSessionFlushesPending(t *testing.T) {
+	server := newMockStreamServer(t)
+	config := server.config()
+	config.EvaluateEveryNTokens = 3
+	guardrail := NewStreamingGuardrail(config)
+
+	ctx := context.Background()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	for _, token := range []string{"a", "b"} {
+		if _, err := guardrail.Evaluate(ctx, token, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := server.evaluations.Load(); n != 0 {
+		t.Fatalf("expected the tokens to be buffered, got %d evaluation requests", n)
+	}
+
+	if _, err := guardrail.CompleteSession(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := server.evaluations.Load(); n != 1 {
+		t.Errorf("expected the buffered tokens to be evaluated on completion, got %d evaluation requests", n)
+	}
+}
+
+func TestEvaluateEveryNTokensDefault(t *testing.T) {
+	server := newMockStreamServer(t)
+	guardrail := NewStreamingGuardrail(server.config())
+
+	ctx := context.Background()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	if body := server.lastStart(); body["evaluateEveryNTokens"] != float64(10) {
+		t.Errorf("expected the default of 10 to be sent, got %v", body)
+	}
+
+	var result string
+	for i := 0; i < 10; i++ {
+		var err error
+		if result, err = guardrail.Evaluate(ctx, "a", false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if i < 9 && result != "" {
+			t.Fatalf("expected token %d to be buffered, got %q", i, result)
+		}
+	}
+	if result != strings.Repeat("a", 10) || server.evaluations.Load() != 1 {
+		t.Errorf("expected one evaluation of 10 tokens, got %q in %d evaluations", result, server.evaluations.Load())
+	}
+}
+
+func TestEvaluateEarlyTerminationPrefix(t *testing.T) {
+	server := newMockStreamServer(t)
+	server.onToken = func(token string, index int) []map[string]interface{} {
+		return []map[string]interface{}{{
+			"type":              "early_termination",
+			"reason":            "blocked",
+			"tokensProcessed":   2,
+			"blockingViolation": map[string]interface{}{"policyId": "pii", "enforcementLevel": "blocking", "message": "email"},
+		}}
+	}
+	config := server.config()
+	config.EvaluateEveryNTokens = 4
+	config.EnableEarlyTermination = true
+	guardrail := NewStreamingGuardrail(config)
+
+	ctx := context.Background()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+
+	var result string
+	var err error
+	for _, token := range []string{"mail ", "me ", "at ", "a@b.c"} {
+		result, err = guardrail.Evaluate(ctx, token, false)
+	}
+
+	var violationErr *ViolationError
+	if !errors.As(err, &violationErr) {
+		t.Fatalf("expected ViolationError, got %v", err)
+	}
+	if result != "mail me " {
+		t.Errorf("expected allowed prefix 'mail me ', got '%s'", result)
+	}
+	if !guardrail.GetSession().Terminated {
+		t.Error("expected session to be terminated")
+	}
+}
+
+func TestStartSessionWithOptions(t *testing.T) {
+	server := newMockStreamServer(t)
+	config := server.config()
+	config.EnableEarlyTermination = true
+	guardrail := NewStreamingGuardrail(config)
+
+	input := "What is my balance?"
+	disabled := false
+	ctx := context.Background()
+	_, err := guardrail.StartSessionWithOptions(ctx, SessionOptions{
+		Input:                  &input,
+		EvaluateEveryNTokens:   2,
+		EnableEarlyTermination: &disabled,
+		PolicyIDs:              []string{"pii"},
+		Metadata:               map[string]interface{}{"tenant": "acme"},
+	})
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+
+	body := server.lastStart()
+	if body["input"] != input {
+		t.Errorf("expected input %q, got %v", input, body["input"])
+	}
+	if body["evaluateEveryNTokens"] != float64(2) {
+		t.Errorf("expected evaluateEveryNTokens 2, got %v", body["evaluateEveryNTokens"])
+	}
+	if body["enableEarlyTermination"] != false {
+		t.Errorf("expected early termination disabled, got %v", body["enableEarlyTermination"])
+	}
+	if fmt.Sprint(body["policyIds"]) != "[pii]" {
+		t.Errorf("expected policyIds [pii], got %v", body["policyIds"])
+	}
+	if metadata, _ := body["metadata"].(map[string]interface{}); metadata["tenant"] != "acme" {
+		t.Errorf("expected metadata tenant 'acme', got %v", body["metadata"])
+	}
+
+	// The per-session frequency also sets the local chunk size
+	if result, _ := guardrail.Evaluate(ctx, "a", false); result != "" {
+		t.Errorf("expected first token to be buffered, got '%s'", result)
+	}
+	if result, _ := guardrail.Evaluate(ctx, "b", false); result != "ab" {
+		t.Errorf("expected chunk 'ab', got '%s'", result)
+	}
+
+	// Defaults come from the config
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	body = server.lastStart()
+	if body["evaluateEveryNTokens"] != float64(10) || body["enableEarlyTermination"] != true {
+		t.Errorf("expected config defaults, got %v", body)
+	}
+	if _, ok := body["policyIds"]; ok {
+		t.Error("expected policyIds to be omitted by default")
+	}
+}
+
+func TestEvaluateEarlyTerminationDisabled(t *testing.T) {
+	server := newMockStreamServer(t)
+	server.onToken = func(token string, index int) []map[string]interface{} {
+		if token != "a@b.c" {
+			return []map[string]interface{}{{"type": "token_allowed", "tokenIndex": index}}
+		}
+		return []map[string]interface{}{{
+			"type":              "early_termination",
+			"reason":            "blocked",
+			"tokensProcessed":   index,
+			"blockingViolation": map[string]interface{}{"policyId": "pii", "enforcementLevel": "blocking", "message": "email"},
+		}}
+	}
+	config := server.config()
+	config.EvaluateEveryNTokens = 1
+	guardrail := NewStreamingGuardrail(config)
+
+	ctx := context.Background()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+
+	var output string
+	for i, token := range []string{"mail ", "a@b.c", " thanks"} {
+		result, err := guardrail.Evaluate(ctx, token, i == 2)
+		if err != nil {
+			t.Fatalf("expected no error with early termination disabled, got %v", err)
+		}
+		output += result
+	}
+
+	if output != "mail a@b.c thanks" {
+		t.Errorf("expected the stream to continue, got '%s'", output)
+	}
+	session := guardrail.GetSession()
+	if session.Terminated {
+		t.Error("expected session not to be terminated")
+	}
+	if session.Allowed {
+		t.Error("expected session to be marked not allowed")
+	}
+	if len(session.Violations) != 1 || session.Violations[0].PolicyID != "pii" {
+		t.Errorf("expected the pii violation to be recorded, got %+v", session.Violations)
+	}
+}
+
+func TestCallbackPanics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	config := StreamingGuardrailConfig{
+		APIKey:            "test-key",
+		OrganizationID:    "org-1",
+		BaseURL:           server.URL,
+		FailOpen:          true,
+		OnDegraded:        func(error) { panic("degraded hook bug") },
+		OnSessionComplete: func(*StreamingGuardrailSession) { panic("complete hook bug") },
+	}
+
+	tokens := make(chan string, 2)
+	tokens <- "Hello"
+	tokens <- " world"
+	close(tokens)
+
+	results, errs := StreamWithGuardrails(context.Background(), config, tokens, nil, nil)
+	var output string
+	for result := range results {
+		output += result
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("expected panicking callbacks not to fail the stream, got %v", err)
+	}
+	if output != "Hello world" {
+		t.Errorf("expected tokens to pass through, got '%s'", output)
+	}
+}
+
+func TestFailOpen(t *testing.T) {
+	t.Run("session start failure", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		var degraded []error
+		config := StreamingGuardrailConfig{
+			APIKey:         "test-key",
+			OrganizationID: "org-1",
+			BaseURL:        server.URL,
+			FailOpen:       true,
+			OnDegraded:     func(err error) { degraded = append(degraded, err) },
+		}
+
+		tokens := make(chan string, 2)
+		tokens <- "Hello"
+		tokens <- " world"
+		close(tokens)
+
+		var session *StreamingGuardrailSession
+		config.OnSessionComplete = func(s *StreamingGuardrailSession) { session = s }
+		results, errs := StreamWithGuardrails(context.Background(), config, tokens, nil, nil)
+
+		var output string
+		for result := range results {
+			output += result
+		}
+		if err := <-errs; err != nil {
+			t.Fatalf("expected no error with FailOpen, got %v", err)
+		}
+		if output != "Hello world" {
+			t.Errorf("expected tokens to pass through, got '%s'", output)
+		}
+		if len(degraded) != 1 {
+			t.Errorf("expected 1 degradation, got %d", len(degraded))
+		}
+		if session == nil || !session.Degraded {
+			t.Errorf("expected a degraded session, got %+v", session)
+		}
+	})
+
+	t.Run("evaluation failure", func(t *testing.T) {
+		server := newMockStreamServer(t)
+		config := server.config()
+		config.EvaluateEveryNTokens = 2
+		config.FailOpen = true
+		guardrail := NewStreamingGuardrail(config)
+
+		ctx := context.Background()
+		if _, err := guardrail.StartSession(ctx, nil); err != nil {
+			t.Fatalf("failed to start session: %v", err)
+		}
+		server.Close()
+
+		guardrail.Evaluate(ctx, "a", false)
+		result, err := guardrail.Evaluate(ctx, "b", false)
+		if err != nil {
+			t.Fatalf("expected no error with FailOpen, got %v", err)
+		}
+		if result != "ab" {
+			t.Errorf("expected chunk 'ab' to pass through, got '%s'", result)
+		}
+		if guardrail.Degradations() != 1 {
+			t.Errorf("expected 1 degradation, got %d", guardrail.Degradations())
+		}
+	})
+
+	t.Run("does not fail open on a refused request", func(t *testing.T) {
+		for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound} {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			}))
+			guardrail := NewStreamingGuardrail(StreamingGuardrailConfig{
+				APIKey:         "bad-key",
+				OrganizationID: "org-1",
+				BaseURL:        server.URL,
+				FailOpen:       true,
+			})
+
+			_, err := guardrail.StartSession(context.Background(), nil)
+			server.Close()
+			if err == nil {
+				t.Errorf("expected status %d to be returned despite FailOpen", status)
+			}
+			if guardrail.Degradations() != 0 {
+				t.Errorf("expected no degradation for status %d, got %d", status, guardrail.Degradations())
+			}
+		}
+	})
+
+	t.Run("fails closed by default", func(t *testing.T) {
+		server := newMockStreamServer(t)
+		guardrail := NewStreamingGuardrail(server.config())
+		server.Close()
+
+		if _, err := guardrail.StartSession(context.Background(), nil); err == nil {
+			t.Error("expected an error when the server is unavailable")
+		}
+	})
+}
+
+func TestStreamingDryRun(t *testing.T) {
+	server := newMockStreamServer(t)
+	config := server.config()
+	config.DryRun = true
+	config.EvaluateEveryNTokens = 1
+	guardrail := NewStreamingGuardrail(config)
+
+	ctx := context.Background()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	if result, err := guardrail.Evaluate(ctx, "Hello", true); err != nil || result != "Hello" {
+		t.Errorf("expected token to be allowed, got '%s', %v", result, err)
+	}
+	if _, err := guardrail.CompleteSession(ctx); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if server.lastStart() != nil || server.evaluations.Load() != 0 {
+		t.Error("expected no requests in dry run")
+	}
+}
+
+func TestStreamWithGuardrailsRetry(t *testing.T) {
+	var evaluations atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": "sess-1"})
+		case strings.HasSuffix(r.URL.Path, "/complete"):
+			writeSSE(w, map[string]interface{}{"type": "session_complete", "totalTokens": 2, "allowed": true})
+		default:
+			// Every other evaluation request fails once before succeeding
+			if evaluations.Add(1)%2 == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			writeSSE(w, map[string]interface{}{"type": "token_allowed", "tokenIndex": 0})
+		}
+	}))
+	defer server.Close()
+
+	config := StreamingGuardrailConfig{
+		APIKey:               "test-key",
+		OrganizationID:       "org-1",
+		BaseURL:              server.URL,
+		EvaluateEveryNTokens: 1,
+		MaxEvaluateRetries:   1,
+		RetryBaseDelay:       time.Millisecond,
+	}
+
+	tokens := make(chan string, 2)
+	tokens <- "Hello"
+	tokens <- " world"
+	close(tokens)
+
+	results, errs := StreamWithGuardrails(context.Background(), config, tokens, nil, nil)
+
+	var output string
+	for result := range results {
+		output += result
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("expected transient failures to be retried, got %v", err)
+	}
+	if output != "Hello world" {
+		t.Errorf("expected 'Hello world', got '%s'", output)
+	}
+	if n := evaluations.Load(); n != 4 {
+		t.Errorf("expected 4 evaluation requests, got %d", n)
+	}
+
+	// Without retries the first failure aborts the stream
+	config.MaxEvaluateRetries = 0
+	evaluations.Store(0)
+	tokens = make(chan string, 1)
+	tokens <- "Hello"
+	close(tokens)
+
+	results, errs = StreamWithGuardrails(context.Background(), config, tokens, nil, nil)
+	for range results {
+	}
+	if err := <-errs; err == nil {
+		t.Error("expected an error without retries")
+	}
+}
+
+func TestEvaluateRetryResetsPartialState(t *testing.T) {
+	var evaluations atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": "sess-1"})
+		case strings.HasSuffix(r.URL.Path, "/sess-1"):
+			json.NewEncoder(w).Encode(map[string]interface{}{"cancelled": true})
+		default:
+			if evaluations.Add(1) == 1 {
+				// Cut the stream short after a violation
+				w.Header().Set("Content-Length", "1000")
+				writeSSE(w, map[string]interface{}{"type": "violation_detected", "policyId": "pii", "enforcementLevel": "blocking"})
+				return
+			}
+			writeSSE(w, map[string]interface{}{"type": "token_allowed", "tokenIndex": 0})
+		}
+	}))
+	defer server.Close()
+
+	guardrail := NewStreamingGuardrail(StreamingGuardrailConfig{
+		APIKey:               "test-key",
+		OrganizationID:       "org-1",
+		BaseURL:              server.URL,
+		EvaluateEveryNTokens: 1,
+		MaxEvaluateRetries:   1,
+		RetryBaseDelay:       50 * time.Millisecond,
+	})
+
+	ctx := context.Background()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+
+	// The lock is released during the backoff
+	var evaluated atomic.Bool
+	blocked := make(chan bool)
+	go func() {
+		for evaluations.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		guardrail.GetSession()
+		blocked <- evaluated.Load()
+	}()
+
+	result, err := guardrail.Evaluate(ctx, "a", false)
+	evaluated.Store(true)
+	if err != nil || result != "a" {
+		t.Fatalf("expected the retry to allow the token, got %q, %v", result, err)
+	}
+	if <-blocked {
+		t.Error("expected GetSession not to wait for the retry")
+	}
+	if n := evaluations.Load(); n != 2 {
+		t.Errorf("expected 2 evaluation requests, got %d", n)
+	}
+	if session := guardrail.GetSession(); len(session.Violations) != 0 || !session.Allowed {
+		t.Errorf("expected the failed attempt's violation to be discarded, got %+v", session.Violations)
+	}
+}
+
+func TestEvaluateSessionEndsDuringBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": "sess-1"})
+		case strings.HasSuffix(r.URL.Path, "/sess-1"):
+			json.NewEncoder(w).Encode(map[string]interface{}{"cancelled": true})
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	guardrail := NewStreamingGuardrail(StreamingGuardrailConfig{
+		APIKey:               "test-key",
+		OrganizationID:       "org-1",
+		BaseURL:              server.URL,
+		EvaluateEveryNTokens: 1,
+		MaxEvaluateRetries:   1,
+		RetryBaseDelay:       100 * time.Millisecond,
+		RecordDiff:           true,
+	})
+
+	ctx := context.Background()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		guardrail.CancelSession(ctx)
+	}()
+
+	if _, err := guardrail.Evaluate(ctx, "a", false); !errors.Is(err, ErrSessionEnded) {
+		t.Errorf("expected ErrSessionEnded, got %v", err)
+	}
+}
+
+func TestEvaluateServerError(t *testing.T) {
+	server := newMockStreamServer(t)
+	server.onToken = func(token string, index int) []map[string]interface{} {
+		return []map[string]interface{}{{"type": "error", "error": "session has expired", "code": ErrorCodeSessionExpired}}
+	}
+	config := server.config()
+	config.EvaluateEveryNTokens = 1
+	config.MaxEvaluateRetries = 2
+	config.FailOpen = true
+	guardrail := NewStreamingGuardrail(config)
+
+	ctx := context.Background()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+
+	_, err := guardrail.Evaluate(ctx, "a", false)
+	if !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("expected ErrSessionExpired, got %v", err)
+	}
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Message != "session has expired" {
+		t.Errorf("expected ServerError with message, got %v", err)
+	}
+	if n := server.evaluations.Load(); n != 1 {
+		t.Errorf("expected session errors not to be retried, got %d evaluations", n)
+	}
+	if guardrail.Degradations() != 0 {
+		t.Error("expected session errors not to fail open")
+	}
+}
+
+func TestRecordDiff(t *testing.T) {
+	server := newMockStreamServer(t)
+	server.onToken = func(token string, index int) []map[string]interface{} {
+		if !strings.Contains(token, "@") {
+			return []map[string]interface{}{{"type": "token_allowed", "tokenIndex": index}}
+		}
+		return []map[string]interface{}{{
+			"type":              "early_termination",
+			"reason":            "blocked",
+			"tokensProcessed":   3,
+			"blockingViolation": map[string]interface{}{"policyId": "pii", "enforcementLevel": "blocking", "message": "email"},
+		}}
+	}
+	config := server.config()
+	config.EvaluateEveryNTokens = 2
+	config.EnableEarlyTermination = true
+	config.RecordDiff = true
+
+	var session *StreamingGuardrailSession
+	config.OnSessionComplete = func(s *StreamingGuardrailSession) { session = s }
+
+	tokens := make(chan string, 4)
+	for _, token := range []string{"mail ", "me ", "at ", "a@b.c"} {
+		tokens <- token
+	}
+	close(tokens)
+
+	results, errs := StreamWithGuardrails(context.Background(), config, tokens, nil, nil)
+	for range results {
+	}
+	var violationErr *ViolationError
+	if err := <-errs; !errors.As(err, &violationErr) {
+		t.Fatalf("expected ViolationError, got %v", err)
+	}
+
+	diff := session.Diff()
+	if len(diff) != 1 {
+		t.Fatalf("expected one edit, got %+v", diff)
+	}
+	edit := diff[0]
+	if edit.Index != 3 || edit.Original != "a@b.c" {
+		t.Errorf("expected token 3 to be dropped, got %+v", edit)
+	}
+	if edit.Violation == nil || edit.Violation.PolicyID != "pii" {
+		t.Errorf("expected the edit to carry the pii violation, got %+v", edit.Violation)
+	}
+
+	t.Run("capped and off by default", func(t *testing.T) {
+		config := server.config()
+		config.EvaluateEveryNTokens = 2
+		config.EnableEarlyTermination = true
+		config.RecordDiff = true
+		config.MaxDiffEdits = 1
+		server.onToken = func(token string, index int) []map[string]interface{} {
+			return []map[string]interface{}{{
+				"type":              "early_termination",
+				"tokensProcessed":   0,
+				"blockingViolation": map[string]interface{}{"policyId": "pii", "enforcementLevel": "blocking"},
+			}}
+		}
+
+		for _, record := range []bool{true, false} {
+			config.RecordDiff = record
+			guardrail := NewStreamingGuardrail(config)
+			session, err := guardrail.StartSession(context.Background(), nil)
+			if err != nil {
+				t.Fatalf("failed to start session: %v", err)
+			}
+			guardrail.Evaluate(context.Background(), "a", false)
+			guardrail.Evaluate(context.Background(), "b", false)
+
+			want := 0
+			if record {
+				want = 1
+			}
+			if got := len(session.Diff()); got != want {
+				t.Errorf("RecordDiff=%v: expected %d edits, got %d", record, want, got)
+			}
+		}
+	})
+}
+
+func TestRecordTokenTimings(t *testing.T) {
+	server := newMockStreamServer(t)
+	config := server.config()
+	config.EvaluateEveryNTokens = 2
+	config.RecordTokenTimings = true
+
+	var session *StreamingGuardrailSession
+	config.OnSessionComplete = func(s *StreamingGuardrailSession) { session = s }
+
+	tokens := make(chan string, 5)
+	for _, token := range []string{"a", "b", "c", "d", "e"} {
+		tokens <- token
+	}
+	close(tokens)
+
+	results, errs := StreamWithGuardrails(context.Background(), config, tokens, nil, nil)
+	for range results {
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if session.Timings == nil {
+		t.Fatal("expected timings to be recorded")
+	}
+	if session.Timings.Tokens != 5 {
+		t.Errorf("expected 5 tokens across the emitted chunks, got %d", session.Timings.Tokens)
+	}
+	if session.Timings.MaxGap <= 0 {
+		t.Errorf("expected a gap between the chunks, got %s", session.Timings.MaxGap)
+	}
+}
+
+func TestStreamingMalformedEvent(t *testing.T) {
+	server := newMockStreamServer(t)
+	server.onToken = func(token string, index int) []map[string]interface{} {
+		return []map[string]interface{}{{"type": "token_allowed", "tokenIndex": index}}
+	}
+	config := server.config()
+	config.EvaluateEveryNTokens = 1
+	var reported []error
+	config.OnParseError = func(err error) { reported = append(reported, err) }
+	guardrail := NewStreamingGuardrail(config)
+
+	// Break the frames the mock server sends by wrapping its handler
+	inner := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/evaluate/stream") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {not json\n\n")
+		}
+		inner.ServeHTTP(w, r)
+	})
+
+	ctx := context.Background()
+	if _, err := guardrail.StartSession(ctx, nil); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	result, err := guardrail.Evaluate(ctx, "hi", false)
+	if err != nil || result != "hi" {
+		t.Fatalf("expected the token to be allowed after the malformed frame, got %q, %v", result, err)
+	}
+	if guardrail.ParseErrors() != 1 {
+		t.Errorf("expected 1 parse error, got %d", guardrail.ParseErrors())
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], ErrParseError) || !strings.Contains(reported[0].Error(), "{not json") {
+		t.Errorf("expected OnParseError to receive the malformed frame, got %v", reported)
+	}
+}
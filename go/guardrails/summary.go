@@ -0,0 +1,63 @@
+package guardrails
+
+import "strings"
+
+// SessionViolationSummary aggregates the violations collected in a session
+type SessionViolationSummary struct {
+	TotalViolations int
+	ByPolicy        map[string]int
+	ByEnforcement   map[EnforcementLevel]int
+	BySeverity      map[string]int
+	// HighestSeverity is the most severe violation, or nil if there were none.
+	// Ties keep the earliest violation.
+	HighestSeverity *Violation
+}
+
+// severityRank orders the known severities; unknown values rank lowest
+var severityRank = map[string]int{
+	"info":     1,
+	"low":      2,
+	"medium":   3,
+	"high":     4,
+	"critical": 5,
+}
+
+// Summary aggregates the session's violations by policy, enforcement level
+// and severity
+func (s *Session) Summary() SessionViolationSummary {
+	return summarizeViolations(s.Violations)
+}
+
+// Summary aggregates the session's violations by policy, enforcement level
+// and severity
+func (s *StreamingGuardrailSession) Summary() SessionViolationSummary {
+	return summarizeViolations(s.Violations)
+}
+
+func summarizeViolations(violations []Violation) SessionViolationSummary {
+	summary := SessionViolationSummary{
+		TotalViolations: len(violations),
+		ByPolicy:        make(map[string]int),
+		ByEnforcement:   make(map[EnforcementLevel]int),
+		BySeverity:      make(map[string]int),
+	}
+
+	highestRank := -1
+	for i := range violations {
+		v := violations[i]
+		policy := v.PolicyID
+		if policy == "" {
+			policy = v.PolicyName
+		}
+		summary.ByPolicy[policy]++
+		summary.ByEnforcement[v.EnforcementLevel]++
+		summary.BySeverity[v.Severity]++
+
+		if rank := severityRank[strings.ToLower(v.Severity)]; rank > highestRank {
+			highestRank = rank
+			summary.HighestSeverity = &violations[i]
+		}
+	}
+
+	return summary
+}
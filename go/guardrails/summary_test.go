@@ -0,0 +1,46 @@
+package guardrails
+
+import "testing"
+
+func TestSessionSummary(t *testing.T) {
+	session := &Session{
+		Violations: []Violation{
+			{PolicyID: "pii", Severity: "medium", EnforcementLevel: EnforcementWarning, Message: "email"},
+			{PolicyID: "pii", Severity: "medium", EnforcementLevel: EnforcementWarning, Message: "phone"},
+			{PolicyID: "toxicity", Severity: "critical", EnforcementLevel: EnforcementBlocking, Message: "slur"},
+			{PolicyID: "tone", Severity: "low", EnforcementLevel: EnforcementAdvisory, Message: "rude"},
+		},
+	}
+
+	summary := session.Summary()
+
+	if summary.TotalViolations != 4 {
+		t.Errorf("expected 4 violations, got %d", summary.TotalViolations)
+	}
+	if summary.ByPolicy["pii"] != 2 {
+		t.Errorf("expected 2 pii violations, got %d", summary.ByPolicy["pii"])
+	}
+	if summary.ByEnforcement[EnforcementWarning] != 2 {
+		t.Errorf("expected 2 warning violations, got %d", summary.ByEnforcement[EnforcementWarning])
+	}
+	if summary.ByEnforcement[EnforcementBlocking] != 1 {
+		t.Errorf("expected 1 blocking violation, got %d", summary.ByEnforcement[EnforcementBlocking])
+	}
+	if summary.BySeverity["medium"] != 2 {
+		t.Errorf("expected 2 medium violations, got %d", summary.BySeverity["medium"])
+	}
+	if summary.HighestSeverity == nil || summary.HighestSeverity.PolicyID != "toxicity" {
+		t.Errorf("expected highest severity violation from 'toxicity', got %+v", summary.HighestSeverity)
+	}
+}
+
+func TestSessionSummaryEmpty(t *testing.T) {
+	summary := (&StreamingGuardrailSession{}).Summary()
+
+	if summary.TotalViolations != 0 {
+		t.Errorf("expected 0 violations, got %d", summary.TotalViolations)
+	}
+	if summary.HighestSeverity != nil {
+		t.Errorf("expected no highest severity violation, got %+v", summary.HighestSeverity)
+	}
+}
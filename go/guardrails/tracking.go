@@ -0,0 +1,192 @@
+package guardrails
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/diagnyxai/diagnyx-go"
+)
+
+// TrackedStreamOptions configures Client.StreamWithTracking
+type TrackedStreamOptions struct {
+	// SessionID and Input are passed to StartSession
+	SessionID string
+	Input     string
+	// Tenant overrides the configured organization and project
+	Tenant *Tenant
+	// Tracker receives the call once the session completes. Nil disables
+	// tracking.
+	Tracker diagnyx.Tracker
+	// Provider and Model identify the tracked call
+	Provider diagnyx.Provider
+	Model    string
+	// MarkLast reports whether a token is the last of the stream
+	MarkLast func(string) bool
+	// OnEvent receives every event delivered for the session, e.g. to log
+	// non-blocking violations
+	OnEvent func(Event)
+}
+
+// StreamWithTracking evaluates each token from tokens with EvaluateToken,
+// forwards allowed tokens to the returned channel and, once tokens is
+// closed, completes the session and tracks an LLMCall with the token total
+// and latency from its SessionCompleteEvent. An early termination ends the
+// stream with a *ViolationError and the call is tracked as
+// diagnyx.StatusFiltered; other evaluation errors end it with that error and
+// the call is tracked as diagnyx.StatusError. If ctx is canceled the
+// session is canceled and nothing is tracked. tokens is not read after the
+// stream ends, so producers should send in a select on ctx.
+//
+// Unlike StreamWithGuardrails, which buffers tokens in a StreamingGuardrail
+// and evaluates them in chunks, every token is a separate request on the
+// event-channel API, so callers see each event through OnEvent and the
+// session's state through GetSession, at the cost of one request per token.
+func (c *Client) StreamWithTracking(ctx context.Context, tokens <-chan string, opts TrackedStreamOptions) (<-chan string, <-chan error) {
+	results := make(chan string, 10)
+	errors := make(chan error, 1)
+
+	go func() {
+		defer close(results)
+		defer close(errors)
+
+		if err := c.streamWithTracking(ctx, tokens, opts, results); err != nil {
+			errors <- err
+		}
+	}()
+
+	return results, errors
+}
+
+// streamWithTracking runs a StreamWithTracking session, sending allowed
+// tokens to results
+func (c *Client) streamWithTracking(ctx context.Context, tokens <-chan string, opts TrackedStreamOptions, results chan<- string) error {
+	var tenant []Tenant
+	if opts.Tenant != nil {
+		tenant = append(tenant, *opts.Tenant)
+	}
+
+	start := time.Now()
+	started, err := c.StartSession(ctx, opts.SessionID, opts.Input, tenant...)
+	if err != nil {
+		return err
+	}
+	sessionID := started.SessionID
+
+	streamErr := c.evaluateStream(ctx, sessionID, tokens, opts, results)
+	if ctx.Err() != nil {
+		// ctx is done, but the cancel must still reach the server and free
+		// the session's slot
+		if _, err := c.CancelSession(context.WithoutCancel(ctx), sessionID); err != nil {
+			c.log(fmt.Sprintf("Failed to cancel session %s: %v", sessionID, err))
+			c.mu.Lock()
+			c.removeSessionLocked(sessionID)
+			c.mu.Unlock()
+		}
+		return ctx.Err()
+	}
+
+	call := diagnyx.LLMCall{
+		Provider:    opts.Provider,
+		Model:       opts.Model,
+		InputTokens: diagnyx.EstimateTokens(opts.Input),
+		LatencyMs:   time.Since(start).Milliseconds(),
+		Status:      diagnyx.StatusSuccess,
+		Timestamp:   start.UTC(),
+	}
+	if session := c.GetSession(sessionID); session != nil {
+		call.OutputTokens = session.TokensProcessed
+	}
+
+	events, err := c.CompleteSession(ctx, sessionID)
+	if err != nil {
+		c.log(fmt.Sprintf("Failed to complete session %s: %v", sessionID, err))
+	} else {
+		for event := range events {
+			c.deliver(opts, event)
+			if complete, ok := event.(*SessionCompleteEvent); ok {
+				call.OutputTokens = complete.TotalTokens
+				if complete.LatencyMs > 0 {
+					call.LatencyMs = int64(complete.LatencyMs)
+				}
+				call.Metadata = map[string]interface{}{"guardrail_violations": complete.TotalViolations}
+				if !complete.Allowed {
+					call.Status = diagnyx.StatusFiltered
+				}
+			}
+		}
+	}
+
+	if streamErr != nil {
+		call.Status = diagnyx.StatusError
+		var violation *ViolationError
+		if errors.As(streamErr, &violation) {
+			call.Status = diagnyx.StatusFiltered
+		}
+		call.ErrorMessage = streamErr.Error()
+	}
+	if opts.Tracker != nil {
+		opts.Tracker.Track(call)
+	}
+	return streamErr
+}
+
+// evaluateStream evaluates tokens until the channel closes, the session is
+// terminated or an evaluation fails
+func (c *Client) evaluateStream(ctx context.Context, sessionID string, tokens <-chan string, opts TrackedStreamOptions, results chan<- string) error {
+	for {
+		var token string
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case token, ok = <-tokens:
+		}
+		if !ok {
+			return nil
+		}
+
+		isLast := opts.MarkLast != nil && opts.MarkLast(token)
+		events, err := c.EvaluateToken(ctx, sessionID, token, nil, isLast)
+		if err != nil {
+			return err
+		}
+		for event := range events {
+			c.deliver(opts, event)
+			switch e := event.(type) {
+			case *EarlyTerminationEvent:
+				err = terminationError(e)
+			case *ErrorEvent:
+				err = e.Err()
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case results <- token:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if isLast {
+			return nil
+		}
+	}
+}
+
+// deliver passes an event to opts.OnEvent
+func (c *Client) deliver(opts TrackedStreamOptions, event Event) {
+	if opts.OnEvent != nil {
+		runCallback(c.log, "OnEvent", func() { opts.OnEvent(event) })
+	}
+}
+
+// terminationError returns the ViolationError for an early termination
+func terminationError(e *EarlyTerminationEvent) error {
+	if e.BlockingViolation != nil {
+		return &ViolationError{Violation: e.BlockingViolation.ToViolation()}
+	}
+	return &ViolationError{Violation: Violation{Message: e.Reason}}
+}
@@ -0,0 +1,133 @@
+package guardrails
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/diagnyxai/diagnyx-go"
+)
+
+func TestStreamWithTracking(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/organizations/org-1/guardrails/evaluate/stream/start", func(w http.ResponseWriter, r *http.Request) {
+		var req StartSessionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": req.SessionID})
+	})
+	mux.HandleFunc("/api/v1/organizations/org-1/guardrails/evaluate/stream", func(w http.ResponseWriter, r *http.Request) {
+		var req EvaluateTokenRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "text/event-stream")
+		if req.Token == "secret" {
+			fmt.Fprintf(w, "data: {\"type\":\"early_termination\",\"sessionId\":%q,\"reason\":\"pii\",\"tokensProcessed\":1,\"blockingViolation\":{\"policyType\":\"pii_detection\",\"message\":\"PII found\"}}\n\n", req.SessionID)
+			return
+		}
+		fmt.Fprintf(w, "data: {\"type\":\"token_allowed\",\"sessionId\":%q}\n\n", req.SessionID)
+	})
+	mux.HandleFunc("/api/v1/organizations/org-1/guardrails/evaluate/stream/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"type\":\"session_complete\",\"totalTokens\":2,\"totalViolations\":0,\"allowed\":true,\"latencyMs\":42}\n\n")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tracker := diagnyx.NewClientWithConfig(diagnyx.Config{APIKey: "test-key", FlushIntervalMs: 60000})
+	defer tracker.Close()
+	defer tracker.ClearBuffer()
+
+	client := NewClient(Config{APIKey: "test-key", OrganizationID: "org-1", BaseURL: server.URL})
+	stream := func(sessionID string, input ...string) (string, error) {
+		tokens := make(chan string, len(input))
+		for _, token := range input {
+			tokens <- token
+		}
+		close(tokens)
+
+		results, errs := client.StreamWithTracking(context.Background(), tokens, TrackedStreamOptions{
+			SessionID: sessionID,
+			Tracker:   tracker,
+			Provider:  diagnyx.ProviderOpenAI,
+			Model:     "gpt-4",
+			// A panicking OnEvent must not break the stream
+			OnEvent: func(Event) { panic("event hook bug") },
+		})
+		var output strings.Builder
+		for token := range results {
+			output.WriteString(token)
+		}
+		return output.String(), <-errs
+	}
+
+	output, err := stream("sess-1", "Hello", " world")
+	if err != nil || output != "Hello world" {
+		t.Fatalf("expected all tokens to be forwarded, got %q, %v", output, err)
+	}
+
+	output, err = stream("sess-2", "My", "secret", "is")
+	var violation *ViolationError
+	if !errors.As(err, &violation) || violation.Violation.PolicyType != "pii_detection" {
+		t.Fatalf("expected a ViolationError for the early termination, got %v", err)
+	}
+	if output != "My" {
+		t.Errorf("expected only the tokens before the termination, got %q", output)
+	}
+
+	calls := tracker.PeekBuffer()
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 tracked calls, got %d", len(calls))
+	}
+	if calls[0].Status != diagnyx.StatusSuccess || calls[0].OutputTokens != 2 || calls[0].LatencyMs != 42 {
+		t.Errorf("expected a successful call with the session totals, got %+v", calls[0])
+	}
+	if calls[1].Status != diagnyx.StatusFiltered {
+		t.Errorf("expected the terminated call to be filtered, got '%s'", calls[1].Status)
+	}
+}
+
+func TestStreamWithTrackingCancel(t *testing.T) {
+	var cancels atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete:
+			cancels.Add(1)
+			json.NewEncoder(w).Encode(map[string]bool{"cancelled": true})
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "session_started", "sessionId": "sess-1"})
+		default:
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"type\":\"token_allowed\",\"sessionId\":\"sess-1\"}\n\n")
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(Config{APIKey: "test-key", OrganizationID: "org-1", BaseURL: server.URL, MaxConcurrentSessions: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	tokens := make(chan string)
+	results, errs := client.StreamWithTracking(ctx, tokens, TrackedStreamOptions{})
+
+	tokens <- "hello"
+	<-results
+	cancel()
+	for range results {
+	}
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if cancels.Load() != 1 {
+		t.Errorf("expected the session to be canceled on the server, got %d cancels", cancels.Load())
+	}
+	if active := client.ActiveSessions(); len(active) != 0 {
+		t.Errorf("expected no active sessions, got %v", active)
+	}
+	if client.slots.Active() != 0 {
+		t.Errorf("expected the session's slot to be freed, got %d active", client.slots.Active())
+	}
+}
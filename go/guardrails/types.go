@@ -1,16 +1,22 @@
 // Package guardrails provides streaming guardrails for LLM responses
 package guardrails
 
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
 // EventType represents the type of streaming evaluation event
 type EventType string
 
 const (
-	EventSessionStarted   EventType = "session_started"
-	EventTokenAllowed     EventType = "token_allowed"
+	EventSessionStarted    EventType = "session_started"
+	EventTokenAllowed      EventType = "token_allowed"
 	EventViolationDetected EventType = "violation_detected"
-	EventEarlyTermination EventType = "early_termination"
-	EventSessionComplete  EventType = "session_complete"
-	EventError            EventType = "error"
+	EventEarlyTermination  EventType = "early_termination"
+	EventSessionComplete   EventType = "session_complete"
+	EventError             EventType = "error"
 )
 
 // EnforcementLevel represents the policy enforcement level
@@ -22,6 +28,21 @@ const (
 	EnforcementBlocking EnforcementLevel = "blocking"
 )
 
+// DuplicateSessionPolicy selects what StartSession does when the session ID
+// is already active
+type DuplicateSessionPolicy string
+
+const (
+	// DuplicateSessionReplace starts a new session, discarding the active
+	// one's local state
+	DuplicateSessionReplace DuplicateSessionPolicy = "replace"
+	// DuplicateSessionError returns ErrDuplicateSession
+	DuplicateSessionError DuplicateSessionPolicy = "error"
+	// DuplicateSessionResume keeps the active session and returns its
+	// started event without contacting the server
+	DuplicateSessionResume DuplicateSessionPolicy = "resume"
+)
+
 // Event is the base streaming event interface
 type Event interface {
 	GetType() EventType
@@ -36,9 +57,9 @@ type BaseEvent struct {
 	Timestamp int64     `json:"timestamp"`
 }
 
-func (e BaseEvent) GetType() EventType    { return e.Type }
-func (e BaseEvent) GetSessionID() string  { return e.SessionID }
-func (e BaseEvent) GetTimestamp() int64   { return e.Timestamp }
+func (e BaseEvent) GetType() EventType   { return e.Type }
+func (e BaseEvent) GetSessionID() string { return e.SessionID }
+func (e BaseEvent) GetTimestamp() int64  { return e.Timestamp }
 
 // SessionStartedEvent is emitted when a streaming session starts
 type SessionStartedEvent struct {
@@ -51,6 +72,8 @@ type TokenAllowedEvent struct {
 	BaseEvent
 	TokenIndex        int `json:"tokenIndex"`
 	AccumulatedLength int `json:"accumulatedLength"`
+	// Token is the allowed token, as sent to EvaluateToken
+	Token string `json:"token,omitempty"`
 }
 
 // Violation represents a guardrail violation
@@ -131,6 +154,109 @@ type Session struct {
 	Terminated        bool
 	TerminationReason string
 	Allowed           bool
+
+	// slot is whether the session holds a MaxConcurrentSessions slot
+	slot bool
+	// audited is whether the session's decision went to Config.AuditLogger
+	audited bool
+	// acquired is whether the session is in use from AcquireSession, under
+	// poolKey
+	acquired bool
+	poolKey  string
+	// lastEventID is the ID of the most recent SSE event received for the
+	// session, sent as Last-Event-ID when a stream reconnects
+	lastEventID string
+	// events is a ring of the most recent events delivered for the session
+	events     []Event
+	eventsNext int
+	eventsFull bool
+	mu         sync.Mutex
+}
+
+// ReconstructOutput rebuilds the text delivered for the session from the
+// TokenAllowedEvents in its replay buffer, up to any early termination.
+// It requires event recording (Config.EventBufferSize must not be
+// negative) and a buffer large enough for every event of the stream: once
+// the buffer wraps, the oldest tokens are missing from the output.
+func (s *Session) ReconstructOutput() string {
+	var output strings.Builder
+	for _, event := range s.recentEvents() {
+		switch e := event.(type) {
+		case *TokenAllowedEvent:
+			output.WriteString(e.Token)
+		case *EarlyTerminationEvent:
+			return output.String()
+		}
+	}
+	return output.String()
+}
+
+// markAcquired records that the session is in use from the pool under key
+func (s *Session) markAcquired(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acquired = true
+	s.poolKey = key
+}
+
+// markReleased records that the session is no longer in use and returns
+// its pool key, or false if it was not acquired
+func (s *Session) markReleased() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.acquired {
+		return "", false
+	}
+	s.acquired = false
+	return s.poolKey, true
+}
+
+// LastEventID returns the ID of the most recent event received for the session
+func (s *Session) LastEventID() string {
+	return s.getLastEventID()
+}
+
+func (s *Session) getLastEventID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastEventID
+}
+
+func (s *Session) setLastEventID(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastEventID = id
+}
+
+// recordEvent adds an event to the session's replay ring, overwriting the
+// oldest event once size events are held
+func (s *Session) recordEvent(event Event, size int) {
+	if size <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.events == nil {
+		s.events = make([]Event, size)
+	}
+	s.events[s.eventsNext] = event
+	s.eventsNext++
+	if s.eventsNext == len(s.events) {
+		s.eventsNext = 0
+		s.eventsFull = true
+	}
+}
+
+// recentEvents returns the events in the replay ring, oldest first
+func (s *Session) recentEvents() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.eventsFull {
+		return append([]Event(nil), s.events[:s.eventsNext]...)
+	}
+	events := make([]Event, 0, len(s.events))
+	events = append(events, s.events[s.eventsNext:]...)
+	return append(events, s.events[:s.eventsNext]...)
 }
 
 // Config holds configuration for the StreamingGuardrails client
@@ -143,6 +269,67 @@ type Config struct {
 	EvaluateEveryNTokens   int
 	EnableEarlyTermination bool
 	Debug                  bool
+	// StreamIdleTimeout tears down an event stream when no SSE line (including
+	// keepalive comments) arrives within the duration, emitting an ErrorEvent
+	// with code STREAM_IDLE. Zero disables idle detection.
+	StreamIdleTimeout time.Duration
+	// MaxReconnects is how many times EvaluateToken reopens a stream that
+	// drops before its final event. Reconnects send the last received event
+	// ID as Last-Event-ID so the server can resume; events already delivered
+	// are skipped if the server replays them. Default: 0 (no reconnect)
+	MaxReconnects int
+	// EventBufferSize is how many recent events are kept per session for
+	// SessionEvents. Negative disables the replay buffer.
+	// Default: 100
+	EventBufferSize int
+	// DryRun skips all HTTP requests: sessions are started locally and
+	// every token is reported as allowed. Default: false
+	DryRun bool
+	// UserAgent is appended to the SDK's own User-Agent, e.g. "my-app/1.2".
+	// Default: "" (only "diagnyx-go/<Version> (go<version>)" is sent)
+	UserAgent string
+	// AuthHeader and AuthScheme select how the API key is sent, for
+	// gateways with their own auth conventions; see diagnyx.SetAuthHeader.
+	// Default: "" ("Authorization: Bearer <key>")
+	AuthHeader string
+	AuthScheme string
+	// MaxEvaluationsPerSecond caps EvaluateToken requests, including
+	// reconnects, with a token bucket allowing bursts of one second's worth.
+	// Callers block until a request is allowed or their context is done.
+	// Default: 0 (unlimited)
+	MaxEvaluationsPerSecond float64
+	// OnRateLimitWait is called with how long an evaluation was held back
+	// by MaxEvaluationsPerSecond
+	OnRateLimitWait func(wait time.Duration)
+	// OnDuplicateSession selects what StartSession does with a session ID
+	// that is already active, e.g. on a retried start.
+	// Default: DuplicateSessionReplace
+	OnDuplicateSession DuplicateSessionPolicy
+	// MaxConcurrentSessions bounds the sessions this client keeps open at
+	// once. StartSession waits for a session to complete or be canceled
+	// while the client is at capacity. Dry-run sessions are not counted.
+	// Default: 0 (unlimited)
+	MaxConcurrentSessions int
+	// SessionWaitTimeout is how long StartSession waits for a free slot
+	// under MaxConcurrentSessions before failing with ErrAtCapacity.
+	// Default: 0 (until the context is done)
+	SessionWaitTimeout time.Duration
+	// AuditLogger receives an AuditRecord for every guardrail decision, once
+	// per session: when EvaluateToken or CompleteSession delivers its
+	// early_termination or session_complete event. It is called
+	// synchronously on the event stream, so it should hand the record to a
+	// durable sink quickly. A panic is recovered and logged.
+	// Default: nil (no audit records)
+	AuditLogger func(record AuditRecord)
+	// MaxPooledSessions bounds the idle sessions ReleaseSession pre-warms
+	// for AcquireSession, across all keys. Negative disables pooling.
+	// Default: 10
+	MaxPooledSessions int
+	// PooledSessionTTL is how long a session may sit idle in the pool before
+	// it is completed instead of reused. Keep it below the server's session
+	// expiry. Negative keeps idle sessions indefinitely.
+	// Default: 1 minute
+	PooledSessionTTL time.Duration
 }
 
 // DefaultConfig returns a Config with default values
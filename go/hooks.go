@@ -0,0 +1,51 @@
+package diagnyx
+
+import "fmt"
+
+// CallbackPanicError is passed to Config.OnError, with no calls, when a
+// user-supplied callback panics. Callbacks should not panic, but the client
+// recovers so a faulty hook cannot crash the process: a Sampler keeps the
+// call, a ModelNormalizer leaves the model unchanged, a BeforeSend aborts
+// the send as if it had returned an error, a MetadataSink keeps the metadata
+// inline and a RetryPolicy stops retrying. A panicking ContentStore or Codec
+// is treated as a store or marshal error, and a panicking AuditWriter,
+// OnDrop or OnError is only logged. The guardrails package recovers its
+// callbacks the same way and logs the panic.
+type CallbackPanicError struct {
+	// Callback names the Config or options field that panicked
+	Callback string
+	// Value is the value passed to panic
+	Value interface{}
+}
+
+func (e *CallbackPanicError) Error() string {
+	return fmt.Sprintf("diagnyx: %s panicked: %v", e.Callback, e.Value)
+}
+
+// runCallback calls fn, recovering a panic into a *CallbackPanicError that
+// is reported to Config.OnError and returned
+func (c *Client) runCallback(name string, fn func()) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &CallbackPanicError{Callback: name, Value: v}
+			c.log("%v", err)
+			c.reportError(err, nil)
+		}
+	}()
+	fn()
+	return nil
+}
+
+// reportError passes err and calls to Config.OnError, recovering and
+// logging a panic in OnError itself
+func (c *Client) reportError(err error, calls []LLMCall) {
+	if c.config.OnError == nil {
+		return
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			c.log("OnError panicked: %v", v)
+		}
+	}()
+	c.config.OnError(err, calls)
+}
@@ -0,0 +1,109 @@
+package diagnyx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+type panickingStore struct{}
+
+func (panickingStore) Put(context.Context, string) (string, error) {
+	panic("store down")
+}
+
+func TestCallbackPanics(t *testing.T) {
+	var mu sync.Mutex
+	var panicked []string
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		FlushIntervalMs: 60000,
+		DryRun:          true,
+		Sampler:         SamplerFunc(func(LLMCall) bool { panic("sampler bug") }),
+		ModelNormalizer: func(Provider, string) string { panic("normalizer bug") },
+		OnFlush:         func(BatchRequest) { panic("flush hook bug") },
+		OnError: func(err error, calls []LLMCall) {
+			var panicErr *CallbackPanicError
+			if errors.As(err, &panicErr) {
+				mu.Lock()
+				panicked = append(panicked, panicErr.Callback)
+				mu.Unlock()
+			}
+			panic("OnError bug")
+		},
+	})
+	defer client.Close()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"})
+	if calls := client.PeekBuffer(); len(calls) != 1 || calls[0].Model != "gpt-4" {
+		t.Fatalf("expected the call to be kept with its model unchanged, got %v", calls)
+	}
+	if err := client.Flush(); err != nil {
+		t.Errorf("expected a panicking OnFlush not to fail the flush, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(panicked) != 3 || panicked[0] != "Sampler" || panicked[1] != "ModelNormalizer" || panicked[2] != "OnFlush" {
+		t.Errorf("expected each panic to be reported to OnError, got %v", panicked)
+	}
+
+	var call LLMCall
+	call.SetPrompt(context.Background(), Config{ContentStore: panickingStore{}}, "hello")
+	if call.Metadata["content_store_error"] == nil || call.PromptRef != "" {
+		t.Errorf("expected a panicking ContentStore to be recorded as a store error, got %+v", call)
+	}
+}
+
+func TestBeforeSendPanic(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		BeforeSend:      func(*BatchRequest) error { panic("redaction bug") },
+	})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	_, err := client.TrackAndFlush(context.Background(), LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"})
+	var panicErr *CallbackPanicError
+	if !errors.As(err, &panicErr) || panicErr.Callback != "BeforeSend" {
+		t.Errorf("expected the send to be aborted with a CallbackPanicError, got %v", err)
+	}
+	if server.RequestCount != 0 {
+		t.Errorf("expected nothing to be sent, got %d requests", server.RequestCount)
+	}
+}
+
+type panickingCodec struct{}
+
+func (panickingCodec) ContentType() string { return "application/json" }
+
+func (panickingCodec) Marshal(interface{}) ([]byte, error) { panic("codec bug") }
+
+func TestCodecPanic(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		Codec:           panickingCodec{},
+	})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	_, err := client.TrackAndFlush(context.Background(), LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"})
+	var panicErr *CallbackPanicError
+	if !errors.As(err, &panicErr) || panicErr.Callback != "Codec" {
+		t.Errorf("expected the send to fail with a CallbackPanicError, got %v", err)
+	}
+	if server.RequestCount != 0 {
+		t.Errorf("expected nothing to be sent, got %d requests", server.RequestCount)
+	}
+}
@@ -0,0 +1,133 @@
+package diagnyx
+
+import "sync/atomic"
+
+// Hub shares one Client, and with it one buffer, flush loop and connection
+// pool, between several subsystems. Each subsystem tracks through its own
+// Tracer, which stamps the subsystem's default TrackOptions onto its calls.
+//
+// Example:
+//
+//	hub := diagnyx.NewHub(diagnyx.DefaultConfig(apiKey))
+//	defer hub.Close()
+//
+//	search := hub.Tracer(diagnyx.TrackOptions{ProjectID: "search"})
+//	search.Track(call)
+type Hub struct {
+	client *Client
+}
+
+// NewHub creates a Hub backed by a new Client with the given configuration
+func NewHub(config Config) *Hub {
+	return &Hub{client: NewClientWithConfig(config)}
+}
+
+// Client returns the shared Client, for use with the wrappers
+func (h *Hub) Client() *Client {
+	return h.client
+}
+
+// Tracer returns a Tracer that fills empty call fields from opts before
+// forwarding calls to the hub. opts.SpanID is the subsystem's span: each
+// call gets a new span under it rather than sharing it.
+func (h *Hub) Tracer(opts TrackOptions) *Tracer {
+	return &Tracer{hub: h, opts: opts}
+}
+
+// Flush sends all calls buffered by the hub's tracers
+func (h *Hub) Flush() error {
+	return h.client.Flush()
+}
+
+// Close flushes remaining calls and shuts down the shared Client. Tracers
+// must not be used afterwards.
+func (h *Hub) Close() error {
+	return h.client.Close()
+}
+
+// Tracer is a lightweight handle for tracking calls through a Hub
+type Tracer struct {
+	hub    *Hub
+	opts   TrackOptions
+	closed atomic.Bool
+}
+
+// Track stamps the tracer's defaults onto call and records it in the hub.
+// After Close the call is passed to Config.OnError with ErrClientClosed.
+func (t *Tracer) Track(call LLMCall) {
+	if t.closed.Load() {
+		t.hub.client.deadLetter(ErrClientClosed, []LLMCall{call})
+		return
+	}
+	t.hub.client.Track(t.apply(call))
+}
+
+// TrackCalls stamps the tracer's defaults onto calls and records them
+func (t *Tracer) TrackCalls(calls []LLMCall) {
+	if t.closed.Load() {
+		t.hub.client.deadLetter(ErrClientClosed, calls)
+		return
+	}
+	stamped := make([]LLMCall, len(calls))
+	for i, call := range calls {
+		stamped[i] = t.apply(call)
+	}
+	t.hub.client.TrackCalls(stamped)
+}
+
+// Close detaches the tracer from the hub. Its calls already tracked stay in
+// the shared buffer and are sent by the hub; the hub keeps running.
+func (t *Tracer) Close() error {
+	t.closed.Store(true)
+	return nil
+}
+
+// apply fills the fields of call left empty from the tracer's options.
+// Each call in a trace gets its own span, a child of opts.SpanID if set or
+// else of opts.ParentSpanID; calls without a trace or span are left for
+// Config.AutoGenerateTraceID. Metadata is merged, with the call's own keys
+// taking precedence.
+func (t *Tracer) apply(call LLMCall) LLMCall {
+	opts := t.opts
+	if call.ProjectID == "" {
+		call.ProjectID = opts.ProjectID
+	}
+	if call.Environment == "" {
+		call.Environment = opts.Environment
+	}
+	if call.UserIdentifier == "" {
+		call.UserIdentifier = opts.UserIdentifier
+	}
+	if call.TraceID == "" {
+		call.TraceID = opts.TraceID
+	}
+	if call.SpanID == "" && (call.TraceID != "" || opts.SpanID != "") {
+		call.SpanID = NewSpanID()
+	}
+	if call.ParentSpanID == "" {
+		call.ParentSpanID = opts.ParentSpanID
+		if opts.SpanID != "" && opts.SpanID != call.SpanID {
+			call.ParentSpanID = opts.SpanID
+		}
+	}
+	if call.Endpoint == "" {
+		call.Endpoint = opts.Endpoint
+	}
+	if call.ExperimentID == "" {
+		call.ExperimentID = opts.ExperimentID
+	}
+	if call.Variant == "" {
+		call.Variant = opts.Variant
+	}
+	if len(opts.Metadata) > 0 {
+		merged := make(map[string]interface{}, len(opts.Metadata)+len(call.Metadata))
+		for k, v := range opts.Metadata {
+			merged[k] = v
+		}
+		for k, v := range call.Metadata {
+			merged[k] = v
+		}
+		call.Metadata = merged
+	}
+	return call
+}
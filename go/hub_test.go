@@ -0,0 +1,81 @@
+package diagnyx
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHubTracers(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	var dropErr error
+	hub := NewHub(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		OnError:         func(err error, calls []LLMCall) { dropErr = err },
+	})
+	defer hub.Close()
+
+	search := hub.Tracer(TrackOptions{ProjectID: "search", Metadata: map[string]interface{}{"team": "search", "tier": "free"}})
+	billing := hub.Tracer(TrackOptions{ProjectID: "billing", Environment: "prod"})
+
+	search.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, Metadata: map[string]interface{}{"tier": "pro"}})
+	billing.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, ProjectID: "override"})
+
+	if err := hub.Flush(); err != nil {
+		t.Fatalf("unexpected flush error: %v", err)
+	}
+
+	calls := server.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("expected both tracers' calls in one batch, got %d", len(calls))
+	}
+	if calls[0].ProjectID != "search" || calls[0].Metadata["team"] != "search" || calls[0].Metadata["tier"] != "pro" {
+		t.Errorf("expected search defaults merged under call values, got %+v", calls[0])
+	}
+	if calls[1].ProjectID != "override" || calls[1].Environment != "prod" {
+		t.Errorf("expected call fields to take precedence over defaults, got %+v", calls[1])
+	}
+
+	search.Close()
+	search.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	if !errors.Is(dropErr, ErrClientClosed) {
+		t.Errorf("expected ErrClientClosed for a closed tracer, got %v", dropErr)
+	}
+	billing.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	if hub.Client().BufferSize() != 1 {
+		t.Errorf("expected other tracers to keep working, got buffer size %d", hub.Client().BufferSize())
+	}
+}
+
+func TestTracerSpans(t *testing.T) {
+	hub := NewHub(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+	defer hub.Close()
+	client := hub.Client()
+	defer client.ClearBuffer()
+
+	tracer := hub.Tracer(TrackOptions{TraceID: "trace-1", SpanID: "agent-span"})
+	tracer.TrackCalls([]LLMCall{
+		{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess},
+		{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess},
+		{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, SpanID: "own-span", ParentSpanID: "other"},
+	})
+
+	calls := client.PeekBuffer()
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 buffered calls, got %d", len(calls))
+	}
+	for _, call := range calls[:2] {
+		if call.TraceID != "trace-1" || call.SpanID == "" || call.SpanID == "agent-span" || call.ParentSpanID != "agent-span" {
+			t.Errorf("expected a new span under the tracer's span, got %+v", call)
+		}
+	}
+	if calls[0].SpanID == calls[1].SpanID {
+		t.Error("expected each call to get its own span")
+	}
+	if calls[2].SpanID != "own-span" || calls[2].ParentSpanID != "other" {
+		t.Errorf("expected the call's own span to be kept, got %+v", calls[2])
+	}
+}
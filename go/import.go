@@ -0,0 +1,188 @@
+package diagnyx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ImportOptions configures ImportCallsWithOptions
+type ImportOptions struct {
+	// Concurrency is the number of chunks uploaded in parallel, capped at
+	// Config.MaxIdleConnsPerHost so every upload can reuse a kept-alive
+	// connection; raise both for more parallelism. There is no limit on
+	// requests across the client, so flushes and other imports run
+	// alongside. Default: 1
+	Concurrency int
+	// OnProgress is called after each chunk finishes, successfully or not,
+	// with the number of finished chunks and the total. Calls may come from
+	// several goroutines but are never concurrent.
+	OnProgress func(done, total int)
+	// Timeout bounds the whole import. Each request is still limited by
+	// the client's per-request timeout. Default: 0 (no bound beyond ctx)
+	Timeout time.Duration
+}
+
+// ImportChunkError describes a chunk of calls that failed to import
+type ImportChunkError struct {
+	// Start and End are the indexes of the chunk's first and last call
+	Start, End int
+	// Indexes lists the calls of the chunk that failed when only some of
+	// its endpoints failed (see Config.ProjectRouting); the others were
+	// imported. Nil means the whole chunk failed.
+	Indexes []int
+	Err     error
+}
+
+// ImportError is returned when some chunks of an import failed. Retry them
+// by importing calls[Start:End+1] of each failed chunk, or just the calls
+// in Indexes when it is set.
+type ImportError struct {
+	// Failed lists the failed chunks in call order
+	Failed []ImportChunkError
+}
+
+func (e *ImportError) Error() string {
+	parts := make([]string, len(e.Failed))
+	for i, chunk := range e.Failed {
+		if chunk.Indexes != nil {
+			parts[i] = fmt.Sprintf("%d of calls %d-%d: %v", len(chunk.Indexes), chunk.Start, chunk.End, chunk.Err)
+			continue
+		}
+		parts[i] = fmt.Sprintf("calls %d-%d: %v", chunk.Start, chunk.End, chunk.Err)
+	}
+	return fmt.Sprintf("failed to import %d chunks: %s", len(e.Failed), strings.Join(parts, "; "))
+}
+
+// Unwrap returns the errors of the failed chunks
+func (e *ImportError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, chunk := range e.Failed {
+		errs[i] = chunk.Err
+	}
+	return errs
+}
+
+// ImportCalls sends historical calls synchronously, one chunk at a time.
+// See ImportCallsWithOptions.
+func (c *Client) ImportCalls(ctx context.Context, calls []LLMCall) (*BatchResponse, error) {
+	return c.ImportCallsWithOptions(ctx, calls, ImportOptions{})
+}
+
+// ImportCallsWithOptions sends historical calls synchronously, bypassing the
+// buffer. Every call must carry its own non-zero Timestamp; nothing is
+// substituted. Calls are sent in chunks of BatchSize with an X-Import
+// header, up to opts.Concurrency chunks at a time, and the per-chunk server
+// results are summed in call order. If some chunks fail, the others are
+// still sent; the returned response covers the accepted calls and the
+// error is an *ImportError listing the failed ones.
+func (c *Client) ImportCallsWithOptions(ctx context.Context, calls []LLMCall, opts ImportOptions) (*BatchResponse, error) {
+	for i := range calls {
+		if calls[i].Timestamp.IsZero() {
+			return nil, fmt.Errorf("call %d has no timestamp", i)
+		}
+		if err := c.validateCall(calls[i]); err != nil {
+			return nil, fmt.Errorf("call %d: %w", i, err)
+		}
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if concurrency > c.config.MaxIdleConnsPerHost {
+		c.log("Import concurrency %d capped at MaxIdleConnsPerHost %d", concurrency, c.config.MaxIdleConnsPerHost)
+		concurrency = c.config.MaxIdleConnsPerHost
+	}
+
+	header := http.Header{}
+	header.Set("X-Import", "true")
+
+	total := (len(calls) + c.config.BatchSize - 1) / c.config.BatchSize
+	responses := make([]*BatchResponse, total)
+	errs := make([]error, total)
+
+	var mu sync.Mutex
+	done := 0
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for chunk := 0; chunk < total; chunk++ {
+		start, end := c.importChunk(chunk, len(calls))
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(chunk, start, end int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// Copy the chunk: sending records its batch on the calls
+			chunkCalls := append([]LLMCall(nil), calls[start:end]...)
+			responses[chunk], errs[chunk] = c.send(ctx, chunkCalls, header)
+			if errs[chunk] == nil {
+				c.log("Imported %d calls", end-start)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			if opts.OnProgress != nil {
+				opts.OnProgress(done, total)
+			}
+		}(chunk, start, end)
+	}
+	wg.Wait()
+
+	result := &BatchResponse{}
+	importErr := &ImportError{}
+	for chunk, resp := range responses {
+		start, end := c.importChunk(chunk, len(calls))
+		if errs[chunk] != nil {
+			chunkErr := ImportChunkError{Start: start, End: end - 1, Err: errs[chunk]}
+			var routeErr *routeError
+			if !errors.As(errs[chunk], &routeErr) {
+				importErr.Failed = append(importErr.Failed, chunkErr)
+				continue
+			}
+			// Other endpoints took their calls; count those
+			for _, i := range routeErr.undelivered {
+				chunkErr.Indexes = append(chunkErr.Indexes, start+i)
+			}
+			importErr.Failed = append(importErr.Failed, chunkErr)
+			resp = routeErr.delivered
+		}
+
+		result.Tracked += resp.Tracked
+		result.TotalCost += resp.TotalCost
+		result.TotalTokens += resp.TotalTokens
+		result.IDs = append(result.IDs, resp.IDs...)
+		for _, callErr := range resp.Errors {
+			callErr.Index += start
+			result.Errors = append(result.Errors, callErr)
+		}
+	}
+
+	if len(importErr.Failed) > 0 {
+		return result, importErr
+	}
+	return result, nil
+}
+
+// importChunk returns the bounds of the chunk-th chunk of n calls
+func (c *Client) importChunk(chunk, n int) (start, end int) {
+	start = chunk * c.config.BatchSize
+	end = start + c.config.BatchSize
+	if end > n {
+		end = n
+	}
+	return start, end
+}
@@ -0,0 +1,256 @@
+package diagnyx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestImportCalls(t *testing.T) {
+	t.Run("sends chunks with import header", func(t *testing.T) {
+		var mu sync.Mutex
+		var batches []BatchRequest
+		var importHeaders []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req BatchRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			batches = append(batches, req)
+			importHeaders = append(importHeaders, r.Header.Get("X-Import"))
+			mu.Unlock()
+			json.NewEncoder(w).Encode(BatchResponse{Tracked: len(req.Calls), TotalTokens: 10 * len(req.Calls)})
+		}))
+		defer server.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         server.URL,
+			BatchSize:       2,
+			FlushIntervalMs: 60000,
+		})
+		defer client.Close()
+
+		ts := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+		calls := make([]LLMCall, 5)
+		for i := range calls {
+			calls[i] = LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, Timestamp: ts.Add(time.Duration(i) * time.Minute)}
+		}
+
+		resp, err := client.ImportCalls(context.Background(), calls)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.Tracked != 5 {
+			t.Errorf("expected tracked 5, got %d", resp.Tracked)
+		}
+		if resp.TotalTokens != 50 {
+			t.Errorf("expected total tokens 50, got %d", resp.TotalTokens)
+		}
+		if len(batches) != 3 {
+			t.Fatalf("expected 3 batches, got %d", len(batches))
+		}
+		for _, h := range importHeaders {
+			if h != "true" {
+				t.Errorf("expected X-Import 'true', got '%s'", h)
+			}
+		}
+		if !batches[0].Calls[0].Timestamp.Equal(ts) {
+			t.Errorf("expected timestamp %v to be preserved, got %v", ts, batches[0].Calls[0].Timestamp)
+		}
+		if client.BufferSize() != 0 {
+			t.Errorf("expected buffer to be untouched, got size %d", client.BufferSize())
+		}
+	})
+
+	t.Run("rejects calls without timestamp", func(t *testing.T) {
+		server := newMockServer()
+		defer server.Close()
+
+		client := NewClientWithConfig(Config{
+			APIKey:          "test-key",
+			BaseURL:         server.URL,
+			FlushIntervalMs: 60000,
+		})
+		defer client.Close()
+
+		_, err := client.ImportCalls(context.Background(), []LLMCall{{Provider: ProviderOpenAI, Model: "gpt-4"}})
+		if err == nil {
+			t.Error("expected error for missing timestamp")
+		}
+		if server.RequestCount != 0 {
+			t.Errorf("expected no API requests, got %d", server.RequestCount)
+		}
+	})
+}
+
+func TestImportCallsWithOptions(t *testing.T) {
+	var mu sync.Mutex
+	inflight, maxInflight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req BatchRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		mu.Lock()
+		inflight++
+		if inflight > maxInflight {
+			maxInflight = inflight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inflight--
+		mu.Unlock()
+
+		if req.Calls[0].Model == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(BatchResponse{Tracked: len(req.Calls), IDs: []string{req.Calls[0].Model}})
+	}))
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		BatchSize:       2,
+		FlushIntervalMs: 60000,
+	})
+	defer client.Close()
+
+	ts := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	calls := make([]LLMCall, 8)
+	for i := range calls {
+		calls[i] = LLMCall{Provider: ProviderOpenAI, Model: fmt.Sprintf("chunk-%d", i/2), Timestamp: ts}
+	}
+	calls[4].Model = "bad"
+
+	var progress []int
+	resp, err := client.ImportCallsWithOptions(context.Background(), calls, ImportOptions{
+		Concurrency: 4,
+		OnProgress: func(done, total int) {
+			if total != 4 {
+				t.Errorf("expected 4 chunks, got %d", total)
+			}
+			progress = append(progress, done)
+		},
+	})
+
+	var importErr *ImportError
+	if !errors.As(err, &importErr) {
+		t.Fatalf("expected ImportError, got %v", err)
+	}
+	if len(importErr.Failed) != 1 || importErr.Failed[0].Start != 4 || importErr.Failed[0].End != 5 {
+		t.Errorf("expected chunk 4-5 to fail, got %+v", importErr.Failed)
+	}
+	if resp.Tracked != 6 {
+		t.Errorf("expected 6 tracked calls, got %d", resp.Tracked)
+	}
+	if fmt.Sprint(resp.IDs) != "[chunk-0 chunk-1 chunk-3]" {
+		t.Errorf("expected IDs in call order, got %v", resp.IDs)
+	}
+	if fmt.Sprint(progress) != "[1 2 3 4]" {
+		t.Errorf("expected progress 1-4, got %v", progress)
+	}
+	if maxInflight < 2 {
+		t.Errorf("expected chunks to be uploaded concurrently, max in flight %d", maxInflight)
+	}
+}
+
+func TestImportConcurrencyCap(t *testing.T) {
+	var mu sync.Mutex
+	inflight, maxInflight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inflight++
+		if inflight > maxInflight {
+			maxInflight = inflight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inflight--
+		mu.Unlock()
+		json.NewEncoder(w).Encode(BatchResponse{Tracked: 1})
+	}))
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:              "test-key",
+		BaseURL:             server.URL,
+		BatchSize:           1,
+		FlushIntervalMs:     60000,
+		MaxIdleConnsPerHost: 2,
+	})
+	defer client.Close()
+
+	calls := make([]LLMCall, 8)
+	for i := range calls {
+		calls[i] = LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Timestamp: time.Now()}
+	}
+	if _, err := client.ImportCallsWithOptions(context.Background(), calls, ImportOptions{Concurrency: 8}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxInflight != 2 {
+		t.Errorf("expected concurrency to be capped at MaxIdleConnsPerHost 2, max in flight %d", maxInflight)
+	}
+}
+
+func TestImportCallsTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{APIKey: "test-key", BaseURL: server.URL, FlushIntervalMs: 60000, MaxRetries: 1})
+	defer client.Close()
+
+	calls := []LLMCall{{Provider: ProviderOpenAI, Model: "gpt-4", Timestamp: time.Now()}}
+	_, err := client.ImportCallsWithOptions(context.Background(), calls, ImportOptions{Timeout: 20 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestImportCallsProjectRouting(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	euServer := newMockServer()
+	euServer.StatusCode = http.StatusInternalServerError
+	defer euServer.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		MaxRetries:      1,
+		ProjectRouting:  map[string]string{"eu-project": euServer.URL},
+	})
+	defer client.Close()
+
+	ts := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	resp, err := client.ImportCalls(context.Background(), []LLMCall{
+		{Provider: ProviderOpenAI, Model: "gpt-4", Timestamp: ts},
+		{Provider: ProviderOpenAI, Model: "gpt-4", Timestamp: ts, ProjectID: "eu-project"},
+		{Provider: ProviderOpenAI, Model: "gpt-4", Timestamp: ts},
+	})
+
+	var importErr *ImportError
+	if !errors.As(err, &importErr) || len(importErr.Failed) != 1 {
+		t.Fatalf("expected one failed chunk, got %v", err)
+	}
+	if indexes := importErr.Failed[0].Indexes; len(indexes) != 1 || indexes[0] != 1 {
+		t.Errorf("expected only call 1 to be reported failed, got %v", indexes)
+	}
+	if resp.Tracked != 2 {
+		t.Errorf("expected the 2 delivered calls to be counted, got %d", resp.Tracked)
+	}
+}
@@ -0,0 +1,60 @@
+package diagnyx
+
+import "encoding/json"
+
+// OffloadedMetadataKey is the metadata key that references metadata stored
+// by a MetadataSink
+const OffloadedMetadataKey = "_offloaded"
+
+// MetadataSink stores call metadata that is too large to send inline, for
+// example in object storage
+type MetadataSink interface {
+	// StoreMetadata stores metadata under key. If it returns an error the
+	// metadata is sent inline instead.
+	StoreMetadata(key string, metadata map[string]interface{}) error
+}
+
+// MetadataSinkFunc adapts a function to the MetadataSink interface
+type MetadataSinkFunc func(key string, metadata map[string]interface{}) error
+
+// StoreMetadata calls f(key, metadata)
+func (f MetadataSinkFunc) StoreMetadata(key string, metadata map[string]interface{}) error {
+	return f(key, metadata)
+}
+
+// offloadMetadata hands oversized metadata to the configured MetadataSink and
+// replaces it with a reference to the stored copy
+func (c *Client) offloadMetadata(call *LLMCall) {
+	if c.config.MetadataSink == nil || len(call.Metadata) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(call.Metadata)
+	if err != nil || len(data) <= c.config.MetadataOffloadBytes {
+		return
+	}
+
+	key := metadataKey(*call)
+	if panicErr := c.runCallback("MetadataSink", func() { err = c.config.MetadataSink.StoreMetadata(key, call.Metadata) }); panicErr != nil {
+		err = panicErr
+	}
+	if err != nil {
+		c.log("Failed to offload metadata: %v", err)
+		return
+	}
+	call.Metadata = map[string]interface{}{OffloadedMetadataKey: key}
+}
+
+// metadataKey returns the storage key for a call's metadata, built from its
+// trace and span IDs. A random span ID is used when the call has none so
+// calls sharing a trace do not overwrite each other.
+func metadataKey(call LLMCall) string {
+	spanID := call.SpanID
+	if spanID == "" {
+		spanID = NewSpanID()
+	}
+	if call.TraceID == "" {
+		return spanID
+	}
+	return call.TraceID + "/" + spanID
+}
@@ -0,0 +1,93 @@
+package diagnyx
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMetadataOffload(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	stored := make(map[string]map[string]interface{})
+	client := NewClientWithConfig(Config{
+		APIKey:               "test-key",
+		BaseURL:              server.URL,
+		FlushIntervalMs:      60000,
+		MetadataOffloadBytes: 64,
+		MetadataSink: MetadataSinkFunc(func(key string, metadata map[string]interface{}) error {
+			stored[key] = metadata
+			return nil
+		}),
+	})
+	defer client.Close()
+
+	client.Track(LLMCall{
+		Provider: ProviderOpenAI,
+		Model:    "gpt-4",
+		Status:   StatusSuccess,
+		TraceID:  "trace-1",
+		SpanID:   "span-1",
+		Metadata: map[string]interface{}{"documents": strings.Repeat("x", 100)},
+	})
+	client.Track(LLMCall{
+		Provider: ProviderOpenAI,
+		Model:    "gpt-4",
+		Status:   StatusSuccess,
+		Metadata: map[string]interface{}{"small": "value"},
+	})
+
+	calls := client.PeekBuffer()
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 buffered calls, got %d", len(calls))
+	}
+
+	if calls[0].Metadata[OffloadedMetadataKey] != "trace-1/span-1" {
+		t.Errorf("expected offloaded key 'trace-1/span-1', got %v", calls[0].Metadata)
+	}
+	if len(calls[0].Metadata) != 1 {
+		t.Errorf("expected only the offloaded key to remain, got %v", calls[0].Metadata)
+	}
+	if _, ok := stored["trace-1/span-1"]["documents"]; !ok {
+		t.Error("expected sink to receive the original metadata")
+	}
+
+	if calls[1].Metadata["small"] != "value" {
+		t.Errorf("expected small metadata to stay inline, got %v", calls[1].Metadata)
+	}
+	if len(stored) != 1 {
+		t.Errorf("expected 1 stored entry, got %d", len(stored))
+	}
+}
+
+func TestMetadataOffloadSinkError(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:               "test-key",
+		BaseURL:              server.URL,
+		FlushIntervalMs:      60000,
+		MetadataOffloadBytes: 8,
+		MetadataSink: MetadataSinkFunc(func(key string, metadata map[string]interface{}) error {
+			return errors.New("storage unavailable")
+		}),
+	})
+	defer client.Close()
+
+	client.Track(LLMCall{
+		Provider: ProviderOpenAI,
+		Model:    "gpt-4",
+		Status:   StatusSuccess,
+		Metadata: map[string]interface{}{"documents": "too large to inline"},
+	})
+
+	calls := client.PeekBuffer()
+	if len(calls) != 1 {
+		t.Fatalf("expected 1 buffered call, got %d", len(calls))
+	}
+	if calls[0].Metadata["documents"] != "too large to inline" {
+		t.Errorf("expected metadata to stay inline when the sink fails, got %v", calls[0].Metadata)
+	}
+}
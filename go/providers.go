@@ -0,0 +1,248 @@
+package diagnyx
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ProviderInfo describes what the SDK knows about a provider
+type ProviderInfo struct {
+	Provider Provider
+	// DefaultEndpoint is the path of the provider's main API, for reference.
+	// It is not recorded on calls: TrackCall cannot know which endpoint a
+	// call used, so calls carry only the endpoint given in TrackOptions.
+	DefaultEndpoint string
+	// ModelPrefixes identify the provider from a model name, matched
+	// case-insensitively. The longest matching prefix across providers wins.
+	ModelPrefixes []string
+	// InputTokensField and OutputTokensField name the usage fields reported
+	// by integrations such as langchaingo's GenerationInfo
+	InputTokensField  string
+	OutputTokensField string
+	// InputCostPer1M and OutputCostPer1M are USD prices per million tokens,
+	// for local estimates of models without an entry in ModelPrices. Zero
+	// means unknown; the backend prices calls independently.
+	InputCostPer1M  float64
+	OutputCostPer1M float64
+	// ModelPrices maps model name prefixes, matched case-insensitively, to
+	// their prices. The longest matching prefix wins, so "gpt-4o-mini" can
+	// be priced apart from "gpt-4o".
+	ModelPrices map[string]ModelPrice
+}
+
+// ModelPrice is a model's USD price per million tokens
+type ModelPrice struct {
+	InputCostPer1M  float64
+	OutputCostPer1M float64
+}
+
+// ProviderRegistry maps providers to their ProviderInfo. It is safe for
+// concurrent use.
+type ProviderRegistry struct {
+	mu        sync.RWMutex
+	providers map[Provider]ProviderInfo
+}
+
+// NewProviderRegistry returns an empty registry
+func NewProviderRegistry() *ProviderRegistry {
+	return &ProviderRegistry{providers: make(map[Provider]ProviderInfo)}
+}
+
+// Register adds or replaces the info for info.Provider
+func (r *ProviderRegistry) Register(info ProviderInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prefixes := make([]string, len(info.ModelPrefixes))
+	for i, prefix := range info.ModelPrefixes {
+		prefixes[i] = strings.ToLower(prefix)
+	}
+	info.ModelPrefixes = prefixes
+	if info.ModelPrices != nil {
+		prices := make(map[string]ModelPrice, len(info.ModelPrices))
+		for prefix, price := range info.ModelPrices {
+			prices[strings.ToLower(prefix)] = price
+		}
+		info.ModelPrices = prices
+	}
+	r.providers[info.Provider] = info
+}
+
+// Lookup returns the info registered for provider
+func (r *ProviderRegistry) Lookup(provider Provider) (ProviderInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	info, ok := r.providers[provider]
+	return info, ok
+}
+
+// Price returns the price of model: that of the longest matching prefix in
+// the provider's ModelPrices, or else the provider's own. It returns false
+// if the provider is not registered.
+func (r *ProviderRegistry) Price(provider Provider, model string) (ModelPrice, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	info, ok := r.providers[provider]
+	if !ok {
+		return ModelPrice{}, false
+	}
+	price := ModelPrice{InputCostPer1M: info.InputCostPer1M, OutputCostPer1M: info.OutputCostPer1M}
+	modelLower := strings.ToLower(model)
+	longest := -1
+	for prefix, p := range info.ModelPrices {
+		if len(prefix) > longest && strings.HasPrefix(modelLower, prefix) {
+			price = p
+			longest = len(prefix)
+		}
+	}
+	return price, true
+}
+
+// Detect returns the provider whose model prefix is the longest match for
+// model, or ProviderCustom if none matches
+func (r *ProviderRegistry) Detect(model string) Provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	modelLower := strings.ToLower(model)
+	detected := ProviderCustom
+	longest := 0
+	for provider, info := range r.providers {
+		for _, prefix := range info.ModelPrefixes {
+			if len(prefix) > longest && strings.HasPrefix(modelLower, prefix) {
+				detected = provider
+				longest = len(prefix)
+			}
+		}
+	}
+	return detected
+}
+
+// DefaultProviderRegistry holds the built-in providers and any added with
+// RegisterProvider. Built-in model prices are list prices for standard
+// usage and may lag the providers' pricing pages; register updated prices
+// where the estimates matter.
+var DefaultProviderRegistry = newBuiltinProviderRegistry()
+
+func newBuiltinProviderRegistry() *ProviderRegistry {
+	r := NewProviderRegistry()
+	r.Register(ProviderInfo{
+		Provider:          ProviderOpenAI,
+		DefaultEndpoint:   "/v1/chat/completions",
+		ModelPrefixes:     []string{"gpt-", "o1-", "o3-", "text-embedding-"},
+		InputTokensField:  "PromptTokens",
+		OutputTokensField: "CompletionTokens",
+		ModelPrices: map[string]ModelPrice{
+			"gpt-4o":        {InputCostPer1M: 2.5, OutputCostPer1M: 10},
+			"gpt-4o-mini":   {InputCostPer1M: 0.15, OutputCostPer1M: 0.6},
+			"gpt-4-turbo":   {InputCostPer1M: 10, OutputCostPer1M: 30},
+			"gpt-4":         {InputCostPer1M: 30, OutputCostPer1M: 60},
+			"gpt-3.5-turbo": {InputCostPer1M: 0.5, OutputCostPer1M: 1.5},
+		},
+	})
+	r.Register(ProviderInfo{
+		Provider:          ProviderAnthropic,
+		DefaultEndpoint:   "/v1/messages",
+		ModelPrefixes:     []string{"claude-"},
+		InputTokensField:  "InputTokens",
+		OutputTokensField: "OutputTokens",
+		ModelPrices: map[string]ModelPrice{
+			"claude-3-opus":     {InputCostPer1M: 15, OutputCostPer1M: 75},
+			"claude-3-5-sonnet": {InputCostPer1M: 3, OutputCostPer1M: 15},
+			"claude-3-5-haiku":  {InputCostPer1M: 0.8, OutputCostPer1M: 4},
+			"claude-3-haiku":    {InputCostPer1M: 0.25, OutputCostPer1M: 1.25},
+		},
+	})
+	r.Register(ProviderInfo{
+		Provider:          ProviderGoogle,
+		ModelPrefixes:     []string{"gemini-"},
+		InputTokensField:  "input_tokens",
+		OutputTokensField: "output_tokens",
+		ModelPrices: map[string]ModelPrice{
+			"gemini-1.5-pro":   {InputCostPer1M: 1.25, OutputCostPer1M: 5},
+			"gemini-1.5-flash": {InputCostPer1M: 0.075, OutputCostPer1M: 0.3},
+		},
+	})
+	r.Register(ProviderInfo{
+		Provider:          ProviderCustom,
+		ModelPrefixes:     []string{"command", "mistral", "mixtral", "llama"},
+		InputTokensField:  "PromptTokens",
+		OutputTokensField: "CompletionTokens",
+	})
+	return r
+}
+
+// RegisterProvider adds or replaces a provider in DefaultProviderRegistry
+func RegisterProvider(info ProviderInfo) {
+	DefaultProviderRegistry.Register(info)
+}
+
+// DetectProvider detects the provider of a model name using
+// DefaultProviderRegistry
+func DetectProvider(model string) Provider {
+	return DefaultProviderRegistry.Detect(model)
+}
+
+var (
+	// isoDateSuffix matches snapshot dates such as "-2024-04-09"
+	isoDateSuffix = regexp.MustCompile(`-\d{4}-\d{2}-\d{2}$`)
+	// compactDateSuffix matches snapshot dates such as "-20240229"
+	compactDateSuffix = regexp.MustCompile(`-\d{8}$`)
+	// openAISnapshotSuffix matches OpenAI's "-MMDD" snapshots such as "-0613"
+	openAISnapshotSuffix = regexp.MustCompile(`-\d{4}$`)
+)
+
+// NormalizeModel strips snapshot date suffixes from OpenAI and Anthropic
+// model names, so "gpt-4-0613" becomes "gpt-4", "gpt-4-turbo-2024-04-09"
+// becomes "gpt-4-turbo" and "claude-3-opus-20240229" becomes
+// "claude-3-opus". Other models are returned unchanged. Use it as
+// Config.ModelNormalizer.
+func NormalizeModel(provider Provider, model string) string {
+	switch provider {
+	case ProviderOpenAI, ProviderAzure:
+		model = isoDateSuffix.ReplaceAllString(model, "")
+		return openAISnapshotSuffix.ReplaceAllString(model, "")
+	case ProviderAnthropic:
+		return compactDateSuffix.ReplaceAllString(model, "")
+	}
+	return model
+}
+
+// openAIHost is the host of OpenAI's own API
+const openAIHost = "api.openai.com"
+
+// providerHostSuffixes map the hosts of OpenAI-compatible endpoints to their
+// provider. Hosts of providers without a Provider constant, such as Groq or
+// Together, are ProviderCustom and told apart by the host.
+var providerHostSuffixes = []struct {
+	suffix   string
+	provider Provider
+}{
+	{".openai.azure.com", ProviderAzure},
+	{".services.ai.azure.com", ProviderAzure},
+	{".amazonaws.com", ProviderAWS},
+	{"generativelanguage.googleapis.com", ProviderGoogle},
+	{"aiplatform.googleapis.com", ProviderGoogle},
+	{"api.anthropic.com", ProviderAnthropic},
+	{"api.groq.com", ProviderCustom},
+	{"api.together.xyz", ProviderCustom},
+	{"api.fireworks.ai", ProviderCustom},
+	{"api.deepseek.com", ProviderCustom},
+	{"api.mistral.ai", ProviderCustom},
+	{"openrouter.ai", ProviderCustom},
+	{"api.perplexity.ai", ProviderCustom},
+}
+
+// providerForHost returns the provider serving an OpenAI-compatible API at
+// host, falling back to ProviderOpenAI for unknown hosts such as local
+// proxies
+func providerForHost(host string) Provider {
+	host = strings.ToLower(host)
+	for _, known := range providerHostSuffixes {
+		if host == strings.TrimPrefix(known.suffix, ".") || strings.HasSuffix(host, known.suffix) {
+			return known.provider
+		}
+	}
+	return ProviderOpenAI
+}
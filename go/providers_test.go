@@ -0,0 +1,105 @@
+package diagnyx
+
+import "testing"
+
+func TestProviderRegistryDetect(t *testing.T) {
+	registry := NewProviderRegistry()
+	registry.Register(ProviderInfo{Provider: ProviderOpenAI, ModelPrefixes: []string{"gpt-"}})
+	registry.Register(ProviderInfo{Provider: Provider("groq"), ModelPrefixes: []string{"GPT-OSS"}})
+
+	tests := []struct {
+		model    string
+		expected Provider
+	}{
+		{"gpt-4", ProviderOpenAI},
+		{"gpt-oss-120b", Provider("groq")}, // longest prefix wins
+		{"unknown", ProviderCustom},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := registry.Detect(tt.model); got != tt.expected {
+				t.Errorf("Detect(%s) = %s, expected %s", tt.model, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRegisterProvider(t *testing.T) {
+	provider := Provider("test-vendor")
+	RegisterProvider(ProviderInfo{
+		Provider:        provider,
+		DefaultEndpoint: "/v2/generate",
+		ModelPrefixes:   []string{"testvendor-"},
+	})
+	t.Cleanup(func() {
+		DefaultProviderRegistry.mu.Lock()
+		delete(DefaultProviderRegistry.providers, provider)
+		DefaultProviderRegistry.mu.Unlock()
+	})
+
+	if got := DetectProvider("TestVendor-large"); got != provider {
+		t.Errorf("expected registered provider to be detected, got %s", got)
+	}
+	if got := DetectProvider("claude-3-opus"); got != ProviderAnthropic {
+		t.Errorf("expected built-in providers to remain registered, got %s", got)
+	}
+
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	TrackCall(client, provider, "testvendor-large", func() (int, int, error) { return 1, 1, nil })
+	TrackCall(client, provider, "testvendor-large", func() (int, int, error) { return 1, 1, nil }, TrackOptions{Endpoint: "/custom"})
+
+	calls := client.PeekBuffer()
+	if calls[0].Endpoint != "" {
+		t.Errorf("expected no endpoint for a manual call without one, got '%s'", calls[0].Endpoint)
+	}
+	if calls[1].Endpoint != "/custom" {
+		t.Errorf("expected endpoint override '/custom', got '%s'", calls[1].Endpoint)
+	}
+}
+
+func TestNormalizeModel(t *testing.T) {
+	tests := []struct {
+		provider Provider
+		model    string
+		expected string
+	}{
+		{ProviderOpenAI, "gpt-4-0613", "gpt-4"},
+		{ProviderOpenAI, "gpt-4-turbo-2024-04-09", "gpt-4-turbo"},
+		{ProviderOpenAI, "gpt-4o-mini", "gpt-4o-mini"},
+		{ProviderAnthropic, "claude-3-opus-20240229", "claude-3-opus"},
+		{ProviderGoogle, "gemini-1.5-pro-002", "gemini-1.5-pro-002"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := NormalizeModel(tt.provider, tt.model); got != tt.expected {
+				t.Errorf("NormalizeModel(%s, %s) = %s, expected %s", tt.provider, tt.model, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestModelNormalizer(t *testing.T) {
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, ModelNormalizer: NormalizeModel})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	metadata := map[string]interface{}{"feature": "chat"}
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4-0613", Metadata: metadata})
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"})
+
+	calls := client.PeekBuffer()
+	if calls[0].Model != "gpt-4" || calls[0].Metadata["raw_model"] != "gpt-4-0613" {
+		t.Errorf("expected normalized model with raw name in metadata, got %s %v", calls[0].Model, calls[0].Metadata)
+	}
+	if _, ok := metadata["raw_model"]; ok {
+		t.Error("expected the caller's metadata to be left unchanged")
+	}
+	if _, ok := calls[1].Metadata["raw_model"]; ok {
+		t.Error("expected no raw_model for unchanged model names")
+	}
+}
@@ -0,0 +1,75 @@
+package diagnyx
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides whether a failed HTTP attempt should be retried and how
+// long to wait first. attempt is the zero-based index of the attempt that
+// failed; statusCode is 0 when err is a transport error. The total number of
+// attempts is still capped by MaxRetries.
+type RetryPolicy interface {
+	ShouldRetry(attempt int, statusCode int, err error) (retry bool, delay time.Duration)
+}
+
+// ExponentialBackoff retries transport errors and non-4xx responses, waiting
+// BaseDelay * 2^attempt (capped at MaxDelay if set) plus up to Jitter of that
+// delay. Client errors (4xx) and canceled requests are never retried.
+type ExponentialBackoff struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter is the maximum fraction of the delay added at random (0-1)
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured:
+// exponential backoff from one second with 20% jitter
+func DefaultRetryPolicy() RetryPolicy {
+	return ExponentialBackoff{
+		BaseDelay: time.Second,
+		Jitter:    0.2,
+	}
+}
+
+// ShouldRetry implements RetryPolicy
+func (b ExponentialBackoff) ShouldRetry(attempt int, statusCode int, err error) (bool, time.Duration) {
+	if err == nil && statusCode >= 400 && statusCode < 500 {
+		// Don't retry client errors
+		return false, 0
+	}
+	if err != nil && !retryableError(err) {
+		return false, 0
+	}
+
+	delay := b.BaseDelay << attempt
+	if b.MaxDelay > 0 && delay > b.MaxDelay {
+		delay = b.MaxDelay
+	}
+	if b.Jitter > 0 {
+		delay += time.Duration(rand.Float64() * b.Jitter * float64(delay))
+	}
+	return true, delay
+}
+
+// retryableError reports whether a transport error is worth retrying. A
+// request canceled by the caller is final. Timeouts, connection resets and
+// broken pipes mid-write are transient: each attempt builds a new request
+// from the encoded body, so a partially sent body is sent again in full.
+func retryableError(err error) bool {
+	return !errors.Is(err, context.Canceled)
+}
+
+// sleepContext waits for d, returning ctx.Err() early if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,175 @@
+package diagnyx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultRetryPolicy(t *testing.T) {
+	policy := DefaultRetryPolicy()
+
+	tests := []struct {
+		name       string
+		attempt    int
+		statusCode int
+		err        error
+		retry      bool
+		minDelay   time.Duration
+	}{
+		{"retries transport error", 0, 0, errors.New("connection reset"), true, time.Second},
+		{"retries timeout", 0, 0, fmt.Errorf("request failed: %w", context.DeadlineExceeded), true, time.Second},
+		{"does not retry canceled request", 0, 0, fmt.Errorf("request failed: %w", context.Canceled), false, 0},
+		{"retries server error", 1, http.StatusInternalServerError, nil, true, 2 * time.Second},
+		{"retries bad gateway", 2, http.StatusBadGateway, nil, true, 4 * time.Second},
+		{"does not retry bad request", 0, http.StatusBadRequest, nil, false, 0},
+		{"does not retry unauthorized", 0, http.StatusUnauthorized, nil, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retry, delay := policy.ShouldRetry(tt.attempt, tt.statusCode, tt.err)
+			if retry != tt.retry {
+				t.Errorf("expected retry %v, got %v", tt.retry, retry)
+			}
+			maxDelay := tt.minDelay + tt.minDelay/5
+			if delay < tt.minDelay || delay > maxDelay {
+				t.Errorf("expected delay in [%v, %v], got %v", tt.minDelay, maxDelay, delay)
+			}
+		})
+	}
+}
+
+func TestExponentialBackoffMaxDelay(t *testing.T) {
+	policy := ExponentialBackoff{BaseDelay: time.Second, MaxDelay: 3 * time.Second}
+
+	_, delay := policy.ShouldRetry(5, http.StatusServiceUnavailable, nil)
+	if delay != 3*time.Second {
+		t.Errorf("expected delay capped at 3s, got %v", delay)
+	}
+}
+
+type noRetryPolicy struct{}
+
+func (noRetryPolicy) ShouldRetry(int, int, error) (bool, time.Duration) {
+	return false, 0
+}
+
+func TestCustomRetryPolicy(t *testing.T) {
+	server := newMockServer()
+	server.StatusCode = http.StatusInternalServerError
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		MaxRetries:      3,
+		RetryPolicy:     noRetryPolicy{},
+	})
+	defer client.Close()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	if err := client.Flush(); err == nil {
+		t.Error("expected error on flush failure")
+	}
+	if server.RequestCount != 1 {
+		t.Errorf("expected 1 attempt with no-retry policy, got %d", server.RequestCount)
+	}
+}
+
+func TestRetryBackoffCanceled(t *testing.T) {
+	server := newMockServer()
+	server.StatusCode = http.StatusInternalServerError
+	defer server.Close()
+
+	slowPolicy := ExponentialBackoff{BaseDelay: time.Minute}
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		RetryPolicy:     slowPolicy,
+	})
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.TrackAndFlush(ctx, LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected backoff to abort promptly, took %v", elapsed)
+	}
+
+	feedback := NewFeedbackClient("test-key", "org-1",
+		WithFeedbackBaseURL(server.URL),
+		WithFeedbackRetryPolicy(slowPolicy),
+	)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start = time.Now()
+	_, err = feedback.GetForSpanWithContext(ctx, "span-1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected feedback backoff to abort promptly, took %v", elapsed)
+	}
+}
+
+func TestRetryAfterPartialWrite(t *testing.T) {
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(bodies) == 0 {
+			// Read part of the body, then drop the connection mid-request
+			partial := make([]byte, 16)
+			io.ReadFull(r.Body, partial)
+			bodies = append(bodies, partial)
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("failed to hijack connection: %v", err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, body)
+		w.Write([]byte(`{"tracked":1}`))
+	}))
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+		MaxRetries:      2,
+		RetryPolicy:     ExponentialBackoff{BaseDelay: time.Millisecond},
+	})
+	defer client.Close()
+
+	resp, err := client.TrackAndFlush(context.Background(), LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", FullPrompt: strings.Repeat("x", 64<<10)})
+	if err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+	if resp.Tracked != 1 || len(bodies) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(bodies))
+	}
+	var req BatchRequest
+	if err := json.Unmarshal(bodies[1], &req); err != nil || len(req.Calls) != 1 || len(req.Calls[0].FullPrompt) != 64<<10 {
+		t.Errorf("expected the full body to be re-sent, got %d bytes (%v)", len(bodies[1]), err)
+	}
+}
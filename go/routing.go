@@ -0,0 +1,177 @@
+package diagnyx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// routeGroup is the calls of a batch bound for one ingest endpoint, with
+// their positions in the batch
+type routeGroup struct {
+	baseURL string
+	calls   []LLMCall
+	indices []int
+}
+
+// routeError is returned by send when the calls for some endpoints could
+// not be sent. The calls for the other endpoints were delivered.
+type routeError struct {
+	err error
+	// failed holds the calls that were not sent and can be retried
+	failed []LLMCall
+	// vetoed holds the calls Config.BeforeSend refused to send, and vetoErr
+	// the first such refusal
+	vetoed  []LLMCall
+	vetoErr error
+	// undelivered holds the positions in the batch of the failed and
+	// vetoed calls, in order
+	undelivered []int
+	// delivered is the merged response of the endpoints that accepted
+	// their calls, indexed like the whole batch
+	delivered *BatchResponse
+}
+
+func (e *routeError) Error() string { return e.err.Error() }
+func (e *routeError) Unwrap() error { return e.err }
+
+// failedCalls returns the calls of a batch that send failed to deliver
+// with err and that should be retried: all of them, unless only some
+// endpoints failed or BeforeSend vetoed the send
+func failedCalls(calls []LLMCall, err error) []LLMCall {
+	var routeErr *routeError
+	if errors.As(err, &routeErr) {
+		return routeErr.failed
+	}
+	if errors.Is(err, ErrSendVetoed) {
+		return nil
+	}
+	return calls
+}
+
+// vetoedCalls returns the calls of a batch that BeforeSend refused to send,
+// which must not be retried, and the veto error
+func vetoedCalls(calls []LLMCall, err error) ([]LLMCall, error) {
+	var routeErr *routeError
+	if errors.As(err, &routeErr) {
+		return routeErr.vetoed, routeErr.vetoErr
+	}
+	if errors.Is(err, ErrSendVetoed) {
+		return calls, err
+	}
+	return nil, nil
+}
+
+// baseURLFor returns the ingest base URL for a project
+func (c *Client) baseURLFor(projectID string) string {
+	if baseURL, ok := c.config.ProjectRouting[projectID]; ok && projectID != "" {
+		return baseURL
+	}
+	return c.config.BaseURL
+}
+
+// routeCalls groups calls by the base URL of their project, in order of
+// first appearance. Without ProjectRouting, or when every call goes to the
+// same endpoint, there is a single group holding calls itself.
+func (c *Client) routeCalls(calls []LLMCall) []routeGroup {
+	if len(c.config.ProjectRouting) == 0 {
+		return []routeGroup{{baseURL: c.config.BaseURL, calls: calls}}
+	}
+
+	var groups []routeGroup
+	byURL := make(map[string]int)
+	for i, call := range calls {
+		baseURL := c.baseURLFor(call.ProjectID)
+		g, ok := byURL[baseURL]
+		if !ok {
+			g = len(groups)
+			byURL[baseURL] = g
+			groups = append(groups, routeGroup{baseURL: baseURL})
+		}
+		groups[g].calls = append(groups[g].calls, call)
+		groups[g].indices = append(groups[g].indices, i)
+	}
+	if len(groups) == 1 {
+		return []routeGroup{{baseURL: groups[0].baseURL, calls: calls}}
+	}
+	return groups
+}
+
+// send posts calls to their ingest endpoints, one batch per endpoint (see
+// Config.ProjectRouting), and returns the merged server response, with
+// rejections indexed like calls and IDs in call order. If some endpoints fail, the others are
+// still sent and the error is a *routeError.
+func (c *Client) send(ctx context.Context, calls []LLMCall, header http.Header) (*BatchResponse, error) {
+	groups := c.routeCalls(calls)
+	if len(groups) == 1 {
+		return c.sendTo(ctx, groups[0].baseURL, calls, header)
+	}
+
+	merged := &BatchResponse{}
+	var ids []indexedID
+	routeErr := &routeError{delivered: merged}
+	for _, group := range groups {
+		resp, err := c.sendTo(ctx, group.baseURL, group.calls, header)
+		if err != nil {
+			c.log("Failed to send %d calls to %s: %v", len(group.calls), group.baseURL, err)
+			routeErr.undelivered = append(routeErr.undelivered, group.indices...)
+			if errors.Is(err, ErrSendVetoed) {
+				routeErr.vetoed = append(routeErr.vetoed, group.calls...)
+				if routeErr.vetoErr == nil {
+					routeErr.vetoErr = err
+				}
+			} else {
+				routeErr.failed = append(routeErr.failed, group.calls...)
+			}
+			if routeErr.err == nil {
+				routeErr.err = fmt.Errorf("failed to send to %s: %w", group.baseURL, err)
+			}
+			continue
+		}
+		ids = mergeResponse(merged, resp, group.indices, ids)
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool { return ids[i].index < ids[j].index })
+	for _, id := range ids {
+		merged.IDs = append(merged.IDs, id.id)
+	}
+	if routeErr.err != nil {
+		sort.Ints(routeErr.undelivered)
+		return nil, routeErr
+	}
+	return merged, nil
+}
+
+// indexedID is an ID returned for the call at index in the whole batch
+type indexedID struct {
+	index int
+	id    string
+}
+
+// mergeResponse adds the response for a group of calls to merged, mapping
+// rejection indices back to the calls' positions in the whole batch. The
+// group's IDs are appended to ids with the positions of their calls, so
+// they can be put in call order; if the server returned fewer IDs than
+// calls, the extra IDs take the position of the group's last call.
+func mergeResponse(merged, resp *BatchResponse, indices []int, ids []indexedID) []indexedID {
+	merged.Tracked += resp.Tracked
+	merged.TotalCost += resp.TotalCost
+	merged.TotalTokens += resp.TotalTokens
+	for i, id := range resp.IDs {
+		index := indices[len(indices)-1]
+		if i < len(indices) {
+			index = indices[i]
+		}
+		ids = append(ids, indexedID{index: index, id: id})
+	}
+	for _, callErr := range resp.Errors {
+		if callErr.Index < 0 || callErr.Index >= len(indices) {
+			continue
+		}
+		callErr.Index = indices[callErr.Index]
+		merged.Errors = append(merged.Errors, callErr)
+	}
+	return ids
+}
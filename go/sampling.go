@@ -0,0 +1,36 @@
+package diagnyx
+
+import "math/rand"
+
+// Sampler decides whether a call is tracked. It is consulted once per call,
+// before any content extraction in the wrappers.
+type Sampler interface {
+	Sample(call LLMCall) bool
+}
+
+// SamplerFunc adapts a function to the Sampler interface
+type SamplerFunc func(call LLMCall) bool
+
+// Sample implements Sampler
+func (f SamplerFunc) Sample(call LLMCall) bool {
+	return f(call)
+}
+
+// RateSampler returns a Sampler that keeps a random fraction (0-1) of calls
+func RateSampler(rate float64) Sampler {
+	return SamplerFunc(func(LLMCall) bool {
+		return rand.Float64() < rate
+	})
+}
+
+// ShouldTrack reports whether the configured Sampler keeps the call. Each
+// call to ShouldTrack is an independent decision, so callers that check
+// before doing expensive work should record the result rather than ask again.
+func (c *Client) ShouldTrack(call LLMCall) bool {
+	if c.config.Sampler == nil {
+		return true
+	}
+	keep := true
+	c.runCallback("Sampler", func() { keep = c.config.Sampler.Sample(call) })
+	return keep
+}
@@ -0,0 +1,76 @@
+package diagnyx
+
+import (
+	"strings"
+	"unicode"
+)
+
+var positiveWords = map[string]bool{
+	"good": true, "great": true, "excellent": true, "amazing": true, "awesome": true,
+	"helpful": true, "useful": true, "correct": true, "accurate": true, "perfect": true,
+	"love": true, "like": true, "thanks": true, "thank": true, "nice": true,
+	"clear": true, "fast": true, "right": true, "works": true, "worked": true,
+	"best": true, "fantastic": true, "brilliant": true, "solved": true, "easy": true,
+}
+
+var negativeWords = map[string]bool{
+	"bad": true, "wrong": true, "incorrect": true, "terrible": true, "awful": true,
+	"useless": true, "unhelpful": true, "poor": true, "hate": true, "slow": true,
+	"broken": true, "confusing": true, "inaccurate": true, "irrelevant": true, "worst": true,
+	"error": true, "fails": true, "failed": true, "hallucinated": true, "nonsense": true,
+	"misleading": true, "incomplete": true, "outdated": true, "rude": true, "bug": true,
+}
+
+var negators = map[string]bool{
+	"not": true, "no": true, "never": true, "isn't": true, "wasn't": true,
+	"don't": true, "doesn't": true, "didn't": true, "hardly": true, "barely": true,
+}
+
+// InferSentiment classifies free-text feedback with a keyword heuristic.
+// Positive and negative words from a small built-in lexicon are counted, a
+// preceding negation ("not helpful") flips a word, and the sign of the
+// total decides the result. Texts without a clear signal are neutral.
+//
+// It is meant for quick local filtering; the server assigns its own
+// sentiment using a richer model.
+func InferSentiment(text string) FeedbackSentiment {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+
+	score := 0
+	for i, word := range words {
+		value := 0
+		if positiveWords[word] {
+			value = 1
+		} else if negativeWords[word] {
+			value = -1
+		}
+		if value == 0 {
+			continue
+		}
+		if negated(words, i) {
+			value = -value
+		}
+		score += value
+	}
+
+	switch {
+	case score > 0:
+		return FeedbackSentimentPositive
+	case score < 0:
+		return FeedbackSentimentNegative
+	default:
+		return FeedbackSentimentNeutral
+	}
+}
+
+// negated reports whether one of the two words before words[i] is a negator
+func negated(words []string, i int) bool {
+	for j := i - 1; j >= 0 && j >= i-2; j-- {
+		if negators[words[j]] {
+			return true
+		}
+	}
+	return false
+}
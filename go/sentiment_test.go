@@ -0,0 +1,26 @@
+package diagnyx
+
+import "testing"
+
+func TestInferSentiment(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected FeedbackSentiment
+	}{
+		{"Great answer, thanks!", FeedbackSentimentPositive},
+		{"This is wrong and confusing", FeedbackSentimentNegative},
+		{"Not helpful at all", FeedbackSentimentNegative},
+		{"It wasn't bad", FeedbackSentimentPositive},
+		{"The response mentions Paris", FeedbackSentimentNeutral},
+		{"Good start but incorrect ending", FeedbackSentimentNeutral},
+		{"", FeedbackSentimentNeutral},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := InferSentiment(tt.text); got != tt.expected {
+				t.Errorf("InferSentiment(%q) = %s, expected %s", tt.text, got, tt.expected)
+			}
+		})
+	}
+}
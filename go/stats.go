@@ -0,0 +1,129 @@
+package diagnyx
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyStats summarizes recent call latencies in milliseconds
+type LatencyStats struct {
+	Count int
+	P50   int64
+	P90   int64
+	P95   int64
+	P99   int64
+}
+
+type latencySample struct {
+	at        time.Time
+	latencyMs int64
+}
+
+// latencyRing keeps the most recent latency samples in a fixed-size ring
+type latencyRing struct {
+	mu      sync.Mutex
+	samples []latencySample
+	next    int
+	full    bool
+}
+
+func newLatencyRing(size int) *latencyRing {
+	return &latencyRing{samples: make([]latencySample, size)}
+}
+
+func (r *latencyRing) add(at time.Time, latencyMs int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples[r.next] = latencySample{at: at, latencyMs: latencyMs}
+	r.next++
+	if r.next == len(r.samples) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// stats computes percentiles over samples recorded at or after since
+func (r *latencyRing) stats(since time.Time) LatencyStats {
+	r.mu.Lock()
+	n := r.next
+	if r.full {
+		n = len(r.samples)
+	}
+	values := make([]int64, 0, n)
+	for _, s := range r.samples[:n] {
+		if !s.at.Before(since) {
+			values = append(values, s.latencyMs)
+		}
+	}
+	r.mu.Unlock()
+
+	if len(values) == 0 {
+		return LatencyStats{}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return LatencyStats{
+		Count: len(values),
+		P50:   percentile(values, 50),
+		P90:   percentile(values, 90),
+		P95:   percentile(values, 95),
+		P99:   percentile(values, 99),
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// LatencyStats returns latency percentiles over the most recent
+// LatencySampleSize tracked calls, limited to the last LatencyWindow if set
+func (c *Client) LatencyStats() LatencyStats {
+	var since time.Time
+	if c.config.LatencyWindow > 0 {
+		since = time.Now().Add(-c.config.LatencyWindow)
+	}
+	return c.latencies.stats(since)
+}
+
+// Stats summarizes the calls this client has tracked since it was created
+type Stats struct {
+	// Calls is the number of tracked calls, including cache hits
+	Calls int64
+	// CacheHits is the number of tracked calls marked as CacheHit
+	CacheHits int64
+	// CacheHitRate is CacheHits / Calls, or 0 if no calls were tracked
+	CacheHitRate float64
+	// Latency holds the same percentiles as LatencyStats
+	Latency LatencyStats
+}
+
+// recordStats accounts for a call that passed sampling
+func (c *Client) recordStats(now time.Time, call LLMCall) {
+	c.latencies.add(now, call.LatencyMs)
+	c.calls.Add(1)
+	if call.CacheHit {
+		c.cacheHits.Add(1)
+	}
+	c.usage.add(call, c.estimateCost(call))
+}
+
+// Stats returns local counters for tracked calls. Calls dropped by the
+// Sampler, or rejected because the buffer is full or the client is closed,
+// are not counted.
+func (c *Client) Stats() Stats {
+	stats := Stats{
+		Calls:     c.calls.Load(),
+		CacheHits: c.cacheHits.Load(),
+		Latency:   c.LatencyStats(),
+	}
+	if stats.Calls > 0 {
+		stats.CacheHitRate = float64(stats.CacheHits) / float64(stats.Calls)
+	}
+	return stats
+}
@@ -0,0 +1,110 @@
+package diagnyx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyStats(t *testing.T) {
+	t.Run("computes percentiles", func(t *testing.T) {
+		client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		for i := 1; i <= 100; i++ {
+			client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, LatencyMs: int64(i)})
+		}
+
+		stats := client.LatencyStats()
+		if stats.Count != 100 {
+			t.Errorf("expected count 100, got %d", stats.Count)
+		}
+		if stats.P50 != 50 || stats.P90 != 90 || stats.P95 != 95 || stats.P99 != 99 {
+			t.Errorf("unexpected percentiles: %+v", stats)
+		}
+	})
+
+	t.Run("keeps only the most recent samples", func(t *testing.T) {
+		client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, LatencySampleSize: 10})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		for i := 1; i <= 20; i++ {
+			client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, LatencyMs: int64(i)})
+		}
+
+		stats := client.LatencyStats()
+		if stats.Count != 10 {
+			t.Errorf("expected count 10, got %d", stats.Count)
+		}
+		if stats.P50 != 15 {
+			t.Errorf("expected p50 15, got %d", stats.P50)
+		}
+	})
+
+	t.Run("excludes samples outside the window", func(t *testing.T) {
+		client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, LatencyWindow: 50 * time.Millisecond})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, LatencyMs: 1000})
+		time.Sleep(100 * time.Millisecond)
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, LatencyMs: 10})
+
+		stats := client.LatencyStats()
+		if stats.Count != 1 || stats.P99 != 10 {
+			t.Errorf("expected only the recent sample, got %+v", stats)
+		}
+	})
+
+	t.Run("uses the default for a negative sample size", func(t *testing.T) {
+		client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, LatencySampleSize: -1})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, LatencyMs: 10})
+		if stats := client.LatencyStats(); stats.Count != 1 {
+			t.Errorf("expected count 1, got %d", stats.Count)
+		}
+	})
+
+	t.Run("returns zero stats without calls", func(t *testing.T) {
+		client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+		defer client.Close()
+
+		if stats := client.LatencyStats(); stats.Count != 0 {
+			t.Errorf("expected count 0, got %d", stats.Count)
+		}
+	})
+}
+
+func TestStatsCacheHitRate(t *testing.T) {
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	if stats := client.Stats(); stats.CacheHitRate != 0 {
+		t.Errorf("expected cache hit rate 0 with no calls, got %v", stats.CacheHitRate)
+	}
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess, LatencyMs: 800})
+	TrackCall(client, ProviderOpenAI, "gpt-4", func() (int, int, error) {
+		return 100, 50, nil
+	}, TrackOptions{CacheHit: true})
+
+	stats := client.Stats()
+	if stats.Calls != 2 || stats.CacheHits != 1 {
+		t.Errorf("expected 2 calls and 1 cache hit, got %+v", stats)
+	}
+	if stats.CacheHitRate != 0.5 {
+		t.Errorf("expected cache hit rate 0.5, got %v", stats.CacheHitRate)
+	}
+	if stats.Latency.Count != 2 {
+		t.Errorf("expected cache hits to count towards latency, got %d samples", stats.Latency.Count)
+	}
+
+	calls := client.PeekBuffer()
+	if !calls[1].CacheHit {
+		t.Error("expected TrackOptions.CacheHit to mark the call")
+	}
+}
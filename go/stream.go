@@ -0,0 +1,241 @@
+package diagnyx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// OpenAIStreamClient is implemented by OpenAI clients that support streaming
+// chat completions, including *openai.Client
+type OpenAIStreamClient interface {
+	CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error)
+}
+
+// ChatCompletionStream wraps an OpenAI chat completion stream and tracks the
+// call once the stream ends or is closed. OpenAI only reports usage for
+// streams in a trailing event, which not every client surfaces; pass it to
+// SetUsage when available. Otherwise token counts are estimated from the
+// prompt and the streamed response with EstimateTokens, and the call is
+// marked with Metadata["tokens_estimated"] = true.
+type ChatCompletionStream struct {
+	stream  *openai.ChatCompletionStream
+	wrapper *OpenAIWrapper
+	req     openai.ChatCompletionRequest
+	start   time.Time
+
+	mu           sync.Mutex
+	ttftMs       *int64
+	response     strings.Builder
+	finishReason openai.FinishReason
+	usage        *openai.Usage
+	timer        *TokenTimer
+	once         sync.Once
+}
+
+// CreateChatCompletionStream creates a streaming chat completion. The call
+// is tracked when Recv returns io.EOF or an error, or on Close.
+func (w *OpenAIWrapper) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (*ChatCompletionStream, error) {
+	streamer, ok := w.client.(OpenAIStreamClient)
+	if !ok {
+		return nil, errors.New("wrapped OpenAI client does not support streaming")
+	}
+
+	start := time.Now()
+	stream, err := streamer.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		call := w.newCall(req.Model, "/v1/chat/completions", time.Since(start).Milliseconds())
+		call.Status = errorStatus(err)
+		call.ErrorMessage = err.Error()
+		if shouldTrack(w.diagnyx, call) {
+			w.track(call)
+		}
+		return nil, err
+	}
+
+	s := &ChatCompletionStream{stream: stream, wrapper: w, req: req, start: start}
+	if config := w.diagnyx.Config(); config.RecordTokenTimings {
+		s.timer = NewTokenTimer(config.MaxTokenTimingSamples)
+	}
+	return s, nil
+}
+
+// Recv returns the next chunk of the stream
+func (s *ChatCompletionStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	resp, err := s.stream.Recv()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			s.finish(nil)
+		} else {
+			s.finish(err)
+		}
+		return resp, err
+	}
+
+	s.mu.Lock()
+	for _, choice := range resp.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if choice.Delta.Content != "" && s.ttftMs == nil {
+			ttft := time.Since(s.start).Milliseconds()
+			s.ttftMs = &ttft
+		}
+		if choice.Delta.Content != "" && s.timer != nil {
+			// OpenAI streams about one token per chunk
+			s.timer.Record(time.Now(), 1)
+		}
+		s.response.WriteString(choice.Delta.Content)
+		if choice.FinishReason != "" {
+			s.finishReason = choice.FinishReason
+		}
+	}
+	s.mu.Unlock()
+	return resp, nil
+}
+
+// SetUsage records the token usage reported by the provider, which is
+// preferred over the estimate. Call it before the stream ends.
+func (s *ChatCompletionStream) SetUsage(usage openai.Usage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage = &usage
+}
+
+// Close closes the underlying stream, tracking the call if it has not been
+// tracked yet
+func (s *ChatCompletionStream) Close() {
+	s.stream.Close()
+	s.finish(nil)
+}
+
+// finish tracks the call once
+func (s *ChatCompletionStream) finish(err error) {
+	s.once.Do(func() {
+		w := s.wrapper
+		call := w.newCall(s.req.Model, "/v1/chat/completions", time.Since(s.start).Milliseconds())
+
+		s.mu.Lock()
+		response := s.response.String()
+		call.TTFTMs = s.ttftMs
+		usage := s.usage
+		finishReason := s.finishReason
+		if s.timer != nil {
+			call = withStreamTimings(call, s.timer.Timings())
+		}
+		s.mu.Unlock()
+
+		switch {
+		case usage != nil:
+			call.InputTokens = usage.PromptTokens
+			call.OutputTokens = usage.CompletionTokens
+		case err == nil || billedError(err, true):
+			call.InputTokens = EstimateTokens(formatOpenAIPrompt(s.req.Messages))
+			call.OutputTokens = EstimateTokens(response)
+			call.Metadata = withMetadata(call.Metadata, "tokens_estimated", true)
+		}
+
+		if err != nil {
+			// The stream failed mid-generation. As for non-streaming
+			// calls, the provider bills for the tokens counted so far only
+			// if it accepted the request; see withBilledOnError.
+			call.Status = errorStatus(err)
+			call.ErrorMessage = err.Error()
+			if call.InputTokens > 0 || call.OutputTokens > 0 {
+				call.Metadata = withMetadata(call.Metadata, "billed_on_error", true)
+			}
+		} else {
+			call.Status = StatusSuccess
+			call = withFinishReason(call, finishReason)
+		}
+
+		if !shouldTrack(w.diagnyx, call) {
+			return
+		}
+
+		config := w.diagnyx.Config()
+		if config.CaptureParameters {
+			call.Parameters = extractOpenAIParameters(s.req)
+		}
+		policy := w.opts.capturePolicy(config)
+		if policy.CapturesPrompt() {
+			call.SetPrompt(context.Background(), config, formatOpenAIPrompt(s.req.Messages))
+		}
+		if policy.CapturesResponse() {
+			call.SetResponse(context.Background(), config, response)
+		}
+
+		w.track(call)
+	})
+}
+
+// OpenAIStreamTokens pumps the content deltas of an OpenAI chat completion
+// stream into a channel, ready for guardrails.StreamWithGuardrails with the
+// same ctx. The channel is closed, and the stream with it, when the stream
+// ends, Recv fails or ctx is done; a consumer that stops reading early only
+// needs to cancel ctx. Only the first choice is read.
+//
+// The returned function reports the usage of the stream so far and, once
+// the channel is closed, the error that ended it: nil at the end of the
+// stream, otherwise the Recv error or ctx.Err(). Check it before treating
+// the content as complete, since a truncated stream also closes the
+// channel. go-openai does not surface the trailing usage event, so
+// CompletionTokens is estimated from the streamed text with EstimateTokens
+// and PromptTokens is zero.
+func OpenAIStreamTokens(ctx context.Context, stream *openai.ChatCompletionStream) (<-chan string, func() (openai.Usage, error)) {
+	tokens := make(chan string, 10)
+	var mu sync.Mutex
+	var response strings.Builder
+	var streamErr error
+
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		streamErr = err
+	}
+
+	go func() {
+		defer close(tokens)
+		defer stream.Close()
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					fail(err)
+				}
+				return
+			}
+			for _, choice := range resp.Choices {
+				if choice.Index != 0 || choice.Delta.Content == "" {
+					continue
+				}
+				if err := ctx.Err(); err != nil {
+					fail(err)
+					return
+				}
+				mu.Lock()
+				response.WriteString(choice.Delta.Content)
+				mu.Unlock()
+				select {
+				case tokens <- choice.Delta.Content:
+				case <-ctx.Done():
+					fail(ctx.Err())
+					return
+				}
+			}
+		}
+	}()
+
+	usage := func() (openai.Usage, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		completion := EstimateTokens(response.String())
+		return openai.Usage{CompletionTokens: completion, TotalTokens: completion}, streamErr
+	}
+	return tokens, usage
+}
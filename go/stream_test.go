@@ -0,0 +1,259 @@
+package diagnyx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// newOpenAIStreamServer streams the given content deltas as chat completion
+// chunks
+func newOpenAIStreamServer(deltas ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range deltas {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", delta)
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+func TestOpenAIWrapperStream(t *testing.T) {
+	server := newOpenAIStreamServer("Hello", ", world")
+	defer server.Close()
+
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL + "/v1"
+	openaiClient := openai.NewClientWithConfig(config)
+
+	req := openai.ChatCompletionRequest{
+		Model:    openai.GPT4,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Say hello"}},
+	}
+
+	t.Run("estimates tokens without usage", func(t *testing.T) {
+		tracker := &fakeTracker{config: Config{CaptureFullContent: true}}
+		stream, err := WrapOpenAI(openaiClient, tracker).CreateChatCompletionStream(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer stream.Close()
+
+		for {
+			if _, err := stream.Recv(); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		if len(tracker.calls) != 1 {
+			t.Fatalf("expected 1 tracked call, got %d", len(tracker.calls))
+		}
+		call := tracker.calls[0]
+		if call.Metadata["tokens_estimated"] != true {
+			t.Error("expected tokens_estimated to be set")
+		}
+		if call.InputTokens != EstimateTokens("[user]: Say hello") || call.OutputTokens != EstimateTokens("Hello, world") {
+			t.Errorf("unexpected estimated tokens: %d/%d", call.InputTokens, call.OutputTokens)
+		}
+		if call.FullResponse != "Hello, world" || call.TTFTMs == nil {
+			t.Errorf("expected response and TTFT to be captured, got %+v", call)
+		}
+	})
+
+	t.Run("prefers reported usage", func(t *testing.T) {
+		tracker := &fakeTracker{}
+		stream, err := WrapOpenAI(openaiClient, tracker).CreateChatCompletionStream(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		stream.Recv()
+		stream.SetUsage(openai.Usage{PromptTokens: 12, CompletionTokens: 3})
+		stream.Close()
+
+		call := tracker.calls[0]
+		if call.InputTokens != 12 || call.OutputTokens != 3 {
+			t.Errorf("expected reported usage 12/3, got %d/%d", call.InputTokens, call.OutputTokens)
+		}
+		if _, ok := call.Metadata["tokens_estimated"]; ok {
+			t.Error("expected tokens_estimated to be unset")
+		}
+	})
+
+	t.Run("records token timings", func(t *testing.T) {
+		tracker := &fakeTracker{config: Config{RecordTokenTimings: true, MaxTokenTimingSamples: 10}}
+		stream, err := WrapOpenAI(openaiClient, tracker).CreateChatCompletionStream(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for {
+			if _, err := stream.Recv(); err != nil {
+				break
+			}
+		}
+
+		call := tracker.calls[0]
+		for _, key := range []string{"tokens_per_second", "max_inter_token_ms", "p95_inter_token_ms"} {
+			if _, ok := call.Metadata[key]; !ok {
+				t.Errorf("expected %s to be recorded, got %v", key, call.Metadata)
+			}
+		}
+	})
+
+	t.Run("bills a stream cut off after the request was accepted", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hello\"}}]}\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+		config := openai.DefaultConfig("test-key")
+		config.BaseURL = server.URL + "/v1"
+
+		ctx, cancel := context.WithCancel(context.Background())
+		tracker := &fakeTracker{}
+		stream, err := WrapOpenAI(openai.NewClientWithConfig(config), tracker).CreateChatCompletionStream(ctx, req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		stream.Recv()
+		cancel()
+		if _, err := stream.Recv(); err == nil {
+			t.Fatal("expected the stream to fail")
+		}
+
+		call := tracker.calls[0]
+		if call.Status != StatusTimeout || call.InputTokens == 0 || call.Metadata["billed_on_error"] != true {
+			t.Errorf("expected a billed timeout, got %+v", call)
+		}
+	})
+
+	t.Run("does not bill a rejected stream", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hello\"}}]}\n\n")
+			fmt.Fprint(w, "data: {\"error\":{\"message\":\"content policy\",\"type\":\"invalid_request_error\"}}\n\n")
+		}))
+		defer server.Close()
+		config := openai.DefaultConfig("test-key")
+		config.BaseURL = server.URL + "/v1"
+
+		tracker := &fakeTracker{}
+		stream, err := WrapOpenAI(openai.NewClientWithConfig(config), tracker).CreateChatCompletionStream(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for {
+			if _, err := stream.Recv(); err != nil {
+				break
+			}
+		}
+
+		call := tracker.calls[0]
+		if call.Status != StatusError || call.InputTokens != 0 || call.OutputTokens != 0 {
+			t.Errorf("expected an unbilled error, got %+v", call)
+		}
+		if call.Metadata["billed_on_error"] != nil || call.Metadata["tokens_estimated"] != nil {
+			t.Errorf("expected no billing metadata, got %v", call.Metadata)
+		}
+	})
+
+	t.Run("requires a streaming client", func(t *testing.T) {
+		if _, err := WrapOpenAI(&stubOpenAIClient{}, &fakeTracker{}).CreateChatCompletionStream(context.Background(), req); err == nil {
+			t.Error("expected an error for a client without streaming support")
+		}
+	})
+}
+
+func TestOpenAIStreamTokens(t *testing.T) {
+	newStream := func(t *testing.T, url string) *openai.ChatCompletionStream {
+		config := openai.DefaultConfig("test-key")
+		config.BaseURL = url + "/v1"
+		stream, err := openai.NewClientWithConfig(config).CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{
+			Model:    openai.GPT4,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Say hello"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return stream
+	}
+
+	t.Run("pumps deltas", func(t *testing.T) {
+		server := newOpenAIStreamServer("Hello", ", world")
+		defer server.Close()
+
+		tokens, usage := OpenAIStreamTokens(context.Background(), newStream(t, server.URL))
+		var got []string
+		for token := range tokens {
+			got = append(got, token)
+		}
+
+		if len(got) != 2 || got[0] != "Hello" || got[1] != ", world" {
+			t.Errorf("unexpected tokens: %q", got)
+		}
+		u, err := usage()
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if u.CompletionTokens != EstimateTokens("Hello, world") || u.TotalTokens != u.CompletionTokens {
+			t.Errorf("unexpected usage: %+v", u)
+		}
+	})
+
+	t.Run("stops when the consumer cancels", func(t *testing.T) {
+		deltas := make([]string, 100)
+		for i := range deltas {
+			deltas[i] = "token "
+		}
+		server := newOpenAIStreamServer(deltas...)
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		tokens, usage := OpenAIStreamTokens(ctx, newStream(t, server.URL))
+		<-tokens
+		cancel()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			// The pump may have queued tokens before it saw the cancel
+			for range tokens {
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expected the pump to stop after the cancel")
+		}
+		if _, err := usage(); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("reports a truncated stream", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+			fmt.Fprint(w, "data: {not json\n\n")
+		}))
+		defer server.Close()
+
+		tokens, usage := OpenAIStreamTokens(context.Background(), newStream(t, server.URL))
+		for range tokens {
+		}
+		if _, err := usage(); err == nil {
+			t.Error("expected the truncated stream to report an error")
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package diagnyx
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// StreamTimings summarizes how smoothly a streamed response arrived
+type StreamTimings struct {
+	// Tokens is the number of tokens recorded
+	Tokens int
+	// TokensPerSecond is the rate after the first token, which excludes
+	// the time to first token. Zero if fewer than two emissions were
+	// recorded.
+	TokensPerSecond float64
+	// MaxGap is the longest pause between two emissions
+	MaxGap time.Duration
+	// P95Gap is the 95th percentile pause between emissions, over the
+	// sampled gaps
+	P95Gap time.Duration
+}
+
+// TokenTimer records when the tokens of a stream are emitted. It keeps
+// the token count and the longest gap in constant space and keeps a
+// uniform random sample (reservoir sample) of at most maxSamples gaps for
+// P95Gap, so memory stays bounded on long streams while late stalls still
+// count. It is not safe for concurrent use.
+type TokenTimer struct {
+	maxSamples int
+	first      time.Time
+	last       time.Time
+	tokens     int
+	// afterFirst counts tokens emitted after the first emission
+	afterFirst int
+	maxGap     time.Duration
+	gaps       []time.Duration
+	// seenGaps counts every gap, sampled or not
+	seenGaps int
+}
+
+// NewTokenTimer returns a timer sampling at most maxSamples gaps
+func NewTokenTimer(maxSamples int) *TokenTimer {
+	return &TokenTimer{maxSamples: maxSamples}
+}
+
+// Record notes that tokens tokens were emitted together at now
+func (t *TokenTimer) Record(now time.Time, tokens int) {
+	if t.first.IsZero() {
+		t.first = now
+	} else {
+		gap := now.Sub(t.last)
+		if gap > t.maxGap {
+			t.maxGap = gap
+		}
+		t.seenGaps++
+		if len(t.gaps) < t.maxSamples {
+			t.gaps = append(t.gaps, gap)
+		} else if i := rand.Intn(t.seenGaps); i < t.maxSamples {
+			t.gaps[i] = gap
+		}
+		t.afterFirst += tokens
+	}
+	t.last = now
+	t.tokens += tokens
+}
+
+// Timings returns the summary of the recorded emissions
+func (t *TokenTimer) Timings() StreamTimings {
+	timings := StreamTimings{Tokens: t.tokens, MaxGap: t.maxGap}
+	if elapsed := t.last.Sub(t.first); elapsed > 0 {
+		timings.TokensPerSecond = float64(t.afterFirst) / elapsed.Seconds()
+	}
+	if len(t.gaps) > 0 {
+		gaps := append([]time.Duration(nil), t.gaps...)
+		sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+		timings.P95Gap = gaps[(len(gaps)*95-1)/100]
+	}
+	return timings
+}
+
+// withStreamTimings records timings in call's Metadata as
+// tokens_per_second, max_inter_token_ms and p95_inter_token_ms
+func withStreamTimings(call LLMCall, timings StreamTimings) LLMCall {
+	call.Metadata = withMetadata(call.Metadata, "tokens_per_second", timings.TokensPerSecond)
+	call.Metadata = withMetadata(call.Metadata, "max_inter_token_ms", timings.MaxGap.Milliseconds())
+	call.Metadata = withMetadata(call.Metadata, "p95_inter_token_ms", timings.P95Gap.Milliseconds())
+	return call
+}
@@ -0,0 +1,58 @@
+package diagnyx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenTimer(t *testing.T) {
+	start := time.Now()
+	timer := NewTokenTimer(10)
+	timer.Record(start, 1)
+	for i, gap := range []time.Duration{100, 50, 50, 400} {
+		start = start.Add(gap * time.Millisecond)
+		timer.Record(start, i+1)
+	}
+
+	timings := timer.Timings()
+	if timings.Tokens != 11 {
+		t.Errorf("expected 11 tokens, got %d", timings.Tokens)
+	}
+	// 10 tokens after the first emission over 600ms
+	if timings.TokensPerSecond < 16.6 || timings.TokensPerSecond > 16.7 {
+		t.Errorf("expected about 16.7 tokens/s, got %f", timings.TokensPerSecond)
+	}
+	if timings.MaxGap != 400*time.Millisecond {
+		t.Errorf("expected the 400ms gap to be the max, got %s", timings.MaxGap)
+	}
+	if timings.P95Gap != 400*time.Millisecond {
+		t.Errorf("expected p95 of the 4 gaps to be 400ms, got %s", timings.P95Gap)
+	}
+
+	if empty := NewTokenTimer(10).Timings(); empty != (StreamTimings{}) {
+		t.Errorf("expected zero timings without emissions, got %+v", empty)
+	}
+}
+
+func TestTokenTimerSamplesWholeStream(t *testing.T) {
+	// A stream that stalls in its second half: sampling only the first
+	// gaps would miss the stalls entirely
+	now := time.Now()
+	timer := NewTokenTimer(100)
+	timer.Record(now, 1)
+	for i := 0; i < 2000; i++ {
+		gap := time.Millisecond
+		if i >= 1000 {
+			gap = 100 * time.Millisecond
+		}
+		now = now.Add(gap)
+		timer.Record(now, 1)
+	}
+
+	if len(timer.gaps) != 100 {
+		t.Fatalf("expected 100 sampled gaps, got %d", len(timer.gaps))
+	}
+	if p95 := timer.Timings().P95Gap; p95 != 100*time.Millisecond {
+		t.Errorf("expected p95 to reflect the late stalls, got %s", p95)
+	}
+}
@@ -0,0 +1,35 @@
+package diagnyx
+
+// Tracker is the part of *Client used by the wrappers, TrackCall,
+// TrackCallWithContent and the framework callbacks. *Client implements it;
+// substitute a fake to unit test code built on the SDK without a network
+// connection or background goroutines.
+type Tracker interface {
+	Track(call LLMCall)
+	TrackCalls(calls []LLMCall)
+	Config() Config
+	Flush() error
+}
+
+var _ Tracker = (*Client)(nil)
+
+// shouldTrack applies the tracker's configured Sampler to a call
+func shouldTrack(t Tracker, call LLMCall) bool {
+	if c, ok := t.(*Client); ok {
+		return c.ShouldTrack(call)
+	}
+	if sampler := t.Config().Sampler; sampler != nil {
+		return sampler.Sample(call)
+	}
+	return true
+}
+
+// trackSampled records a call that already passed shouldTrack and reports
+// whether it was accepted. Trackers other than *Client always accept.
+func trackSampled(t Tracker, call LLMCall) bool {
+	if c, ok := t.(*Client); ok {
+		return c.trackSampled(call)
+	}
+	t.Track(call)
+	return true
+}
@@ -1,6 +1,11 @@
 package diagnyx
 
-import "time"
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
 
 // Provider represents an LLM provider
 type Provider string
@@ -22,6 +27,42 @@ const (
 	StatusError       CallStatus = "error"
 	StatusTimeout     CallStatus = "timeout"
 	StatusRateLimited CallStatus = "rate_limited"
+	// StatusFiltered marks a call that completed but whose output was
+	// withheld by the provider's content filter or refused by the model
+	StatusFiltered CallStatus = "filtered"
+)
+
+// CapturePolicy controls which parts of a call's content are captured
+type CapturePolicy string
+
+const (
+	CaptureNone         CapturePolicy = "none"
+	CapturePromptOnly   CapturePolicy = "prompt_only"
+	CaptureResponseOnly CapturePolicy = "response_only"
+	CaptureBoth         CapturePolicy = "both"
+)
+
+// CapturesPrompt reports whether the policy captures prompt content
+func (p CapturePolicy) CapturesPrompt() bool {
+	return p == CapturePromptOnly || p == CaptureBoth
+}
+
+// CapturesResponse reports whether the policy captures response content
+func (p CapturePolicy) CapturesResponse() bool {
+	return p == CaptureResponseOnly || p == CaptureBoth
+}
+
+// TruncationStrategy selects which part of over-long captured content is kept
+type TruncationStrategy string
+
+const (
+	// TruncateHead keeps the beginning of the content, followed by the marker
+	TruncateHead TruncationStrategy = "head"
+	// TruncateTail keeps the end of the content, preceded by the marker; for
+	// conversations this keeps the latest turns
+	TruncateTail TruncationStrategy = "tail"
+	// TruncateMiddle keeps the beginning and the end with the marker between
+	TruncateMiddle TruncationStrategy = "middle"
 )
 
 // Config holds the configuration for the Diagnyx client
@@ -31,13 +72,226 @@ type Config struct {
 	BatchSize       int
 	FlushIntervalMs int
 	MaxRetries      int
-	Debug           bool
+	// RetryPolicy decides which failed requests are retried and the backoff
+	// between attempts. Default: DefaultRetryPolicy()
+	RetryPolicy RetryPolicy
+	Debug       bool
+	// OrganizationID scopes ingest requests to an organization and is sent
+	// as the X-Organization-ID header. Calls can override it per call with
+	// LLMCall.OrganizationID. Set it when one API key serves several
+	// organizations.
+	// Default: "" (the API key's organization)
+	OrganizationID string
+	// RequireOrganizationID rejects calls that get no organization from
+	// either OrganizationID or the call itself, for backends that require
+	// explicit scoping. Rejected calls are passed to OnError with
+	// ErrOrganizationRequired.
+	// Default: false
+	RequireOrganizationID bool
 	// CaptureFullContent enables capturing full prompt/response content.
+	// Equivalent to CapturePolicy: CaptureBoth. Ignored if CapturePolicy is set.
 	// Default: false (privacy-first)
 	CaptureFullContent bool
+	// CapturePolicy selects which content to capture (prompt, response, both
+	// or none). Supersedes CaptureFullContent when set.
+	// Default: "" (use CaptureFullContent)
+	CapturePolicy CapturePolicy
 	// ContentMaxLength is the maximum length for captured content before truncation.
 	// Default: 10000
 	ContentMaxLength int
+	// TruncationStrategy selects which part of over-long content is kept.
+	// Default: TruncateHead
+	TruncationStrategy TruncationStrategy
+	// TruncationMarker is inserted where captured content was cut.
+	// Default: "... [truncated]"
+	TruncationMarker string
+	// CaptureParameters records request parameters such as temperature and
+	// max_tokens in LLMCall.Parameters.
+	// Default: false
+	CaptureParameters bool
+	// CaptureRawResponse stores the provider's complete response JSON in
+	// LLMCall.RawResponse (OpenAI wrapper only). Raw responses are several
+	// times larger than the extracted content and are sent with every
+	// sampled call, so enable it only while debugging. Responses longer than
+	// ContentMaxLength are truncated and sent as a JSON string instead.
+	// Default: false
+	CaptureRawResponse bool
+	// ContentStore, if set, receives captured prompts and responses in full;
+	// calls then carry the returned references in PromptRef and ResponseRef
+	// instead of inline content. Put runs on the tracking goroutine.
+	// Default: nil (content is sent inline)
+	ContentStore ContentStore
+	// AutoGenerateTraceID assigns a random TraceID and SpanID to calls tracked
+	// without either. The wrappers also assign a fresh SpanID to every call when
+	// TrackOptions.SpanID is empty, so TrackOptions.TraceID groups them.
+	// Default: false
+	AutoGenerateTraceID bool
+	// RecordTokenTimings makes the streaming wrapper time every chunk it
+	// receives and record tokens_per_second, max_inter_token_ms and
+	// p95_inter_token_ms in the call's Metadata. It costs a clock read per
+	// chunk and up to MaxTokenTimingSamples gaps of memory per open stream.
+	// Default: false
+	RecordTokenTimings bool
+	// MaxTokenTimingSamples caps the gaps kept per stream for the p95;
+	// the token count and longest gap cover the whole stream regardless.
+	// Default: 1000
+	MaxTokenTimingSamples int
+	// Sampler drops calls before they are buffered. The wrappers consult it
+	// before extracting content so dropped calls cost no extraction work.
+	// Default: nil (track every call)
+	Sampler Sampler
+	// LatencySampleSize is the number of recent call latencies kept for
+	// LatencyStats. Values below 1 use the default. Default: 1000
+	LatencySampleSize int
+	// LatencyWindow limits LatencyStats to calls tracked within the duration.
+	// Default: 0 (all retained samples)
+	LatencyWindow time.Duration
+	// BeforeSend is called once per outgoing batch, before it is marshaled and
+	// before any retry attempts. The hook may mutate the batch (for example to
+	// redact content). Returning an error aborts the send; the error wraps
+	// ErrSendVetoed, and buffered calls are passed to OnError rather than
+	// put back in the buffer.
+	BeforeSend func(batch *BatchRequest) error
+	// DryRun skips all HTTP requests. Each batch that would have been sent
+	// is passed to OnFlush (or logged when OnFlush is nil and Debug is set)
+	// and then treated as accepted, so no data leaves the process.
+	// Default: false
+	DryRun bool
+	// OnFlush receives every batch skipped in DryRun mode
+	OnFlush func(batch BatchRequest)
+	// FlushOnErrorCount triggers an immediate flush once this many error-status
+	// calls are buffered, regardless of BatchSize. Zero disables it.
+	// Default: 0
+	FlushOnErrorCount int
+	// MaxCallAge bounds how long calls can wait in the buffer. The
+	// background flusher flushes as soon as the oldest buffered call has
+	// waited longer than MaxCallAge, regardless of BatchSize, and calls
+	// buffered for more than three times MaxCallAge (for example, held
+	// through an outage) are dropped and passed to OnError with
+	// ErrCallTooOld. Age is measured from when a call was tracked, not from
+	// its Timestamp, so backfilled calls are not dropped. Zero disables both.
+	// Default: 0
+	MaxCallAge time.Duration
+	// SyncFlush makes Track and TrackCalls flush on the calling goroutine
+	// when a flush threshold is reached, and send completed traces inline,
+	// instead of in the background. It trades throughput for determinism
+	// and is meant for tests; Track then blocks for the full round trip.
+	// Default: false
+	SyncFlush bool
+	// PreserveTimestamps makes Track, TrackCalls and TrackAndFlush trust
+	// the caller's Timestamp and never substitute the current time. Calls
+	// with a zero Timestamp are rejected with ErrMissingTimestamp instead,
+	// for backfills where silent substitution would corrupt ordering.
+	// Default: false
+	PreserveTimestamps bool
+	// VerifyBatchDelivery asks the ingest status endpoint whether a batch
+	// already landed before retrying it after a network error, timeout or
+	// 5xx response, and once more before giving up, so a batch whose
+	// response was lost is neither re-sent nor put back in the buffer.
+	// Requires server support for /api/v1/ingest/status/{batch_id}.
+	// Default: false
+	VerifyBatchDelivery bool
+	// MetadataSink receives call metadata whose JSON encoding exceeds
+	// MetadataOffloadBytes. The metadata is replaced with a single
+	// "_offloaded" key referencing where the sink stored it. The sink is
+	// called synchronously from Track, so it should be fast or hand off work.
+	// Default: nil (no offloading)
+	MetadataSink MetadataSink
+	// MetadataOffloadBytes is the encoded metadata size above which metadata
+	// is handed to MetadataSink. Default: 8192
+	MetadataOffloadBytes int
+	// FlushByTrace holds calls that have a TraceID until their trace ends,
+	// then sends the trace's calls together in their own batch. A trace ends
+	// when a call is tracked with TrackOptions.EndOfTrace or when it reaches
+	// BatchSize calls. Calls without a TraceID are buffered as usual.
+	// Held calls are not sent by the flush timer, so traces that never end
+	// stay in memory until Close; mark the last call of every trace.
+	// Default: false
+	FlushByTrace bool
+	// DefaultExperimentID is applied to calls tracked without an
+	// ExperimentID, for service-wide experiments. Default: ""
+	DefaultExperimentID string
+	// ProjectRouting maps project IDs to the base URL of the backend that
+	// ingests their calls, for deployments with a backend per business
+	// unit. Each flush sends one batch per endpoint; calls of unmapped
+	// projects go to BaseURL. ImportCalls reports a chunk as failed if any
+	// of its endpoints fails.
+	// Default: nil (every call goes to BaseURL)
+	ProjectRouting map[string]string
+	// Environment is applied to calls tracked without an Environment,
+	// including those from the wrappers and the LangChain handler.
+	// EnvironmentAuto detects it once at startup with DetectEnvironment.
+	// Default: "" (calls keep their own Environment)
+	Environment string
+	// ModelNormalizer canonicalizes model names before calls are recorded,
+	// e.g. to group dated snapshots by model family. When it changes a
+	// name, the original is kept in Metadata["raw_model"].
+	// Default: nil (model names are recorded as is; see NormalizeModel)
+	ModelNormalizer func(provider Provider, model string) string
+	// MaxBufferSize caps the number of buffered calls. Once reached, new
+	// calls, and failed calls that no longer fit back in the buffer, are
+	// dropped and passed to OnError with ErrBufferFull; use TryTrack to
+	// detect drops. Default: 0 (unbounded)
+	MaxBufferSize int
+	// OnError receives calls the client drops instead of sending, such as
+	// calls tracked after Close, along with the reason. It acts as a
+	// dead-letter hook, e.g. to persist the calls elsewhere. It also
+	// receives a *CallbackPanicError, with no calls, when another callback
+	// panics; the client recovers instead of crashing.
+	// Default: nil (dropped calls are only logged in Debug mode)
+	OnError func(err error, calls []LLMCall)
+	// HTTPClient sends all requests made by the client. When set, the
+	// connection pool settings below are ignored and the client's own
+	// transport is used as is.
+	// Default: nil (a client with a pooled transport and a 30s timeout)
+	HTTPClient *http.Client
+	// MaxIdleConns caps idle keep-alive connections across all hosts.
+	// Default: 10
+	MaxIdleConns int
+	// MaxIdleConnsPerHost caps idle keep-alive connections to the API host.
+	// Default: 4
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept for reuse.
+	// Default: 90s
+	IdleConnTimeout time.Duration
+	// UserAgent is appended to the SDK's own User-Agent, e.g. "my-app/1.2".
+	// Default: "" (only "diagnyx-go/<Version> (go<version>)" is sent)
+	UserAgent string
+	// AuthHeader is the header that carries the API key, for gateways with
+	// their own auth conventions, e.g. "X-API-Key". Also used by
+	// NewFeedbackClientFrom.
+	// Default: "" ("Authorization")
+	AuthHeader string
+	// AuthScheme prefixes the API key in AuthHeader. With a custom
+	// AuthHeader an empty scheme sends the bare key.
+	// Default: "Bearer" for the Authorization header
+	AuthScheme string
+	// AuditWriter, if set, receives every call the client accepts as a JSON
+	// line, after timestamps, trace IDs, model normalization and metadata
+	// offload are applied and before buffering. It is an append-only record
+	// of what was captured, whether or not the call is later delivered;
+	// changes made by BeforeSend are not reflected. Writes happen on a
+	// background goroutine so a slow writer cannot stall Track.
+	AuditWriter io.Writer
+	// AuditBufferSize is how many calls may be queued for AuditWriter. When
+	// the queue is full further calls are left out of the audit log and
+	// logged as dropped.
+	// Default: 1000
+	AuditBufferSize int
+	// Encoding selects the wire format of ingest batches. EncodingMsgpack
+	// cuts egress bandwidth on high-volume hosts. An unknown encoding falls
+	// back to JSON and is logged when Debug is set. Ignored if Codec is set.
+	// Default: EncodingJSON
+	Encoding Encoding
+	// Codec marshals ingest batches and sets their Content-Type, for
+	// encoders the SDK does not ship, such as a MessagePack library.
+	// Supersedes Encoding when set.
+	// Default: nil (the codec for Encoding)
+	Codec Codec
+	// Providers prices calls for UsageReport. Use a registry of your own to
+	// price models under negotiated rates.
+	// Default: DefaultProviderRegistry
+	Providers *ProviderRegistry
 }
 
 // DefaultConfig returns a Config with default values
@@ -54,34 +308,123 @@ func DefaultConfig(apiKey string) Config {
 	}
 }
 
+// ContentCapturePolicy returns the effective capture policy, mapping
+// CaptureFullContent to CaptureBoth when CapturePolicy is unset
+func (c Config) ContentCapturePolicy() CapturePolicy {
+	if c.CapturePolicy != "" {
+		return c.CapturePolicy
+	}
+	if c.CaptureFullContent {
+		return CaptureBoth
+	}
+	return CaptureNone
+}
+
+// capturePolicy returns the capture policy for calls tracked with opts,
+// where TrackOptions.CaptureContent turns capture on or off but a
+// configured CapturePromptOnly or CaptureResponseOnly still limits it
+func (o TrackOptions) capturePolicy(config Config) CapturePolicy {
+	if o.CaptureContent == nil {
+		return config.ContentCapturePolicy()
+	}
+	if !*o.CaptureContent {
+		return CaptureNone
+	}
+	if config.CapturePolicy == CapturePromptOnly || config.CapturePolicy == CaptureResponseOnly {
+		return config.CapturePolicy
+	}
+	return CaptureBoth
+}
+
+// TruncateContent shortens content to ContentMaxLength bytes using the
+// configured TruncationStrategy and TruncationMarker. The marker is added
+// on top of the kept content and does not count towards the limit.
+func (c Config) TruncateContent(content string) string {
+	maxLength := c.ContentMaxLength
+	if maxLength <= 0 {
+		maxLength = 10000
+	}
+	if len(content) <= maxLength {
+		return content
+	}
+	marker := c.TruncationMarker
+	if marker == "" {
+		marker = "... [truncated]"
+	}
+
+	switch c.TruncationStrategy {
+	case TruncateTail:
+		return marker + content[len(content)-maxLength:]
+	case TruncateMiddle:
+		head := maxLength / 2
+		return content[:head] + marker + content[len(content)-(maxLength-head):]
+	default:
+		return content[:maxLength] + marker
+	}
+}
+
 // LLMCall represents a single LLM API call
 type LLMCall struct {
-	Provider       Provider               `json:"provider"`
-	Model          string                 `json:"model"`
-	Endpoint       string                 `json:"endpoint,omitempty"`
-	InputTokens    int                    `json:"input_tokens"`
-	OutputTokens   int                    `json:"output_tokens"`
-	LatencyMs      int64                  `json:"latency_ms"`
-	TTFTMs         *int64                 `json:"ttft_ms,omitempty"`
-	Status         CallStatus             `json:"status"`
-	ErrorCode      string                 `json:"error_code,omitempty"`
-	ErrorMessage   string                 `json:"error_message,omitempty"`
-	ProjectID      string                 `json:"project_id,omitempty"`
-	Environment    string                 `json:"environment,omitempty"`
-	UserIdentifier string                 `json:"user_identifier,omitempty"`
-	TraceID        string                 `json:"trace_id,omitempty"`
-	SpanID         string                 `json:"span_id,omitempty"`
-	Metadata       map[string]interface{} `json:"metadata,omitempty"`
-	Timestamp      time.Time              `json:"timestamp"`
+	Provider       Provider   `json:"provider"`
+	Model          string     `json:"model"`
+	Endpoint       string     `json:"endpoint,omitempty"`
+	InputTokens    int        `json:"input_tokens"`
+	OutputTokens   int        `json:"output_tokens"`
+	LatencyMs      int64      `json:"latency_ms"`
+	TTFTMs         *int64     `json:"ttft_ms,omitempty"`
+	Status         CallStatus `json:"status"`
+	ErrorCode      string     `json:"error_code,omitempty"`
+	ErrorMessage   string     `json:"error_message,omitempty"`
+	OrganizationID string     `json:"organization_id,omitempty"`
+	ProjectID      string     `json:"project_id,omitempty"`
+	Environment    string     `json:"environment,omitempty"`
+	UserIdentifier string     `json:"user_identifier,omitempty"`
+	TraceID        string     `json:"trace_id,omitempty"`
+	SpanID         string     `json:"span_id,omitempty"`
+	ParentSpanID   string     `json:"parent_span_id,omitempty"`
+	ExperimentID   string     `json:"experiment_id,omitempty"`
+	Variant        string     `json:"variant,omitempty"`
+	// CacheHit marks a response served from the caller's cache. The backend
+	// records the call and its latency but counts no tokens or cost for it.
+	CacheHit bool                   `json:"cache_hit,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// Parameters holds the model parameters of the request (temperature,
+	// max_tokens, ...), captured only if CaptureParameters=true
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Timestamp  time.Time              `json:"timestamp"`
 	// FullPrompt contains the full prompt content (only captured if CaptureFullContent=true)
 	FullPrompt string `json:"full_prompt,omitempty"`
 	// FullResponse contains the full response content (only captured if CaptureFullContent=true)
 	FullResponse string `json:"full_response,omitempty"`
+	// PromptRef and ResponseRef point to content kept in Config.ContentStore
+	// instead of FullPrompt and FullResponse
+	PromptRef   string `json:"prompt_ref,omitempty"`
+	ResponseRef string `json:"response_ref,omitempty"`
+	// RawResponse is the provider's response JSON (only captured if
+	// CaptureRawResponse=true)
+	RawResponse json.RawMessage `json:"raw_response,omitempty"`
+	// EndOfTrace marks the last call of a trace; see Config.FlushByTrace
+	EndOfTrace bool `json:"-"`
+
+	// bufferedAt is when the call entered the client's buffer, for
+	// MaxCallAge. It survives re-buffering after failed flushes.
+	bufferedAt time.Time
+	// batch is the batch the call was last sent in, so that re-sending the
+	// same calls after re-buffering reuses its BatchID
+	batch *sentBatch
 }
 
 // BatchRequest is the request body for batch ingestion
 type BatchRequest struct {
 	Calls []LLMCall `json:"calls"`
+	// SDKVersion is the version of the SDK that sent the batch
+	SDKVersion string `json:"sdk_version,omitempty"`
+	// Host is the hostname of the sending machine (best-effort)
+	Host string `json:"host,omitempty"`
+	// BatchID uniquely identifies the batch across retries, including
+	// later flushes of the same calls after they were put back in the
+	// buffer. Adding or dropping calls starts a new batch.
+	BatchID string `json:"batch_id,omitempty"`
 }
 
 // BatchResponse is the response from batch ingestion
@@ -90,18 +433,54 @@ type BatchResponse struct {
 	TotalCost   float64  `json:"total_cost"`
 	TotalTokens int      `json:"total_tokens"`
 	IDs         []string `json:"ids"`
+	// Errors lists calls the server rejected, by index in the request
+	Errors []BatchCallError `json:"errors,omitempty"`
+}
+
+// BatchCallError is a server-reported rejection of a single call in a batch
+type BatchCallError struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
 }
 
 // TrackOptions provides optional parameters for tracking
 type TrackOptions struct {
+	// OrganizationID routes tracked calls to an organization other than
+	// Config.OrganizationID
+	OrganizationID string
 	ProjectID      string
 	Environment    string
 	UserIdentifier string
 	TraceID        string
 	SpanID         string
-	Metadata       map[string]interface{}
+	// ParentSpanID links the call to the span that triggered it, for nested
+	// agent and tool calls
+	ParentSpanID string
+	// Endpoint overrides the endpoint recorded by the wrappers, for
+	// OpenAI-compatible providers whose paths differ from OpenAI's
+	Endpoint string
+	// ExperimentID and Variant tag the call for A/B analysis
+	ExperimentID string
+	Variant      string
+	// CacheHit marks tracked calls as served from the caller's cache; see
+	// LLMCall.CacheHit
+	CacheHit bool
+	Metadata map[string]interface{}
 	// FullPrompt is the full prompt content (for manual tracking with content capture)
 	FullPrompt string
 	// FullResponse is the full response content (for manual tracking with content capture)
 	FullResponse string
+	// CaptureContent overrides the content capture configuration for the
+	// calls tracked with these options, e.g. to capture a sample of calls.
+	// true captures what CapturePolicy allows, or the prompt and the
+	// response if CapturePolicy is unset or CaptureNone; false captures
+	// nothing. Used by the wrappers and TrackCallWithContent.
+	// Default: nil (use the Config)
+	CaptureContent *bool
+	// EndOfTrace marks the tracked call as the last of its trace, flushing the
+	// trace when Config.FlushByTrace is enabled
+	EndOfTrace bool
+	// OnDrop is called by the wrappers when a call could not be tracked
+	// because the buffer is full or the client is closed
+	OnDrop func(call LLMCall)
 }
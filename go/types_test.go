@@ -77,6 +77,9 @@ func TestLLMCallJSON(t *testing.T) {
 		if _, ok := result["project_id"]; ok && result["project_id"] != "" {
 			t.Error("project_id should be omitted when empty")
 		}
+		if _, ok := result["cache_hit"]; ok {
+			t.Error("cache_hit should be omitted when false")
+		}
 	})
 
 	t.Run("unmarshals correctly", func(t *testing.T) {
@@ -141,6 +144,7 @@ func TestCallStatusConstants(t *testing.T) {
 		{StatusError, "error"},
 		{StatusTimeout, "timeout"},
 		{StatusRateLimited, "rate_limited"},
+		{StatusFiltered, "filtered"},
 	}
 
 	for _, tt := range tests {
@@ -232,3 +236,48 @@ func TestTrackOptions(t *testing.T) {
 		t.Error("expected metadata to contain custom key")
 	}
 }
+
+func TestContentCapturePolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		expected CapturePolicy
+	}{
+		{"default captures nothing", Config{}, CaptureNone},
+		{"full content maps to both", Config{CaptureFullContent: true}, CaptureBoth},
+		{"policy supersedes boolean", Config{CaptureFullContent: true, CapturePolicy: CaptureResponseOnly}, CaptureResponseOnly},
+		{"explicit none", Config{CaptureFullContent: true, CapturePolicy: CaptureNone}, CaptureNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.ContentCapturePolicy(); got != tt.expected {
+				t.Errorf("expected policy '%s', got '%s'", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestTruncateContent(t *testing.T) {
+	content := "0123456789"
+
+	tests := []struct {
+		name     string
+		config   Config
+		expected string
+	}{
+		{"short content is kept", Config{ContentMaxLength: 20}, content},
+		{"head by default", Config{ContentMaxLength: 4}, "0123... [truncated]"},
+		{"tail", Config{ContentMaxLength: 4, TruncationStrategy: TruncateTail}, "... [truncated]6789"},
+		{"middle", Config{ContentMaxLength: 5, TruncationStrategy: TruncateMiddle, TruncationMarker: "~"}, "01~789"},
+		{"custom marker", Config{ContentMaxLength: 4, TruncationMarker: " [cut]"}, "0123 [cut]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.TruncateContent(content); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
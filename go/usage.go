@@ -0,0 +1,124 @@
+package diagnyx
+
+import (
+	"sync"
+	"time"
+)
+
+// UsageTotals accumulates usage for a group of tracked calls
+type UsageTotals struct {
+	Calls        int64
+	InputTokens  int64
+	OutputTokens int64
+	// EstimatedCost is in USD, priced per model with Config.Providers (see
+	// ProviderRegistry.Price). Models without pricing contribute zero.
+	EstimatedCost float64
+	// Errors counts calls with StatusError or StatusTimeout
+	Errors int64
+}
+
+func (t *UsageTotals) add(call LLMCall, cost float64) {
+	t.Calls++
+	t.InputTokens += int64(call.InputTokens)
+	t.OutputTokens += int64(call.OutputTokens)
+	t.EstimatedCost += cost
+	if call.Status == StatusError || call.Status == StatusTimeout {
+		t.Errors++
+	}
+}
+
+func (t *UsageTotals) merge(other UsageTotals) {
+	t.Calls += other.Calls
+	t.InputTokens += other.InputTokens
+	t.OutputTokens += other.OutputTokens
+	t.EstimatedCost += other.EstimatedCost
+	t.Errors += other.Errors
+}
+
+// UsageKey identifies a provider and model pair in a UsageReport
+type UsageKey struct {
+	Provider Provider
+	Model    string
+}
+
+// UsageReport is a local, cumulative view of tracked usage
+type UsageReport struct {
+	// Since is when accounting started: client creation or the last
+	// ResetUsageReport
+	Since      time.Time
+	Total      UsageTotals
+	ByProvider map[Provider]UsageTotals
+	ByModel    map[UsageKey]UsageTotals
+}
+
+// usageAccumulator keeps per-model totals under a mutex; provider and
+// overall totals are derived when a report is taken
+type usageAccumulator struct {
+	mu      sync.Mutex
+	since   time.Time
+	byModel map[UsageKey]*UsageTotals
+}
+
+func newUsageAccumulator() *usageAccumulator {
+	return &usageAccumulator{since: time.Now(), byModel: make(map[UsageKey]*UsageTotals)}
+}
+
+func (u *usageAccumulator) add(call LLMCall, cost float64) {
+	key := UsageKey{Provider: call.Provider, Model: call.Model}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	totals, ok := u.byModel[key]
+	if !ok {
+		totals = &UsageTotals{}
+		u.byModel[key] = totals
+	}
+	totals.add(call, cost)
+}
+
+func (u *usageAccumulator) report() UsageReport {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	report := UsageReport{
+		Since:      u.since,
+		ByProvider: make(map[Provider]UsageTotals),
+		ByModel:    make(map[UsageKey]UsageTotals, len(u.byModel)),
+	}
+	for key, totals := range u.byModel {
+		report.ByModel[key] = *totals
+		provider := report.ByProvider[key.Provider]
+		provider.merge(*totals)
+		report.ByProvider[key.Provider] = provider
+		report.Total.merge(*totals)
+	}
+	return report
+}
+
+func (u *usageAccumulator) reset() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.since = time.Now()
+	u.byModel = make(map[UsageKey]*UsageTotals)
+}
+
+// estimateCost prices a call with the client's provider registry. Cache
+// hits cost nothing.
+func (c *Client) estimateCost(call LLMCall) float64 {
+	if call.CacheHit {
+		return 0
+	}
+	price, _ := c.config.Providers.Price(call.Provider, call.Model)
+	return (float64(call.InputTokens)*price.InputCostPer1M + float64(call.OutputTokens)*price.OutputCostPer1M) / 1e6
+}
+
+// UsageReport returns cumulative usage by provider and model for calls
+// tracked by this client. Calls dropped by the Sampler, or rejected because
+// the buffer is full or the client is closed, are not counted.
+// Cache hits count as calls but add no estimated cost.
+func (c *Client) UsageReport() UsageReport {
+	return c.usage.report()
+}
+
+// ResetUsageReport clears the totals returned by UsageReport
+func (c *Client) ResetUsageReport() {
+	c.usage.reset()
+}
@@ -0,0 +1,79 @@
+package diagnyx
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUsageReport(t *testing.T) {
+	providers := NewProviderRegistry()
+	providers.Register(ProviderInfo{Provider: "priced", InputCostPer1M: 2, OutputCostPer1M: 10})
+
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, Providers: providers})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	client.Track(LLMCall{Provider: "priced", Model: "a", Status: StatusSuccess, InputTokens: 1000, OutputTokens: 100})
+	client.Track(LLMCall{Provider: "priced", Model: "a", Status: StatusError, InputTokens: 500})
+	client.Track(LLMCall{Provider: "priced", Model: "b", Status: StatusSuccess, InputTokens: 1000, CacheHit: true})
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusTimeout, InputTokens: 10, OutputTokens: 5})
+
+	report := client.UsageReport()
+	a := report.ByModel[UsageKey{Provider: "priced", Model: "a"}]
+	if a.Calls != 2 || a.InputTokens != 1500 || a.OutputTokens != 100 || a.Errors != 1 {
+		t.Errorf("unexpected totals for model a: %+v", a)
+	}
+	if math.Abs(a.EstimatedCost-0.004) > 1e-9 {
+		t.Errorf("expected cost 0.004, got %v", a.EstimatedCost)
+	}
+	if b := report.ByModel[UsageKey{Provider: "priced", Model: "b"}]; b.EstimatedCost != 0 {
+		t.Errorf("expected cache hits to add no cost, got %v", b.EstimatedCost)
+	}
+	if p := report.ByProvider["priced"]; p.Calls != 3 || p.InputTokens != 2500 {
+		t.Errorf("unexpected provider totals: %+v", p)
+	}
+	if report.Total.Calls != 4 || report.Total.Errors != 2 {
+		t.Errorf("unexpected overall totals: %+v", report.Total)
+	}
+
+	since := report.Since
+	client.ResetUsageReport()
+	report = client.UsageReport()
+	if report.Total.Calls != 0 || len(report.ByModel) != 0 {
+		t.Errorf("expected empty report after reset, got %+v", report)
+	}
+	if report.Since.Before(since) {
+		t.Error("expected Since to move forward on reset")
+	}
+}
+
+func TestUsageReportPricesModels(t *testing.T) {
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4o-2024-08-06", Status: StatusSuccess, InputTokens: 1000000})
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4o-mini", Status: StatusSuccess, InputTokens: 1000000})
+
+	report := client.UsageReport()
+	if cost := report.ByModel[UsageKey{Provider: ProviderOpenAI, Model: "gpt-4o-2024-08-06"}].EstimatedCost; math.Abs(cost-2.5) > 1e-9 {
+		t.Errorf("expected gpt-4o to cost 2.5, got %v", cost)
+	}
+	if cost := report.ByModel[UsageKey{Provider: ProviderOpenAI, Model: "gpt-4o-mini"}].EstimatedCost; math.Abs(cost-0.15) > 1e-9 {
+		t.Errorf("expected gpt-4o-mini to cost 0.15, got %v", cost)
+	}
+}
+
+func TestUsageReportSkipsRejectedCalls(t *testing.T) {
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, MaxBufferSize: 1, OnError: func(error, []LLMCall) {}})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess})
+	client.TrackCalls([]LLMCall{{Provider: ProviderOpenAI, Model: "gpt-4", Status: StatusSuccess}})
+
+	if calls := client.UsageReport().Total.Calls; calls != 1 {
+		t.Errorf("expected only the buffered call to be counted, got %d", calls)
+	}
+}
@@ -0,0 +1,17 @@
+package diagnyx
+
+import "runtime"
+
+// Version is the Diagnyx Go SDK version
+const Version = "0.1.0"
+
+// UserAgent returns the User-Agent header sent with SDK requests, e.g.
+// "diagnyx-go/0.1.0 (go1.22.1)". A non-empty extra is appended after a
+// space so callers can identify their application without hiding the SDK.
+func UserAgent(extra string) string {
+	ua := "diagnyx-go/" + Version + " (" + runtime.Version() + ")"
+	if extra != "" {
+		ua += " " + extra
+	}
+	return ua
+}
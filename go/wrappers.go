@@ -3,30 +3,82 @@ package diagnyx
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http/httptrace"
+	"net/url"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sashabaranov/go-openai"
 )
 
-// truncateContent truncates a string to maxLength, appending "... [truncated]" if needed
-func truncateContent(content string, maxLength int) string {
-	if maxLength <= 0 {
-		maxLength = 10000
+// rawResponse marshals a provider response for LLMCall.RawResponse. JSON
+// longer than ContentMaxLength is truncated and wrapped in a JSON string so
+// the batch stays valid.
+func rawResponse(resp interface{}, config Config) json.RawMessage {
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return nil
 	}
-	if len(content) > maxLength {
-		return content[:maxLength] + "... [truncated]"
+	if truncated := config.TruncateContent(string(raw)); truncated != string(raw) {
+		raw, _ = json.Marshal(truncated)
 	}
-	return content
+	return raw
 }
 
-// extractOpenAIPrompt extracts prompt content from OpenAI messages
-func extractOpenAIPrompt(messages []openai.ChatCompletionMessage, maxLength int) string {
-	if len(messages) == 0 {
-		return ""
+// errorStatus maps a call error to a CallStatus, distinguishing timeouts and
+// cancellations from other API errors
+func errorStatus(err error) CallStatus {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return StatusTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return StatusTimeout
+	}
+	return StatusError
+}
+
+// withBilledOnError records the tokens a provider may bill for a failed
+// chat completion and marks the call with Metadata["billed_on_error"]. Usage
+// reported alongside the error is used as is. Otherwise, for errors raised
+// after the provider accepted the request (5xx API errors, and timeouts once
+// the request was sent), the prompt tokens are estimated with EstimateTokens
+// and the call is also marked with Metadata["tokens_estimated"]. Other
+// errors, such as 4xx API errors, are not billed and leave the token counts
+// at 0.
+func withBilledOnError(call LLMCall, err error, sent bool, usage openai.Usage, messages []openai.ChatCompletionMessage) LLMCall {
+	switch {
+	case usage.PromptTokens > 0 || usage.CompletionTokens > 0:
+		call.InputTokens = usage.PromptTokens
+		call.OutputTokens = usage.CompletionTokens
+	case billedError(err, sent):
+		call.InputTokens = EstimateTokens(formatOpenAIPrompt(messages))
+		call.Metadata = withMetadata(call.Metadata, "tokens_estimated", true)
+	default:
+		return call
 	}
+	call.Metadata = withMetadata(call.Metadata, "billed_on_error", true)
+	return call
+}
 
+// billedError reports whether err was raised after the provider accepted
+// the request and may have started generating
+func billedError(err error, sent bool) bool {
+	if errorStatus(err) == StatusTimeout {
+		return sent
+	}
+	var apiErr *openai.APIError
+	return errors.As(err, &apiErr) && apiErr.HTTPStatusCode >= 500
+}
+
+// formatOpenAIPrompt renders messages as "[role]: content" lines
+func formatOpenAIPrompt(messages []openai.ChatCompletionMessage) string {
 	var parts []string
 	for _, m := range messages {
 		var content string
@@ -50,79 +102,319 @@ func extractOpenAIPrompt(messages []openai.ChatCompletionMessage, maxLength int)
 		parts = append(parts, fmt.Sprintf("[%s]: %s", m.Role, content))
 	}
 
-	return truncateContent(strings.Join(parts, "\n"), maxLength)
+	return strings.Join(parts, "\n")
+}
+
+// IsLengthFinishReason reports whether a provider's finish or stop reason
+// means the response was cut off by the output token limit: "length"
+// (OpenAI), "max_tokens" (Anthropic) or "MAX_TOKENS" (Google)
+func IsLengthFinishReason(reason string) bool {
+	switch strings.ToLower(reason) {
+	case "length", "max_tokens":
+		return true
+	}
+	return false
+}
+
+// withFinishReason marks content-filtered calls as StatusFiltered and
+// responses cut off by max_tokens with Metadata["truncated"]
+func withFinishReason(call LLMCall, reason openai.FinishReason) LLMCall {
+	switch {
+	case reason == openai.FinishReasonContentFilter:
+		call.Status = StatusFiltered
+		call.Metadata = withMetadata(call.Metadata, "finish_reason", string(reason))
+	case IsLengthFinishReason(string(reason)):
+		call.Metadata = withMetadata(call.Metadata, "truncated", true)
+	}
+	return call
 }
 
 // extractOpenAIResponse extracts response content from OpenAI completion
-func extractOpenAIResponse(resp openai.ChatCompletionResponse, maxLength int) string {
+func extractOpenAIResponse(resp openai.ChatCompletionResponse) string {
 	if len(resp.Choices) == 0 {
 		return ""
 	}
-	content := resp.Choices[0].Message.Content
-	return truncateContent(content, maxLength)
+	return resp.Choices[0].Message.Content
+}
+
+// extractOpenAIParameters returns the model parameters set on a chat request
+func extractOpenAIParameters(req openai.ChatCompletionRequest) map[string]interface{} {
+	params := make(map[string]interface{})
+	if req.Temperature != 0 {
+		params["temperature"] = req.Temperature
+	}
+	if req.TopP != 0 {
+		params["top_p"] = req.TopP
+	}
+	if req.MaxTokens != 0 {
+		params["max_tokens"] = req.MaxTokens
+	}
+	if req.PresencePenalty != 0 {
+		params["presence_penalty"] = req.PresencePenalty
+	}
+	if req.FrequencyPenalty != 0 {
+		params["frequency_penalty"] = req.FrequencyPenalty
+	}
+	if req.Seed != nil {
+		params["seed"] = *req.Seed
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
+// withMetadata returns a copy of metadata with key set to value, leaving the
+// original map (often shared through TrackOptions) unchanged
+func withMetadata(metadata map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(metadata)+1)
+	for k, v := range metadata {
+		result[k] = v
+	}
+	result[key] = value
+	return result
+}
+
+// firstByteTimer measures the time between writing a request and receiving
+// the first byte of its response, as an approximation of time to first
+// token for non-streaming calls. With retries the last attempt wins.
+type firstByteTimer struct {
+	mu        sync.Mutex
+	wrote     time.Time
+	firstByte time.Time
+}
+
+// withFirstByteTimer attaches an httptrace.ClientTrace to ctx that feeds a
+// new firstByteTimer, keeping any trace already on ctx
+func withFirstByteTimer(ctx context.Context) (context.Context, *firstByteTimer) {
+	t := &firstByteTimer{}
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			t.wrote = time.Now()
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.firstByte = time.Now()
+			t.mu.Unlock()
+		},
+	}
+	return httptrace.WithClientTrace(ctx, trace), t
+}
+
+// ttftMs returns the measured gap in milliseconds, or nil if no response
+// byte was seen
+func (t *firstByteTimer) ttftMs() *int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.firstByte.IsZero() || t.wrote.IsZero() || t.firstByte.Before(t.wrote) {
+		return nil
+	}
+	ms := t.firstByte.Sub(t.wrote).Milliseconds()
+	return &ms
+}
+
+// sent reports whether a request was written
+func (t *firstByteTimer) sent() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !t.wrote.IsZero()
+}
+
+// OpenAIClient is the subset of *openai.Client methods used by OpenAIWrapper.
+// Implement it to wrap a custom client or a test stub.
+type OpenAIClient interface {
+	CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error)
 }
 
 // OpenAIWrapper wraps an OpenAI client for automatic tracking
 type OpenAIWrapper struct {
-	client  *openai.Client
-	diagnyx *Client
+	client  OpenAIClient
+	diagnyx Tracker
 	opts    TrackOptions
+	dropped atomic.Int64
+	// provider is detected from the client's base URL; host is that URL's
+	// host when it is not OpenAI's own API
+	provider Provider
+	host     string
 }
 
-// WrapOpenAI wraps an OpenAI client for automatic call tracking
-func WrapOpenAI(client *openai.Client, diagnyx *Client, opts ...TrackOptions) *OpenAIWrapper {
+// WrapOpenAI wraps an OpenAI client for automatic call tracking.
+//
+// go-openai clients are often pointed at OpenAI-compatible endpoints, so
+// for an *openai.Client the provider is detected from its ClientConfig:
+// Azure (by APIType or an *.openai.azure.com host), AWS, Google and
+// Anthropic hosts get their Provider, other known hosts such as
+// api.groq.com get ProviderCustom, and unknown hosts keep ProviderOpenAI.
+// Calls to any host but api.openai.com carry it in
+// Metadata["base_url_host"].
+func WrapOpenAI(client OpenAIClient, diagnyx Tracker, opts ...TrackOptions) *OpenAIWrapper {
 	var trackOpts TrackOptions
 	if len(opts) > 0 {
 		trackOpts = opts[0]
 	}
-	return &OpenAIWrapper{
-		client:  client,
-		diagnyx: diagnyx,
-		opts:    trackOpts,
+	w := &OpenAIWrapper{
+		client:   client,
+		diagnyx:  diagnyx,
+		opts:     trackOpts,
+		provider: ProviderOpenAI,
 	}
+	if c, ok := client.(*openai.Client); ok {
+		w.provider, w.host = detectOpenAIProvider(c)
+	}
+	return w
 }
 
-// CreateChatCompletion creates a chat completion and tracks the call
-func (w *OpenAIWrapper) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
-	start := time.Now()
+// detectOpenAIProvider returns the provider and, unless it is OpenAI's own
+// API, the host that a go-openai client sends requests to. The client keeps
+// its ClientConfig unexported, so it is read by reflection.
+func detectOpenAIProvider(client *openai.Client) (Provider, string) {
+	if client == nil {
+		return ProviderOpenAI, ""
+	}
+	config := reflect.ValueOf(client).Elem().FieldByName("config")
+	if !config.IsValid() || config.Kind() != reflect.Struct {
+		return ProviderOpenAI, ""
+	}
 
-	resp, err := w.client.CreateChatCompletion(ctx, req)
+	var host string
+	if baseURL := config.FieldByName("BaseURL"); baseURL.Kind() == reflect.String {
+		if u, err := url.Parse(baseURL.String()); err == nil && u.Hostname() != openAIHost {
+			host = u.Hostname()
+		}
+	}
+	if apiType := config.FieldByName("APIType"); apiType.Kind() == reflect.String {
+		switch openai.APIType(apiType.String()) {
+		case openai.APITypeAzure, openai.APITypeAzureAD:
+			return ProviderAzure, host
+		}
+	}
+	return providerForHost(host), host
+}
 
-	latencyMs := time.Since(start).Milliseconds()
+// spanID returns the span ID for the next tracked call. With AutoGenerateTraceID
+// each call under a fixed TraceID gets its own span; calls without a TraceID
+// are left for Track to assign both.
+func (w *OpenAIWrapper) spanID() string {
+	if w.opts.SpanID == "" && w.opts.TraceID != "" && w.diagnyx.Config().AutoGenerateTraceID {
+		return NewSpanID()
+	}
+	return w.opts.SpanID
+}
+
+// endpoint returns TrackOptions.Endpoint if set, otherwise the OpenAI path
+func (w *OpenAIWrapper) endpoint(defaultPath string) string {
+	if w.opts.Endpoint != "" {
+		return w.opts.Endpoint
+	}
+	return defaultPath
+}
 
+// newCall returns an LLMCall for a request to defaultPath, populated from
+// the wrapper's TrackOptions and detected provider
+func (w *OpenAIWrapper) newCall(model, defaultPath string, latencyMs int64) LLMCall {
 	call := LLMCall{
-		Provider:       ProviderOpenAI,
-		Model:          req.Model,
-		Endpoint:       "/v1/chat/completions",
+		Provider:       w.provider,
+		Model:          model,
+		Endpoint:       w.endpoint(defaultPath),
 		LatencyMs:      latencyMs,
+		OrganizationID: w.opts.OrganizationID,
 		ProjectID:      w.opts.ProjectID,
 		Environment:    w.opts.Environment,
 		UserIdentifier: w.opts.UserIdentifier,
 		TraceID:        w.opts.TraceID,
-		SpanID:         w.opts.SpanID,
+		SpanID:         w.spanID(),
+		ParentSpanID:   w.opts.ParentSpanID,
+		ExperimentID:   w.opts.ExperimentID,
+		Variant:        w.opts.Variant,
+		CacheHit:       w.opts.CacheHit,
 		Metadata:       w.opts.Metadata,
+		EndOfTrace:     w.opts.EndOfTrace,
 		Timestamp:      time.Now().UTC(),
 	}
+	if w.host != "" {
+		call.Metadata = withMetadata(call.Metadata, "base_url_host", w.host)
+	}
+	return call
+}
+
+// track records a sampled call and reports calls the client dropped
+func (w *OpenAIWrapper) track(call LLMCall) {
+	if trackSampled(w.diagnyx, call) {
+		return
+	}
+	w.dropped.Add(1)
+	if w.opts.OnDrop == nil {
+		return
+	}
+	if c, ok := w.diagnyx.(*Client); ok {
+		c.runCallback("OnDrop", func() { w.opts.OnDrop(call) })
+	} else {
+		w.opts.OnDrop(call)
+	}
+}
+
+// DroppedCalls returns the number of calls this wrapper could not track
+// because the client's buffer was full or the client was closed
+func (w *OpenAIWrapper) DroppedCalls() int64 {
+	return w.dropped.Load()
+}
+
+// CreateChatCompletion creates a chat completion and tracks the call. When
+// the client sends the request over net/http, TTFTMs is set to the time
+// between writing the request and the first response byte. Failed calls
+// the provider may still bill for carry token counts; see withBilledOnError.
+func (w *OpenAIWrapper) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	ctx, timer := withFirstByteTimer(ctx)
+	start := time.Now()
+
+	resp, err := w.client.CreateChatCompletion(ctx, req)
+
+	latencyMs := time.Since(start).Milliseconds()
+
+	call := w.newCall(req.Model, "/v1/chat/completions", latencyMs)
+	call.TTFTMs = timer.ttftMs()
 
 	if err != nil {
-		call.Status = StatusError
+		call.Status = errorStatus(err)
 		call.ErrorMessage = err.Error()
-		call.InputTokens = 0
-		call.OutputTokens = 0
+		call = withBilledOnError(call, err, timer.sent(), resp.Usage, req.Messages)
 	} else {
 		call.Status = StatusSuccess
 		call.InputTokens = resp.Usage.PromptTokens
 		call.OutputTokens = resp.Usage.CompletionTokens
+		if len(resp.Choices) > 0 {
+			call = withFinishReason(call, resp.Choices[0].FinishReason)
+		}
+	}
+
+	// Decide sampling before extracting content so dropped calls skip it
+	if !shouldTrack(w.diagnyx, call) {
+		return resp, err
+	}
 
+	config := w.diagnyx.Config()
+	if config.CaptureParameters {
+		call.Parameters = extractOpenAIParameters(req)
+	}
+
+	if err == nil {
 		// Extract content if enabled
-		config := w.diagnyx.Config()
-		if config.CaptureFullContent {
-			call.FullPrompt = extractOpenAIPrompt(req.Messages, config.ContentMaxLength)
-			call.FullResponse = extractOpenAIResponse(resp, config.ContentMaxLength)
+		policy := w.opts.capturePolicy(config)
+		if policy.CapturesPrompt() {
+			call.SetPrompt(ctx, config, formatOpenAIPrompt(req.Messages))
+		}
+		if policy.CapturesResponse() {
+			call.SetResponse(ctx, config, extractOpenAIResponse(resp))
+		}
+		if config.CaptureRawResponse {
+			call.RawResponse = rawResponse(resp, config)
 		}
 	}
 
-	w.diagnyx.Track(call)
+	w.track(call)
 
 	return resp, err
 }
@@ -133,24 +425,20 @@ func (w *OpenAIWrapper) CreateEmbeddings(ctx context.Context, req openai.Embeddi
 
 	resp, err := w.client.CreateEmbeddings(ctx, req)
 
-	latencyMs := time.Since(start).Milliseconds()
-
-	call := LLMCall{
-		Provider:       ProviderOpenAI,
-		Model:          fmt.Sprintf("%v", req.Model),
-		Endpoint:       "/v1/embeddings",
-		LatencyMs:      latencyMs,
-		ProjectID:      w.opts.ProjectID,
-		Environment:    w.opts.Environment,
-		UserIdentifier: w.opts.UserIdentifier,
-		TraceID:        w.opts.TraceID,
-		SpanID:         w.opts.SpanID,
-		Metadata:       w.opts.Metadata,
-		Timestamp:      time.Now().UTC(),
+	call := w.embeddingCall(req, resp, err, time.Since(start).Milliseconds())
+	if shouldTrack(w.diagnyx, call) {
+		w.track(call)
 	}
 
+	return resp, err
+}
+
+// embeddingCall builds the LLMCall recorded for an embeddings request
+func (w *OpenAIWrapper) embeddingCall(req openai.EmbeddingRequest, resp openai.EmbeddingResponse, err error, latencyMs int64) LLMCall {
+	call := w.newCall(fmt.Sprintf("%v", req.Model), "/v1/embeddings", latencyMs)
+
 	if err != nil {
-		call.Status = StatusError
+		call.Status = errorStatus(err)
 		call.ErrorMessage = err.Error()
 		call.InputTokens = 0
 		call.OutputTokens = 0
@@ -159,19 +447,23 @@ func (w *OpenAIWrapper) CreateEmbeddings(ctx context.Context, req openai.Embeddi
 		call.InputTokens = resp.Usage.PromptTokens
 		call.OutputTokens = 0
 	}
-
-	w.diagnyx.Track(call)
-
-	return resp, err
+	return call
 }
 
-// Underlying returns the underlying OpenAI client for direct access
+// Underlying returns the underlying OpenAI client for direct access.
+// It returns nil if the wrapper was created with a custom OpenAIClient.
 func (w *OpenAIWrapper) Underlying() *openai.Client {
+	client, _ := w.client.(*openai.Client)
+	return client
+}
+
+// Client returns the wrapped OpenAIClient
+func (w *OpenAIWrapper) Client() OpenAIClient {
 	return w.client
 }
 
 // TrackCall is a helper to manually track any LLM call
-func TrackCall(diagnyx *Client, provider Provider, model string, fn func() (inputTokens, outputTokens int, err error), opts ...TrackOptions) error {
+func TrackCall(diagnyx Tracker, provider Provider, model string, fn func() (inputTokens, outputTokens int, err error), opts ...TrackOptions) error {
 	var trackOpts TrackOptions
 	if len(opts) > 0 {
 		trackOpts = opts[0]
@@ -184,22 +476,29 @@ func TrackCall(diagnyx *Client, provider Provider, model string, fn func() (inpu
 	call := LLMCall{
 		Provider:       provider,
 		Model:          model,
+		Endpoint:       trackOpts.Endpoint,
 		InputTokens:    inputTokens,
 		OutputTokens:   outputTokens,
 		LatencyMs:      latencyMs,
+		OrganizationID: trackOpts.OrganizationID,
 		ProjectID:      trackOpts.ProjectID,
 		Environment:    trackOpts.Environment,
 		UserIdentifier: trackOpts.UserIdentifier,
 		TraceID:        trackOpts.TraceID,
 		SpanID:         trackOpts.SpanID,
+		ParentSpanID:   trackOpts.ParentSpanID,
+		ExperimentID:   trackOpts.ExperimentID,
+		Variant:        trackOpts.Variant,
+		CacheHit:       trackOpts.CacheHit,
 		Metadata:       trackOpts.Metadata,
+		EndOfTrace:     trackOpts.EndOfTrace,
 		Timestamp:      time.Now().UTC(),
 		FullPrompt:     trackOpts.FullPrompt,
 		FullResponse:   trackOpts.FullResponse,
 	}
 
 	if err != nil {
-		call.Status = StatusError
+		call.Status = errorStatus(err)
 		call.ErrorMessage = err.Error()
 	} else {
 		call.Status = StatusSuccess
@@ -213,7 +512,7 @@ func TrackCall(diagnyx *Client, provider Provider, model string, fn func() (inpu
 // TrackCallWithContent is a helper to track any LLM call with full content capture
 // Use this for providers without dedicated wrappers (like Anthropic in Go)
 func TrackCallWithContent(
-	diagnyx *Client,
+	diagnyx Tracker,
 	provider Provider,
 	model string,
 	prompt string,
@@ -228,31 +527,36 @@ func TrackCallWithContent(
 		trackOpts = opts[0]
 	}
 
-	config := diagnyx.Config()
-	fullPrompt := ""
-	fullResponse := ""
-
-	if config.CaptureFullContent {
-		fullPrompt = truncateContent(prompt, config.ContentMaxLength)
-		fullResponse = truncateContent(response, config.ContentMaxLength)
-	}
-
 	call := LLMCall{
 		Provider:       provider,
 		Model:          model,
+		Endpoint:       trackOpts.Endpoint,
 		InputTokens:    inputTokens,
 		OutputTokens:   outputTokens,
 		LatencyMs:      latencyMs,
 		Status:         StatusSuccess,
+		OrganizationID: trackOpts.OrganizationID,
 		ProjectID:      trackOpts.ProjectID,
 		Environment:    trackOpts.Environment,
 		UserIdentifier: trackOpts.UserIdentifier,
 		TraceID:        trackOpts.TraceID,
 		SpanID:         trackOpts.SpanID,
+		ParentSpanID:   trackOpts.ParentSpanID,
+		ExperimentID:   trackOpts.ExperimentID,
+		Variant:        trackOpts.Variant,
+		CacheHit:       trackOpts.CacheHit,
 		Metadata:       trackOpts.Metadata,
+		EndOfTrace:     trackOpts.EndOfTrace,
 		Timestamp:      time.Now().UTC(),
-		FullPrompt:     fullPrompt,
-		FullResponse:   fullResponse,
+	}
+
+	config := diagnyx.Config()
+	policy := trackOpts.capturePolicy(config)
+	if policy.CapturesPrompt() {
+		call.SetPrompt(context.Background(), config, prompt)
+	}
+	if policy.CapturesResponse() {
+		call.SetResponse(context.Background(), config, response)
 	}
 
 	diagnyx.Track(call)
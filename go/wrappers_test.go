@@ -0,0 +1,808 @@
+package diagnyx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func newTestOpenAIClient(url string) *openai.Client {
+	config := openai.DefaultConfig("test-openai-key")
+	config.BaseURL = url + "/v1"
+	return openai.NewClientWithConfig(config)
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected CallStatus
+	}{
+		{"deadline exceeded", context.DeadlineExceeded, StatusTimeout},
+		{"canceled", context.Canceled, StatusTimeout},
+		{"wrapped deadline", fmt.Errorf("request failed: %w", context.DeadlineExceeded), StatusTimeout},
+		{"generic error", errors.New("boom"), StatusError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorStatus(tt.err); got != tt.expected {
+				t.Errorf("expected status '%s', got '%s'", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestOpenAIWrapperTimeout(t *testing.T) {
+	openaiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer openaiServer.Close()
+
+	server := newMockServer()
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+	})
+	defer client.Close()
+
+	wrapped := WrapOpenAI(newTestOpenAIClient(openaiServer.URL), client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := wrapped.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:    openai.GPT4,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hello"}},
+	})
+	if err == nil {
+		t.Fatal("expected error for canceled context")
+	}
+
+	calls := client.PeekBuffer()
+	if len(calls) != 1 {
+		t.Fatalf("expected 1 tracked call, got %d", len(calls))
+	}
+	if calls[0].Status != StatusTimeout {
+		t.Errorf("expected status 'timeout', got '%s'", calls[0].Status)
+	}
+	if calls[0].InputTokens != 0 || calls[0].Metadata["billed_on_error"] != nil {
+		t.Errorf("expected a request canceled before sending not to be billed, got %+v", calls[0])
+	}
+}
+
+func TestTrackCallTimeout(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		BaseURL:         server.URL,
+		FlushIntervalMs: 60000,
+	})
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := TrackCall(client, ProviderAnthropic, "claude-3-sonnet", func() (int, int, error) {
+		return 0, 0, ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	calls := client.PeekBuffer()
+	if len(calls) != 1 {
+		t.Fatalf("expected 1 tracked call, got %d", len(calls))
+	}
+	if calls[0].Status != StatusTimeout {
+		t.Errorf("expected status 'timeout', got '%s'", calls[0].Status)
+	}
+}
+
+func TestTrackCallWithContentCapturePolicy(t *testing.T) {
+	tests := []struct {
+		policy       CapturePolicy
+		wantPrompt   bool
+		wantResponse bool
+	}{
+		{CaptureNone, false, false},
+		{CapturePromptOnly, true, false},
+		{CaptureResponseOnly, false, true},
+		{CaptureBoth, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			server := newMockServer()
+			defer server.Close()
+
+			client := NewClientWithConfig(Config{
+				APIKey:          "test-key",
+				BaseURL:         server.URL,
+				FlushIntervalMs: 60000,
+				CapturePolicy:   tt.policy,
+			})
+			defer client.Close()
+
+			TrackCallWithContent(client, ProviderAnthropic, "claude-3-sonnet", "prompt", "response", 10, 5, 100)
+
+			call := client.PeekBuffer()[0]
+			if (call.FullPrompt != "") != tt.wantPrompt {
+				t.Errorf("expected prompt captured=%v, got '%s'", tt.wantPrompt, call.FullPrompt)
+			}
+			if (call.FullResponse != "") != tt.wantResponse {
+				t.Errorf("expected response captured=%v, got '%s'", tt.wantResponse, call.FullResponse)
+			}
+		})
+	}
+}
+
+func TestCaptureContentOverride(t *testing.T) {
+	capture, skip := true, false
+	tests := []struct {
+		name         string
+		global       bool
+		policy       CapturePolicy
+		override     *bool
+		wantPrompt   bool
+		wantResponse bool
+	}{
+		{"enables capture when globally off", false, "", &capture, true, true},
+		{"disables capture when globally on", true, "", &skip, false, false},
+		{"falls back to the config", true, "", nil, true, true},
+		{"enables capture under CaptureNone", false, CaptureNone, &capture, true, true},
+		{"keeps a response-only policy", false, CaptureResponseOnly, &capture, false, true},
+	}
+
+	openaiServer := newChatCompletionServer()
+	defer openaiServer.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &fakeTracker{config: Config{CaptureFullContent: tt.global, CapturePolicy: tt.policy}}
+			opts := TrackOptions{CaptureContent: tt.override}
+
+			TrackCallWithContent(tracker, ProviderAnthropic, "claude-3-sonnet", "prompt", "response", 10, 5, 100, opts)
+			wrapped := WrapOpenAI(newTestOpenAIClient(openaiServer.URL), tracker, opts)
+			_, err := wrapped.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+				Model:    openai.GPT4,
+				Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hello"}},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(tracker.calls) != 2 {
+				t.Fatalf("expected 2 tracked calls, got %d", len(tracker.calls))
+			}
+			for _, call := range tracker.calls {
+				if (call.FullPrompt != "") != tt.wantPrompt || (call.FullResponse != "") != tt.wantResponse {
+					t.Errorf("expected prompt captured=%v and response captured=%v for %s, got %q/%q", tt.wantPrompt, tt.wantResponse, call.Model, call.FullPrompt, call.FullResponse)
+				}
+			}
+		})
+	}
+}
+
+func newChatCompletionServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Model: openai.GPT4,
+			Choices: []openai.ChatCompletionChoice{
+				{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "Hi there!"}},
+			},
+			Usage: openai.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		})
+	}))
+}
+
+func TestOpenAIWrapperSampling(t *testing.T) {
+	openaiServer := newChatCompletionServer()
+	defer openaiServer.Close()
+
+	sampled := 0
+	client := NewClientWithConfig(Config{
+		APIKey:             "test-key",
+		FlushIntervalMs:    60000,
+		CaptureFullContent: true,
+		Sampler: SamplerFunc(func(LLMCall) bool {
+			sampled++
+			return false
+		}),
+	})
+	defer client.Close()
+
+	wrapped := WrapOpenAI(newTestOpenAIClient(openaiServer.URL), client)
+	_, err := wrapped.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:    openai.GPT4,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hello"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sampled != 1 {
+		t.Errorf("expected sampler to be consulted once, got %d", sampled)
+	}
+	if client.BufferSize() != 0 {
+		t.Errorf("expected dropped call not to be buffered, got size %d", client.BufferSize())
+	}
+}
+
+func BenchmarkOpenAIWrapperSampling(b *testing.B) {
+	openaiServer := newChatCompletionServer()
+	defer openaiServer.Close()
+
+	messages := make([]openai.ChatCompletionMessage, 200)
+	for i := range messages {
+		messages[i] = openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: strings.Repeat("lorem ipsum dolor sit amet ", 200),
+		}
+	}
+	req := openai.ChatCompletionRequest{Model: openai.GPT4, Messages: messages}
+
+	for _, rate := range []float64{1, 0.01} {
+		b.Run(fmt.Sprintf("rate=%v", rate), func(b *testing.B) {
+			client := NewClientWithConfig(Config{
+				APIKey:             "test-key",
+				BatchSize:          1000,
+				FlushIntervalMs:    3600000,
+				CaptureFullContent: true,
+				Sampler:            RateSampler(rate),
+			})
+			defer func() {
+				client.ClearBuffer()
+				client.Close()
+			}()
+			wrapped := WrapOpenAI(newTestOpenAIClient(openaiServer.URL), client)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := wrapped.CreateChatCompletion(context.Background(), req); err != nil {
+					b.Fatal(err)
+				}
+				if i%100 == 0 {
+					client.ClearBuffer()
+				}
+			}
+		})
+	}
+}
+
+// stubOpenAIClient is an OpenAIClient returning canned responses
+type stubOpenAIClient struct {
+	chatResp  openai.ChatCompletionResponse
+	embedResp openai.EmbeddingResponse
+	err       error
+}
+
+func (s *stubOpenAIClient) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return s.chatResp, s.err
+}
+
+func (s *stubOpenAIClient) CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error) {
+	return s.embedResp, s.err
+}
+
+var _ OpenAIClient = (*openai.Client)(nil)
+
+func TestOpenAIWrapperWithStub(t *testing.T) {
+	t.Run("tracks tokens and content", func(t *testing.T) {
+		client := NewClientWithConfig(Config{
+			APIKey:             "test-key",
+			FlushIntervalMs:    60000,
+			CaptureFullContent: true,
+		})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		stub := &stubOpenAIClient{
+			chatResp: openai.ChatCompletionResponse{
+				Choices: []openai.ChatCompletionChoice{
+					{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "Hi there!"}},
+				},
+				Usage: openai.Usage{PromptTokens: 12, CompletionTokens: 3},
+			},
+		}
+		wrapped := WrapOpenAI(stub, client, TrackOptions{ProjectID: "proj-1"})
+
+		_, err := wrapped.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+			Model:    openai.GPT4,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hello"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		call := client.PeekBuffer()[0]
+		if call.InputTokens != 12 || call.OutputTokens != 3 {
+			t.Errorf("expected tokens 12/3, got %d/%d", call.InputTokens, call.OutputTokens)
+		}
+		if call.FullPrompt != "[user]: Hello" {
+			t.Errorf("expected prompt '[user]: Hello', got '%s'", call.FullPrompt)
+		}
+		if call.FullResponse != "Hi there!" {
+			t.Errorf("expected response 'Hi there!', got '%s'", call.FullResponse)
+		}
+		if call.ProjectID != "proj-1" {
+			t.Errorf("expected project ID 'proj-1', got '%s'", call.ProjectID)
+		}
+		if wrapped.Underlying() != nil {
+			t.Error("expected Underlying to be nil for a custom client")
+		}
+	})
+
+	t.Run("tracks errors", func(t *testing.T) {
+		client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		wrapped := WrapOpenAI(&stubOpenAIClient{err: errors.New("boom")}, client)
+
+		_, err := wrapped.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{
+			Input: []string{"text"},
+			Model: openai.AdaEmbeddingV2,
+		})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		call := client.PeekBuffer()[0]
+		if call.Status != StatusError {
+			t.Errorf("expected status 'error', got '%s'", call.Status)
+		}
+		if call.ErrorMessage != "boom" {
+			t.Errorf("expected error message 'boom', got '%s'", call.ErrorMessage)
+		}
+		if call.Endpoint != "/v1/embeddings" {
+			t.Errorf("expected endpoint '/v1/embeddings', got '%s'", call.Endpoint)
+		}
+	})
+}
+
+func TestOpenAIWrapperCaptureParameters(t *testing.T) {
+	seed := 42
+	req := openai.ChatCompletionRequest{
+		Model:       openai.GPT4,
+		Messages:    []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hello"}},
+		Temperature: 0.5,
+		MaxTokens:   256,
+		Seed:        &seed,
+	}
+
+	t.Run("captures set parameters when enabled", func(t *testing.T) {
+		client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, CaptureParameters: true})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		wrapped := WrapOpenAI(&stubOpenAIClient{}, client)
+		wrapped.CreateChatCompletion(context.Background(), req)
+
+		params := client.PeekBuffer()[0].Parameters
+		if params["temperature"] != float32(0.5) {
+			t.Errorf("expected temperature 0.5, got %v", params["temperature"])
+		}
+		if params["max_tokens"] != 256 {
+			t.Errorf("expected max_tokens 256, got %v", params["max_tokens"])
+		}
+		if params["seed"] != 42 {
+			t.Errorf("expected seed 42, got %v", params["seed"])
+		}
+		if _, ok := params["top_p"]; ok {
+			t.Error("expected unset top_p to be omitted")
+		}
+	})
+
+	t.Run("omits parameters by default", func(t *testing.T) {
+		client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		wrapped := WrapOpenAI(&stubOpenAIClient{}, client)
+		wrapped.CreateChatCompletion(context.Background(), req)
+
+		if params := client.PeekBuffer()[0].Parameters; params != nil {
+			t.Errorf("expected no parameters, got %v", params)
+		}
+	})
+}
+
+func TestOpenAIWrapperEndpointOverride(t *testing.T) {
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	stub := &stubOpenAIClient{}
+	ctx := context.Background()
+
+	WrapOpenAI(stub, client).CreateChatCompletion(ctx, openai.ChatCompletionRequest{Model: "llama-3"})
+	WrapOpenAI(stub, client, TrackOptions{Endpoint: "/openai/v1/chat/completions"}).
+		CreateChatCompletion(ctx, openai.ChatCompletionRequest{Model: "llama-3"})
+	WrapOpenAI(stub, client, TrackOptions{Endpoint: "/openai/v1/embeddings"}).
+		CreateEmbeddings(ctx, openai.EmbeddingRequest{Model: openai.AdaEmbeddingV2})
+
+	calls := client.PeekBuffer()
+	expected := []string{"/v1/chat/completions", "/openai/v1/chat/completions", "/openai/v1/embeddings"}
+	if len(calls) != len(expected) {
+		t.Fatalf("expected %d calls, got %d", len(expected), len(calls))
+	}
+	for i, endpoint := range expected {
+		if calls[i].Endpoint != endpoint {
+			t.Errorf("call %d: expected endpoint '%s', got '%s'", i, endpoint, calls[i].Endpoint)
+		}
+	}
+}
+
+func TestOpenAIWrapperDroppedCalls(t *testing.T) {
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, MaxBufferSize: 1})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	var onDrop int
+	wrapped := WrapOpenAI(&stubOpenAIClient{}, client, TrackOptions{
+		OnDrop: func(call LLMCall) { onDrop++ },
+	})
+
+	for i := 0; i < 3; i++ {
+		wrapped.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: openai.GPT4})
+	}
+
+	if wrapped.DroppedCalls() != 2 {
+		t.Errorf("expected 2 dropped calls, got %d", wrapped.DroppedCalls())
+	}
+	if onDrop != 2 {
+		t.Errorf("expected OnDrop to be called twice, got %d", onDrop)
+	}
+}
+
+func TestOpenAIWrapperOnDropPanic(t *testing.T) {
+	var panicked []string
+	client := NewClientWithConfig(Config{
+		APIKey:          "test-key",
+		FlushIntervalMs: 60000,
+		MaxBufferSize:   1,
+		OnError: func(err error, calls []LLMCall) {
+			var panicErr *CallbackPanicError
+			if errors.As(err, &panicErr) {
+				panicked = append(panicked, panicErr.Callback)
+			}
+		},
+	})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	wrapped := WrapOpenAI(&stubOpenAIClient{}, client, TrackOptions{
+		OnDrop: func(call LLMCall) { panic("drop hook bug") },
+	})
+	for i := 0; i < 2; i++ {
+		wrapped.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: openai.GPT4})
+	}
+
+	if len(panicked) != 1 || panicked[0] != "OnDrop" {
+		t.Errorf("expected the OnDrop panic to be reported to OnError, got %v", panicked)
+	}
+}
+
+func TestExperimentTagging(t *testing.T) {
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, DefaultExperimentID: "exp-default"})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	stub := &stubOpenAIClient{}
+	ctx := context.Background()
+
+	WrapOpenAI(stub, client, TrackOptions{ExperimentID: "exp-prompt", Variant: "b"}).
+		CreateChatCompletion(ctx, openai.ChatCompletionRequest{Model: openai.GPT4})
+	TrackCall(client, ProviderAnthropic, "claude-3", func() (int, int, error) { return 1, 1, nil }, TrackOptions{Variant: "a"})
+
+	calls := client.PeekBuffer()
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 calls, got %d", len(calls))
+	}
+	if calls[0].ExperimentID != "exp-prompt" || calls[0].Variant != "b" {
+		t.Errorf("expected exp-prompt/b, got %s/%s", calls[0].ExperimentID, calls[0].Variant)
+	}
+	if calls[1].ExperimentID != "exp-default" || calls[1].Variant != "a" {
+		t.Errorf("expected exp-default/a, got %s/%s", calls[1].ExperimentID, calls[1].Variant)
+	}
+}
+
+func TestDefaultEnvironment(t *testing.T) {
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, Environment: "staging"})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	stub := &stubOpenAIClient{}
+	WrapOpenAI(stub, client, TrackOptions{}).
+		CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: openai.GPT4})
+	client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4", Environment: "production"})
+
+	calls := client.PeekBuffer()
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 calls, got %d", len(calls))
+	}
+	if calls[0].Environment != "staging" {
+		t.Errorf("expected the wrapper's call to get the default environment, got %q", calls[0].Environment)
+	}
+	if calls[1].Environment != "production" {
+		t.Errorf("expected an explicit environment to win, got %q", calls[1].Environment)
+	}
+
+	t.Run("auto", func(t *testing.T) {
+		for _, tt := range []struct {
+			vars map[string]string
+			want string
+		}{
+			{map[string]string{"DIAGNYX_ENV": "prod", "ENV": "stage", "APP_ENV": "qa"}, "prod"},
+			{map[string]string{"ENV": "stage", "APP_ENV": "qa"}, "stage"},
+			{map[string]string{"APP_ENV": "qa"}, "qa"},
+			{nil, "development"},
+		} {
+			for _, name := range environmentVars {
+				t.Setenv(name, tt.vars[name])
+			}
+			client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, Environment: EnvironmentAuto})
+			client.Track(LLMCall{Provider: ProviderOpenAI, Model: "gpt-4"})
+			if got := client.PeekBuffer()[0].Environment; got != tt.want {
+				t.Errorf("with %v: expected %q, got %q", tt.vars, tt.want, got)
+			}
+			client.ClearBuffer()
+			client.Close()
+		}
+	})
+}
+
+func TestOpenAIWrapperContentFilter(t *testing.T) {
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	metadata := map[string]interface{}{"feature": "chat"}
+	stub := &stubOpenAIClient{
+		chatResp: openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{FinishReason: openai.FinishReasonContentFilter}},
+		},
+	}
+	wrapped := WrapOpenAI(stub, client, TrackOptions{Metadata: metadata})
+
+	if _, err := wrapped.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: openai.GPT4}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	call := client.PeekBuffer()[0]
+	if call.Status != StatusFiltered {
+		t.Errorf("expected status '%s', got '%s'", StatusFiltered, call.Status)
+	}
+	if call.Metadata["finish_reason"] != "content_filter" || call.Metadata["feature"] != "chat" {
+		t.Errorf("expected finish reason merged into metadata, got %v", call.Metadata)
+	}
+	if _, ok := metadata["finish_reason"]; ok {
+		t.Error("expected the shared TrackOptions metadata to be left unchanged")
+	}
+}
+
+func TestOpenAIWrapperRawResponse(t *testing.T) {
+	stub := &stubOpenAIClient{
+		chatResp: openai.ChatCompletionResponse{
+			ID:      "chatcmpl-1",
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "Hello"}}},
+		},
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		WrapOpenAI(stub, client).CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: openai.GPT4})
+		if raw := client.PeekBuffer()[0].RawResponse; raw != nil {
+			t.Errorf("expected no raw response, got %s", raw)
+		}
+	})
+
+	t.Run("captures the response JSON", func(t *testing.T) {
+		client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, CaptureRawResponse: true})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		WrapOpenAI(stub, client).CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: openai.GPT4})
+
+		var resp openai.ChatCompletionResponse
+		if err := json.Unmarshal(client.PeekBuffer()[0].RawResponse, &resp); err != nil {
+			t.Fatalf("expected valid JSON, got %v", err)
+		}
+		if resp.ID != "chatcmpl-1" || resp.Choices[0].Message.Content != "Hello" {
+			t.Errorf("unexpected raw response: %+v", resp)
+		}
+	})
+
+	t.Run("truncates to a JSON string", func(t *testing.T) {
+		client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000, CaptureRawResponse: true, ContentMaxLength: 10})
+		defer client.Close()
+		defer client.ClearBuffer()
+
+		WrapOpenAI(stub, client).CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: openai.GPT4})
+
+		var truncated string
+		if err := json.Unmarshal(client.PeekBuffer()[0].RawResponse, &truncated); err != nil {
+			t.Fatalf("expected a JSON string, got %v", err)
+		}
+		if !strings.HasSuffix(truncated, "... [truncated]") {
+			t.Errorf("expected truncated content, got %q", truncated)
+		}
+	})
+}
+
+// fakeTracker records calls in memory
+type fakeTracker struct {
+	config Config
+	calls  []LLMCall
+}
+
+func (f *fakeTracker) Track(call LLMCall)         { f.calls = append(f.calls, call) }
+func (f *fakeTracker) TrackCalls(calls []LLMCall) { f.calls = append(f.calls, calls...) }
+func (f *fakeTracker) Config() Config             { return f.config }
+func (f *fakeTracker) Flush() error               { return nil }
+
+func TestWrappersWithTracker(t *testing.T) {
+	tracker := &fakeTracker{
+		config: Config{Sampler: SamplerFunc(func(call LLMCall) bool { return call.Model != "skip" })},
+	}
+	wrapped := WrapOpenAI(&stubOpenAIClient{}, tracker)
+
+	wrapped.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: openai.GPT4})
+	wrapped.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: "skip"})
+	TrackCall(tracker, ProviderAnthropic, "claude-3-opus", func() (int, int, error) { return 1, 1, nil })
+
+	if len(tracker.calls) != 2 {
+		t.Fatalf("expected 2 tracked calls, got %d", len(tracker.calls))
+	}
+	if tracker.calls[0].Model != openai.GPT4 || tracker.calls[1].Provider != ProviderAnthropic {
+		t.Errorf("unexpected tracked calls: %+v", tracker.calls)
+	}
+}
+
+func TestOpenAIWrapperTTFT(t *testing.T) {
+	openaiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "Hi"}}},
+		})
+	}))
+	defer openaiServer.Close()
+
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	wrapped := WrapOpenAI(newTestOpenAIClient(openaiServer.URL), client)
+	_, err := wrapped.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:    openai.GPT4,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hello"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	calls := client.PeekBuffer()
+	if len(calls) != 1 || calls[0].TTFTMs == nil {
+		t.Fatalf("expected a call with TTFT, got %+v", calls)
+	}
+	if ttft := *calls[0].TTFTMs; ttft < 30 || ttft > calls[0].LatencyMs {
+		t.Errorf("expected TTFT between 30ms and latency %dms, got %dms", calls[0].LatencyMs, ttft)
+	}
+}
+
+func TestOpenAIWrapperLengthFinish(t *testing.T) {
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	stub := &stubOpenAIClient{
+		chatResp: openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{FinishReason: openai.FinishReasonLength}},
+		},
+	}
+	WrapOpenAI(stub, client).CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: openai.GPT4})
+	stub.chatResp.Choices[0].FinishReason = openai.FinishReasonStop
+	WrapOpenAI(stub, client).CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: openai.GPT4})
+
+	calls := client.PeekBuffer()
+	if calls[0].Status != StatusSuccess || calls[0].Metadata["truncated"] != true {
+		t.Errorf("expected a successful call marked truncated, got %+v", calls[0])
+	}
+	if _, ok := calls[1].Metadata["truncated"]; ok {
+		t.Error("expected calls that stopped normally not to be marked truncated")
+	}
+}
+
+func TestOpenAIWrapperBilledOnError(t *testing.T) {
+	client := NewClientWithConfig(Config{APIKey: "test-key", FlushIntervalMs: 60000})
+	defer client.Close()
+	defer client.ClearBuffer()
+
+	req := openai.ChatCompletionRequest{
+		Model:    openai.GPT4,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hello there"}},
+	}
+	stubs := []*stubOpenAIClient{
+		{err: &openai.APIError{HTTPStatusCode: http.StatusBadGateway}, chatResp: openai.ChatCompletionResponse{Usage: openai.Usage{PromptTokens: 7, CompletionTokens: 2}}},
+		{err: &openai.APIError{HTTPStatusCode: http.StatusInternalServerError}},
+		{err: &openai.APIError{HTTPStatusCode: http.StatusUnauthorized}},
+	}
+	for _, stub := range stubs {
+		WrapOpenAI(stub, client).CreateChatCompletion(context.Background(), req)
+	}
+
+	calls := client.PeekBuffer()
+	if calls[0].InputTokens != 7 || calls[0].OutputTokens != 2 || calls[0].Metadata["billed_on_error"] != true {
+		t.Errorf("expected reported usage to be kept on error, got %+v", calls[0])
+	}
+	if calls[1].InputTokens != EstimateTokens(formatOpenAIPrompt(req.Messages)) || calls[1].Metadata["tokens_estimated"] != true {
+		t.Errorf("expected estimated prompt tokens for a server error, got %+v", calls[1])
+	}
+	if calls[2].InputTokens != 0 || calls[2].Metadata["billed_on_error"] != nil {
+		t.Errorf("expected no tokens for a rejected request, got %+v", calls[2])
+	}
+}
+
+func TestOpenAIProviderDetection(t *testing.T) {
+	withBaseURL := func(baseURL string) *openai.Client {
+		config := openai.DefaultConfig("test-key")
+		config.BaseURL = baseURL
+		return openai.NewClientWithConfig(config)
+	}
+
+	tests := []struct {
+		name         string
+		client       *openai.Client
+		wantProvider Provider
+		wantHost     string
+	}{
+		{"openai", openai.NewClient("test-key"), ProviderOpenAI, ""},
+		{"azure config", openai.NewClientWithConfig(openai.DefaultAzureConfig("test-key", "https://example.com")), ProviderAzure, "example.com"},
+		{"azure host", withBaseURL("https://my-resource.openai.azure.com/openai"), ProviderAzure, "my-resource.openai.azure.com"},
+		{"groq", withBaseURL("https://api.groq.com/openai/v1"), ProviderCustom, "api.groq.com"},
+		{"together", withBaseURL("https://api.together.xyz/v1"), ProviderCustom, "api.together.xyz"},
+		{"gemini", withBaseURL("https://generativelanguage.googleapis.com/v1beta/openai"), ProviderGoogle, "generativelanguage.googleapis.com"},
+		{"unknown proxy", withBaseURL("http://localhost:4000/v1"), ProviderOpenAI, "localhost"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, host := detectOpenAIProvider(tt.client)
+			if provider != tt.wantProvider || host != tt.wantHost {
+				t.Errorf("expected %s at %q, got %s at %q", tt.wantProvider, tt.wantHost, provider, host)
+			}
+		})
+	}
+
+	t.Run("stamps tracked calls", func(t *testing.T) {
+		tracker := &fakeTracker{}
+		opts := TrackOptions{Metadata: map[string]interface{}{"team": "search"}}
+		wrapped := WrapOpenAI(withBaseURL("https://api.groq.com/openai/v1"), tracker, opts)
+
+		call := wrapped.newCall("llama3-70b-8192", "/v1/chat/completions", 10)
+		if call.Provider != ProviderCustom || call.Metadata["base_url_host"] != "api.groq.com" || call.Metadata["team"] != "search" {
+			t.Errorf("unexpected call: %+v", call)
+		}
+		if _, ok := opts.Metadata["base_url_host"]; ok {
+			t.Error("expected the shared TrackOptions metadata to be left unchanged")
+		}
+	})
+}